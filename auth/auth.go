@@ -24,11 +24,16 @@ package auth
 
 import (
 	"fmt"
+	"os/user"
+	"strconv"
 	"time"
 
 	"github.com/mitghi/protox/protobase"
 )
 
+// ensure interface (protocol) conformance
+var _ protobase.PeerCredInterface = (*Authentication)(nil)
+
 /**
 * TODO
 * . add auth modes
@@ -135,6 +140,47 @@ func (a *Authentication) CanAuthenticate(creds protobase.CredentialsInterface) (
 	return ok, err
 }
 
+// CanAuthenticatePeer returns a boolean indicating validity of the given
+// credentials of a local client. In `protobase.AUTHModePeerCred` mode the
+// username must be registered and name the account of the peer process,
+// the password is not checked. Otherwise it is identical to `CanAuthenticate`.
+func (a *Authentication) CanAuthenticatePeer(creds protobase.CredentialsInterface, peer protobase.PeerCred) (ok bool, err error) {
+	if a.GetMode() != protobase.AUTHModePeerCred {
+		return a.CanAuthenticate(creds)
+	}
+	uid := creds.GetUID()
+	account, err := user.LookupId(strconv.FormatUint(uint64(peer.Uid), 10))
+	if err != nil || account.Username != uid {
+		logger.Debugf("* [AuthSys] peer uid %d does not match [uid] %s .", peer.Uid, uid)
+		return false, EAUTHPeerMismatch
+	}
+	if !a.hasUserWithIdentifier(&uid) {
+		return false, NonExistingUser
+	}
+	logger.Debugf("* [AuthSys] peer auth status for [uid] %s is true .", uid)
+	return true, nil
+}
+
+// SetAddr records `addr` as the last address user `uid` connected from.
+func (a *Authentication) SetAddr(uid string, addr string) {
+	if user, ok := a.getUserWithIdentifier(&uid); ok && user != nil {
+		user.Lock()
+		user.lstip = addr
+		user.Unlock()
+	}
+}
+
+// GetAddr returns the last address user `uid` connected from.
+func (a *Authentication) GetAddr(uid string) (addr string, ok bool) {
+	user, ok := a.getUserWithIdentifier(&uid)
+	if !ok || user == nil {
+		return "", false
+	}
+	user.RLock()
+	defer user.RUnlock()
+	return user.lstip, true
+}
+
 func (a *Authentication) GetUserType(uid string) (utype protobase.AuthUserType, err error) {
 	if uinfo, ok := a.getUserWithIdentifier(&uid); !ok {
 		return "", fmt.Errorf(eFMT, "auth", "unable to find user with given id")
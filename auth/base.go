@@ -84,6 +84,7 @@ var (
 	EAUTHGeneralFailure error = errors.New("permissions: general operation failure")
 	EAUTHUserReadd      error = errors.New("auth: attempt to re-registering existing user")
 	ECREDINVAL          error = errors.New("credentials: missing or invalid credentials")
+	EAUTHPeerMismatch   error = errors.New("permissions: peer credentials do not match user")
 )
 
 // Debug codes ( for development )
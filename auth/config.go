@@ -59,6 +59,7 @@ func NewAuthConfig() *AuthConfig {
 	// .. AUTHModeDynamic
 	// .. AUTHModeStrict
 	// .. AUTHModeRouter
+	// .. AUTHModePeerCred
 	var ac *AuthConfig = &AuthConfig{
 		Mode: protobase.AUTHModeNone,
 	}
@@ -80,7 +81,7 @@ func (ac *AuthConfig) IsValid() (ok bool, err error) {
 	case protobase.AUTHModeNone:
 		err = EACInconsistentConfig
 		goto ERROR
-	case protobase.AUTHModeDynamic:
+	case protobase.AUTHModeDynamic, protobase.AUTHModePeerCred:
 		ok = true
 		goto OK
 	case protobase.AUTHModeStrict:
@@ -143,7 +144,8 @@ func (ac *AuthConfig) SetMode(mode protobase.AuthMode) (ok bool) {
 	ok = (mode == protobase.AUTHModeNone) ||
 		(mode == protobase.AUTHModeDynamic) ||
 		(mode == protobase.AUTHModeStrict) ||
-		(mode == protobase.AUTHModeRouter)
+		(mode == protobase.AUTHModeRouter) ||
+		(mode == protobase.AUTHModePeerCred)
 	if !ok {
 		return false
 	}
@@ -72,12 +72,33 @@ type ClientStore struct {
 // Options holds configuration detail.
 type Options struct {
 	HeartBeat          int
+	MaxPacketSize      uint32
+	ReceiveMaximum     uint16
+	MessageExpiry      time.Duration
+	RequestTimeout     time.Duration
+	ShareStrategy      byte
+	DeadLetterTopic    string
+	MaxAttempts        int
+	DrainTimeout       time.Duration
+	DisableRetain      bool
+	DisableWildcard    bool
+	Versions           []string // accepted protocol versions in ascending order
+	Compression        []byte   // negotiable payload compression codecs
+	CompressionOptOut  []string // topic filters delivered uncompressed
+	MQTTAddr           string   // address of the MQTT 3.1.1 listener, empty disables it
+	WSAddr             string   // address of the WebSocket listener, empty disables it
+	WSPath             string   // HTTP path upgraded to WebSocket connections
+	HTTPAddr           string   // address of the HTTP gateway, empty disables it
+	RESPAddr           string   // address of the RESP frontend, empty disables it
+	UDPAddr            string   // address of the UDP datagram listener, empty disables it
 	Auth               protobase.AuthInterface
 	MsgStore           protobase.MessageStorage
 	ClientStore        protobase.CLStoreInterface
 	ClientDelegate     server.ClientDelegate
 	ConnectionDelegate server.ConnectionDelegate
 	ServerConf         server.ServerConfigs
+	Listeners          []server.ServerConfigs                     // additional listeners sharing router and state
+	Frontends          map[server.ServerType]server.ServerConfigs // listener configurations of frontends, e.g. TLS
 	ShutdownDeadline   time.Duration
 	Exit               chan struct{}
 }
@@ -107,6 +128,12 @@ type Broker struct {
 	shwddln     time.Duration              // maximum tolerable time for shutdown procedure
 	opts        *Options                   // options
 	heartbeat   int                        // maximum tolerable time for connection health check
+	addr        string                     // listener address
+	mqttAddr    string                     // MQTT 3.1.1 listener address
+	wsAddr      string                     // WebSocket listener address
+	httpAddr    string                     // HTTP gateway address
+	respAddr    string                     // RESP frontend address
+	udpAddr     string                     // UDP datagram listener address
 	firstRun    uint32                     // initial startup flag
 	running     uint32                     // running status flag
 	stopping    uint32                     // stopping procedure flag
@@ -58,6 +58,12 @@ func NewBroker(opts Options) protobase.BrokerInterface {
 	} else {
 		ret.server = server.NewServer()
 	}
+	for _, lopts := range opts.Listeners {
+		if err = ret.server.AddListener(lopts); err != nil {
+			fmt.Println(err)
+			return nil
+		}
+	}
 	ret.exitch = ret.server.GetErrChan()
 	if opts.Auth != nil {
 		ret.server.SetAuthenticator(opts.Auth)
@@ -78,6 +84,50 @@ func NewBroker(opts Options) protobase.BrokerInterface {
 		ret.heartbeat = HEARTBEAT
 		ret.server.SetHeartBeat(HEARTBEAT)
 	}
+	if opts.MaxPacketSize != 0 {
+		ret.server.SetMaxPacketSize(opts.MaxPacketSize)
+	}
+	if opts.ReceiveMaximum != 0 {
+		ret.server.SetReceiveMaximum(opts.ReceiveMaximum)
+	}
+	if opts.MessageExpiry != 0 {
+		ret.server.SetMessageExpiry(opts.MessageExpiry)
+	}
+	if opts.RequestTimeout != 0 {
+		ret.server.SetRequestTimeout(opts.RequestTimeout)
+	}
+	ret.server.SetShareStrategy(opts.ShareStrategy)
+	if opts.DeadLetterTopic != "" {
+		ret.server.SetDeadLetterTopic(opts.DeadLetterTopic)
+	}
+	ret.server.SetMaxAttempts(opts.MaxAttempts)
+	if opts.DrainTimeout != 0 {
+		ret.server.SetDrainTimeout(opts.DrainTimeout)
+	}
+	ret.server.SetRetainAvailable(!opts.DisableRetain)
+	ret.server.SetWildcardAvailable(!opts.DisableWildcard)
+	ret.addr = ADDR
+	if opts.ServerConf.Addr != "" {
+		ret.addr = opts.ServerConf.Addr
+	}
+	ret.mqttAddr = opts.MQTTAddr
+	ret.wsAddr = opts.WSAddr
+	ret.httpAddr = opts.HTTPAddr
+	ret.respAddr = opts.RESPAddr
+	ret.udpAddr = opts.UDPAddr
+	for typ, fopts := range opts.Frontends {
+		ret.server.SetFrontend(typ, fopts)
+	}
+	if opts.WSPath != "" {
+		ret.server.SetWSPath(opts.WSPath)
+	}
+	if opts.Versions != nil {
+		ret.server.SetVersions(opts.Versions)
+	}
+	if opts.Compression != nil {
+		ret.server.SetCompression(opts.Compression)
+		ret.server.SetCompressionOptOut(opts.CompressionOptOut...)
+	}
 	if opts.ClientDelegate != nil {
 		ret.server.SetClientHandler(opts.ClientDelegate)
 	} else {
@@ -106,7 +156,7 @@ func NewBroker(opts Options) protobase.BrokerInterface {
 		ret.shwddln = DSTDWN
 	}
 	ret.sigch = make(chan os.Signal, 1)
-	signal.Notify(ret.sigch, syscall.SIGINT, syscall.SIGKILL)
+	signal.Notify(ret.sigch, syscall.SIGINT, syscall.SIGKILL, syscall.SIGUSR2)
 
 	return ret
 }
@@ -123,13 +173,23 @@ func (brk *Broker) Status() byte {
 }
 
 func (brk *Broker) handleSignals() {
-	select {
-	case <-brk.sigch:
-		fmt.Printf("[X] received SIGINT, shutting down ....\n")
-	case <-brk.exitch:
-		fmt.Printf("[X] server exiting ( fatal ? ) .\n")
+	for {
+		select {
+		case sig := <-brk.sigch:
+			if sig == syscall.SIGUSR2 {
+				fmt.Printf("[X] received SIGUSR2, restarting ....\n")
+				if brk.Restart() {
+					return
+				}
+				continue
+			}
+			fmt.Printf("[X] received SIGINT, shutting down ....\n")
+		case <-brk.exitch:
+			fmt.Printf("[X] server exiting ( fatal ? ) .\n")
+		}
+		brk.Stop()
+		return
 	}
-	brk.Stop()
 }
 
 func (brk *Broker) Start() (ok bool) {
@@ -150,12 +210,27 @@ func (brk *Broker) Start() (ok bool) {
 	logger.Info("[+] starting server....")
 	// spawn handler coroutines
 	go brk.handleSignals()
-	go brk.server.ServeTCP(ADDR)
+	go brk.server.ServeTCP(brk.addr)
 	statusChan = brk.server.GetStatusChan()
 	serverStatus = <-statusChan
 	switch serverStatus {
 	case protobase.ServerRunning:
 		atomic.StoreUint32(&brk.running, BrokerRunning)
+		if brk.mqttAddr != "" {
+			go brk.serve("MQTT", brk.mqttAddr, brk.server.ServeMQTT)
+		}
+		if brk.wsAddr != "" {
+			go brk.serve("WebSocket", brk.wsAddr, brk.server.ServeWS)
+		}
+		if brk.httpAddr != "" {
+			go brk.serve("HTTP", brk.httpAddr, brk.server.ServeHTTPGateway)
+		}
+		if brk.respAddr != "" {
+			go brk.serve("RESP", brk.respAddr, brk.server.ServeRESP)
+		}
+		if brk.udpAddr != "" {
+			go brk.serve("UDP", brk.udpAddr, brk.server.ServeUDP)
+		}
 		ok = true
 	case protobase.ServerStopped:
 		atomic.StoreUint32(&brk.running, BrokerStopping)
@@ -166,11 +241,48 @@ func (brk *Broker) Start() (ok bool) {
 	return ok
 }
 
+// serve runs the `name` listener on `addr` by `fn` and logs the
+// error it returns while the server is running, e.g. when `addr`
+// cannot be bound.
+func (brk *Broker) serve(name string, addr string, fn func(string) error) {
+	if err := fn(addr); err != nil && brk.server.GetStatus() == protobase.ServerRunning {
+		logger.Errorf("- [Broker] %s listener on (%s) failed. error: %s", name, addr, err)
+	}
+}
+
 func (brk *Broker) corou(fn func()) {
 	brk.wg.Add(1)
 	go fn()
 }
 
+// Restart hands listening sockets and sessions over to a new broker
+// process and stops this one once existing connections are drained.
+func (brk *Broker) Restart() bool {
+	if atomic.LoadUint32(&brk.running) != BrokerRunning {
+		return false
+	} else if atomic.LoadUint32(&brk.stopping) == BrokerStopping {
+		return false
+	}
+	ch, err := brk.server.Restart()
+	if err != nil {
+		fmt.Println("[-] Restart failed.", err)
+		return false
+	}
+	atomic.StoreUint32(&brk.stopping, BrokerStopping)
+	select {
+	case <-time.After(brk.shwddln + brk.server.GetDrainTimeout()):
+		fmt.Println("[-----unable-to-restart-before-timeout-----]")
+	case <-ch:
+		fmt.Println("[+] Restart completed.")
+	}
+	atomic.StoreUint32(&brk.running, BrokerNone)
+	select {
+	case brk.E <- struct{}{}:
+	default:
+	}
+	return true
+}
+
 func (brk *Broker) Stop() bool {
 	if atomic.LoadUint32(&brk.running) == BrokerNone {
 		return false
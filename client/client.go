@@ -87,6 +87,12 @@ func (c *Client) Subscribe(msg protobase.MsgInterface) {
 	c.AddTopic(topic)
 }
 
+func (c *Client) Unsubscribe(msg protobase.MsgInterface) {
+	topic := msg.Envelope().Route()
+	logger.Debug("+ [1][Client] Marked to stop receiving updates.")
+	c.RemoveTopic(topic)
+}
+
 func (c *Client) GetTopics() []string {
 	return c.Topics
 }
@@ -96,6 +102,16 @@ func (c *Client) AddTopic(topic string) {
 	c.Topics = append(c.Topics, topic)
 }
 
+func (c *Client) RemoveTopic(topic string) {
+	// TODO
+	for i, t := range c.Topics {
+		if t == topic {
+			c.Topics = append(c.Topics[:i], c.Topics[i+1:]...)
+			return
+		}
+	}
+}
+
 func (c *Client) SetAuthMechanism() {
 	// TODO
 }
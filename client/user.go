@@ -68,6 +68,10 @@ type CLBUser struct {
 	hadSetup   bool
 	Running    bool
 	Connected  bool
+	will       protobase.MsgInterface
+	willDelay  uint32
+	sessionId  string
+	codecs     []byte
 }
 
 // CLBOptions contains values for
@@ -79,11 +83,24 @@ type CLBOptions struct {
 	StorageDelegate protobase.MessageBox
 	Conn            protobase.ProtoClientConnection
 	CFCallback      func(*CLBUser)
-	Addr            string
+	Addr            string // host:port, unix:// path, or a ws:// or wss:// URL
 	MaxRetry        int
 	HeartBeat       int
 	MinSecMRS       int // minimum retry delay ( number in Milliseconds)
 	SecMRS          int // maximum sleep duration
+	// last will, published by the broker when the connection
+	// ends without disconnecting ( empty topic means no will ).
+	WillTopic   string
+	WillMessage []byte
+	WillQoS     byte
+	WillRetain  bool
+	WillDelay   uint32 // delay in seconds before publishing the will
+	// session token of a previous connection, it
+	// resumes the session on connect.
+	SessionId string
+	// payload compression codecs offered to the broker,
+	// preferred first ( see `protobase.COMP*` ).
+	Compression []byte
 }
 
 // checkOpts returns whether 'opts' is valid.
@@ -123,6 +140,13 @@ func NewCLBUser(opts CLBOptions) (clbu *CLBUser, ok bool) {
 		Storage:    opts.StorageDelegate,
 		hadSetup:   false,
 		Connected:  false,
+		sessionId:  opts.SessionId,
+		codecs:     opts.Compression,
+	}
+	if opts.WillTopic != "" {
+		clbu.will = opts.Conn.MakeEnvelope(opts.WillTopic, opts.WillMessage, opts.WillQoS, 0, protobase.MDOutbound)
+		clbu.will.SetRetain(opts.WillRetain, 0)
+		clbu.willDelay = opts.WillDelay
 	}
 	ok = true
 	return clbu, ok
@@ -147,6 +171,15 @@ func (u *CLBUser) Setup() error {
 	}
 	u.Conn.SetClient(u.Cl)
 	u.Conn.SetMessageStorage(u.Storage)
+	if u.will != nil {
+		u.Conn.SetWill(u.will, u.willDelay)
+	}
+	if u.sessionId != "" {
+		u.Conn.SetSessionId(u.sessionId)
+	}
+	if len(u.codecs) > 0 {
+		u.Conn.SetCompression(u.codecs)
+	}
 	if u.HeartBeat >= 1 {
 		u.Conn.SetHeartBeat(u.HeartBeat)
 	}
@@ -187,6 +220,29 @@ func (u *CLBUser) SetConnected(b bool) {
 	u.Unlock()
 }
 
+// ConnectError returns the reason the
+// broker refused the last connection
+// attempt, or nil when it was accepted.
+func (u *CLBUser) ConnectError() error {
+	return u.Conn.ConnectError()
+}
+
+// SessionId returns the session token
+// issued by the broker. It can be kept
+// and passed as 'CLBOptions.SessionId'
+// to resume the session later.
+func (u *CLBUser) SessionId() string {
+	return u.Conn.SessionId()
+}
+
+// SessionPresent returns whether the
+// broker resumed the previous session.
+// Subscriptions must be repeated when
+// it returns false.
+func (u *CLBUser) SessionPresent() bool {
+	return u.Conn.SessionPresent()
+}
+
 // SetRunning sets running status to 'b'.
 func (u *CLBUser) SetRunning(b bool) {
 	u.Lock()
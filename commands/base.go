@@ -94,6 +94,20 @@ const (
 	STRFormat string = "$%d\r\n%s\r\n"
 )
 
+// Reply format constants ( RESP )
+const (
+	// Array header without total length
+	ARRFormat string = "*%d\r\n"
+	// Simple string format
+	SIMFormat string = "+%s\r\n"
+	// Error format
+	ERRFormat string = "-%s\r\n"
+	// Integer format
+	INTFormat string = ":%d\r\n"
+	// Null string
+	NILString string = "$-1\r\n"
+)
+
 // Token constants
 const (
 	TOK_ART = '*'
@@ -0,0 +1,130 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// DefaultMaxLength is the default maximum length of a string
+// read by `Reader`.
+const DefaultMaxLength int = 1 << 20
+
+// Reader reads commands from a stream. A command is an array of
+// strings, its header is either given with total length ( e.g.
+// "*2:5\r\n" ) or without it as in RESP ( e.g. "*2\r\n" ). Lines
+// not starting with an array header are read as inline commands
+// with space separated arguments.
+type Reader struct {
+	r *bufio.Reader
+	// MaxLength is the maximum length of a single string.
+	MaxLength int
+}
+
+// NewReader allocates and initializes a new `Reader` reading
+// from `r` and returns a pointer to it.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:         bufio.NewReader(r),
+		MaxLength: DefaultMaxLength,
+	}
+}
+
+// ReadCommand reads a single command and returns its arguments.
+// It returns an error in case of protocol violation or when the
+// underlaying reader fails.
+func (rd *Reader) ReadCommand() (args []string, err error) {
+	var (
+		line []byte
+		n    int
+	)
+	if line, err = rd.readLine(); err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != TOK_ART {
+		for _, f := range bytes.Fields(line) {
+			args = append(args, string(f))
+		}
+		return args, nil
+	}
+	if bytes.IndexByte(line, TOK_SEP) >= 0 {
+		h, err := parseArrayHeader(append(append([]byte{}, line...), TOK_CR, TOK_LF))
+		if err != nil {
+			return nil, err
+		}
+		n = h.es
+	} else if n, err = strconv.Atoi(string(line[1:])); err != nil || n < 0 {
+		return nil, EINVARRH
+	}
+	args = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		s, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, s)
+	}
+	return args, nil
+}
+
+// readString reads a single string ( e.g. "$1\r\na\r\n" ).
+func (rd *Reader) readString() (string, error) {
+	line, err := rd.readLine()
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 || line[0] != TOK_LEN {
+		return "", EVIOTOK
+	}
+	l, err := strconv.Atoi(string(line[1:]))
+	if err != nil || l < 0 {
+		return "", EINLEN
+	} else if l > rd.MaxLength {
+		return "", EVIOLEN
+	}
+	b := make([]byte, l+2)
+	if _, err = io.ReadFull(rd.r, b); err != nil {
+		return "", err
+	}
+	if b[l] != TOK_CR || b[l+1] != TOK_LF {
+		return "", EVIOLEN
+	}
+	return string(b[:l]), nil
+}
+
+// readLine reads a line and returns it without CRLF.
+func (rd *Reader) readLine() ([]byte, error) {
+	line, err := rd.r.ReadSlice(TOK_LF)
+	if err == bufio.ErrBufferFull {
+		return nil, EINLEN
+	} else if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != TOK_CR {
+		return nil, EVIOTOK
+	}
+	return line[:len(line)-2], nil
+}
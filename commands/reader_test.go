@@ -0,0 +1,60 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	var (
+		input string = "*2:5\r\n$3\r\nGET\r\n$2\r\nab\r\n" +
+			"*3\r\n$7\r\nPUBLISH\r\n$1\r\na\r\n$0\r\n\r\n" +
+			"PING  hello\r\n" +
+			"*1\r\n$4\nPING\r\n"
+		rd       *Reader = NewReader(strings.NewReader(input))
+		expected         = [][]string{
+			{"GET", "ab"},
+			{"PUBLISH", "a", ""},
+			{"PING", "hello"},
+		}
+	)
+	for i, e := range expected {
+		args, err := rd.ReadCommand()
+		if err != nil {
+			t.Fatalf("[case %d] expected err==nil, got %s.", i, err)
+		}
+		if strings.Join(args, ",") != strings.Join(e, ",") {
+			t.Fatalf("[case %d] inconsistent arguments ( %q != %q ).", i, args, e)
+		}
+	}
+	if _, err := rd.ReadCommand(); err != EVIOTOK {
+		t.Fatalf("expected EVIOTOK, got %v.", err)
+	}
+	rd = NewReader(strings.NewReader("*1\r\n$8\r\nTOOLARGE\r\n"))
+	rd.MaxLength = 4
+	if _, err := rd.ReadCommand(); err != EVIOLEN {
+		t.Fatalf("expected EVIOLEN, got %v.", err)
+	}
+}
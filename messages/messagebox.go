@@ -167,6 +167,27 @@ func (mb *MessageBox) GetAllOut() (msgs []protobase.EDProtocol) {
 	return msgs
 }
 
+// ReplaceOut swaps an outgoing packet with `msg` which carries the
+// same UUID ( e.g. PUBLISH -> PUBREL ) while preserving its order.
+func (mb *MessageBox) ReplaceOut(msg protobase.EDProtocol) bool {
+	mb.RLock()
+	defer mb.RUnlock()
+
+	var (
+		cid string = uidstr(msg)
+	)
+
+	mb.out.Lock()
+	defer mb.out.Unlock()
+
+	if _, ok := mb.out.messages[cid]; !ok {
+		return false
+	}
+	mb.out.messages[cid] = msg
+
+	return true
+}
+
 func (mb *MessageBox) GetAllOutStr() (msgs []string) {
 	mb.RLock()
 	mb.out.Lock()
@@ -216,7 +237,7 @@ func (mb *MessageBox) GetIDStoreO() (idstore protobase.MSGIDInterface) {
 	mb.RLock()
 	mb.out.Lock()
 
-	idstore = mb.in.ids
+	idstore = mb.out.ids
 
 	mb.out.Unlock()
 	mb.RUnlock()
@@ -23,6 +23,7 @@
 package messages
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/google/uuid"
@@ -85,6 +86,18 @@ func (m *MessageId) getNewId(uid uuid.UUID) (uint16, int) {
 	return 0, m.cursor
 }
 
+// Reserve associates a specific `id` ( e.g. chosen by the remote
+// peer ) with `uid`. It returns `false` when `id` is already in use.
+func (m *MessageId) Reserve(id uint16, uid uuid.UUID) bool {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.id[id]; ok {
+		return false
+	}
+	m.id[id] = uid
+	return true
+}
+
 // GetFNewID finds an empty slot and returns a new `uint16` associated
 // with that slot as well as a `cursor`. Cursor increments on each
 // new association and restarts when maximum message length is reached.
@@ -118,6 +131,28 @@ func (m *MessageId) FreeId(id uint16) {
 	m.Unlock()
 }
 
+// Occupied returns ids in use in ascending order.
+func (m *MessageId) Occupied() (ids []uint16) {
+	m.RLock()
+	for id := range m.id {
+		ids = append(ids, id)
+	}
+	m.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// freeUUID removes every id associated with `uid`.
+func (m *MessageId) freeUUID(uid uuid.UUID) {
+	m.Lock()
+	for id, u := range m.id {
+		if u == uid {
+			delete(m.id, id)
+		}
+	}
+	m.Unlock()
+}
+
 // - MARK: QueueId section.
 
 // GetNewId finds an empty slot and returns a new `uint16`.
@@ -26,6 +26,7 @@ import (
 	"errors"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -204,6 +205,30 @@ func (self *MessageStore) DeleteOut(client string, msg protobase.EDProtocol) boo
 	return true
 }
 
+// ReplaceOut swaps an outgoing packet of a client with `msg` which
+// carries the same UUID ( e.g. PUBLISH -> PUBREL ) while preserving
+// its order. It returns `false` when no such packet exists.
+func (self *MessageStore) ReplaceOut(client string, msg protobase.EDProtocol) bool {
+	self.RLock()
+	if ok := self.nomexist(client); !ok {
+		self.RUnlock()
+		return false
+	}
+	var cid string = uidstr(msg)
+	self.out[client].Lock()
+	if _, ok := self.out[client].messages[cid]; ok == false {
+		self.out[client].Unlock()
+		self.RUnlock()
+
+		return false
+	}
+	self.out[client].messages[cid] = msg
+	self.out[client].Unlock()
+	self.RUnlock()
+
+	return true
+}
+
 // Exists returns a `bool` indicating whether a client is already registered or not.
 func (self *MessageStore) Exists(client string) (ok bool) {
 	self.RLock()
@@ -338,7 +363,7 @@ func (self *MessageStore) GetIDStoreO(client string) (idstore protobase.MSGIDInt
 func (self *MessageStore) GetIDStoreI(client string) (idstore protobase.MSGIDInterface) {
 	self.RLock()
 	defer self.RUnlock()
-	entry, ok := self.out[client]
+	entry, ok := self.in[client]
 	if !ok {
 		return nil
 	}
@@ -346,6 +371,30 @@ func (self *MessageStore) GetIDStoreI(client string) (idstore protobase.MSGIDInt
 	return idstore
 }
 
+// PurgeExpired removes outgoing packets whose lifetime has passed
+// at `now` and releases their message ids. It returns the removed
+// packets mapped to their client.
+func (self *MessageStore) PurgeExpired(now time.Time) (purged map[string][]protobase.EDProtocol) {
+	purged = make(map[string][]protobase.EDProtocol)
+	self.RLock()
+	defer self.RUnlock()
+	for client, entry := range self.out {
+		entry.Lock()
+		for cid, msg := range entry.messages {
+			em, ok := msg.(protobase.ExpirableInterface)
+			if !ok || !em.Expired(now) {
+				continue
+			}
+			delete(entry.messages, cid)
+			delete(entry.order, cid)
+			entry.ids.freeUUID(msg.UUID())
+			purged[client] = append(purged[client], msg)
+		}
+		entry.Unlock()
+	}
+	return purged
+}
+
 // GenSeqID creates and returns a sequence id used to preserve order.
 func (self *MsgEntry) GenSeqID() int {
 	sid := self.counter
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -224,6 +225,87 @@ func TestDeleteOut(t *testing.T) {
 	}
 }
 
+func TestReplaceOut(t *testing.T) {
+	var store *MessageStore = NewInitedMessageStore()
+	var first *protocol.Publish = protocol.NewRawPublish()
+	var second *protocol.Publish = protocol.NewRawPublish()
+
+	store.AddClient(DEFCLN)
+	store.AddOutbound(DEFCLN, first)
+	store.AddOutbound(DEFCLN, second)
+	var pubrel *protocol.Pubrel = protocol.NewRawPubrel()
+	pubrel.Id = first.Id
+	if ok := store.ReplaceOut(DEFCLN, pubrel); !ok {
+		t.Fatal(EINVS)
+	}
+	msgs := store.GetAllOut(DEFCLN)
+	if len(msgs) != 2 {
+		t.Fatal(EINVS, len(msgs))
+	}
+	if _, ok := msgs[0].(*protocol.Pubrel); !ok {
+		t.Fatal("expected *protocol.Pubrel as first packet", msgs[0])
+	}
+	// existing user, non existing packet
+	if ok := store.ReplaceOut(DEFCLN, protocol.NewRawPubrel()); ok {
+		t.Fatal(EINVS)
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	var (
+		store   *MessageStore     = NewInitedMessageStore()
+		now     time.Time         = time.Now()
+		expired *protocol.Publish = protocol.NewRawPublish()
+		alive   *protocol.Publish = protocol.NewRawPublish()
+		forever *protocol.Publish = protocol.NewRawPublish()
+	)
+	store.AddClient(DEFCLN)
+	expired.ExpiresAt = now.Add(-time.Second)
+	alive.ExpiresAt = now.Add(time.Minute)
+	idstore := store.GetIDStoreO(DEFCLN)
+	for _, p := range []*protocol.Publish{expired, alive, forever} {
+		p.Meta.MessageId = idstore.GetNewID(p.UUID())
+		store.AddOutbound(DEFCLN, p)
+	}
+	purged := store.PurgeExpired(now)
+	if len(purged[DEFCLN]) != 1 || purged[DEFCLN][0] != expired {
+		t.Fatal("expected only the expired packet to be purged", purged)
+	}
+	if idstore.IsOccupied(expired.Meta.MessageId) {
+		t.Fatal("expected id of the purged packet to be released")
+	}
+	if msgs := store.GetAllOut(DEFCLN); len(msgs) != 2 {
+		t.Fatal(EINVS, len(msgs))
+	}
+	if purged = store.PurgeExpired(now.Add(time.Hour)); len(purged[DEFCLN]) != 1 {
+		t.Fatal("expected the packet with deadline to be purged", purged)
+	}
+}
+
+func TestIDStores(t *testing.T) {
+	var store *MessageStore = NewInitedMessageStore()
+
+	store.AddClient(DEFCLN)
+	var (
+		istore protobase.MSGIDInterface = store.GetIDStoreI(DEFCLN)
+		ostore protobase.MSGIDInterface = store.GetIDStoreO(DEFCLN)
+		pckt   *protocol.Pong           = protocol.NewRawPong()
+	)
+	if ok := istore.Reserve(10, pckt.UUID()); !ok {
+		t.Fatal(EINVS)
+	}
+	if ok := istore.Reserve(10, pckt.UUID()); ok {
+		t.Fatal("expected false for an occupied id")
+	}
+	if ostore.IsOccupied(10) {
+		t.Fatal("inbound and outbound id stores must be distinct")
+	}
+	istore.Reserve(3, pckt.UUID())
+	if ids := istore.Occupied(); len(ids) != 2 || ids[0] != 3 || ids[1] != 10 {
+		t.Fatal("inconsistent occupied ids", ids)
+	}
+}
+
 // TestGetNewID covers most of `MessageId` methods except a single case
 // in `GetNewID(uuid.UUID)`. Full test case is excluded to a new file
 // because of its long running time (`TestGetNewIDThreaded(t *testing.T)`).
@@ -23,7 +23,9 @@
 package messages
 
 import (
+	"bytes"
 	"errors"
+	"time"
 
 	"github.com/mitghi/protox/protobase"
 )
@@ -38,6 +40,7 @@ var (
 type Retain struct {
 	topic  string
 	packet protobase.EDProtocol
+	expiry time.Time // zero value never expires
 	next   map[string]*Retain
 }
 
@@ -51,7 +54,29 @@ func NewRetain() *Retain {
 // Insert inserts/replace a node and its associated `packet`
 // at `topic` path.
 func (self *Retain) Insert(topic []byte, packet protobase.EDProtocol) (err error) {
-	return self.rinsert(topic, packet)
+	return self.rinsert(topic, packet, time.Time{})
+}
+
+// InsertTTL inserts/replace a node and its associated `packet`
+// at `topic` path which expires after `ttl`. A zero `ttl` never
+// expires.
+func (self *Retain) InsertTTL(topic []byte, packet protobase.EDProtocol, ttl time.Duration) (err error) {
+	var expiry time.Time
+	if ttl > 0 {
+		expiry = time.Now().Add(ttl)
+	}
+	return self.rinsert(topic, packet, expiry)
+}
+
+// Match returns all non-expired packets whose topic matches
+// `filter`. A wildcard level matches exactly one level, or
+// all remaining levels when it is the last one.
+func (self *Retain) Match(filter []byte) (packets []protobase.EDProtocol) {
+	if len(filter) == 0 {
+		return nil
+	}
+	self.rmatch(bytes.Split(filter, []byte{TSEP}), time.Now(), &packets)
+	return packets
 }
 
 // Find finds a node associated with `topic` and returns
@@ -65,12 +90,26 @@ func (self *Retain) Remove(topic []byte) (err error) {
 	return self.rremove(topic)
 }
 
+// Prune removes packets expired at `now` along with nodes
+// left without packets and children. It returns the number
+// of removed packets.
+func (self *Retain) Prune(now time.Time) int {
+	return self.rprune(now)
+}
+
+// Walk calls `fn` with each non-expired packet and its
+// expiry. A zero expiry never expires.
+func (self *Retain) Walk(fn func(packet protobase.EDProtocol, expiry time.Time)) {
+	self.rwalk(time.Now(), fn)
+}
+
 // rinsert is a receiver method that recursively traverse
 // the tree and insert `packet` argument into the appropirate
 // node. It creates missing levels during recursion.
-func (self *Retain) rinsert(topic []byte, packet protobase.EDProtocol) (err error) {
+func (self *Retain) rinsert(topic []byte, packet protobase.EDProtocol, expiry time.Time) (err error) {
 	if len(topic) == 0 {
 		self.packet = packet
+		self.expiry = expiry
 		return nil
 	}
 	nt, rem, err := DNextLevelP(topic)
@@ -86,7 +125,7 @@ func (self *Retain) rinsert(topic []byte, packet protobase.EDProtocol) (err erro
 		self.next[lvl] = n
 	}
 
-	return n.rinsert(rem, packet)
+	return n.rinsert(rem, packet, expiry)
 }
 
 // rfind is a receiver method that recursively traverse
@@ -94,7 +133,7 @@ func (self *Retain) rinsert(topic []byte, packet protobase.EDProtocol) (err erro
 // returns an error to indicate unsuccessfull operation.
 func (self *Retain) rfind(topic []byte) (packet protobase.EDProtocol, err error) {
 	if len(topic) == 0 {
-		if self.packet == nil {
+		if self.packet == nil || self.expired(time.Now()) {
 			return nil, ERINVNode
 		}
 		return self.packet, nil
@@ -121,8 +160,11 @@ func (self *Retain) rremove(topic []byte) error {
 		if self.packet == nil {
 			return ERNotFound
 		}
+		// NOTE
+		// . children may hold retained packets
+		//   of deeper levels, keep them.
 		self.packet = nil
-		self.next = nil
+		self.expiry = time.Time{}
 		return nil
 	}
 	nt, rem, err := DNextLevelP(topic)
@@ -137,9 +179,79 @@ func (self *Retain) rremove(topic []byte) error {
 	if err := n.rremove(rem); err != nil {
 		return err
 	}
-	if len(self.next) == 0 {
+	if n.packet == nil && len(n.next) == 0 {
 		delete(self.next, lvl)
 	}
 
 	return nil
 }
+
+// rmatch is a receiver method that recursively traverse the
+// tree and collects packets of nodes matching `levels`.
+func (self *Retain) rmatch(levels [][]byte, now time.Time, packets *[]protobase.EDProtocol) {
+	if len(levels) == 0 {
+		if self.packet != nil && !self.expired(now) {
+			*packets = append(*packets, self.packet)
+		}
+		return
+	}
+	lvl := levels[0]
+	if len(lvl) == 1 && lvl[0] == TWLDCD {
+		for _, n := range self.next {
+			if len(levels) == 1 {
+				n.rcollect(now, packets)
+				continue
+			}
+			n.rmatch(levels[1:], now, packets)
+		}
+		return
+	}
+	if n, ok := self.next[string(lvl)]; ok {
+		n.rmatch(levels[1:], now, packets)
+	}
+}
+
+// rcollect is a receiver method that collects packets of
+// current node and all of its descendants.
+func (self *Retain) rcollect(now time.Time, packets *[]protobase.EDProtocol) {
+	if self.packet != nil && !self.expired(now) {
+		*packets = append(*packets, self.packet)
+	}
+	for _, n := range self.next {
+		n.rcollect(now, packets)
+	}
+}
+
+// rwalk is a receiver method that recursively traverse the
+// tree and calls `fn` with non-expired packets.
+func (self *Retain) rwalk(now time.Time, fn func(protobase.EDProtocol, time.Time)) {
+	if self.packet != nil && !self.expired(now) {
+		fn(self.packet, self.expiry)
+	}
+	for _, n := range self.next {
+		n.rwalk(now, fn)
+	}
+}
+
+// rprune is a receiver method that recursively traverse the
+// tree and removes expired packets and empty leaf nodes.
+func (self *Retain) rprune(now time.Time) (n int) {
+	if self.packet != nil && self.expired(now) {
+		self.packet = nil
+		self.expiry = time.Time{}
+		n++
+	}
+	for lvl, child := range self.next {
+		n += child.rprune(now)
+		if child.packet == nil && len(child.next) == 0 {
+			delete(self.next, lvl)
+		}
+	}
+	return n
+}
+
+// expired returns a `bool` indicating whether the retained
+// packet of current node is expired at `now`.
+func (self *Retain) expired(now time.Time) bool {
+	return !self.expiry.IsZero() && now.After(self.expiry)
+}
@@ -25,6 +25,7 @@ package messages
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/mitghi/protox/protobase"
 )
@@ -52,7 +53,7 @@ func TestRetain(t *testing.T) {
 		err    error
 	)
 	// insertion
-	err = retain.rinsert(topics[0], packet)
+	err = retain.rinsert(topics[0], packet, time.Time{})
 	if err != nil {
 		t.Fatalf("assertion failed, expected err==nil, got %v.", err)
 	}
@@ -84,7 +85,7 @@ func TestRetain(t *testing.T) {
 	for i, v := range topics {
 		err = retain.rinsert(v, &dummyPacket{
 			name: fmt.Sprintf("%s%d", "test", i),
-		})
+		}, time.Time{})
 		if err != nil {
 			t.Fatalf("assertion failed, expected err==nil, got %v.", err)
 		}
@@ -119,3 +120,67 @@ func TestRetain(t *testing.T) {
 		t.Fatalf(eFATALfmt, "err==nil", err, "", "")
 	}
 }
+
+func TestRetainMatch(t *testing.T) {
+	var (
+		retain *Retain  = NewRetain()
+		topics []string = []string{
+			"a/test/topic",
+			"a/test",
+			"a/other/topic",
+			"b/test/topic",
+		}
+	)
+	for _, v := range topics {
+		if err := retain.Insert([]byte(v), &dummyPacket{name: v}); err != nil {
+			t.Fatalf("assertion failed, expected err==nil, got %v.", err)
+		}
+	}
+	cases := map[string]int{
+		"a/test/topic": 1,
+		"a/*":          3,
+		"a/*/topic":    2,
+		"*/test/topic": 2,
+		"c/*":          0,
+	}
+	for filter, expected := range cases {
+		if packets := retain.Match([]byte(filter)); len(packets) != expected {
+			t.Fatalf(eFATALfmt, fmt.Sprintf("%d packets", expected), len(packets), "filter", filter)
+		}
+	}
+	// removing a parent level keeps its children
+	if err := retain.Remove([]byte("a/test")); err != nil {
+		t.Fatalf(eFATALfmt, "err==nil", err, "", "")
+	}
+	if _, err := retain.Find([]byte("a/test/topic")); err != nil {
+		t.Fatalf(eFATALfmt, "err==nil", err, "", "")
+	}
+}
+
+func TestRetainTTL(t *testing.T) {
+	var retain *Retain = NewRetain()
+	if err := retain.InsertTTL([]byte("a/test"), &dummyPacket{name: "ttl"}, time.Millisecond); err != nil {
+		t.Fatalf("assertion failed, expected err==nil, got %v.", err)
+	}
+	if _, err := retain.Find([]byte("a/test")); err != nil {
+		t.Fatalf(eFATALfmt, "err==nil", err, "", "")
+	}
+	time.Sleep(time.Millisecond * 5)
+	if _, err := retain.Find([]byte("a/test")); err == nil {
+		t.Fatalf(eFATALfmt, "err!=nil", err, "", "")
+	}
+	if packets := retain.Match([]byte("a/*")); len(packets) != 0 {
+		t.Fatalf(eFATALfmt, "0 packets", len(packets), "", "")
+	}
+	// expired packets and their empty levels are pruned
+	retain.Insert([]byte("b/test"), &dummyPacket{name: "kept"})
+	if n := retain.Prune(time.Now()); n != 1 {
+		t.Fatalf(eFATALfmt, "1 pruned packet", n, "", "")
+	}
+	if _, ok := retain.next["a"]; ok {
+		t.Fatalf(eFATALfmt, "pruned level", "a", "", "")
+	}
+	if _, err := retain.Find([]byte("b/test")); err != nil {
+		t.Fatalf(eFATALfmt, "err==nil", err, "", "")
+	}
+}
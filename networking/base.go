@@ -41,8 +41,43 @@ func init() {
 // Error messages
 var (
 	ECLBCONNINVALDISCONN error = errors.New("CLBConn: disconnect req while not online.")
+	EPEERCREDUNAVAIL     error = errors.New("Conn: peer credentials are unavailable.")
 )
 
+// ConnackError is the error reported when broker refuses
+// the connection. `Code` is the result code of `Connack`.
+type ConnackError struct {
+	Code   byte
+	reason string
+}
+
+// Error returns the refusal reason.
+func (e *ConnackError) Error() string {
+	return "CLBConn: connection refused, " + e.reason + "."
+}
+
+// Connection refusal errors
+var (
+	ECONNREFUSED   *ConnackError = &ConnackError{protobase.RESPFAIL, "unspecified error"}
+	ECONNBADCREDS  *ConnackError = &ConnackError{protobase.RESPBADCREDS, "bad credentials"}
+	ECONNNOTAUTH   *ConnackError = &ConnackError{protobase.RESPNOTAUTH, "not authorized"}
+	ECONNBADVER    *ConnackError = &ConnackError{protobase.RESPBADVERSION, "unsupported protocol version"}
+	ECONNBUSY      *ConnackError = &ConnackError{protobase.RESPBUSY, "server busy"}
+	ECONNBANNED    *ConnackError = &ConnackError{protobase.RESPBANNED, "client banned"}
+	ECONNBADCLIENT *ConnackError = &ConnackError{protobase.RESPBADCLID, "client identifier rejected"}
+)
+
+// connackError returns the refusal error corresponding
+// to `code`.
+func connackError(code byte) error {
+	for _, e := range []*ConnackError{ECONNREFUSED, ECONNBADCREDS, ECONNNOTAUTH, ECONNBADVER, ECONNBUSY, ECONNBANNED, ECONNBADCLIENT} {
+		if e.Code == code {
+			return e
+		}
+	}
+	return &ConnackError{code, "unknown reason"}
+}
+
 // Authorization status codes
 const (
 	RESULTFAIL = 0x01
@@ -71,3 +106,13 @@ const (
 // 	RESPNOK    = 0x03
 // 	RESPERR    = 0x04
 // )
+
+// isPubrel returns a `bool` indicating whether a PUBREC coded
+// packet carries the release flag ( i.e. it is a PUBREL ).
+func isPubrel(packet protobase.PacketInterface) bool {
+	data := packet.GetData()
+	if len(data) == 0 {
+		return false
+	}
+	return data[0]&0x0F == protobase.PUBRELFlag
+}
@@ -24,14 +24,18 @@ package networking
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/mitghi/protox/messages"
 	"github.com/mitghi/protox/protobase"
 	"github.com/mitghi/protox/protocol"
 )
@@ -41,7 +45,14 @@ var _ protobase.ProtoClientConnection = (*CLBConnection)(nil)
 
 // Client Connector error messages
 var (
-	ECLBSendFailure = errors.New("protocol(clientConnector): unable to send packet.")
+	ECLBSendFailure         = errors.New("protocol(clientConnector): unable to send packet.")
+	ECLBPacketTooLarge      = errors.New("protocol(clientConnector): packet exceeds broker maximum packet size.")
+	ECLBReceiveMaxExceeded  = errors.New("protocol(clientConnector): too many unacknowledged publishes.")
+	ECLBRetainUnavailable   = errors.New("protocol(clientConnector): broker does not support retained messages.")
+	ECLBWildcardUnavailable = errors.New("protocol(clientConnector): broker does not support wildcard subscriptions.")
+	ECLBNoResponders        = errors.New("protocol(clientConnector): no responders are available.")
+	ECLBRequestTimeout      = errors.New("protocol(clientConnector): request timed out.")
+	ECLBRequestFailed       = errors.New("protocol(clientConnector): request is rejected by broker.")
 )
 
 // Constants
@@ -69,6 +80,18 @@ type CLBConnection struct {
 	stateOpts      map[byte]protobase.OptionInterface
 	clbpub         map[uint16]func(protobase.OptionInterface, protobase.MsgInterface)
 	clbsub         map[uint16]func(protobase.OptionInterface, protobase.MsgInterface)
+	clbunsub       map[uint16]func(protobase.OptionInterface, protobase.MsgInterface)
+	clbrel         map[uint16]protobase.MsgInterface
+	clbque         map[uint16]func(*QAck)
+	clbreq         map[string]func(*Queue)
+	will           protobase.MsgInterface
+	willDelay      uint32
+	connErr        error
+	caps           *protocol.Capabilities
+	sessionId      string
+	sessionPresent bool
+	codecs         []byte
+	versions       []string
 }
 
 func (cg *CLBConnection) SetupTLSConfig(certPath string, keyPath string) error {
@@ -95,6 +118,12 @@ func (cg *CLBConnection) dialRemoteAddr(addr string, isTLS bool) (net.Conn, erro
 		conn net.Conn
 		err  error
 	)
+	if isWSAddr(addr) {
+		return cg.dialWebSocket(addr)
+	}
+	if path, ok := unixPath(addr); ok {
+		return net.Dial("unix", path)
+	}
 	if isTLS {
 		conn, err = tls.Dial("tcp", addr, cg.tlsconf)
 	} else {
@@ -133,6 +162,10 @@ func NewClientConnection(addr string) (clbc *CLBConnection) {
 		stateOpts:      make(map[byte]protobase.OptionInterface),
 		clbpub:         make(map[uint16]func(protobase.OptionInterface, protobase.MsgInterface)),
 		clbsub:         make(map[uint16]func(protobase.OptionInterface, protobase.MsgInterface)),
+		clbunsub:       make(map[uint16]func(protobase.OptionInterface, protobase.MsgInterface)),
+		clbrel:         make(map[uint16]protobase.MsgInterface),
+		clbque:         make(map[uint16]func(*QAck)),
+		clbreq:         make(map[string]func(*Queue)),
 	}
 	// set the state to client genesis
 	clbc.State = NewCGenesis(clbc)
@@ -176,6 +209,11 @@ func (clbc *CLBConnection) SendMessage(pb protobase.MsgInterface) (err error) {
 	)
 	msg.Message = ([]byte)(message)
 	msg.Topic = topic
+	msg.Meta.Props = pb.Properties()
+	msg.Expiry = pb.Expiry()
+	if err = clbc.compress(msg); err != nil {
+		return err
+	}
 	if qos > 0 {
 		logger.FDebug(fn, "* [QoS] QoS>0 in [SendMessage].", "qos", qos)
 		puid = (msg.Id)
@@ -248,7 +286,7 @@ func (clbc *CLBConnection) Send(packet *Packet) {
 	clbc.SendLock.Lock()
 	if clbc.SendChan != nil {
 		if clbc.pinger != nil {
-			clbc.pinger.Reset(time.Duration(time.Second * time.Duration(clbc.heartbeat)))
+			clbc.pinger.Reset(clbc.keepAlive())
 		}
 		clbc.SendChan <- packet
 	} else {
@@ -261,7 +299,7 @@ func (clbc *CLBConnection) Send(packet *Packet) {
 func (clbc *CLBConnection) sendHandler() {
 	const fname = "sendHandler"
 	var (
-		dur time.Duration = time.Second * time.Duration(clbc.heartbeat)
+		dur time.Duration = clbc.keepAlive()
 	)
 	defer func() {
 		logger.FInfo(fname, "+ [WorkGroup] decrementing workgroup.")
@@ -296,7 +334,7 @@ func (clbc *CLBConnection) uniSendHandler() {
 		fname = "uniSendHandler"
 	)
 	var (
-		dur time.Duration = time.Second * time.Duration(clbc.heartbeat)
+		dur time.Duration = clbc.keepAlive()
 	)
 	defer func() {
 		logger.FDebug(fname, "+ [WorkGroup] decrementing workgroup")
@@ -423,6 +461,8 @@ func (clbc *CLBConnection) Handle(_ protobase.PacketInterface) {
 		clbc.client.Disconnected(protobase.PURejected)
 		return
 	}
+	// broker keepalive takes precedence
+	dur = clbc.keepAlive()
 	// send first ping packet
 	// set timer to routin ping delivery
 	// run I/O coroutines
@@ -562,10 +602,26 @@ func (clbc *CLBConnection) dispatch(packet protobase.PacketInterface) {
 		clbc.State.OnSUBSCRIBE(packet)
 	case protobase.PSUBACK:
 		clbc.State.OnSUBACK(packet)
+	case protobase.PUNSUBSCRIBE:
+		clbc.State.OnUNSUBSCRIBE(packet)
+	case protobase.PUNSUBACK:
+		clbc.State.OnUNSUBACK(packet)
 	case protobase.PPUBLISH:
 		clbc.State.OnPUBLISH(packet)
 	case protobase.PPUBACK:
 		clbc.State.OnPUBACK(packet)
+	case protobase.PPUBREC:
+		if isPubrel(packet) {
+			clbc.State.OnPUBREL(packet)
+		} else {
+			clbc.State.OnPUBREC(packet)
+		}
+	case protobase.PPUBCOMP:
+		clbc.State.OnPUBCOMP(packet)
+	case protobase.PQUEUE:
+		clbc.State.OnQUEUE(packet)
+	case protobase.PQUEUEACK:
+		clbc.State.OnQueueAck(packet)
 	case protobase.PPING:
 		clbc.State.OnPING(packet)
 	case protobase.PPONG:
@@ -587,6 +643,162 @@ func (clbc *CLBConnection) SetNetConnection(conn net.Conn) {
 	clbc.protocon.Reader = bufio.NewReader(clbc.protocon.Conn)
 }
 
+// ConnectError returns the reason of the last connection refusal
+// ( a `*ConnackError` ), or nil when the broker accepted it.
+func (clbc *CLBConnection) ConnectError() error {
+	clbc.protocon.RLock()
+	defer clbc.protocon.RUnlock()
+	return clbc.connErr
+}
+
+// setConnectError sets the connection refusal reason.
+func (clbc *CLBConnection) setConnectError(err error) {
+	clbc.protocon.Lock()
+	clbc.connErr = err
+	clbc.protocon.Unlock()
+}
+
+// Capabilities returns broker limits and features advertised
+// on connection. It is nil for brokers not advertising them.
+func (clbc *CLBConnection) Capabilities() *protocol.Capabilities {
+	clbc.protocon.RLock()
+	defer clbc.protocon.RUnlock()
+	return clbc.caps
+}
+
+// setCapabilities sets broker limits and features.
+func (clbc *CLBConnection) setCapabilities(caps *protocol.Capabilities) {
+	clbc.protocon.Lock()
+	clbc.caps = caps
+	clbc.protocon.Unlock()
+}
+
+// SetVersions sets the protocol versions offered to the broker in
+// ascending order, nil offers `protobase.ProtoVersions`. It takes
+// effect on next connect.
+func (clbc *CLBConnection) SetVersions(versions []string) {
+	clbc.protocon.Lock()
+	clbc.versions = versions
+	clbc.protocon.Unlock()
+}
+
+// SetCompression sets the payload compression codecs offered to
+// the broker, preferred first. It takes effect on next connect.
+func (clbc *CLBConnection) SetCompression(codecs []byte) {
+	clbc.protocon.Lock()
+	clbc.codecs = codecs
+	clbc.protocon.Unlock()
+}
+
+// Compression returns the payload compression codec negotiated
+// with the broker.
+func (clbc *CLBConnection) Compression() byte {
+	if caps := clbc.Capabilities(); caps != nil {
+		return caps.Compression
+	}
+	return protobase.COMPNone
+}
+
+// compress compresses the payload of `pb` using the negotiated codec.
+func (clbc *CLBConnection) compress(pb *Publish) (err error) {
+	codec := clbc.Compression()
+	if codec == protobase.COMPNone || len(pb.Message) == 0 {
+		return nil
+	}
+	if pb.Message, err = protocol.Compress(codec, pb.Message); err != nil {
+		return err
+	}
+	pb.Compression = codec
+	return nil
+}
+
+// keepAlive returns the ping interval, the broker keepalive
+// takes precedence when advertised.
+func (clbc *CLBConnection) keepAlive() time.Duration {
+	if caps := clbc.Capabilities(); caps != nil && caps.ServerKeepAlive > 0 {
+		return time.Second * time.Duration(caps.ServerKeepAlive)
+	}
+	return time.Second * time.Duration(clbc.heartbeat)
+}
+
+// checkPublish returns an error when `pb` violates the limits
+// advertised by the broker. It downgrades QoS to the maximum.
+func (clbc *CLBConnection) checkPublish(pb *Publish) error {
+	caps := clbc.Capabilities()
+	if caps == nil {
+		return nil
+	}
+	if pb.Meta.Qos > caps.MaxQoS {
+		pb.Meta.Qos = caps.MaxQoS
+	}
+	if pb.Meta.Ret && !caps.RetainAvailable {
+		return ECLBRetainUnavailable
+	}
+	if pb.Meta.Qos > 0 && caps.ReceiveMax > 0 {
+		clbc.clblock.RLock()
+		inflight := len(clbc.clbpub)
+		clbc.clblock.RUnlock()
+		if inflight >= int(caps.ReceiveMax) {
+			return ECLBReceiveMaxExceeded
+		}
+	}
+	if caps.MaxPacketSize > 0 {
+		// encode a copy, message identifier is not assigned yet
+		// but does not change the size.
+		var (
+			probe Publish            = *pb
+			meta  protocol.ProtoMeta = *pb.Meta
+		)
+		probe.Header, probe.Encoded, probe.Meta = new(bytes.Buffer), nil, &meta
+		if err := probe.Encode(); err != nil {
+			return err
+		}
+		if uint32(probe.Encoded.Len()) > caps.MaxPacketSize {
+			return ECLBPacketTooLarge
+		}
+	}
+	return nil
+}
+
+// SetSessionId sets the session token presented to the broker
+// on next connect to resume the session.
+func (clbc *CLBConnection) SetSessionId(sid string) {
+	clbc.protocon.Lock()
+	clbc.sessionId = sid
+	clbc.protocon.Unlock()
+}
+
+// SessionId returns the session token issued by the broker.
+func (clbc *CLBConnection) SessionId() string {
+	clbc.protocon.RLock()
+	defer clbc.protocon.RUnlock()
+	return clbc.sessionId
+}
+
+// SessionPresent returns whether the broker resumed the previous
+// session ( i.e. subscriptions are restored and need not to be
+// repeated ).
+func (clbc *CLBConnection) SessionPresent() bool {
+	clbc.protocon.RLock()
+	defer clbc.protocon.RUnlock()
+	return clbc.sessionPresent
+}
+
+// setSession sets the session token and its presence flag.
+func (clbc *CLBConnection) setSession(sid string, present bool) {
+	clbc.protocon.Lock()
+	clbc.sessionId, clbc.sessionPresent = sid, present
+	clbc.protocon.Unlock()
+}
+
+// SetWill sets the last will which broker publishes when the
+// connection ends without a `Disconnect` packet, after `delay`
+// seconds. Nil `will` removes it. It takes effect on next connect.
+func (clbc *CLBConnection) SetWill(will protobase.MsgInterface, delay uint32) {
+	clbc.will = will
+	clbc.willDelay = delay
+}
+
 func (clbc *CLBConnection) SetMessageStorage(storage protobase.MessageBox) {
 	clbc.storage = storage
 }
@@ -647,6 +859,7 @@ func (clbc *CLBConnection) SendRedelivery() {
 			case *Publish:
 				tmp := p.(*Publish)
 				tmp.Meta.Dup = true
+				tmp.Meta.WideLength = clbc.wideLength
 				/* d e b u g */
 				// tmp.Meta.Qos = p.QoS()
 				/* d e b u g */
@@ -655,6 +868,9 @@ func (clbc *CLBConnection) SendRedelivery() {
 				if err != nil {
 					logger.FWarnf(fn, "- [CLBConnection] unable to encode publish packet. error:", err)
 				}
+			case *Pubrel:
+				// NOTE: already encoded, resume QoS 2 handshake as is.
+				logger.FDebug(fn, "+ [Redeliver] resending pubrel.", "msgid", p.(*Pubrel).Meta.MessageId)
 			case *Subscribe:
 				logger.Warn("- [sendRedelivery] PACKET TYPE IS [Subscribe]")
 			default:
@@ -677,6 +893,29 @@ func (clbc *CLBConnection) MakeEnvelope(route string, payload []byte, qos byte,
 // - MARK: Protocol communication routines section.
 
 func (clbc *CLBConnection) Publish(topic string, message []byte, qos byte, fn func(protobase.OptionInterface, protobase.MsgInterface)) (err error) {
+	return clbc.publish(topic, message, qos, false, 0, 0, nil, fn)
+}
+
+// PublishWithProperties publishes a message carrying user properties
+// such as content-type, correlation id and reply-to ( see `protobase.PROP*` ).
+func (clbc *CLBConnection) PublishWithProperties(topic string, message []byte, qos byte, props protobase.Properties, fn func(protobase.OptionInterface, protobase.MsgInterface)) (err error) {
+	return clbc.publish(topic, message, qos, false, 0, 0, props, fn)
+}
+
+// PublishWithExpiry publishes a message which expires after `expiry`
+// seconds. The broker drops it rather than delivering it stale.
+func (clbc *CLBConnection) PublishWithExpiry(topic string, message []byte, qos byte, expiry uint32, fn func(protobase.OptionInterface, protobase.MsgInterface)) (err error) {
+	return clbc.publish(topic, message, qos, false, 0, expiry, nil, fn)
+}
+
+// PublishRetain publishes a message which the broker retains for `topic`
+// and replays to future subscribers. It expires after `ttl` seconds, zero
+// means forever. An empty `message` clears the retained message.
+func (clbc *CLBConnection) PublishRetain(topic string, message []byte, qos byte, ttl uint32, fn func(protobase.OptionInterface, protobase.MsgInterface)) (err error) {
+	return clbc.publish(topic, message, qos, true, ttl, 0, nil, fn)
+}
+
+func (clbc *CLBConnection) publish(topic string, message []byte, qos byte, retain bool, ttl uint32, expiry uint32, props protobase.Properties, fn func(protobase.OptionInterface, protobase.MsgInterface)) (err error) {
 	const _fn string = "Publish"
 	var (
 		clid    string            = clbc.GetClient().GetIdentifier()
@@ -689,9 +928,23 @@ func (clbc *CLBConnection) Publish(topic string, message []byte, qos byte, fn fu
 	// set topic and message
 	pb.Topic = topic
 	pb.Message = message
+	pb.Meta.WideLength = clbc.wideLength
+	pb.Meta.Ret = retain
+	pb.RetainTTL = ttl
+	pb.Expiry = expiry
+	pb.Meta.Props = props
+	pb.Meta.Qos = qos
+	if err = clbc.compress(pb); err != nil {
+		logger.FWarnf(_fn, "- [CLBConnection] unable to compress payload of topic(%s). error: %s", topic, err)
+		return err
+	}
+	if err = clbc.checkPublish(pb); err != nil {
+		logger.FWarnf(_fn, "- [CLBConnection] refusing to publish on topic(%s). error: %s", topic, err)
+		return err
+	}
+	qos = pb.Meta.Qos
 	// handle quality of service > 0
 	if qos > 0 {
-		pb.Meta.Qos = qos
 		idstore = clbc.storage.GetIDStoreO()
 		pb.Meta.MessageId = idstore.GetNewID(puid)
 		logger.FDebugf(_fn, "* [Publish<-]CLBConnection] with id (%d).", pb.Meta.MessageId)
@@ -737,6 +990,14 @@ func (clbc *CLBConnection) Subscribe(topic string, qos byte, fn func(protobase.O
 	puid = (sb.Id)
 	// set the topic
 	sb.Topic = topic
+	if caps := clbc.Capabilities(); caps != nil {
+		if !caps.WildcardAvailable && strings.IndexByte(topic, messages.TWLDCD) >= 0 {
+			return ECLBWildcardUnavailable
+		}
+		if qos > caps.MaxQoS {
+			qos = caps.MaxQoS
+		}
+	}
 	if qos > 0 {
 		sb.Meta.Qos = qos
 		idstore = clbc.storage.GetIDStoreO()
@@ -772,34 +1033,281 @@ func (clbc *CLBConnection) Subscribe(topic string, qos byte, fn func(protobase.O
 	return nil
 }
 
+func (clbc *CLBConnection) Unsubscribe(topic string, qos byte, fn func(protobase.OptionInterface, protobase.MsgInterface)) (err error) {
+	const _fn string = "Unsubscribe"
+	var (
+		clid    string                   = clbc.GetClient().GetIdentifier() // client identifier
+		usb     *UnSubscribe             = protocol.NewRawUnSubscribe()     // unsubscribe packet
+		puid    uuid.UUID                                                   // packet UID
+		idstore protobase.MSGIDInterface                                    // identifier provider
+		packet  *Packet                                                     // empty packet for manipulating
+	)
+	logger.FDebugf(_fn, "* [Unsubscribe/QoS] qos is (%b) [Topic] is (%s).", qos, topic)
+	puid = (usb.Id)
+	// set the topic
+	usb.Topic = topic
+	if qos > 0 {
+		usb.Meta.Qos = qos
+		idstore = clbc.storage.GetIDStoreO()
+		usb.Meta.MessageId = idstore.GetNewID(puid)
+		logger.FDebugf(_fn, "* [Unsubscribe->][CLBConnection] with id (%d).", usb.Meta.MessageId)
+		if !clbc.storage.AddOutbound(usb) {
+			logger.FWarn(_fn, "- [NOTICE][CLBConnection] unable to add outbound packet to [MessageBox] for userId(%s).", clid)
+			return ECLBSendFailure
+		}
+		// write to callback map
+		clbc.clblock.Lock()
+		clbc.clbunsub[usb.Meta.MessageId] = fn
+		clbc.clblock.Unlock()
+	}
+	err = usb.Encode()
+	if err != nil {
+		logger.FWarnf(_fn, "- [CLBConnection] unable to encode unsubscribe packet. error:", err)
+		return ECLBSendFailure
+	}
+	packet = usb.GetPacket().(*Packet)
+	if clbc.GetStatus() == STATONLINE {
+		clbc.Send(packet)
+		logger.Infof("* [Unsubscribe->] Unsubscribing from [Topic](%s) with [QoS](%b), [MessageId](%d).",
+			topic, qos, usb.Meta.MessageId)
+		if qos == 0 && fn != nil {
+			fn(nil, clbc.MakeEnvelope(topic, nil, qos, usb.Meta.MessageId, protobase.MDInbound))
+		}
+	} else {
+		logger.FWarn(_fn, "- [Unsubscribe] dropping packet due to status.")
+		return ECLBSendFailure
+	}
+	return nil
+}
+
+// Queue sends a queue command to the broker. `protocol.QAInitialize`,
+// `protocol.QADestroy` and `protocol.QADrain` create, destroy and
+// start consuming the queue at `address`, `protocol.QANone` enqueues
+// `message`.
 func (clbc *CLBConnection) Queue(action protobase.QAction, address string, returnPath string, mark []byte, message []byte) (err error) {
-	// TODO
-	const _fn string = "Queue"
+	return clbc.QueueWithAck(action, address, returnPath, mark, message, nil)
+}
+
+// QueueWithAck sends a queue command like `Queue`, `fn` is invoked with
+// the acknowledgement code ( `protocol.QAcOK` or `protocol.QAcERR` )
+// once the broker replies.
+func (clbc *CLBConnection) QueueWithAck(action protobase.QAction, address string, returnPath string, mark []byte, message []byte, fn func(byte)) (err error) {
+	const _fn string = "QueueWithAck"
 	logger.FDebugf(_fn, "* [Queue][CLBConnection] invoking with Address(%s), ReturnPath(%s), Mark(%s), Message(%s).",
 		address, returnPath, string(mark), string(message))
-	var (
-		q *protocol.Queue = protocol.NewQueue()
-		p *Packet
-	)
+	var q *protocol.Queue = protocol.NewQueue()
 	q.Action = action
 	q.Address = address
 	q.ReturnPath = returnPath
 	q.Mark = mark
 	q.Message = message
-	q.Meta.MessageId = 1
+	return clbc.sendQueue(q, func(qa *QAck) {
+		if fn != nil {
+			fn(qa.Code)
+		}
+	})
+}
+
+// sendQueue sends `q` with a new message id, `fn` is invoked with its
+// acknowledgement.
+func (clbc *CLBConnection) sendQueue(q *protocol.Queue, fn func(*QAck)) (err error) {
+	var (
+		idstore protobase.MSGIDInterface
+		p       *Packet
+	)
+	if clbc.GetStatus() != STATONLINE {
+		return ECLBSendFailure
+	}
+	q.Meta.WideLength = clbc.wideLength
+	idstore = clbc.storage.GetIDStoreO()
+	q.Meta.MessageId = idstore.GetNewID(q.Id)
 	if err = q.Encode(); err != nil {
+		idstore.FreeId(q.Meta.MessageId)
 		return err
 	}
+	// write to callback map
+	clbc.clblock.Lock()
+	clbc.clbque[q.Meta.MessageId] = fn
+	clbc.clblock.Unlock()
 	p = q.GetPacket().(*Packet)
-	if clbc.GetStatus() == STATONLINE {
-		clbc.Send(p)
-		logger.Infof("* [Queue->] Sending Queue request to Address(%s) with ReturnPath(%s).", address, returnPath)
-	} else {
-		return ECLBSendFailure
-	}
+	clbc.Send(p)
+	logger.Infof("* [Queue->] Sending Queue request to Address(%s) with ReturnPath(%s), [MessageId](%d).", q.Address, q.ReturnPath, q.Meta.MessageId)
 	return nil
 }
 
+// ackQueue acknowledges the queue message `msgid` received from the
+// broker.
+func (clbc *CLBConnection) ackQueue(msgid uint16) {
+	const fn string = "ackQueue"
+	qa := protocol.NewRawQAck()
+	qa.Code, qa.Meta.MessageId = protocol.QAcOK, msgid
+	if err := qa.Encode(); err != nil {
+		logger.FWarnf(fn, "- [QAck] unable to encode acknowledgement of MessageId(%d). error: %s", msgid, err)
+		return
+	}
+	clbc.Send(qa.GetPacket().(*Packet))
+}
+
+// await registers `fn` as the handler of replies sent to a new return
+// path and returns the path with its correlation mark. The returned
+// function releases the return path.
+func (clbc *CLBConnection) await(fn func(*Queue)) (returnPath string, mark []byte, release func()) {
+	id := uuid.New()
+	returnPath = protocol.QReplyPrefix + id.String()
+	clbc.clblock.Lock()
+	clbc.clbreq[returnPath] = fn
+	clbc.clblock.Unlock()
+	return returnPath, id[:], func() {
+		clbc.clblock.Lock()
+		delete(clbc.clbreq, returnPath)
+		clbc.clblock.Unlock()
+	}
+}
+
+// Request sends `payload` to the responders consuming the queue at
+// `address` and blocks until the reply of the one it is routed to
+// arrives. The reply is correlated by a generated return path and
+// mark. It returns `ECLBNoResponders` when the queue has no consumer
+// and `ECLBRequestTimeout` when the broker times the request out or
+// `ctx` expires first. It must not be called from message handlers as
+// they run on the receiving routine.
+func (clbc *CLBConnection) Request(ctx context.Context, address string, payload []byte) ([]byte, error) {
+	var (
+		replies chan *Queue = make(chan *Queue, 1)
+		acks    chan byte   = make(chan byte, 1)
+		q       *Queue      = protocol.NewQueue()
+	)
+	returnPath, mark, release := clbc.await(func(reply *Queue) {
+		select {
+		case replies <- reply:
+		default:
+		}
+	})
+	defer release()
+	q.Action, q.Address, q.ReturnPath, q.Mark, q.Message = protocol.QANone, address, returnPath, mark, payload
+	err := clbc.sendQueue(q, func(qa *QAck) {
+		acks <- qa.Code
+	})
+	if err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case code := <-acks:
+			if err := queueError(code); err != nil {
+				return nil, err
+			}
+		case reply := <-replies:
+			if reply.Action == protocol.QATimeout {
+				return nil, ECLBRequestTimeout
+			}
+			return reply.Message, nil
+		case <-ctx.Done():
+			return nil, requestError(ctx)
+		}
+	}
+}
+
+// Gather sends `payload` to all subscribers of `address` and collects
+// their replies until `quorum` replies arrived, all subscribers replied,
+// the broker times the request out or `ctx` expires. A non positive
+// `quorum` waits for all of them. It
+// returns the replies and the number of subscribers which did not
+// reply, `ECLBRequestTimeout` is only returned when the broker did not
+// acknowledge the request in time. It must not be called from message
+// handlers.
+func (clbc *CLBConnection) Gather(ctx context.Context, address string, payload []byte, quorum int) (replies [][]byte, missing int, err error) {
+	var (
+		lock       sync.Mutex
+		received   [][]byte
+		notify     chan struct{} = make(chan struct{}, 1)
+		acks       chan *QAck    = make(chan *QAck, 1)
+		q          *Queue        = protocol.NewQueue()
+		responders int           = -1
+		expired    bool
+		done       bool
+	)
+	returnPath, mark, release := clbc.await(func(reply *Queue) {
+		lock.Lock()
+		if reply.Action == protocol.QATimeout {
+			expired = true
+		} else {
+			received = append(received, reply.Message)
+		}
+		lock.Unlock()
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	})
+	defer release()
+	q.Action, q.Address, q.ReturnPath, q.Mark, q.Message = protocol.QAScatter, address, returnPath, mark, payload
+	err = clbc.sendQueue(q, func(qa *QAck) {
+		acks <- qa
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for {
+		lock.Lock()
+		replies, done = received, expired
+		lock.Unlock()
+		if responders >= 0 {
+			if quorum <= 0 || quorum > responders {
+				quorum = responders
+			}
+			if len(replies) >= quorum || done {
+				return replies, responders - len(replies), nil
+			}
+		}
+		select {
+		case qa := <-acks:
+			if err = queueError(qa.Code); err != nil {
+				return nil, 0, err
+			}
+			responders = int(qa.Responders)
+		case <-notify:
+		case <-ctx.Done():
+			if responders < 0 {
+				return nil, 0, requestError(ctx)
+			}
+			return replies, responders - len(replies), nil
+		}
+	}
+}
+
+// queueError returns the error of a queue acknowledgement `code`.
+func queueError(code byte) error {
+	switch code {
+	case protocol.QAcOK:
+		return nil
+	case protocol.QAcNoResponders:
+		return ECLBNoResponders
+	default:
+		return ECLBRequestFailed
+	}
+}
+
+// requestError returns the error of a request whose `ctx` is done.
+func requestError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ECLBRequestTimeout
+	}
+	return ctx.Err()
+}
+
+// reply hands the reply or timeout in `q` to the request waiting on
+// its return path and returns whether there is one.
+func (clbc *CLBConnection) reply(q *Queue) bool {
+	clbc.clblock.RLock()
+	fn, ok := clbc.clbreq[q.Address]
+	clbc.clblock.RUnlock()
+	if ok {
+		fn(q)
+	}
+	return ok
+}
+
 func (clbc *CLBConnection) HandleDefault(packet protobase.PacketInterface) (status bool) {
 	const fn string = "HandleDefault"
 	logger.Infof(fn, "* [CLBConnection] is not implemented.")
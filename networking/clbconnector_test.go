@@ -0,0 +1,123 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package networking
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mitghi/protox/client"
+	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/protocol"
+)
+
+// newOnlineClient returns an online client connection whose packets
+// are sent to its send channel.
+func newOnlineClient() (*CLBConnection, *COnline) {
+	clbc := NewClientConnection("test")
+	clbc.SendChan = make(chan *Packet, 8)
+	clbc.SetMessageStorage(messages.NewMessageBox())
+	clbc.SetStatus(STATONLINE)
+	return clbc, NewCOnline(clbc)
+}
+
+// sentQueue decodes the next queue packet sent by `clbc`.
+func sentQueue(t *testing.T, clbc *CLBConnection) *Queue {
+	t.Helper()
+	select {
+	case p := <-clbc.SendChan:
+		q := NewQueueWide(p, false)
+		if q == nil {
+			t.Fatal("expected a queue packet.", p)
+		}
+		return q
+	case <-time.After(time.Second):
+		t.Fatal("expected a queue packet to be sent.")
+	}
+	return nil
+}
+
+func TestRequestTimeout(t *testing.T) {
+	var (
+		clbc, co = newOnlineClient()
+		errs     = make(chan error, 1)
+	)
+	go func() {
+		// ctx without deadline, the broker reports the timeout
+		_, err := clbc.Request(context.Background(), "svc", []byte("ping"))
+		errs <- err
+	}()
+	q := sentQueue(t, clbc)
+	qa := protocol.NewRawQAck()
+	qa.Code, qa.Meta.MessageId = protocol.QAcOK, q.Meta.MessageId
+	qa.Encode()
+	co.OnQueueAck(qa.GetPacket())
+	tq := protocol.NewRawQueue()
+	tq.Action, tq.Address, tq.Mark = protocol.QATimeout, q.ReturnPath, q.Mark
+	tq.Encode()
+	co.OnQUEUE(tq.GetPacket())
+	select {
+	case err := <-errs:
+		if err != ECLBRequestTimeout {
+			t.Fatal("expected request to time out.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected request to return on broker timeout.")
+	}
+	if len(clbc.clbreq) != 0 {
+		t.Fatal("expected return path to be released.", clbc.clbreq)
+	}
+}
+
+func TestQueueAck(t *testing.T) {
+	clbc, co := newOnlineClient()
+	co.SetClient(client.NewClient("test", "", "test"))
+	q := protocol.NewRawQueue()
+	q.Address, q.Message = "jobs/build", []byte("job")
+	q.Meta.MessageId = 7
+	q.Encode()
+	co.OnQUEUE(q.GetPacket())
+	select {
+	case p := <-clbc.SendChan:
+		qa := NewQAck(p)
+		if qa == nil || qa.Code != protocol.QAcOK || qa.Meta.MessageId != 7 {
+			t.Fatal("inconsistent queue message acknowledgement.", qa)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected queue message to be acknowledged.")
+	}
+}
+
+func TestSubscribeWildcardUnavailable(t *testing.T) {
+	clbc, _ := newOnlineClient()
+	clbc.SetClient(client.NewClient("test", "", "test"))
+	clbc.setCapabilities(&protocol.Capabilities{MaxQoS: 2})
+	if err := clbc.Subscribe("sensors/*", 1, nil); err != ECLBWildcardUnavailable {
+		t.Fatal("expected wildcard subscription to be rejected.", err)
+	}
+	clbc.setCapabilities(&protocol.Capabilities{MaxQoS: 2, WildcardAvailable: true})
+	if err := clbc.Subscribe("sensors/*", 1, nil); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+}
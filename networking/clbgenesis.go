@@ -144,16 +144,9 @@ func (cg *CGenesis) OnCONNACK(packet protobase.PacketInterface) {
 	// 	// . forward to error handler
 	// }
 	logger.FTrace(1, fn, "* [ConnAck] connack content.", p)
-	if p.ResultCode == protobase.RESPFAIL {
-		// TODO
-		// . refactor into separate routine performing pattern matching
-		//   and set the status from the provided table
-		// Fail. invalid credentials.
-		logger.FTrace(1, fn, "- [Credentials] INVALID CREDENTIALS.")
-		cg.Conn.SetStatus(STATERR)
-		return
-	} else if p.ResultCode == protobase.RESPOK {
+	if p.ResultCode == protobase.RESPOK {
 		// successfully validated.
+		cg.Conn.setConnectError(nil)
 		cg.Conn.SetStatus(STATONLINE)
 		logger.FTrace(1, fn, "+ [Credentials] are valid and [Client] is now (Online).")
 		// safe to remove connection state options
@@ -164,6 +157,11 @@ func (cg *CGenesis) OnCONNACK(packet protobase.PacketInterface) {
 		// the packet.
 		caopt = NewConnackOpts()
 		caopt.ParseFrom(p)
+		cg.Conn.wideLength = caopt.WideLength
+		// respect broker limits from now on
+		cg.Conn.setCapabilities(caopt.Caps)
+		// keep session token for resuming on reconnect
+		cg.Conn.setSession(caopt.SessionId, caopt.HasSession)
 		// store packet options for current CCONNACK state
 		cg.Conn.stateOpts[protobase.CCONNACK] = caopt
 		// push to next state
@@ -171,15 +169,16 @@ func (cg *CGenesis) OnCONNACK(packet protobase.PacketInterface) {
 		// clean current state
 		cg.cleanUp()
 		return
-	} else {
-		// NOTE:
-		// TODO:
-		// . THIS IS FATAL. check invalid codes
-		logger.FTracef(1, fn, "- [Connack/Resp] unknown response-code(%b) in packet.", p.ResultCode)
-		// clean current state
-		cg.cleanUp()
-		return
 	}
+	// connection is refused, keep the reason
+	// for the client.
+	err := connackError(p.ResultCode)
+	logger.FTracef(1, fn, "- [Connack/Resp] connection refused with response-code(%d). error: %s", p.ResultCode, err)
+	cg.Conn.setConnectError(err)
+	cg.Conn.SetStatus(STATERR)
+	// clean current state
+	cg.cleanUp()
+	return
 }
 
 // HandleDefault is the first function invoked in `CGenesis` when a
@@ -240,6 +239,26 @@ func (cg *CGenesis) HandleDefault(packet protobase.PacketInterface) (ok bool) {
 	Conn.protocon.Unlock()
 
 	p.Username, p.Password, p.ClientId = newcl.GetCreds().GetCredentials()
+	// request wide payload fields, older brokers ignore it
+	p.Meta.WideLength = true
+	// present session token to resume the session
+	p.SessionId = Conn.SessionId()
+	// offer compression codecs, older brokers ignore them
+	Conn.protocon.RLock()
+	p.Codecs = Conn.codecs
+	versions := Conn.versions
+	Conn.protocon.RUnlock()
+	if versions == nil {
+		versions = protobase.ProtoVersions
+	}
+	offerVersions(p, versions)
+	if will := Conn.will; will != nil {
+		p.WillTopic = will.Envelope().Route()
+		p.WillMessage = will.Envelope().Payload()
+		p.WillQoS = will.QoS()
+		p.WillRetain = will.Retain()
+		p.WillDelay = Conn.willDelay
+	}
 	if err = p.Encode(); err != nil {
 		logger.FFatal("HandleDefault", "- [Encode] cannot encode in [CGenesis].", err)
 		ok = false
@@ -262,3 +281,18 @@ func (cg *CGenesis) HandleDefault(packet protobase.PacketInterface) (ok bool) {
 	// . do proper cleanup before exiting
 	// cg.cleanUp()
 }
+
+// offerVersions offers `versions`, given in ascending order, to the
+// broker most recent first. The list is sent even when it holds a
+// single version, it tells the broker that reason codes are known.
+func offerVersions(p *Connect, versions []string) {
+	n := len(versions)
+	if n == 0 {
+		return
+	}
+	p.Version = versions[n-1]
+	p.Versions = make([]string, 0, n)
+	for i := n - 1; i >= 0; i-- {
+		p.Versions = append(p.Versions, versions[i])
+	}
+}
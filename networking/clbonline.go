@@ -23,6 +23,7 @@
 package networking
 
 import (
+	"strings"
 	"sync/atomic"
 
 	"github.com/google/uuid"
@@ -84,7 +85,7 @@ func (co *COnline) OnCONNACK(packet protobase.PacketInterface) {
 func (co *COnline) OnPUBLISH(packet protobase.PacketInterface) {
 	const fn string = "OnPUBLISH"
 	var (
-		publish *Publish = NewPublish(packet)
+		publish *Publish = NewPublishWide(packet, co.Conn.wideLength)
 		puback  *Puback
 		pckt    *Packet // final packet
 		pb      *protocol.MsgBox
@@ -100,13 +101,50 @@ func (co *COnline) OnPUBLISH(packet protobase.PacketInterface) {
 	// 	co.Shutdown()
 	// 	return
 	// }
-	if stat := co.Conn.storage.AddInbound(publish); stat == false {
+	if publish.Compression != protobase.COMPNone {
+		// payloads are handed to the client uncompressed
+		message, err := protocol.Decompress(publish.Compression, publish.Message)
+		if err != nil {
+			logger.FError(fn, "- [COnline] unable to decompress payload. error:", err)
+			co.Shutdown()
+			return
+		}
+		publish.Message, publish.Compression = message, protobase.COMPNone
+	}
+	if publish.Meta.Qos == protobase.LQOS2 {
+		// NOTE
+		// . message id remains reserved until broker releases it
+		//   ( PUBREL ), duplicates are acknowledged but dismissed.
+		var (
+			iidstore protobase.MSGIDInterface = co.Conn.storage.GetIDStoreI()
+			isNew    bool                     = iidstore.Reserve(publish.Meta.MessageId, publish.Id)
+			pubrec   *Pubrec                  = NewRawPubrec()
+		)
+		if isNew {
+			if stat := co.Conn.storage.AddInbound(publish); stat == false {
+				logger.Debug(fn, "- [COnline][NOTICE] addinbound returned false (conline/publish).")
+				co.Shutdown()
+				return
+			}
+		}
+		pubrec.Meta.MessageId = publish.Meta.MessageId
+		if err := pubrec.Encode(); err != nil {
+			logger.FError(fn, "- [COnline] error while encoding pubrec. error:", err)
+			co.Shutdown()
+			return
+		}
+		co.Conn.SendPrio(pubrec.GetPacket().(*Packet))
+		if !isNew {
+			logger.FDebugf(fn, "* [QoS] duplicate packet MessageID(%d), dismissing.", int(publish.Meta.MessageId))
+			return
+		}
+	} else if stat := co.Conn.storage.AddInbound(publish); stat == false {
 		logger.Debug(fn, "- [COnline][NOTICE] addinbound returned false (conline/publish).")
 		co.Shutdown()
 		return
 	}
 	puback = NewRawPuback()
-	if publish.Meta.Qos > 0 {
+	if publish.Meta.Qos == protobase.LQOS1 {
 		puback.Meta.Qos, puback.Meta.MessageId = publish.Meta.Qos, publish.Meta.MessageId
 		if err := puback.Encode(); err != nil {
 			logger.FError(fn, "- [COnline] error while encoding puback. error:", err)
@@ -123,6 +161,9 @@ func (co *COnline) OnPUBLISH(packet protobase.PacketInterface) {
 	}
 	pb = protocol.NewMsgBox(publish.Meta.Qos, publish.Meta.MessageId,
 		protobase.MDInbound, protocol.NewMsgEnvelope(publish.Topic, publish.Message))
+	pb.SetRetain(publish.Meta.Ret, publish.RetainTTL)
+	pb.SetProperties(publish.Meta.Props)
+	pb.SetExpiry(publish.Expiry)
 	pbc = pb.Clone(protobase.MDInbound)
 	/* d e b u g */
 	// NOTE
@@ -248,6 +289,67 @@ func (co *COnline) OnSUBACK(packet protobase.PacketInterface) {
 	}
 }
 
+// OnUNSUBACK is a handler which removes the outbound unsubscribe
+// message and invokes its completion callback when QoS >0.
+func (co *COnline) OnUNSUBACK(packet protobase.PacketInterface) {
+	const fn string = "OnUNSUBACK"
+	var (
+		ua       *Unsuback = NewUnsuback(packet)
+		uid      uuid.UUID
+		oidstore protobase.MSGIDInterface
+		msgid    uint16
+		npc      *UnSubscribe
+		pb       *protocol.MsgBox
+		pbc      protobase.MsgInterface
+	)
+	logger.FInfo(fn, "* [COnline] packet is received.")
+	if ua == nil {
+		logger.FDebug(fn, "- [Decode] uanble to decode in [UnsubAck].", packet)
+		co.Shutdown()
+		return
+	}
+	oidstore = co.Conn.storage.GetIDStoreO()
+	msgid = ua.Meta.MessageId
+	uid, ok := oidstore.GetUUID(msgid)
+	if !ok {
+		logger.FWarn(fn, "- [COnline][IDStore/Unsuback] no packet with msgid found.", "msgid", msgid)
+		co.Shutdown()
+		return
+	}
+	np, ok := co.Conn.storage.GetOutbound(uid)
+	if !ok {
+		logger.FWarn(fn, "- [COnline][MessageBox/Unsuback] no packet with uid found.", uid)
+		co.Shutdown()
+		return
+	}
+	if !co.Conn.storage.DeleteOut(np) {
+		logger.FWarn(fn, "- [COnline][MessageBox/Unsuback] failed to remove message.")
+		co.Shutdown()
+		return
+	}
+	oidstore.FreeId(msgid)
+	npc, ok = np.(*UnSubscribe)
+	if !ok {
+		logger.FWarn(fn, "- [COnline][MessageBox/Unsuback] unexpected packet type.", np)
+		co.Shutdown()
+		return
+	}
+	pb = protocol.NewMsgBox(npc.Meta.Qos, npc.Meta.MessageId, protobase.MDInbound, protocol.NewMsgEnvelope(npc.Topic, nil))
+	pbc = pb.Clone(protobase.MDInbound)
+	/* critical section */
+	co.Conn.clblock.Lock()
+	callback, ok := co.Conn.clbunsub[msgid]
+	if ok {
+		delete(co.Conn.clbunsub, msgid)
+	}
+	co.Conn.clblock.Unlock()
+	/* critical section - end */
+	co.client.Unsubscribe(pbc)
+	if ok && callback != nil {
+		callback(nil, pbc)
+	}
+}
+
 // onPUBACK is a handler which removes the outbound publish
 // message when QoS >0.
 func (co *COnline) OnPUBACK(packet protobase.PacketInterface) {
@@ -313,6 +415,152 @@ func (co *COnline) OnPUBACK(packet protobase.PacketInterface) {
 	}
 }
 
+// OnPUBREC is a handler which replaces the outbound publish
+// with a release packet and sends it ( QoS 2 ).
+func (co *COnline) OnPUBREC(packet protobase.PacketInterface) {
+	const fn string = "OnPUBREC"
+	var (
+		pr       *Pubrec = NewPubrec(packet)
+		pubrel   *Pubrel
+		uid      uuid.UUID
+		oidstore protobase.MSGIDInterface
+		msgid    uint16
+	)
+	logger.FDebug(fn, "+ [PubRec] packet received.")
+	if pr == nil {
+		logger.FDebug(fn, "- [Decode] uanble to decode in [PubRec].", packet)
+		co.Shutdown()
+		return
+	}
+	oidstore = co.Conn.storage.GetIDStoreO()
+	msgid = pr.Meta.MessageId
+	uid, ok := oidstore.GetUUID(msgid)
+	if !ok {
+		logger.FWarn(fn, "- [IDStore/Pubrec] no packet with msgid found.", "msgid", msgid)
+		co.Shutdown()
+		return
+	}
+	np, ok := co.Conn.storage.GetOutbound(uid)
+	if !ok {
+		logger.FWarn(fn, "- [MessageBox/Pubrec] no packet with uid found.", uid)
+		co.Shutdown()
+		return
+	}
+	switch np.(type) {
+	case *Pubrel:
+		// duplicate pubrec, release again
+		pubrel = np.(*Pubrel)
+	case *Publish:
+		npc := np.(*Publish)
+		pubrel = NewRawPubrel()
+		pubrel.Id = npc.Id
+		pubrel.Meta.MessageId = msgid
+		if err := pubrel.Encode(); err != nil {
+			logger.FError(fn, "- [COnline] error while encoding pubrel. error:", err)
+			co.Shutdown()
+			return
+		}
+		if !co.Conn.storage.ReplaceOut(pubrel) {
+			logger.FWarn(fn, "- [MessageBox/Pubrec] failed to replace message.")
+			co.Shutdown()
+			return
+		}
+		/* critical section */
+		co.Conn.clblock.Lock()
+		co.Conn.clbrel[msgid] = protocol.NewMsgBox(npc.Meta.Qos, npc.Meta.MessageId, protobase.MDInbound,
+			protocol.NewMsgEnvelope(npc.Topic, npc.Message))
+		co.Conn.clblock.Unlock()
+		/* critical section - end */
+	default:
+		logger.FWarn(fn, "- [MessageBox/Pubrec] unexpected packet type.", np)
+		co.Shutdown()
+		return
+	}
+	co.Conn.SendPrio(pubrel.GetPacket().(*Packet))
+}
+
+// OnPUBREL is a handler which frees the reserved inbound message
+// id and completes the QoS 2 handshake.
+func (co *COnline) OnPUBREL(packet protobase.PacketInterface) {
+	const fn string = "OnPUBREL"
+	var (
+		pr       *Pubrel = NewPubrel(packet)
+		pubcomp  *Pubcomp
+		iidstore protobase.MSGIDInterface
+		msgid    uint16
+	)
+	logger.FDebug(fn, "+ [PubRel] packet received.")
+	if pr == nil {
+		logger.FDebug(fn, "- [Decode] uanble to decode in [PubRel].", packet)
+		co.Shutdown()
+		return
+	}
+	iidstore = co.Conn.storage.GetIDStoreI()
+	msgid = pr.Meta.MessageId
+	if uid, ok := iidstore.GetUUID(msgid); ok {
+		if np, ok := co.Conn.storage.GetInbound(uid); ok {
+			if !co.Conn.storage.DeleteIn(np) {
+				logger.FWarn(fn, "- [MessageBox/Pubrel] failed to remove message.")
+			}
+		}
+		iidstore.FreeId(msgid)
+	}
+	pubcomp = NewRawPubcomp()
+	pubcomp.Meta.MessageId = msgid
+	if err := pubcomp.Encode(); err != nil {
+		logger.FError(fn, "- [COnline] error while encoding pubcomp. error:", err)
+		co.Shutdown()
+		return
+	}
+	co.Conn.SendPrio(pubcomp.GetPacket().(*Packet))
+}
+
+// OnPUBCOMP is a handler which removes the outbound release
+// packet and invokes the publish callback ( QoS 2 ).
+func (co *COnline) OnPUBCOMP(packet protobase.PacketInterface) {
+	const fn string = "OnPUBCOMP"
+	var (
+		pc       *Pubcomp = NewPubcomp(packet)
+		uid      uuid.UUID
+		oidstore protobase.MSGIDInterface
+		msgid    uint16
+	)
+	logger.FDebug(fn, "+ [PubComp] packet received.")
+	if pc == nil {
+		logger.FDebug(fn, "- [Decode] uanble to decode in [PubComp].", packet)
+		co.Shutdown()
+		return
+	}
+	oidstore = co.Conn.storage.GetIDStoreO()
+	msgid = pc.Meta.MessageId
+	uid, ok := oidstore.GetUUID(msgid)
+	if !ok {
+		logger.FWarn(fn, "- [IDStore/Pubcomp] no packet with msgid found.", "msgid", msgid)
+		return
+	}
+	if np, ok := co.Conn.storage.GetOutbound(uid); ok {
+		if !co.Conn.storage.DeleteOut(np) {
+			logger.FWarn(fn, "- [MessageBox/Pubcomp] failed to remove message.")
+		}
+	}
+	oidstore.FreeId(msgid)
+	/* critical section */
+	co.Conn.clblock.Lock()
+	pb, pbok := co.Conn.clbrel[msgid]
+	if pbok {
+		delete(co.Conn.clbrel, msgid)
+	}
+	callback, ok := co.Conn.clbpub[msgid]
+	if ok {
+		delete(co.Conn.clbpub, msgid)
+	}
+	co.Conn.clblock.Unlock()
+	/* critical section - end */
+	if ok && pbok && callback != nil {
+		callback(nil, pb)
+	}
+}
+
 func (co *COnline) OnDISCONNECT(packet protobase.PacketInterface) {
 	// TODO
 	const fn string = "OnDISCONNECT"
@@ -332,14 +580,78 @@ func (co *COnline) OnPONG(packet protobase.PacketInterface) {
 	logger.FDebug(fn, "* [COnline] packet received.")
 }
 
-func (co *COnline) OnQueue(packet protobase.PacketInterface) {
-	const fn string = "OnQueue"
+// OnQUEUE hands messages drained from a queue over to the client. The
+// return path and mark are passed as reply-to and correlation id
+// properties, replies to a pending request are handed to it instead.
+// Messages with a message id are acknowledged once handed over.
+func (co *COnline) OnQUEUE(packet protobase.PacketInterface) {
+	const fn string = "OnQUEUE"
+	var (
+		q     *Queue = NewQueueWide(packet, co.Conn.wideLength)
+		pb    *protocol.MsgBox
+		props protobase.Properties
+	)
 	logger.FDebug(fn, "+ [COnline] packet is received.")
+	if q == nil {
+		logger.FDebug(fn, "- [Decode] unable to decode in [Queue].", packet)
+		co.Shutdown()
+		return
+	}
+	// replies are handed to the waiting request, late ones are dropped
+	if strings.HasPrefix(q.Address, protocol.QReplyPrefix) {
+		if !co.Conn.reply(q) {
+			logger.FDebugf(fn, "- [COnline] dropping reply to released return path(%s).", q.Address)
+		}
+		return
+	}
+	if len(q.ReturnPath) > 0 || len(q.Mark) > 0 {
+		props = make(protobase.Properties)
+		if len(q.ReturnPath) > 0 {
+			props.Set(protobase.PROPReplyTo, []byte(q.ReturnPath))
+		}
+		if len(q.Mark) > 0 {
+			props.Set(protobase.PROPCorrelationId, q.Mark)
+		}
+	}
+	pb = protocol.NewMsgBox(0, q.Meta.MessageId, protobase.MDInbound, protocol.NewMsgEnvelope(q.Address, q.Message))
+	pb.SetProperties(props)
+	co.client.Publish(pb.Clone(protobase.MDInbound))
+	if q.Meta.MessageId > 0 {
+		co.Conn.ackQueue(q.Meta.MessageId)
+	}
 }
 
+// OnQueueAck invokes the completion callback of the acknowledged
+// queue command.
 func (co *COnline) OnQueueAck(packet protobase.PacketInterface) {
 	const fn string = "OnQueueAck"
+	var (
+		qa    *QAck = NewQAck(packet)
+		msgid uint16
+	)
 	logger.FDebug(fn, "+ [COnline] packet is received.")
+	if qa == nil {
+		logger.FDebug(fn, "- [Decode] unable to decode in [QAck].", packet)
+		co.Shutdown()
+		return
+	}
+	msgid = qa.Meta.MessageId
+	/* critical section */
+	co.Conn.clblock.Lock()
+	callback, ok := co.Conn.clbque[msgid]
+	if ok {
+		delete(co.Conn.clbque, msgid)
+	}
+	co.Conn.clblock.Unlock()
+	/* critical section - end */
+	if !ok {
+		logger.FWarn(fn, "- [COnline][QAck] no queue command with msgid found.", "msgid", msgid)
+		return
+	}
+	co.Conn.storage.GetIDStoreO().FreeId(msgid)
+	if callback != nil {
+		callback(qa)
+	}
 }
 
 // Shutdown sets the status to error which notifies the supervisor
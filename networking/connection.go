@@ -67,6 +67,15 @@ type Connection struct {
 	heartbeat          int                                                    // maximum idle time
 	unclean            uint32                                                 // refurbished flag
 	justStarted        bool                                                   // status flag
+	maxQoS             byte                                                   // max accepted QoS
+	receiveMax         uint16                                                 // max inflight QoS>0 publishes
+	will               protobase.MsgInterface                                 // last will message
+	willDelay          uint32                                                 // last will delay ( seconds )
+	versions           []string                                               // supported protocol versions
+	codecs             []byte                                                 // supported compression codecs
+	codec              byte                                                   // negotiated compression codec
+	retainAvailable    bool                                                   // retained messages are supported
+	wildcardAvailable  bool                                                   // wildcard subscriptions are supported
 }
 
 // Section: initializers [ constructors ]
@@ -93,6 +102,9 @@ func NewConnection(conn net.Conn) *Connection {
 			justStarted:        true, // deadline is connTimeout when true
 			connTimeout:        CConnectionDefaultTimeout,
 			heartbeat:          CConnectionDefaultHeartbeat,
+			maxQoS:             protobase.MAXQoS,
+			retainAvailable:    true,
+			wildcardAvailable:  true,
 			ErrorHandler:       nil,
 			State:              nil,
 			client:             nil,
@@ -144,6 +156,47 @@ func (c *Connection) SetHeartBeat(heartbeat int) {
 	c.heartbeat = heartbeat
 }
 
+// SetMaxPacketSize sets the maximum size of incoming packets,
+// larger packets terminate the connection ( 0 = unlimited ).
+func (c *Connection) SetMaxPacketSize(size uint32) {
+	c.maxPacketSize = size
+}
+
+// SetMaxQoS sets the maximum Quality of Service
+// level accepted from the client.
+func (c *Connection) SetMaxQoS(qos byte) {
+	c.maxQoS = qos
+}
+
+// SetReceiveMaximum sets the maximum number of inflight
+// QoS>0 publishes advertised to the client ( 0 = unlimited ).
+func (c *Connection) SetReceiveMaximum(n uint16) {
+	c.receiveMax = n
+}
+
+// SetAvailability sets whether retained messages and wildcard
+// subscriptions are supported, both are advertised to the client.
+func (c *Connection) SetAvailability(retain bool, wildcard bool) {
+	c.retainAvailable, c.wildcardAvailable = retain, wildcard
+}
+
+// SetVersions sets the protocol versions accepted from clients
+// in ascending order, nil accepts `protobase.ProtoVersions`.
+func (c *Connection) SetVersions(versions []string) {
+	c.versions = versions
+}
+
+// SetCompression sets the compression codecs which may be
+// negotiated with the client, nil disables compression.
+func (c *Connection) SetCompression(codecs []byte) {
+	c.codecs = codecs
+}
+
+// Compression returns the negotiated payload compression codec.
+func (c *Connection) Compression() byte {
+	return c.codec
+}
+
 // SetClient sets client struct.
 func (c *Connection) SetClient(cl protobase.ClientInterface) {
 	c.client = cl
@@ -195,6 +248,12 @@ func (c *Connection) SetErrorHandler(fn func(client *protobase.ClientInterface))
 	c.ErrorHandler = fn
 }
 
+// GetWill returns the last will message and its delay in
+// seconds. The message is nil when client has no will.
+func (c *Connection) GetWill() (protobase.MsgInterface, uint32) {
+	return c.will, c.willDelay
+}
+
 // GetAuthenticator returns underlying auth subsystem.
 func (c *Connection) GetAuthenticator() protobase.AuthInterface {
 	return c.auth
@@ -228,25 +287,42 @@ func (c *Connection) SendMessage(pb protobase.MsgInterface, isOwner bool) (err e
 	)
 	msg.Message = message
 	msg.Topic = topic
+	msg.Meta.WideLength = c.wideLength
+	msg.Meta.Ret = pb.Retain()
+	msg.Meta.Props = pb.Properties()
+	msg.Compression = pb.Compression()
+	if pb.Expired() {
+		logger.FDebugf(fn, "- [Expiry] dropping expired message on topic(%s) for client(%s).", topic, clid)
+		return nil
+	}
+	if msg.Expiry = pb.Expiry(); msg.Expiry > 0 {
+		msg.ExpiresAt = time.Now().Add(time.Duration(msg.Expiry) * time.Second)
+	}
+	var idstore protobase.MSGIDInterface
 	if qos > 0 {
 		logger.FDebug(fn, "* [QoS] QoS>0 in [SendMessage].", "qos", qos)
 		puid = (msg.Id)
 		logger.FDebug("SendMessage", "Publish QoS.", qos, "msgdir", pb.Dir())
-		if !c.storage.AddOutbound(clid, msg) {
-			logger.Warn("- [MessageStore] unable to add outbound message in [SendMessage].")
-		}
-		idstore := c.storage.GetIDStoreO(clid)
+		idstore = c.storage.GetIDStoreO(clid)
 		msg.Meta.MessageId = idstore.GetNewID(puid)
 		msg.Meta.Qos = qos
+		msg.Attempts = 1
 		logger.FDebugf("SendMessage", "* [MessageId] id(%d). ", msg.Meta.MessageId)
 	}
 	err = msg.Encode()
 	if err != nil {
 		logger.FWarnf(fn, "- [Connection] unable to encode publish packet. error:", err)
-		// TODO
-		// . handle errors by changing the execution flow
+		// NOTE
+		// . packets are stored once encoded, redelivery
+		//   relies on their encoded form.
+		if idstore != nil {
+			idstore.FreeId(msg.Meta.MessageId)
+		}
 		return err
 	}
+	if qos > 0 && !c.storage.AddOutbound(clid, msg) {
+		logger.Warn("- [MessageStore] unable to add outbound message in [SendMessage].")
+	}
 	data = msg.Encoded.Bytes()
 	p = NewPacket(data, msg.Command, msg.Encoded.Len())
 	c.Send(p)
@@ -255,17 +331,59 @@ func (c *Connection) SendMessage(pb protobase.MsgInterface, isOwner bool) (err e
 
 func (c *Connection) SendRedelivery(pb protobase.EDProtocol) (err error) {
 	const fn string = "SendRedelivery"
+	if pr, ok := pb.(*Pubrel); ok {
+		// publish is already acknowledged ( QoS 2 ), resume
+		// the handshake by resending the release packet.
+		logger.FDebugf(fn, "* [Redelivery] resending pubrel MessageId(%d).", pr.Meta.MessageId)
+		c.Send(pr.GetPacket().(*Packet))
+		return nil
+	}
 	var (
-		p      *Packet  = pb.GetPacket().(*Packet)
-		msg    *Publish = NewPublish(p)
+		p      *Packet = pb.GetPacket().(*Packet)
+		wide   bool    = c.wideLength
+		msg    *Publish
 		packet *Packet
 	)
-	if msg == nil {
+	// NOTE
+	// . stored packets are decoded with the length prefix they were
+	//   encoded with, which may belong to a previous connection.
+	if sp, ok := pb.(*Publish); ok {
+		wide = sp.Meta.WideLength
+	}
+	if msg = NewPublishWide(p, wide); msg == nil {
 		logger.FDebug("sendRedelivery", "- [Redelivery] cannot decode a publish packet.", pb)
+		return protocol.InvalidHeader
+	}
+	msg.Meta.WideLength = c.wideLength
+	// NOTE
+	// . compressed payloads are recoded to the codec negotiated by
+	//   this connection. Plain payloads are kept as they are, they
+	//   may belong to topics opted out of compression.
+	if msg.Compression != protobase.COMPNone && msg.Compression != c.codec {
+		payload, err := protocol.Decompress(msg.Compression, msg.Message)
+		if err == nil {
+			payload, err = protocol.Compress(c.codec, payload)
+		}
+		if err != nil {
+			logger.FDebugf(fn, "- [Redelivery] unable to recode payload of MessageId(%d). error: %s", msg.Meta.MessageId, err)
+			return err
+		}
+		msg.Message, msg.Compression = payload, c.codec
 	}
 	// if err := msg.DecodeFrom(p.Data); err != nil {
 	// 	logger.FDebug("sendRedelivery", "- [Redelivery] cannot decode a publish packet.")
 	// }
+	// NOTE
+	// . deadline is not part of the wire format, therefore it is
+	//   taken from the stored packet.
+	if sp, ok := pb.(*Publish); ok && !sp.ExpiresAt.IsZero() {
+		now := time.Now()
+		if sp.Expired(now) {
+			logger.FDebugf(fn, "- [Redelivery] message MessageId(%d) is expired, dropping.", sp.Meta.MessageId)
+			return protocol.MessageExpired
+		}
+		msg.Expiry = sp.Remaining(now)
+	}
 	msg.Meta.Dup = true
 	logger.FDebugf("SendRedelivery", "* [Redelivery] sending stored packages to client  QoS(%d) Duplicate(%t).", msg.Meta.Qos, msg.Meta.Dup)
 	err = msg.Encode()
@@ -278,6 +396,21 @@ func (c *Connection) SendRedelivery(pb protobase.EDProtocol) (err error) {
 	return nil
 }
 
+// SendPacket encodes `pb` and writes it to the send queue. Queue
+// messages are encoded with the length prefix used by the connection.
+func (c *Connection) SendPacket(pb protobase.EDProtocol) (err error) {
+	const fn string = "SendPacket"
+	if q, ok := pb.(*Queue); ok {
+		q.Meta.WideLength = c.wideLength
+	}
+	if err = pb.Encode(); err != nil {
+		logger.FWarnf(fn, "- [Connection] unable to encode packet. error:", err)
+		return err
+	}
+	c.Send(pb.GetPacket().(*Packet))
+	return nil
+}
+
 // Handle is the entry routine into `Connection`. It is the main loop
 // for handling initial logics/allocating and passing data to different stages.
 func (c *Connection) Handle() {
@@ -393,7 +526,7 @@ func (c *Connection) receive() (result []byte, code byte, length int, err error)
 	}
 	pack = append(pack, msg)
 	// Read remaining bytes after the fixed header
-	err = protocol.ReadPacket(c.Reader, &pack, &rl)
+	err = protocol.ReadPacket(c.Reader, &pack, &rl, c.maxPacketSize)
 	if err != nil {
 		return nil, 0, 0, err
 	}
@@ -509,14 +642,28 @@ func (c *Connection) dispatch(packet protobase.PacketInterface) {
 		c.State.OnCONNACK(packet)
 	case protobase.PQUEUE:
 		c.State.OnQUEUE(packet)
+	case protobase.PQUEUEACK:
+		c.State.OnQueueAck(packet)
 	case protobase.PSUBSCRIBE:
 		c.State.OnSUBSCRIBE(packet)
 	case protobase.PSUBACK:
 		c.State.OnSUBACK(packet)
+	case protobase.PUNSUBSCRIBE:
+		c.State.OnUNSUBSCRIBE(packet)
+	case protobase.PUNSUBACK:
+		c.State.OnUNSUBACK(packet)
 	case protobase.PPUBLISH:
 		c.State.OnPUBLISH(packet)
 	case protobase.PPUBACK:
 		c.State.OnPUBACK(packet)
+	case protobase.PPUBREC:
+		if isPubrel(packet) {
+			c.State.OnPUBREL(packet)
+		} else {
+			c.State.OnPUBREC(packet)
+		}
+	case protobase.PPUBCOMP:
+		c.State.OnPUBCOMP(packet)
 	case protobase.PPING:
 		c.State.OnPING(packet)
 	case protobase.PDISCONNECT:
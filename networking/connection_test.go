@@ -0,0 +1,75 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package networking
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+func TestSendRedelivery(t *testing.T) {
+	c := NewConnection(nil)
+	c.SendChan = make(chan *Packet, 1)
+	c.wideLength = true
+	// stored by a previous connection without wide length prefix
+	pb := protocol.NewRawPublish()
+	pb.Topic, pb.Message = "sensors/temp", bytes.Repeat([]byte("a"), 300)
+	pb.Meta.Qos, pb.Meta.MessageId = 1, 7
+	if err := pb.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if err := c.SendRedelivery(pb); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	msg := NewPublishWide(<-c.SendChan, true)
+	if msg == nil || msg.Topic != pb.Topic || !bytes.Equal(msg.Message, pb.Message) || !msg.Meta.Dup {
+		t.Fatal("inconsistent redelivered packet.", msg)
+	}
+}
+
+func TestSendRedeliveryRecode(t *testing.T) {
+	c := NewConnection(nil)
+	c.SendChan = make(chan *Packet, 1)
+	plain := []byte("21.5")
+	compressed, err := protocol.Compress(protobase.COMPGzip, plain)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	// stored for a previous connection which negotiated gzip
+	pb := protocol.NewRawPublish()
+	pb.Topic, pb.Message, pb.Compression = "sensors/temp", compressed, protobase.COMPGzip
+	pb.Meta.Qos, pb.Meta.MessageId = 1, 7
+	if err := pb.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if err := c.SendRedelivery(pb); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	msg := NewPublishWide(<-c.SendChan, false)
+	if msg == nil || msg.Compression != protobase.COMPNone || !bytes.Equal(msg.Message, plain) {
+		t.Fatal("inconsistent redelivered payload.", msg)
+	}
+}
@@ -23,7 +23,9 @@
 package networking
 
 import (
+	"github.com/google/uuid"
 	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
 )
 
 // MARK: Genesis
@@ -130,51 +132,154 @@ func (g *Genesis) HandleDefault(packet protobase.PacketInterface) (status bool)
 	// connection is established
 	// push into the next state
 	g.gotFirstPacket = true
+	supported := g.Conn.versions
+	if supported == nil {
+		supported = protobase.ProtoVersions
+	}
+	version, ok := negotiateVersion(supported, p)
+	if !ok {
+		logger.FDebugf("HandleDefault", "- [Version] unsupported protocol version(%q) for client(%s).", p.Version, p.ClientId)
+		return g.reject(cack, protobase.RESPBADVERSION, p)
+	}
+	g.Conn.codec = protocol.NegotiateCodec(g.Conn.codecs, p.Codecs)
+	if g.server != nil && g.server.GetStatus() != protobase.ServerRunning {
+		return g.reject(cack, protobase.RESPBUSY, p)
+	}
+	if p.ClientId == "" {
+		// assign a unique identifier
+		p.ClientId = uuid.New().String()
+		cack.Caps = g.capabilities(p.ClientId)
+	}
 	// TODO:
 	// . improve by directly pass connect packet to auth subsystem
 	creds, err := authsys.MakeCreds(p.Username, p.Password, p.ClientId)
 	if err != nil {
-		logger.Fatal("- [Fatal] cannot make credentials in [Genesis].", err)
-		return false
+		logger.FDebug("HandleDefault", "- [Credentials] cannot make credentials in [Genesis].", err)
+		return g.reject(cack, protobase.RESPBADCLID, p)
+	}
+	if bs, ok := authsys.(protobase.BanInterface); ok && bs.IsBanned(p.ClientId) {
+		logger.FDebugf("HandleDefault", "- [Banned] client(%s) is banned.", p.ClientId)
+		return g.reject(cack, protobase.RESPBANNED, p)
+	}
+	// NOTE:
+	// . check error explicitely
+	if valid, err = g.authenticate(authsys, creds); !valid {
+		// credentials are rejected when auth subsystem
+		// reports an error, otherwise the client is
+		// denied access.
+		if err != nil {
+			logger.FDebug("HandleDefault", "- [Credentials] invalid credentials.", err)
+			return g.reject(cack, protobase.RESPBADCREDS, p)
+		}
+		return g.reject(cack, protobase.RESPNOTAUTH, p)
+	}
+	if as, ok := authsys.(protobase.AddrInterface); ok {
+		as.SetAddr(creds.GetUID(), g.Conn.Conn.RemoteAddr().String())
 	}
 	// TODO/NOTICE
 	//  do not create a new client until credentials are valid ( reduce memory alloc. overhead )
 	newcl = g.Conn.clientDelegate(p.Username, p.Password, p.ClientId)
-	// NOTE:
-	// . check error explicitely
-	if valid, err = authsys.CanAuthenticate(creds); valid {
-		cack.SetResultCode(protobase.RESPOK)
-		cack.Encode()
-		rpacket = cack.GetPacket().(*Packet)
-		g.Conn.SetClient(newcl)
-		g.SetNextState() // Genesis -> Online
-		if p.Meta.CleanStart {
-			// drop queued packets
-			g.Conn.storage.AddClient(p.Username)
-			// TODO
-			// . drop subscriptions
+	cack.SetResultCode(protobase.RESPOK)
+	cack.Version = version
+	if cack.Caps == nil {
+		cack.Caps = g.capabilities("")
+	}
+	if g.server != nil {
+		// resume or start a session, subscriptions and
+		// queued packets are kept iff session is present.
+		sid, present := g.server.OpenSession(newcl.GetIdentifier(), p.SessionId, p.Meta.CleanStart)
+		cack.SetSessionId(sid)
+		cack.Meta.HasSession = present
+	} else if p.Meta.CleanStart {
+		// drop queued packets
+		g.Conn.storage.AddClient(newcl.GetIdentifier())
+	}
+	// accept wide payload fields when requested
+	g.Conn.wideLength = p.Meta.WideLength
+	cack.Meta.WideLength = p.Meta.WideLength
+	cack.Encode()
+	rpacket = cack.GetPacket().(*Packet)
+	g.Conn.SetClient(newcl)
+	if p.WillTopic != "" {
+		will := protocol.NewMsgBox(p.WillQoS, 0, protobase.MDInbound, protocol.NewMsgEnvelope(p.WillTopic, p.WillMessage))
+		will.SetWishQoS(protobase.MAXQoS)
+		will.SetRetain(p.WillRetain, 0)
+		g.Conn.will, g.Conn.willDelay = will, p.WillDelay
+	}
+	g.SetNextState() // Genesis -> Online
+	g.Conn.SendDirect(rpacket)
+	// TODO
+	//  these lines are moves to cleanUp, remove them when
+	//  its finalized.
+	/* d e b u g */
+	//  g.Conn = nil
+	//  g.client = nil
+	/* d e b u g */
+	g.cleanUp()
+	return true
+}
+
+// authenticate validates credentials. Clients connected over unix
+// sockets authenticate by peer credentials when the auth subsystem
+// supports it and runs in `protobase.AUTHModePeerCred` mode.
+func (g *Genesis) authenticate(authsys protobase.AuthInterface, creds protobase.CredentialsInterface) (bool, error) {
+	if pa, ok := authsys.(protobase.PeerCredInterface); ok && authsys.GetMode() == protobase.AUTHModePeerCred {
+		if peer, err := peerCredentials(g.Conn.Conn); err == nil {
+			logger.FDebugf("authenticate", "* [PeerCred] peer pid(%d) uid(%d) gid(%d).", peer.Pid, peer.Uid, peer.Gid)
+			return pa.CanAuthenticatePeer(creds, *peer)
+		}
+	}
+	return authsys.CanAuthenticate(creds)
+}
+
+// capabilities returns broker limits and features advertised
+// to the client.
+func (g *Genesis) capabilities(assignedId string) *protocol.Capabilities {
+	return &protocol.Capabilities{
+		MaxQoS:            g.Conn.maxQoS,
+		MaxPacketSize:     g.Conn.maxPacketSize,
+		ServerKeepAlive:   uint16(g.Conn.heartbeat),
+		ReceiveMax:        g.Conn.receiveMax,
+		RetainAvailable:   g.Conn.retainAvailable,
+		WildcardAvailable: g.Conn.wildcardAvailable,
+		AssignedClientId:  assignedId,
+		Compression:       g.Conn.codec,
+	}
+}
+
+// reject sends a `Connack` with the refusal reason `code` and
+// cleans up the state.
+func (g *Genesis) reject(cack *Connack, code byte, p *Connect) bool {
+	// clients which do not negotiate the version predate
+	// reason codes and only recognize RESPFAIL.
+	if len(p.Versions) == 0 {
+		code = protobase.RESPFAIL
+	}
+	cack.SetResultCode(code)
+	cack.Encode()
+	rpacket := cack.GetPacket().(*Packet)
+	g.Conn.SetClient(g.Conn.clientDelegate(p.Username, p.Password, p.ClientId))
+	g.Conn.SendDirect(rpacket)
+	// TODO
+	//  improve error handling
+	g.cleanUp()
+	return false
+}
+
+// negotiateVersion returns the most recent version from `supported`
+// which is offered by the client in `p`.
+func negotiateVersion(supported []string, p *Connect) (string, bool) {
+	for i := len(supported) - 1; i >= 0; i-- {
+		if supported[i] == p.Version {
+			return supported[i], true
+		}
+		for _, v := range p.Versions {
+			if supported[i] == v {
+				return supported[i], true
+			}
 		}
-		g.Conn.SendDirect(rpacket)
-		// TODO
-		//  these lines are moves to cleanUp, remove them when
-		//  its finalized.
-		/* d e b u g */
-		//  g.Conn = nil
-		//  g.client = nil
-		/* d e b u g */
-		g.cleanUp()
-		return true
-	} else {
-		cack.SetResultCode(protobase.RESPFAIL)
-		cack.Encode()
-		rpacket = cack.GetPacket().(*Packet)
-		g.Conn.SetClient(newcl)
-		g.Conn.SendDirect(rpacket)
-		// TODO
-		//  improve error handling
-		g.cleanUp()
-		return false
 	}
+	return "", false
 }
 
 func (g *Genesis) OnPONG(packet protobase.PacketInterface) {
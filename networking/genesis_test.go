@@ -0,0 +1,59 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package networking
+
+import (
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	c := NewConnection(nil)
+	if caps := NewGenesis(c).capabilities(""); !caps.RetainAvailable || !caps.WildcardAvailable {
+		t.Fatal("expected retain and wildcard to be available by default.", caps)
+	}
+	c.SetAvailability(false, true)
+	if caps := NewGenesis(c).capabilities(""); caps.RetainAvailable || !caps.WildcardAvailable {
+		t.Fatal("inconsistent advertised availability.", caps)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		client, broker []string
+		version        string
+		ok             bool
+	}{
+		{[]string{"PRX1", "PRX2"}, []string{"PRX1", "PRX2", "PRX3"}, "PRX2", true},
+		{[]string{"PRX1", "PRX2", "PRX3"}, []string{"PRX1", "PRX2"}, "PRX2", true},
+		{[]string{"PRX1", "PRX3"}, []string{"PRX1", "PRX2", "PRX3"}, "PRX3", true},
+		{[]string{"PRX1"}, []string{"PRX1", "PRX2"}, "PRX1", true},
+		{[]string{"PRX2"}, []string{"PRX1"}, "", false},
+	}
+	for _, tt := range tests {
+		p := NewRawConnect()
+		offerVersions(p, tt.client)
+		if version, ok := negotiateVersion(tt.broker, p); version != tt.version || ok != tt.ok {
+			t.Fatalf("inconsistent negotiated version between client%v and broker%v, expected (%q, %v), got (%q, %v).", tt.client, tt.broker, tt.version, tt.ok, version, ok)
+		}
+	}
+}
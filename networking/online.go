@@ -23,9 +23,11 @@
 package networking
 
 import (
+	"strings"
 	"sync/atomic"
 
 	"github.com/google/uuid"
+	"github.com/mitghi/protox/messages"
 	"github.com/mitghi/protox/protobase"
 	"github.com/mitghi/protox/protocol"
 )
@@ -83,7 +85,7 @@ func (o *Online) OnCONNACK(packet protobase.PacketInterface) {
 // onPUBLISH is the handler for `Publish` packets.
 func (o *Online) OnPUBLISH(packet protobase.PacketInterface) {
 	var (
-		publish  *Publish = protocol.NewPublish(packet)
+		publish  *Publish = protocol.NewPublishWide(packet, o.Conn.wideLength)
 		cid      string   = o.client.GetIdentifier()
 		userType protobase.AuthUserType
 	)
@@ -97,6 +99,11 @@ func (o *Online) OnPUBLISH(packet protobase.PacketInterface) {
 	// 	o.Shutdown()
 	// 	return
 	// }
+	if publish.Meta.Ret && !o.Conn.retainAvailable {
+		logger.Debugf("onPUBLISH", "- [Packet] retained messages are not available for Client(%s).", cid)
+		o.Shutdown()
+		return
+	}
 	userType, err := o.Conn.auth.GetUserType(cid)
 	if err != nil {
 		logger.Debugf("onPUBLISH", "- [Packet] unable to find associated User Type for Client(%s).", cid)
@@ -124,34 +131,86 @@ func (o *Online) OnPUBLISH(packet protobase.PacketInterface) {
 			return
 		}
 	}
-	if stat := o.Conn.storage.AddInbound(cid, publish); stat == false {
-		logger.Debug("? [NOTICE] addinbound returned false (online/publish).")
-	}
-	var puback *Puback = protocol.NewRawPuback()
 	logger.FDebugf("onPUBLISH", "+ [Packet] received with [QoS] %d.", int(publish.Meta.Qos))
-	if publish.Meta.Qos > 0 {
-		puback.Meta.Qos, puback.Meta.MessageId = publish.Meta.Qos, publish.Meta.MessageId
-		if puback.Meta.Qos > protobase.MAXQoS {
-			puback.Meta.Qos = protobase.MAXQoS
+	if publish.Meta.Qos > o.Conn.maxQoS {
+		logger.Debugf("onPUBLISH", "- [QoS] QoS(%d) exceeds the advertised maximum for Client(%s).", int(publish.Meta.Qos), cid)
+		o.Shutdown()
+		return
+	}
+	if !protocol.ValidCodec(publish.Compression) {
+		logger.Debugf("onPUBLISH", "- [Compression] unknown codec(%d) from Client(%s).", int(publish.Compression), cid)
+		o.Shutdown()
+		return
+	}
+	if publish.Meta.Qos == protobase.LQOS2 {
+		// NOTE
+		// . message id remains reserved until the sender releases
+		//   it ( PUBREL ). Retransmissions are acknowledged again
+		//   but never delivered twice.
+		var (
+			iidstore protobase.MSGIDInterface = o.Conn.storage.GetIDStoreI(cid)
+			isNew    bool                     = iidstore.Reserve(publish.Meta.MessageId, publish.Id)
+			pubrec   *Pubrec                  = protocol.NewRawPubrec()
+		)
+		if isNew {
+			if stat := o.Conn.storage.AddInbound(cid, publish); stat == false {
+				logger.Debug("? [NOTICE] addinbound returned false (online/publish).")
+			}
 		}
-		if err := puback.Encode(); err != nil {
-			logger.FError("onPUBLISH", "- [ONLINE] Error while encoding puback.")
+		pubrec.Meta.MessageId = publish.Meta.MessageId
+		if err := pubrec.Encode(); err != nil {
+			logger.FError("onPUBLISH", "- [ONLINE] Error while encoding pubrec.")
 			o.Shutdown()
 			return
 		}
-		var pckt *Packet = puback.GetPacket().(*Packet)
-		o.Conn.SendPrio(pckt)
-		if stat := o.Conn.storage.DeleteIn(cid, publish); stat == false {
-			logger.Debug("? [NOTICE] deleteinbound returned false (online/publish).")
+		o.Conn.SendPrio(pubrec.GetPacket().(*Packet))
+		if !isNew {
+			logger.FDebugf("onPUBLISH", "* [QoS] duplicate packet MessageId(%d) for Client(%s), dismissing.", publish.Meta.MessageId, cid)
+			return
+		}
+	} else {
+		if stat := o.Conn.storage.AddInbound(cid, publish); stat == false {
+			logger.Debug("? [NOTICE] addinbound returned false (online/publish).")
+		}
+		var puback *Puback = protocol.NewRawPuback()
+		if publish.Meta.Qos > 0 {
+			puback.Meta.Qos, puback.Meta.MessageId = publish.Meta.Qos, publish.Meta.MessageId
+			if puback.Meta.Qos > protobase.MAXQoS {
+				puback.Meta.Qos = protobase.MAXQoS
+			}
+			if err := puback.Encode(); err != nil {
+				logger.FError("onPUBLISH", "- [ONLINE] Error while encoding puback.")
+				o.Shutdown()
+				return
+			}
+			var pckt *Packet = puback.GetPacket().(*Packet)
+			o.Conn.SendPrio(pckt)
+			if stat := o.Conn.storage.DeleteIn(cid, publish); stat == false {
+				logger.Debug("? [NOTICE] deleteinbound returned false (online/publish).")
+			}
 		}
 	}
 	pb := protocol.NewMsgBox(publish.Meta.Qos, publish.Meta.MessageId, protobase.MDInbound, protocol.NewMsgEnvelope(publish.Topic, publish.Message))
+	pb.SetRetain(publish.Meta.Ret, publish.RetainTTL)
+	pb.SetProperties(publish.Meta.Props)
+	pb.SetExpiry(publish.Expiry)
+	pb.SetCompression(publish.Compression)
 	// publish box clone
 	pbc := pb.Clone(protobase.MDInbound)
 	o.client.Publish(pbc)
 	o.server.NotifyPublish(o.Conn, pb)
 }
 
+// permTopic returns the topic filter permissions of subscription `topic`
+// are checked against, shared subscriptions are authorized by their
+// filter.
+func permTopic(topic string) string {
+	if _, filter, ok := protocol.ParseShare(topic); ok {
+		return filter
+	}
+	return topic
+}
+
 // onSUBSCRIBE is the handler for `Subscribe` packets.
 func (o *Online) OnSUBSCRIBE(packet protobase.PacketInterface) {
 	var (
@@ -169,6 +228,11 @@ func (o *Online) OnSUBSCRIBE(packet protobase.PacketInterface) {
 	// 	o.Shutdown()
 	// 	return
 	// }
+	if !o.Conn.wildcardAvailable && strings.IndexByte(subscribe.Topic, messages.TWLDCD) >= 0 {
+		logger.Debugf("onSUBSCRIBE", "- [Packet] wildcard subscriptions are not available for Client(%s).", cid)
+		o.Shutdown()
+		return
+	}
 	userType, err := o.Conn.auth.GetUserType(cid)
 	if err != nil {
 		logger.Debugf("onSUBSCRIBE", "- [Packet] unable to find associated User Type for Client(%s).", cid)
@@ -177,7 +241,7 @@ func (o *Online) OnSUBSCRIBE(packet protobase.PacketInterface) {
 	}
 	if o.Conn.auth.GetMode() != protobase.AUTHModeNone {
 		if o.Conn.permissionDelegate != nil {
-			if !o.Conn.permissionDelegate(o.Conn.auth, "can", "subscribe", subscribe.Topic) {
+			if !o.Conn.permissionDelegate(o.Conn.auth, "can", "subscribe", permTopic(subscribe.Topic)) {
 				o.Shutdown()
 				return
 			}
@@ -188,7 +252,7 @@ func (o *Online) OnSUBSCRIBE(packet protobase.PacketInterface) {
 				o.Shutdown()
 				return
 			}
-			if !role.HasPerm("can", "subscribe", subscribe.Topic) {
+			if !role.HasPerm("can", "subscribe", permTopic(subscribe.Topic)) {
 				o.Shutdown()
 				return
 			}
@@ -198,12 +262,14 @@ func (o *Online) OnSUBSCRIBE(packet protobase.PacketInterface) {
 		logger.Debug("? [NOTICE] addinbound returned false (online/subscribe).")
 	}
 	var suback *Suback = protocol.NewRawSuback()
+	// grant at most the advertised maximum QoS
+	var granted byte = subscribe.Meta.Qos
+	if granted > o.Conn.maxQoS {
+		granted = o.Conn.maxQoS
+	}
 	logger.FDebugf("onSUBSCRIBE", "+ [Packet] received with [QoS] %d.", int(subscribe.Meta.Qos))
 	if subscribe.Meta.Qos > 0 {
-		suback.Meta.Qos, suback.Meta.MessageId = subscribe.Meta.Qos, subscribe.Meta.MessageId
-		if suback.Meta.Qos > protobase.MAXQoS {
-			suback.Meta.Qos = protobase.MAXQoS
-		}
+		suback.Meta.Qos, suback.Meta.MessageId = granted, subscribe.Meta.MessageId
 		if err := suback.Encode(); err != nil {
 			logger.FError("onSUBSCRIBE", "- [ONLINE] Error while encoding suback.")
 			o.Shutdown()
@@ -215,13 +281,69 @@ func (o *Online) OnSUBSCRIBE(packet protobase.PacketInterface) {
 			logger.Debug("? [NOTICE] deleteinbound returned false (online/subscribe).")
 		}
 	}
-	pb := protocol.NewMsgBox(subscribe.Meta.Qos, subscribe.Meta.MessageId, protobase.MDInbound, protocol.NewMsgEnvelope(subscribe.Topic, nil))
+	pb := protocol.NewMsgBox(granted, subscribe.Meta.MessageId, protobase.MDInbound, protocol.NewMsgEnvelope(subscribe.Topic, nil))
 	// subscribe box clone
 	pbc := pb.Clone(protobase.MDInbound)
 	o.client.Subscribe(pbc)
 	o.server.NotifySubscribe(o.Conn, pb)
 }
 
+// OnUNSUBSCRIBE is the handler for `UnSubscribe` packets.
+func (o *Online) OnUNSUBSCRIBE(packet protobase.PacketInterface) {
+	const fn string = "OnUNSUBSCRIBE"
+	var (
+		unsubscribe *UnSubscribe = protocol.NewUnSubscribe(packet)
+		cid         string       = o.client.GetIdentifier()
+		userType    protobase.AuthUserType
+	)
+	if unsubscribe == nil {
+		logger.Debugf("- [DecodeErr(onUnsubscribe)] Unable to decode data for Client(%s).", packet, cid)
+		o.Shutdown()
+		return
+	}
+	userType, err := o.Conn.auth.GetUserType(cid)
+	if err != nil {
+		logger.FDebugf(fn, "- [Packet] unable to find associated User Type for Client(%s).", cid)
+		o.Shutdown()
+		return
+	}
+	if o.Conn.auth.GetMode() != protobase.AUTHModeNone {
+		if o.Conn.permissionDelegate != nil {
+			if !o.Conn.permissionDelegate(o.Conn.auth, "can", "subscribe", permTopic(unsubscribe.Topic)) {
+				o.Shutdown()
+				return
+			}
+		} else {
+			role := o.Conn.auth.GetACL().GetRole((string)(userType))
+			if role == nil {
+				logger.FDebug(fn, "- [Role] role==nil.")
+				o.Shutdown()
+				return
+			}
+			if !role.HasPerm("can", "subscribe", permTopic(unsubscribe.Topic)) {
+				o.Shutdown()
+				return
+			}
+		}
+	}
+	logger.FDebugf(fn, "+ [Packet] received with [QoS] %d.", int(unsubscribe.Meta.Qos))
+	pb := protocol.NewMsgBox(unsubscribe.Meta.Qos, unsubscribe.Meta.MessageId, protobase.MDInbound, protocol.NewMsgEnvelope(unsubscribe.Topic, nil))
+	// unsubscribe box clone
+	pbc := pb.Clone(protobase.MDInbound)
+	o.client.Unsubscribe(pbc)
+	o.server.NotifyUnsubscribe(o.Conn, pb)
+	if unsubscribe.Meta.Qos > 0 {
+		var unsuback *Unsuback = protocol.NewRawUnsuback()
+		unsuback.Meta.MessageId = unsubscribe.Meta.MessageId
+		if err := unsuback.Encode(); err != nil {
+			logger.FError(fn, "- [ONLINE] Error while encoding unsuback.")
+			o.Shutdown()
+			return
+		}
+		o.Conn.SendPrio(unsuback.GetPacket().(*Packet))
+	}
+}
+
 // onPING is the heartbeat handler ( other packets reset its timer as well ).
 func (o *Online) OnPING(packet protobase.PacketInterface) {
 	logger.Debug("+ [Heartbeat] Received.")
@@ -270,6 +392,122 @@ func (o *Online) OnPUBACK(packet protobase.PacketInterface) {
 	oidstore.FreeId(msgid)
 }
 
+// OnPUBREC is a handler which replaces the outbound publish
+// with a release packet and sends it ( QoS 2, sender side ).
+func (o *Online) OnPUBREC(packet protobase.PacketInterface) {
+	const fn string = "OnPUBREC"
+	var (
+		clid   string  = o.Conn.GetClient().GetIdentifier()
+		pr     *Pubrec = protocol.NewPubrec(packet)
+		pubrel *Pubrel
+		uid    uuid.UUID
+	)
+	if pr == nil {
+		logger.FDebug(fn, "- [PubRec] unable to decode.", "packet", packet)
+		o.Shutdown()
+		return
+	}
+	oidstore := o.Conn.storage.GetIDStoreO(clid)
+	msgid := pr.Meta.MessageId
+	uid, ok := oidstore.GetUUID(msgid)
+	if !ok {
+		logger.FWarn(fn, "- [PubRec] no packet could be found in storage with msgid.", "msgid", msgid)
+		return
+	}
+	np, ok := o.Conn.storage.GetOutbound(clid, uid)
+	if !ok {
+		logger.FWarn(fn, "- [PubRec] no packet found with uid.", "uid", uid)
+		return
+	}
+	switch np.(type) {
+	case *Pubrel:
+		// duplicate pubrec, release again
+		pubrel = np.(*Pubrel)
+	default:
+		pubrel = protocol.NewRawPubrel()
+		pubrel.Id = np.UUID()
+		pubrel.Meta.MessageId = msgid
+		if err := pubrel.Encode(); err != nil {
+			logger.FError(fn, "- [PubRec] error while encoding pubrel.", "error", err)
+			o.Shutdown()
+			return
+		}
+		if !o.Conn.storage.ReplaceOut(clid, pubrel) {
+			logger.FWarn(fn, "- [PubRec] failed to replace packet in storage.")
+		}
+	}
+	o.Conn.SendPrio(pubrel.GetPacket().(*Packet))
+}
+
+// OnPUBREL is a handler which frees the reserved inbound message
+// id and completes the QoS 2 handshake ( receiver side ).
+func (o *Online) OnPUBREL(packet protobase.PacketInterface) {
+	const fn string = "OnPUBREL"
+	var (
+		clid    string  = o.Conn.GetClient().GetIdentifier()
+		pr      *Pubrel = protocol.NewPubrel(packet)
+		pubcomp *Pubcomp
+	)
+	if pr == nil {
+		logger.FDebug(fn, "- [PubRel] unable to decode.", "packet", packet)
+		o.Shutdown()
+		return
+	}
+	iidstore := o.Conn.storage.GetIDStoreI(clid)
+	msgid := pr.Meta.MessageId
+	if uid, ok := iidstore.GetUUID(msgid); ok {
+		if np, ok := o.Conn.storage.GetInbound(clid, uid); ok {
+			if !o.Conn.storage.DeleteIn(clid, np) {
+				logger.FWarn(fn, "- [PubRel] failed to remove packet from storage.")
+			}
+		}
+		iidstore.FreeId(msgid)
+	}
+	// NOTE
+	// . pubcomp is sent even for unknown ids since the
+	//   release may be a retransmission.
+	pubcomp = protocol.NewRawPubcomp()
+	pubcomp.Meta.MessageId = msgid
+	if err := pubcomp.Encode(); err != nil {
+		logger.FError(fn, "- [PubRel] error while encoding pubcomp.", "error", err)
+		o.Shutdown()
+		return
+	}
+	o.Conn.SendPrio(pubcomp.GetPacket().(*Packet))
+}
+
+// OnPUBCOMP is a handler which removes the outbound release
+// packet when QoS 2 handshake is completed.
+func (o *Online) OnPUBCOMP(packet protobase.PacketInterface) {
+	const fn string = "OnPUBCOMP"
+	var (
+		clid string   = o.Conn.GetClient().GetIdentifier()
+		pc   *Pubcomp = protocol.NewPubcomp(packet)
+		uid  uuid.UUID
+	)
+	if pc == nil {
+		logger.FDebug(fn, "- [PubComp] unable to decode.", "packet", packet)
+		o.Shutdown()
+		return
+	}
+	oidstore := o.Conn.storage.GetIDStoreO(clid)
+	msgid := pc.Meta.MessageId
+	uid, ok := oidstore.GetUUID(msgid)
+	if !ok {
+		logger.FWarn(fn, "- [PubComp] no packet could be found in storage with msgid.", "msgid", msgid)
+		return
+	}
+	if np, ok := o.Conn.storage.GetOutbound(clid, uid); ok {
+		if !o.Conn.storage.DeleteOut(clid, np) {
+			logger.FWarn(fn, "- [PubComp] failed to remove packet from storage.")
+		}
+	} else {
+		logger.FWarn(fn, "- [PubComp] no packet found with uid.", "uid", uid)
+	}
+	logger.FDebug(fn, "+ [PubComp] successfull acknowledge.")
+	oidstore.FreeId(msgid)
+}
+
 func (o *Online) onDISCONNECT(packet protobase.PacketInterface) {
 	// TODO
 	logger.FDebug("onDISCONNECT", "* [Disconnect] disconnect packet received.")
@@ -285,7 +523,28 @@ func (o *Online) OnPONG(packet protobase.PacketInterface) {
 	logger.FDebug("onPONG", "* [Pong] packet received.")
 }
 
+// OnQUEUE hands queue packets over to the server which acknowledges
+// them.
 func (o *Online) OnQUEUE(packet protobase.PacketInterface) {
 	logger.FDebug("onQUEUE", "* [QUEUE] packet received.")
-	o.server.NotifyQueue(o.Conn, nil)
+	q := NewQueueWide(packet, o.Conn.wideLength)
+	if q == nil {
+		logger.FDebugf("onQUEUE", "- [DecodeErr(onQueue)] Unable to decode data for Client(%s).", o.client.GetIdentifier())
+		o.Shutdown()
+		return
+	}
+	o.server.NotifyQueue(o.Conn, q)
+}
+
+// OnQueueAck hands acknowledgements of queue messages over to the
+// server.
+func (o *Online) OnQueueAck(packet protobase.PacketInterface) {
+	logger.FDebug("onQueueAck", "* [QueueAck] packet received.")
+	qa := NewQAck(packet)
+	if qa == nil {
+		logger.FDebugf("onQueueAck", "- [DecodeErr(onQueueAck)] Unable to decode data for Client(%s).", o.client.GetIdentifier())
+		o.Shutdown()
+		return
+	}
+	o.server.NotifyQueueAck(o.Conn, qa)
 }
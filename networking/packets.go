@@ -20,36 +20,58 @@ type (
 	ConnackOpts = protocol.ConnackOpts
 	Subscribe   = protocol.Subscribe
 	Suback      = protocol.Suback
+	UnSubscribe = protocol.UnSubscribe
+	Unsuback    = protocol.Unsuback
 	Publish     = protocol.Publish
 	Puback      = protocol.Puback
+	Pubrec      = protocol.Pubrec
+	Pubrel      = protocol.Pubrel
+	Pubcomp     = protocol.Pubcomp
 	Ping        = protocol.Ping
 	Pong        = protocol.Pong
+	Queue       = protocol.Queue
+	QAck        = protocol.QAck
 )
 
 // Packet constructors
 var (
-	NewPacket     func([]byte, byte, int) *Packet = _packet.NewPacket
-	NewConnect    func(PI) *Connect               = protocol.NewConnect
-	NewDisconnect func(PI) *Disconnect            = protocol.NewDisconnect
-	NewConnack    func(PI) *Connack               = protocol.NewConnack
-	NewSubscribe  func(PI) *Subscribe             = protocol.NewSubscribe
-	NewSuback     func(PI) *Suback                = protocol.NewSuback
-	NewPublish    func(PI) *Publish               = protocol.NewPublish
-	NewPuback     func(PI) *Puback                = protocol.NewPuback
-	NewPing       func(PI) *Ping                  = protocol.NewPing
-	NewPong       func(PI) *Pong                  = protocol.NewPong
+	NewPacket      func([]byte, byte, int) *Packet = _packet.NewPacket
+	NewConnect     func(PI) *Connect               = protocol.NewConnect
+	NewDisconnect  func(PI) *Disconnect            = protocol.NewDisconnect
+	NewConnack     func(PI) *Connack               = protocol.NewConnack
+	NewSubscribe   func(PI) *Subscribe             = protocol.NewSubscribe
+	NewSuback      func(PI) *Suback                = protocol.NewSuback
+	NewUnSubscribe func(PI) *UnSubscribe           = protocol.NewUnSubscribe
+	NewUnsuback    func(PI) *Unsuback              = protocol.NewUnsuback
+	NewPublish     func(PI) *Publish               = protocol.NewPublish
+	NewPublishWide func(PI, bool) *Publish         = protocol.NewPublishWide
+	NewPuback      func(PI) *Puback                = protocol.NewPuback
+	NewPubrec      func(PI) *Pubrec                = protocol.NewPubrec
+	NewPubrel      func(PI) *Pubrel                = protocol.NewPubrel
+	NewPubcomp     func(PI) *Pubcomp               = protocol.NewPubcomp
+	NewPing        func(PI) *Ping                  = protocol.NewPing
+	NewPong        func(PI) *Pong                  = protocol.NewPong
+	NewQueueWide   func(PI, bool) *Queue           = protocol.NewQueueWide
+	NewQAck        func(PI) *QAck                  = protocol.NewQAck
 
 	NewConnackOpts func() *ConnackOpts = protocol.NewConnackOpts
 
-	NewRawConnect    func() *Connect    = protocol.NewRawConnect
-	NewRawDisconnect func() *Disconnect = protocol.NewRawDisconnect
-	NewRawConnack    func() *Connack    = protocol.NewRawConnack
-	NewRawSubscribe  func() *Subscribe  = protocol.NewRawSubscribe
-	NewRawSuback     func() *Suback     = protocol.NewRawSuback
-	NewRawPublish    func() *Publish    = protocol.NewRawPublish
-	NewRawPuback     func() *Puback     = protocol.NewRawPuback
-	NewRawPing       func() *Ping       = protocol.NewRawPing
-	NewRawPong       func() *Pong       = protocol.NewRawPong
+	NewRawConnect     func() *Connect     = protocol.NewRawConnect
+	NewRawDisconnect  func() *Disconnect  = protocol.NewRawDisconnect
+	NewRawConnack     func() *Connack     = protocol.NewRawConnack
+	NewRawSubscribe   func() *Subscribe   = protocol.NewRawSubscribe
+	NewRawSuback      func() *Suback      = protocol.NewRawSuback
+	NewRawUnSubscribe func() *UnSubscribe = protocol.NewRawUnSubscribe
+	NewRawUnsuback    func() *Unsuback    = protocol.NewRawUnsuback
+	NewRawPublish     func() *Publish     = protocol.NewRawPublish
+	NewRawPuback      func() *Puback      = protocol.NewRawPuback
+	NewRawPubrec      func() *Pubrec      = protocol.NewRawPubrec
+	NewRawPubrel      func() *Pubrel      = protocol.NewRawPubrel
+	NewRawPubcomp     func() *Pubcomp     = protocol.NewRawPubcomp
+	NewRawPing        func() *Ping        = protocol.NewRawPing
+	NewRawPong        func() *Pong        = protocol.NewRawPong
+	NewRawQueue       func() *Queue       = protocol.NewRawQueue
+	NewRawQAck        func() *QAck        = protocol.NewRawQAck
 )
 
 var (
@@ -0,0 +1,60 @@
+//go:build linux
+
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package networking
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/mitghi/protox/protobase"
+)
+
+// peerCredentials returns credentials of the peer process of unix
+// socket connection `conn` ( SO_PEERCRED ).
+func peerCredentials(conn net.Conn) (*protobase.PeerCred, error) {
+	var (
+		uc   *net.UnixConn
+		raw  syscall.RawConn
+		cred *syscall.Ucred
+		serr error
+		ok   bool
+		err  error
+	)
+	if uc, ok = conn.(*net.UnixConn); !ok {
+		return nil, EPEERCREDUNAVAIL
+	}
+	if raw, err = uc.SyscallConn(); err != nil {
+		return nil, err
+	}
+	err = raw.Control(func(fd uintptr) {
+		cred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	} else if serr != nil {
+		return nil, serr
+	}
+	return &protobase.PeerCred{Pid: cred.Pid, Uid: cred.Uid, Gid: cred.Gid}, nil
+}
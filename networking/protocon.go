@@ -55,6 +55,8 @@ type protocon struct {
 	RecvChan        chan *Packet
 	addr            string
 	Status          uint32
+	maxPacketSize   uint32 // maximum size of incoming packets ( 0 = unlimited )
+	wideLength      bool   // negotiated uint32 payload length prefix
 	// TODO
 	// . implement connection state ( reuse this struct. Prevent new allocations. )
 	// . set the external error handler ( non-critical errors )
@@ -206,7 +208,7 @@ func (pc *protocon) receive() (result []byte, code byte, length int, err error)
 	cmd = (msg & 0xF0) >> 4
 	pack = append(pack, msg)
 	// Read remaining bytes after the fixed header
-	err = protocol.ReadPacket(pc.Reader, &pack, &rl)
+	err = protocol.ReadPacket(pc.Reader, &pack, &rl, pc.maxPacketSize)
 	if err != nil {
 		logger.FDebugf(fn, "- [protocon] readpacket(receive)error, msg, err", msg, err, rl)
 		logger.FDebugf(fn, "- [protocon] readpacket pc.reader:", pc.Reader, pc.Reader.Size())
@@ -146,6 +146,27 @@ func (csb *constatebase) OnPUBACK(packet protobase.PacketInterface) {
 	csb.Conn.Shutdown()
 }
 
+// OnPUBREC handles 'Pubrec' packet.
+// NOTE: empty method
+func (csb *constatebase) OnPUBREC(packet protobase.PacketInterface) {
+	logger.Debug("+ [constatebase] Pubrec.")
+	csb.Conn.Shutdown()
+}
+
+// OnPUBREL handles 'Pubrel' packet.
+// NOTE: empty method
+func (csb *constatebase) OnPUBREL(packet protobase.PacketInterface) {
+	logger.Debug("+ [constatebase] Pubrel.")
+	csb.Conn.Shutdown()
+}
+
+// OnPUBCOMP handles 'Pubcomp' packet.
+// NOTE: empty method
+func (csb *constatebase) OnPUBCOMP(packet protobase.PacketInterface) {
+	logger.Debug("+ [constatebase] Pubcomp.")
+	csb.Conn.Shutdown()
+}
+
 // OnSUBSCRIBE handles 'Subscribe' packet.
 // NOTE: empty method
 func (csb *constatebase) OnSUBSCRIBE(packet protobase.PacketInterface) {
@@ -160,6 +181,20 @@ func (csb *constatebase) OnSUBACK(packet protobase.PacketInterface) {
 	csb.Conn.Shutdown()
 }
 
+// OnUNSUBSCRIBE handles 'UnSubscribe' packet.
+// NOTE: empty method
+func (csb *constatebase) OnUNSUBSCRIBE(packet protobase.PacketInterface) {
+	logger.Debug("+ [constatebase] Unsubscribe.")
+	csb.Conn.Shutdown()
+}
+
+// OnUNSUBACK handles 'Unsuback' packet.
+// NOTE: empty method
+func (csb *constatebase) OnUNSUBACK(packet protobase.PacketInterface) {
+	logger.Debug("+ [constatebase] Unsuback.")
+	csb.Conn.Shutdown()
+}
+
 // OnPING handles 'Ping' packet.
 // NOTE: empty method
 func (csb *constatebase) OnPING(packet protobase.PacketInterface) {
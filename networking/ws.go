@@ -0,0 +1,66 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package networking
+
+import (
+	"net"
+	"net/url"
+
+	"github.com/mitghi/protox/protobase"
+	"golang.org/x/net/websocket"
+)
+
+// isWSAddr returns whether `addr` is a ws:// or wss:// URL.
+func isWSAddr(addr string) bool {
+	u, err := url.Parse(addr)
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss")
+}
+
+// dialWebSocket connects to the broker WebSocket endpoint at `addr`
+// using the protox subprotocol. wss:// endpoints use the TLS
+// configuration set by `SetupTLSConfig`.
+func (cg *CLBConnection) dialWebSocket(addr string) (net.Conn, error) {
+	var (
+		u      *url.URL
+		config *websocket.Config
+		ws     *websocket.Conn
+		origin string = "http://"
+		err    error
+	)
+	if u, err = url.Parse(addr); err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		origin = "https://"
+	}
+	if config, err = websocket.NewConfig(addr, origin+u.Host); err != nil {
+		return nil, err
+	}
+	config.Protocol = []string{protobase.WSSubprotocol}
+	config.TlsConfig = cg.tlsconf
+	if ws, err = websocket.DialConfig(config); err != nil {
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
@@ -23,7 +23,9 @@
 package protobase
 
 import (
+	"context"
 	"net"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -44,8 +46,34 @@ type (
 	AuthUserType string
 	// QAction is the type for identifying Queue commands.
 	QAction uint
+	// Properties is the extensible ( type-length-value ) property
+	// section of a publish packet, keyed by property identifier.
+	Properties map[byte][]byte
 )
 
+// Get returns the value of property `id`.
+func (p Properties) Get(id byte) ([]byte, bool) {
+	v, ok := p[id]
+	return v, ok
+}
+
+// Set sets the value of property `id`.
+func (p Properties) Set(id byte, val []byte) {
+	p[id] = val
+}
+
+// Clone returns a copy of the properties, or nil when empty.
+func (p Properties) Clone() Properties {
+	if len(p) == 0 {
+		return nil
+	}
+	np := make(Properties, len(p))
+	for k, v := range p {
+		np[k] = v
+	}
+	return np
+}
+
 // CredentialsInterface is the interface for credential providers.
 // It is used by authenicators.
 type CredentialsInterface interface {
@@ -73,11 +101,40 @@ type AuthInterface interface {
 	GetUserType(string) (AuthUserType, error)
 }
 
+// BanInterface is an optional interface for `AuthInterface`
+// implementors which deny connections of banned clients.
+type BanInterface interface {
+	IsBanned(clientId string) bool
+}
+
+// AddrInterface is an optional interface for `AuthInterface`
+// implementors which record the address clients connect from.
+type AddrInterface interface {
+	SetAddr(uid string, addr string)
+}
+
+// PeerCred contains credentials of a peer process connected over
+// a unix socket.
+type PeerCred struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+// PeerCredInterface is an optional interface for `AuthInterface`
+// implementors which authenticate local clients by credentials of
+// the peer process ( `AUTHModePeerCred` ).
+type PeerCredInterface interface {
+	CanAuthenticatePeer(creds CredentialsInterface, peer PeerCred) (bool, error)
+}
+
 // RetainStorageInterface is the interface for retained messages
 // container.
 type RetainStorageInterface interface {
 	Insert([]byte, EDProtocol) error
+	InsertTTL([]byte, EDProtocol, time.Duration) error
 	Find([]byte) (EDProtocol, error)
+	Match([]byte) []EDProtocol
 	Remove([]byte) error
 }
 
@@ -95,9 +152,25 @@ type MsgInterface interface {
 	Dir() MsgDir
 	Envelope() MsgEnvelopeInterface
 	SetWishQoS(byte)
+	Retain() bool
+	RetainTTL() uint32
+	SetRetain(bool, uint32)
+	Properties() Properties
+	SetProperties(Properties)
+	Expiry() uint32
+	SetExpiry(uint32)
+	Expired() bool
+	Compression() byte
+	SetCompression(byte)
 	Clone(MsgDir) MsgInterface
 }
 
+// ExpirableInterface is implemented by stored packets which carry
+// a limited lifetime.
+type ExpirableInterface interface {
+	Expired(time.Time) bool
+}
+
 // OptionInterface is the interface that represents a option.
 type OptionInterface interface {
 	StateCode() OptCode
@@ -115,9 +188,11 @@ type OptionInterface interface {
 // of messsage sequence.
 type MSGIDInterface interface {
 	GetNewID(uuid.UUID) uint16
+	Reserve(uint16, uuid.UUID) bool
 	IsOccupied(uint16) bool
 	GetUUID(uint16) (uuid.UUID, bool)
 	FreeId(uint16)
+	Occupied() []uint16
 }
 
 // MessageStorage is a interface that must be implemented
@@ -129,6 +204,7 @@ type MessageStorage interface {
 	AddOutbound(client string, msg EDProtocol) bool
 	DeleteIn(client string, msg EDProtocol) bool
 	DeleteOut(client string, msg EDProtocol) bool
+	ReplaceOut(client string, msg EDProtocol) bool
 	Exists(client string) bool
 	GetAllOut(client string) (msgs []EDProtocol)
 	GetAllOutStr(client string) (msgs []string)
@@ -136,6 +212,7 @@ type MessageStorage interface {
 	GetInbound(string, uuid.UUID) (EDProtocol, bool)
 	GetIDStoreO(client string) MSGIDInterface
 	GetIDStoreI(client string) MSGIDInterface
+	PurgeExpired(now time.Time) map[string][]EDProtocol
 	Close(client string) bool
 }
 
@@ -145,6 +222,7 @@ type MessageBox interface {
 	AddOutbound(msg EDProtocol) bool
 	DeleteIn(msg EDProtocol) bool
 	DeleteOut(msg EDProtocol) bool
+	ReplaceOut(msg EDProtocol) bool
 	GetAllOut() (msgs []EDProtocol)
 	GetAllOutStr() (msgs []string)
 	GetOutbound(uuid.UUID) (EDProtocol, bool)
@@ -162,6 +240,7 @@ type ClientInterface interface {
 	Disconnected(OptCode)
 	Publish(MsgInterface)
 	Subscribe(MsgInterface)
+	Unsubscribe(MsgInterface)
 	GetIdentifier() string
 	GetTopics() []string
 	GetCreds() CredentialsInterface
@@ -194,12 +273,15 @@ type ServerInterface interface {
 	NotifyDisconnected(prc ProtoConnection)
 	NotifyConnected(prc ProtoConnection)
 	NotifySubscribe(prc ProtoConnection, msg MsgInterface)
+	NotifyUnsubscribe(prc ProtoConnection, msg MsgInterface)
 	NotifyPublish(prc ProtoConnection, msg MsgInterface)
 	NotifyReject(prc ProtoConnection)
-	NotifyQueue(prc ProtoConnection, msg MsgInterface)
+	NotifyQueue(prc ProtoConnection, pdu EDProtocol)
+	NotifyQueueAck(prc ProtoConnection, pdu EDProtocol)
 
 	RegisterClient(prc ProtoConnection)
 	Redeliver(prc ProtoConnection)
+	OpenSession(clientId string, token string, clean bool) (string, bool)
 	Shutdown() (<-chan struct{}, error)
 
 	GetStatus() uint32
@@ -220,6 +302,11 @@ type RouterInterface interface {
 	Add(string, string, byte)
 	Find(string) (map[string]byte, error)
 	Remove(string, string) error
+	AddRetained(string, EDProtocol, time.Duration) error
+	RemoveRetained(string) error
+	FindRetained(string) []EDProtocol
+	PurgeRetained(time.Time) int
+	EachRetained(func(EDProtocol, time.Time))
 }
 
 // PacketInterface is low level interface for
@@ -272,16 +359,25 @@ type ProtoConnection interface {
 	SetClientDelegate(cl func(string, string, string) ClientInterface) // client constructor
 	SetMessageStorage(store MessageStorage)                            // data structure for storing packets and sequencing ids
 	SetHeartBeat(heartbeat int)                                        // max idle threshold
+	SetMaxPacketSize(size uint32)                                      // max incoming packet size
+	SetMaxQoS(qos byte)                                                // max accepted Quality of Service
+	SetReceiveMaximum(n uint16)                                        // max inflight QoS>0 publishes
+	SetAvailability(retain bool, wildcard bool)                        // supported retain and wildcard features
+	SetCompression(codecs []byte)                                      // supported payload compression codecs
+	SetVersions(versions []string)                                     // supported protocol versions
 	SetInitiateTimeout(timeout int)                                    // initial authorization/validation deadline
 	SetStatus(uint32)                                                  // set status on connection struct
 	SetNetConnection(net.Conn)                                         // set network connection ( socket )
 	SetPermissionDelegate(cl func(AuthInterface, ...string) bool)      // permission subsystem
 	SendMessage(MsgInterface, bool) error                              // write message to remote destination
 	SendRedelivery(EDProtocol) error                                   // redeliver packet to its destination
+	SendPacket(EDProtocol) error                                       // encode and write packet to remote destination
 	GetConnection() net.Conn                                           // access underlying network connection ( socket )
 	GetClient() ClientInterface                                        // access client structure
 	GetStatus() uint32                                                 // get connection status
 	GetErrChan() chan struct{}                                         // access error channel
+	GetWill() (MsgInterface, uint32)                                   // last will message and its delay in seconds
+	Compression() byte                                                 // negotiated payload compression codec
 	IsClean() bool                                                     // whether connection struct is reused
 
 	// TODO
@@ -299,6 +395,8 @@ type ProtoClientConnection interface {
 	SetNetConnection(net.Conn)
 	SetStatus(uint32)
 	SetClient(ClientInterface)
+	SetWill(MsgInterface, uint32)
+	SetSessionId(string)
 	ContinueFlag(bool)
 
 	GetConnection() net.Conn
@@ -306,14 +404,27 @@ type ProtoClientConnection interface {
 	GetStatus() uint32
 	GetErrChan() chan struct{}
 	GetTermChan() chan struct{}
+	ConnectError() error
+	SessionId() string
+	SessionPresent() bool
+	SetCompression([]byte)
+	SetVersions([]string)
+	Compression() byte
 
 	SendMessage(MsgInterface) error
 	SendRedelivery()
 
 	MakeEnvelope(route string, payload []byte, qos byte, messageId uint16, dir MsgDir) MsgInterface
 	Publish(string, []byte, byte, func(OptionInterface, MsgInterface)) error
+	PublishRetain(string, []byte, byte, uint32, func(OptionInterface, MsgInterface)) error
+	PublishWithProperties(string, []byte, byte, Properties, func(OptionInterface, MsgInterface)) error
+	PublishWithExpiry(string, []byte, byte, uint32, func(OptionInterface, MsgInterface)) error
 	Subscribe(string, byte, func(OptionInterface, MsgInterface)) error
+	Unsubscribe(string, byte, func(OptionInterface, MsgInterface)) error
 	Queue(QAction, string, string, []byte, []byte) error
+	QueueWithAck(QAction, string, string, []byte, []byte, func(byte)) error
+	Request(context.Context, string, []byte) ([]byte, error)
+	Gather(context.Context, string, []byte, int) ([][]byte, int, error)
 	Disconnect() error
 	// TODO
 	// SetOptions(OptionInterface)
@@ -448,12 +559,18 @@ type ProtoEventInterface interface {
 	OnCONNACK(PacketInterface)
 	OnPUBLISH(PacketInterface)
 	OnPUBACK(PacketInterface)
+	OnPUBREC(PacketInterface)
+	OnPUBREL(PacketInterface)
+	OnPUBCOMP(PacketInterface)
 	OnSUBSCRIBE(PacketInterface)
 	OnSUBACK(PacketInterface)
+	OnUNSUBSCRIBE(PacketInterface)
+	OnUNSUBACK(PacketInterface)
 	OnPING(PacketInterface)
 	OnPONG(PacketInterface)
 	OnDISCONNECT(PacketInterface)
 	OnQUEUE(PacketInterface)
+	OnQueueAck(PacketInterface)
 }
 
 // ConStateInterface is the requirement
@@ -27,9 +27,17 @@ const (
 	ProtoVersion = "\x50\x52\x58\x31"
 )
 
+// ProtoVersions lists protocol versions supported by default in
+// ascending order ( the last one is the most recent ).
+var ProtoVersions = []string{ProtoVersion}
+
+// WSSubprotocol is the WebSocket subprotocol carrying binary
+// protox frames.
+const WSSubprotocol = "protox"
+
 // Maximum supported Quality of Service
 const (
-	MAXQoS byte = 0x1
+	MAXQoS byte = 0x2
 )
 
 // QoS (Quality of Service) codes
@@ -71,6 +79,27 @@ const (
 	STATGODOWN       uint32 = 7
 )
 
+// Property identifiers, identifiers from `PROPUser` upwards
+// are free for application defined properties.
+const (
+	PROPContentType   byte = 0x01
+	PROPCorrelationId byte = 0x02
+	PROPReplyTo       byte = 0x03
+	PROPExpiry        byte = 0x04
+	PROPCompression   byte = 0x05
+	PROPDeadTopic     byte = 0x06
+	PROPDeadReason    byte = 0x07
+	PROPDeadAttempts  byte = 0x08
+	PROPUser          byte = 0x80
+)
+
+// Payload compression codecs, negotiated on connection.
+const (
+	COMPNone  byte = 0x00
+	COMPFlate byte = 0x01
+	COMPGzip  byte = 0x02
+)
+
 // Connection response codes
 const (
 	TMP_RESPOK = 0x10 // TODO: change this later
@@ -79,6 +108,13 @@ const (
 	RESPOK     = 0x02
 	RESPNOK    = 0x03
 	RESPERR    = 0x04
+	// connection refused reasons
+	RESPBADCREDS   = 0x05 // bad username or password
+	RESPNOTAUTH    = 0x06 // not authorized
+	RESPBADVERSION = 0x07 // unsupported protocol version
+	RESPBUSY       = 0x08 // server unavailable or busy
+	RESPBANNED     = 0x09 // client is banned
+	RESPBADCLID    = 0x0A // client identifier rejected
 )
 
 // Connection response header options
@@ -94,6 +130,7 @@ const (
 	PNULL        = 0x00
 	PCONNECT     = 0x01
 	PCONNACK     = 0x02
+	PPUBREC      = 0x03
 	PQUEUE       = 0x04
 	PQUEUEACK    = 0x05
 	PPUBACK      = 0x06
@@ -105,6 +142,9 @@ const (
 	PPING        = 0x0C
 	PPONG        = 0x0D
 	PDISCONNECT  = 0x0E
+	PPUBCOMP     = 0x0F
+	// NOTE: PUBREL shares the PUBREC code and is
+	// distinguished by `PUBRELFlag` in the fixed header.
 	// TODO
 	//  PRESACK      = 0x06
 	// NOTE: new control codes should be included
@@ -124,6 +164,8 @@ const (
 const (
 	CCONNECT     byte = byte(0x1 << 4)
 	CCONNACK     byte = byte(0x2 << 4)
+	CPUBREC      byte = byte(0x3 << 4)
+	CPUBREL      byte = byte(0x3<<4) | PUBRELFlag
 	CQUEUE       byte = byte(0x4 << 4)
 	CQUEUEACK    byte = byte(0x5 << 4)
 	CPUBACK      byte = byte(0x6 << 4)
@@ -135,6 +177,7 @@ const (
 	CPING        byte = byte(0xC << 4)
 	CPONG        byte = byte(0xD << 4)
 	CDISCONNECT  byte = byte(0xE << 4)
+	CPUBCOMP     byte = byte(0xF << 4)
 	// TODO
 	//  CRESACK byte      = byte(0x6 << 4)
 	//  CREQACK      byte = byte(0x4 << 4)
@@ -142,6 +185,12 @@ const (
 	// 	CRESPONSE    byte = byte(0x3 << 4)
 )
 
+// PUBRELFlag is the fixed header option ( mask : 0x0F ) which
+// marks a PUBREC coded packet as PUBREL.
+const (
+	PUBRELFlag byte = 0x02
+)
+
 // Server status
 const (
 	ServerNone    uint32 = 1
@@ -190,6 +239,10 @@ const (
 	//   action.
 	AUTHModeStrict
 	AUTHModeRouter
+	// AUTHModePeerCred authenticates clients connecting over
+	// unix sockets by credentials of the peer process instead
+	// of passwords, other clients authenticate with passwords.
+	AUTHModePeerCred
 )
 
 // Client mode flags
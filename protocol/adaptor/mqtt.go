@@ -0,0 +1,815 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package adaptor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+	"github.com/mitghi/protox/protocol/packet"
+)
+
+// MQTT 3.1.1 protocol name and level.
+const (
+	MQTTProtoName  string = "MQTT"
+	MQTTProtoLevel byte   = 0x04
+)
+
+// MQTT control packet codes ( shifted to left, mask : 0xF0 ).
+const (
+	MCONNECT     byte = byte(0x1 << 4)
+	MCONNACK     byte = byte(0x2 << 4)
+	MPUBLISH     byte = byte(0x3 << 4)
+	MPUBACK      byte = byte(0x4 << 4)
+	MPUBREC      byte = byte(0x5 << 4)
+	MPUBREL      byte = byte(0x6<<4) | 0x02
+	MPUBCOMP     byte = byte(0x7 << 4)
+	MSUBSCRIBE   byte = byte(0x8<<4) | 0x02
+	MSUBACK      byte = byte(0x9 << 4)
+	MUNSUBSCRIBE byte = byte(0xA<<4) | 0x02
+	MUNSUBACK    byte = byte(0xB << 4)
+	MPINGREQ     byte = byte(0xC << 4)
+	MPINGRESP    byte = byte(0xD << 4)
+	MDISCONNECT  byte = byte(0xE << 4)
+)
+
+// MQTT connack return codes.
+const (
+	MConnAccepted      byte = 0x00
+	MConnBadVersion    byte = 0x01
+	MConnBadClientId   byte = 0x02
+	MConnUnavailable   byte = 0x03
+	MConnBadCreds      byte = 0x04
+	MConnNotAuthorized byte = 0x05
+)
+
+// MSubFailure is the suback return code of a refused subscription.
+const MSubFailure byte = 0x80
+
+// MQTT adaptor error messages
+var (
+	EMQTTBadVersion   error = errors.New("adaptor: unsupported mqtt protocol version.")
+	EMQTTMalformed    error = errors.New("adaptor: malformed mqtt packet.")
+	EMQTTNotConnected error = errors.New("adaptor: mqtt connect is expected.")
+	EMQTTBadTopic     error = errors.New("adaptor: invalid mqtt topic.")
+)
+
+// MQTTSessions maps MQTT client identifiers to protox session
+// tokens and their subscriptions. MQTT clients resume sessions
+// by their identifier, it is shared by all connections of a
+// listener.
+type MQTTSessions struct {
+	sync.Mutex
+	tokens map[string]string
+	subs   map[string]*mqttSubs
+}
+
+// mqttSubs is the subscription state of a MQTT client. Distinct MQTT
+// filters may collapse onto the same protox subscription, which is
+// therefore reference counted.
+type mqttSubs struct {
+	sync.Mutex
+	filters map[string]byte // MQTT filter -> requested QoS
+	topics  map[string]int  // protox topic -> number of filters
+}
+
+// NewMQTTSessions returns a pointer to a new `MQTTSessions`.
+func NewMQTTSessions() *MQTTSessions {
+	return &MQTTSessions{tokens: make(map[string]string), subs: make(map[string]*mqttSubs)}
+}
+
+// newMQTTSubs returns a pointer to a new `mqttSubs`.
+func newMQTTSubs() *mqttSubs {
+	return &mqttSubs{filters: make(map[string]byte), topics: make(map[string]int)}
+}
+
+// get returns the session token of client `clid`.
+func (ms *MQTTSessions) get(clid string) string {
+	ms.Lock()
+	defer ms.Unlock()
+	return ms.tokens[clid]
+}
+
+// set sets the session token of client `clid`.
+func (ms *MQTTSessions) set(clid string, token string) {
+	ms.Lock()
+	ms.tokens[clid] = token
+	ms.Unlock()
+}
+
+// subscriptions returns the subscriptions of client `clid`, they
+// are discarded when `clean` is set.
+func (ms *MQTTSessions) subscriptions(clid string, clean bool) *mqttSubs {
+	if clid == "" {
+		return newMQTTSubs()
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	subs, ok := ms.subs[clid]
+	if !ok || clean {
+		subs = newMQTTSubs()
+		ms.subs[clid] = subs
+	}
+	return subs
+}
+
+// add records `filter` requested with `qos` which is covered by protox
+// `topics`.
+func (ms *mqttSubs) add(filter string, topics []string, qos byte) {
+	ms.Lock()
+	defer ms.Unlock()
+	if _, ok := ms.filters[filter]; !ok {
+		for _, topic := range topics {
+			ms.topics[topic]++
+		}
+	}
+	ms.filters[filter] = qos
+}
+
+// remove removes `filter` which is covered by protox `topics`. It
+// returns topics which are not referenced by other filters anymore.
+func (ms *mqttSubs) remove(filter string, topics []string) (removed []string) {
+	ms.Lock()
+	defer ms.Unlock()
+	if _, ok := ms.filters[filter]; !ok {
+		return nil
+	}
+	delete(ms.filters, filter)
+	for _, topic := range topics {
+		if ms.topics[topic]--; ms.topics[topic] <= 0 {
+			delete(ms.topics, topic)
+			removed = append(removed, topic)
+		}
+	}
+	return removed
+}
+
+// qos returns the highest QoS requested by filters covered by protox
+// `topic`.
+func (ms *mqttSubs) qos(topic string) (qos byte) {
+	ms.Lock()
+	defer ms.Unlock()
+	for filter, fqos := range ms.filters {
+		if fqos <= qos {
+			continue
+		}
+		if topics, _ := topicFilters(filter); containsTopic(topics, topic) {
+			qos = fqos
+		}
+	}
+	return qos
+}
+
+// match returns whether `topic` matches any of the MQTT filters.
+func (ms *mqttSubs) match(topic string) bool {
+	ms.Lock()
+	defer ms.Unlock()
+	for filter := range ms.filters {
+		if MatchFilter(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// MQTTConn is a `net.Conn` which translates MQTT 3.1.1 packets read
+// from the underlying connection into protox packets, and protox
+// packets written to it into MQTT 3.1.1 packets. It lets a regular
+// protox connection serve MQTT clients.
+type MQTTConn struct {
+	net.Conn
+
+	sync.Mutex
+	sessions   *MQTTSessions
+	reader     *bufio.Reader
+	rbuf       bytes.Buffer // translated packets pending read
+	rlock      sync.Mutex   // guards rbuf
+	wbuf       bytes.Buffer // written packets pending translation
+	wlock      sync.Mutex   // guards writes and negotiated state
+	subs       *mqttSubs
+	permission func(clid string, topic string) bool
+	clientId   string
+	maxQoS     byte
+	wide       bool
+	wildcard   bool
+	connected  bool
+}
+
+// NewMQTTConn returns a pointer to a new `MQTTConn` wrapping `conn`.
+// Session tokens are kept in `sessions`.
+func NewMQTTConn(conn net.Conn, sessions *MQTTSessions) *MQTTConn {
+	return &MQTTConn{
+		Conn:     conn,
+		sessions: sessions,
+		reader:   bufio.NewReader(conn),
+		subs:     newMQTTSubs(),
+		maxQoS:   protobase.MAXQoS,
+		wide:     true,
+		wildcard: true,
+	}
+}
+
+// SetPermissionDelegate sets the routine which decides whether a client
+// may subscribe to a protox topic. Refused subscriptions are not sent to
+// the broker and acknowledged with `MSubFailure`.
+func (mc *MQTTConn) SetPermissionDelegate(fn func(clid string, topic string) bool) {
+	mc.wlock.Lock()
+	mc.permission = fn
+	mc.wlock.Unlock()
+}
+
+// TopicFilter maps a MQTT topic filter onto protox topic syntax. Both
+// single level ( '+' ) and multi level ( '#' ) wildcards become the
+// protox wildcard, which matches all remaining levels when trailing.
+func TopicFilter(filter string) (string, error) {
+	if filter == "" {
+		return "", EMQTTBadTopic
+	}
+	var (
+		sep    string   = string(messages.TSEP)
+		wc     string   = string(messages.TWLDCD)
+		levels []string = strings.Split(filter, sep)
+	)
+	for i, l := range levels {
+		switch {
+		case l == "#":
+			if i != len(levels)-1 {
+				return "", EMQTTBadTopic
+			}
+			levels[i] = wc
+		case l == "+":
+			levels[i] = wc
+		case strings.ContainsAny(l, "+#"+wc):
+			return "", EMQTTBadTopic
+		}
+	}
+	return strings.Join(levels, sep), nil
+}
+
+// topicFilters returns protox subscriptions covering MQTT `filter`. A
+// trailing multi level wildcard also matches its parent level, which
+// is subscribed explicitly.
+func topicFilters(filter string) ([]string, error) {
+	topic, err := TopicFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	topics := []string{topic}
+	if parent := strings.TrimSuffix(filter, "/#"); parent != filter && parent != "" {
+		if parent, err = TopicFilter(parent); err != nil {
+			return nil, err
+		}
+		topics = append(topics, parent)
+	}
+	return topics, nil
+}
+
+// MatchFilter returns whether `topic` matches MQTT topic `filter`.
+// Wildcards at the first level do not match topics starting with '$'.
+func MatchFilter(filter string, topic string) bool {
+	var (
+		fl []string = strings.Split(filter, "/")
+		tl []string = strings.Split(topic, "/")
+	)
+	if strings.HasPrefix(topic, "$") && (fl[0] == "+" || fl[0] == "#") {
+		return false
+	}
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) || (f != "+" && f != tl[i]) {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+// containsTopic returns whether `topics` contains `topic`.
+func containsTopic(topics []string, topic string) bool {
+	for _, t := range topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads translated protox packets.
+func (mc *MQTTConn) Read(b []byte) (n int, err error) {
+	for {
+		mc.rlock.Lock()
+		if mc.rbuf.Len() > 0 {
+			n, err = mc.rbuf.Read(b)
+			mc.rlock.Unlock()
+			return n, err
+		}
+		mc.rlock.Unlock()
+		if err = mc.readPacket(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write translates protox packets in `b` and writes them to the
+// underlying connection. Incomplete packets are kept until the
+// remaining bytes are written.
+func (mc *MQTTConn) Write(b []byte) (n int, err error) {
+	mc.Lock()
+	defer mc.Unlock()
+	mc.wbuf.Write(b)
+	for {
+		pkt := nextPacket(&mc.wbuf)
+		if pkt == nil {
+			break
+		}
+		if err = mc.translateOut(pkt); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// readPacket reads a MQTT packet and translates it.
+func (mc *MQTTConn) readPacket() (err error) {
+	var (
+		cmd    byte
+		length int32
+		body   []byte
+	)
+	if cmd, err = mc.reader.ReadByte(); err != nil {
+		return err
+	}
+	if length, err = readLength(mc.reader); err != nil {
+		return err
+	}
+	body = make([]byte, length)
+	if _, err = io.ReadFull(mc.reader, body); err != nil {
+		return err
+	}
+	if !mc.connected && cmd != MCONNECT {
+		return EMQTTNotConnected
+	}
+	mc.rlock.Lock()
+	defer mc.rlock.Unlock()
+	return mc.translateIn(cmd, body)
+}
+
+// translateIn translates a MQTT packet into protox packets.
+func (mc *MQTTConn) translateIn(cmd byte, body []byte) (err error) {
+	defer func() {
+		err = protocol.RecoverError(err, recover())
+	}()
+	switch cmd & 0xF0 {
+	case MCONNECT:
+		if mc.connected {
+			return EMQTTMalformed
+		}
+		return mc.onConnect(body)
+	case MPUBLISH:
+		return mc.onPublish(cmd, body)
+	case MPUBACK:
+		mc.forward(protobase.CPUBACK, body)
+	case MPUBREC:
+		mc.forward(protobase.CPUBREC, body)
+	case MPUBREL & 0xF0:
+		mc.forward(protobase.CPUBREL, body)
+	case MPUBCOMP:
+		mc.forward(protobase.CPUBCOMP, body)
+	case MSUBSCRIBE & 0xF0:
+		return mc.onSubscribe(body)
+	case MUNSUBSCRIBE & 0xF0:
+		return mc.onUnsubscribe(body)
+	case MPINGREQ:
+		mc.forward(protobase.CPING, nil)
+	case MDISCONNECT:
+		mc.forward(protobase.CDISCONNECT, nil)
+	default:
+		return EMQTTMalformed
+	}
+	return nil
+}
+
+// forward writes a protox packet with command `cmd` and `body`
+// as its content. It must be called while holding `rlock`.
+func (mc *MQTTConn) forward(cmd byte, body []byte) {
+	mc.rbuf.WriteByte(cmd)
+	protocol.EncodeLength(int32(len(body)), &mc.rbuf)
+	mc.rbuf.Write(body)
+}
+
+// onConnect translates a MQTT connect packet.
+func (mc *MQTTConn) onConnect(body []byte) (err error) {
+	var (
+		r   *bytes.Reader     = bytes.NewReader(body)
+		rem int32             = int32(len(body))
+		cn  *protocol.Connect = protocol.NewRawConnect()
+	)
+	name := protocol.GetString(r, &rem)
+	level := protocol.GetUint8(r, &rem)
+	if name != MQTTProtoName || level != MQTTProtoLevel {
+		mc.writePacket(MCONNACK, []byte{0x00, MConnBadVersion})
+		return EMQTTBadVersion
+	}
+	flags := protocol.GetUint8(r, &rem)
+	cn.KeepAlive = int(protocol.GetUint16(r, &rem))
+	cn.ClientId = protocol.GetString(r, &rem)
+	if (flags & 0x04) != 0 {
+		cn.WillTopic = protocol.GetString(r, &rem)
+		cn.WillMessage = protocol.GetBytes(r, &rem)
+		cn.WillQoS = (flags >> 3) & 0x03
+		cn.WillRetain = (flags & 0x20) != 0
+	}
+	if (flags & 0x80) != 0 {
+		cn.Username = protocol.GetString(r, &rem)
+	}
+	if (flags & 0x40) != 0 {
+		cn.Password = string(protocol.GetBytes(r, &rem))
+	}
+	cn.Meta.CleanStart = (flags & 0x02) != 0
+	cn.Meta.WideLength = true
+	// offer the version to receive reason codes
+	cn.Version, cn.Versions = protobase.ProtoVersion, []string{protobase.ProtoVersion}
+	if !cn.Meta.CleanStart && cn.ClientId != "" {
+		cn.SessionId = mc.sessions.get(cn.ClientId)
+	}
+	if err = cn.Encode(); err != nil {
+		return err
+	}
+	subs := mc.sessions.subscriptions(cn.ClientId, cn.Meta.CleanStart)
+	mc.wlock.Lock()
+	mc.clientId = cn.ClientId
+	mc.subs = subs
+	mc.wlock.Unlock()
+	mc.connected = true
+	mc.rbuf.Write(cn.Encoded.Bytes())
+	return nil
+}
+
+// onPublish translates a MQTT publish packet.
+func (mc *MQTTConn) onPublish(cmd byte, body []byte) (err error) {
+	var (
+		r   *bytes.Reader     = bytes.NewReader(body)
+		rem int32             = int32(len(body))
+		pb  *protocol.Publish = protocol.NewRawPublish()
+	)
+	pb.Meta.Dup, pb.Meta.Ret, pb.Meta.Qos = protocol.ParseHOptions(cmd & 0x0F)
+	if pb.Meta.Qos > protobase.MAXQoS {
+		return EMQTTMalformed
+	}
+	pb.Topic = protocol.GetString(r, &rem)
+	if pb.Topic == "" || strings.ContainsAny(pb.Topic, "+#"+string(messages.TWLDCD)) {
+		return EMQTTBadTopic
+	}
+	if pb.Meta.Qos > 0 {
+		pb.Meta.MessageId = protocol.GetUint16(r, &rem)
+	}
+	pb.Message = body[len(body)-int(rem):]
+	pb.Meta.WideLength = mc.isWide()
+	if err = pb.Encode(); err != nil {
+		return err
+	}
+	mc.rbuf.Write(pb.Encoded.Bytes())
+	return nil
+}
+
+// onSubscribe translates a MQTT subscribe packet into protox subscribe
+// packets for topic filters granted by the broker and acknowledges it.
+// Filters collapsing onto the same protox subscription share it with
+// the highest requested QoS, it is sent again to replay retained
+// messages.
+func (mc *MQTTConn) onSubscribe(body []byte) (err error) {
+	var (
+		r      *bytes.Reader = bytes.NewReader(body)
+		rem    int32         = int32(len(body))
+		id     uint16        = protocol.GetUint16(r, &rem)
+		maxQoS byte          = mc.getMaxQoS()
+		subs   *mqttSubs     = mc.getSubs()
+		codes  bytes.Buffer
+	)
+	protocol.SetUint16(id, &codes)
+	for rem > 0 {
+		filter := protocol.GetString(r, &rem)
+		qos := protocol.GetUint8(r, &rem) & 0x03
+		topics, err := topicFilters(filter)
+		if err != nil || qos > protobase.MAXQoS || !mc.canSubscribe(topics) {
+			codes.WriteByte(MSubFailure)
+			continue
+		}
+		subs.add(filter, topics, qos)
+		for _, topic := range topics {
+			sub := protocol.NewRawSubscribe()
+			sub.Topic = topic
+			sub.Meta.Qos, sub.Meta.MessageId = subs.qos(topic), id
+			if err = sub.Encode(); err != nil {
+				return err
+			}
+			mc.rbuf.Write(sub.Encoded.Bytes())
+		}
+		if qos > maxQoS {
+			qos = maxQoS
+		}
+		codes.WriteByte(qos)
+	}
+	if codes.Len() == 2 {
+		// at least one topic filter is required
+		return EMQTTMalformed
+	}
+	return mc.writePacket(MSUBACK, codes.Bytes())
+}
+
+// onUnsubscribe translates a MQTT unsubscribe packet into protox
+// unsubscribe packets for subscriptions not covered by remaining
+// filters and acknowledges it.
+func (mc *MQTTConn) onUnsubscribe(body []byte) (err error) {
+	var (
+		r    *bytes.Reader = bytes.NewReader(body)
+		rem  int32         = int32(len(body))
+		id   uint16        = protocol.GetUint16(r, &rem)
+		subs *mqttSubs     = mc.getSubs()
+		ack  bytes.Buffer
+	)
+	for rem > 0 {
+		filter := protocol.GetString(r, &rem)
+		topics, err := topicFilters(filter)
+		if err != nil {
+			continue
+		}
+		for _, topic := range subs.remove(filter, topics) {
+			unsub := protocol.NewRawUnSubscribe()
+			unsub.Topic = topic
+			unsub.Meta.Qos, unsub.Meta.MessageId = protobase.LQOS1, id
+			if err = unsub.Encode(); err != nil {
+				return err
+			}
+			mc.rbuf.Write(unsub.Encoded.Bytes())
+		}
+	}
+	protocol.SetUint16(id, &ack)
+	return mc.writePacket(MUNSUBACK, ack.Bytes())
+}
+
+// canSubscribe returns whether the broker grants subscriptions to all
+// protox `topics`.
+func (mc *MQTTConn) canSubscribe(topics []string) bool {
+	mc.wlock.Lock()
+	clid, wildcard, permission := mc.clientId, mc.wildcard, mc.permission
+	mc.wlock.Unlock()
+	for _, topic := range topics {
+		if !wildcard && strings.IndexByte(topic, messages.TWLDCD) >= 0 {
+			return false
+		}
+		if permission != nil && !permission(clid, topic) {
+			return false
+		}
+	}
+	return true
+}
+
+// translateOut translates a protox packet into a MQTT packet. Packets
+// without MQTT counterpart, or already acknowledged by the adaptor
+// ( e.g. SUBACK ) are dropped.
+func (mc *MQTTConn) translateOut(pkt []byte) (err error) {
+	defer func() {
+		err = protocol.RecoverError(err, recover())
+	}()
+	cmd := pkt[0]
+	if cmd == protobase.CPUBREL {
+		return mc.relabel(MPUBREL, pkt)
+	}
+	switch cmd & 0xF0 {
+	case protobase.CCONNACK:
+		return mc.onConnack(pkt)
+	case protobase.CPUBLISH:
+		return mc.onPublishOut(pkt)
+	case protobase.CPUBACK:
+		return mc.relabel(MPUBACK, pkt)
+	case protobase.CPUBREC:
+		return mc.relabel(MPUBREC, pkt)
+	case protobase.CPUBCOMP:
+		return mc.relabel(MPUBCOMP, pkt)
+	case protobase.CPONG:
+		return mc.relabel(MPINGRESP, pkt)
+	}
+	return nil
+}
+
+// relabel writes `pkt` with its command replaced by `cmd`.
+func (mc *MQTTConn) relabel(cmd byte, pkt []byte) error {
+	out := make([]byte, len(pkt))
+	copy(out, pkt)
+	out[0] = cmd
+	return mc.write(out)
+}
+
+// onConnack translates a protox connack packet and keeps the
+// negotiated session and limits.
+func (mc *MQTTConn) onConnack(pkt []byte) error {
+	ca := protocol.NewConnack(packet.NewPacket(pkt, pkt[0], len(pkt)))
+	if ca == nil {
+		return EMQTTMalformed
+	}
+	var (
+		code    byte = connackCode(ca.ResultCode)
+		present byte
+	)
+	if code == MConnAccepted {
+		if clid := mc.setLimits(ca); ca.SessionId != "" && clid != "" {
+			mc.sessions.set(clid, ca.SessionId)
+		}
+		if ca.Meta.HasSession {
+			present = 0x01
+		}
+	}
+	return mc.writePacket(MCONNACK, []byte{present, code})
+}
+
+// onPublishOut translates a protox publish packet. Payload is
+// delivered uncompressed and protox only fields are dropped.
+func (mc *MQTTConn) onPublishOut(pkt []byte) (err error) {
+	pb := protocol.NewPublishWide(packet.NewPacket(pkt, pkt[0], len(pkt)), mc.isWide())
+	if pb == nil {
+		return EMQTTMalformed
+	}
+	if !mc.getSubs().match(pb.Topic) {
+		// NOTE
+		// . protox wildcards match more topics than MQTT filters
+		//   they were translated from. Such deliveries are dropped
+		//   and acknowledged on behalf of the client, the ack is
+		//   read along with the next packet of the client.
+		mc.dismiss(pb)
+		return nil
+	}
+	var (
+		body    bytes.Buffer
+		message []byte = pb.Message
+	)
+	if pb.Compression != protobase.COMPNone {
+		if message, err = protocol.Decompress(pb.Compression, message); err != nil {
+			return err
+		}
+	}
+	protocol.SetString(pb.Topic, &body)
+	if pb.Meta.Qos > 0 {
+		protocol.SetUint16(pb.Meta.MessageId, &body)
+	}
+	body.Write(message)
+	return mc.writePacket(MPUBLISH|protocol.CreateHOpts(pb.Meta.Qos, pb.Meta.Dup, pb.Meta.Ret), body.Bytes())
+}
+
+// dismiss acknowledges publish `pb` to the broker without delivery.
+func (mc *MQTTConn) dismiss(pb *protocol.Publish) {
+	var id bytes.Buffer
+	protocol.SetUint16(pb.Meta.MessageId, &id)
+	mc.rlock.Lock()
+	defer mc.rlock.Unlock()
+	switch pb.Meta.Qos {
+	case protobase.LQOS1:
+		mc.forward(protobase.CPUBACK, id.Bytes())
+	case protobase.LQOS2:
+		mc.forward(protobase.CPUBREC, id.Bytes())
+	}
+}
+
+// writePacket writes a MQTT packet with command `cmd` and `body`
+// as its content.
+func (mc *MQTTConn) writePacket(cmd byte, body []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(cmd)
+	protocol.EncodeLength(int32(len(body)), &buf)
+	buf.Write(body)
+	return mc.write(buf.Bytes())
+}
+
+// write writes `b` to the underlying connection.
+func (mc *MQTTConn) write(b []byte) error {
+	mc.wlock.Lock()
+	defer mc.wlock.Unlock()
+	_, err := mc.Conn.Write(b)
+	return err
+}
+
+// setLimits keeps limits negotiated in `ca` and returns the
+// client identifier.
+func (mc *MQTTConn) setLimits(ca *protocol.Connack) string {
+	mc.wlock.Lock()
+	defer mc.wlock.Unlock()
+	mc.wide = ca.Meta.WideLength
+	if ca.Caps != nil {
+		mc.maxQoS = ca.Caps.MaxQoS
+		mc.wildcard = ca.Caps.WildcardAvailable
+	}
+	return mc.clientId
+}
+
+// isWide returns whether wide payload fields are negotiated.
+func (mc *MQTTConn) isWide() bool {
+	mc.wlock.Lock()
+	defer mc.wlock.Unlock()
+	return mc.wide
+}
+
+// getSubs returns the subscriptions of the client.
+func (mc *MQTTConn) getSubs() *mqttSubs {
+	mc.wlock.Lock()
+	defer mc.wlock.Unlock()
+	return mc.subs
+}
+
+// getMaxQoS returns the maximum QoS granted by the broker.
+func (mc *MQTTConn) getMaxQoS() byte {
+	mc.wlock.Lock()
+	defer mc.wlock.Unlock()
+	return mc.maxQoS
+}
+
+// connackCode maps a protox connection response code onto a
+// MQTT connack return code.
+func connackCode(code byte) byte {
+	switch code {
+	case protobase.RESPOK:
+		return MConnAccepted
+	case protobase.RESPBADVERSION:
+		return MConnBadVersion
+	case protobase.RESPBADCLID:
+		return MConnBadClientId
+	case protobase.RESPBADCREDS:
+		return MConnBadCreds
+	case protobase.RESPNOTAUTH, protobase.RESPBANNED:
+		return MConnNotAuthorized
+	}
+	return MConnUnavailable
+}
+
+// readLength reads a remaining length field.
+func readLength(r io.ByteReader) (int32, error) {
+	var (
+		v     int32
+		shift uint
+	)
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= int32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+		shift += 7
+	}
+	return 0, protocol.BadLengthEncodingError
+}
+
+// nextPacket removes and returns the first complete packet in `buf`,
+// or nil when it is not fully buffered yet.
+func nextPacket(buf *bytes.Buffer) []byte {
+	var (
+		b     []byte = buf.Bytes()
+		r     *bytes.Reader
+		total int32
+	)
+	if len(b) < 2 {
+		return nil
+	}
+	r = bytes.NewReader(b[1:])
+	length, err := readLength(r)
+	if err != nil {
+		return nil
+	}
+	total = int32(len(b)-1-r.Len()) + 1 + length
+	if int32(len(b)) < total {
+		return nil
+	}
+	pkt := make([]byte, total)
+	copy(pkt, buf.Next(int(total)))
+	return pkt
+}
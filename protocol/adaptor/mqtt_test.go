@@ -0,0 +1,301 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package adaptor
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+	"github.com/mitghi/protox/protocol/packet"
+)
+
+// mqttPacket crafts a MQTT packet.
+func mqttPacket(cmd byte, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(cmd)
+	protocol.EncodeLength(int32(len(body)), &buf)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// readFrame reads a complete packet from `r`.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var frame bytes.Buffer
+	cmd, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	frame.WriteByte(cmd)
+	protocol.EncodeLength(length, &frame)
+	frame.Write(body)
+	return frame.Bytes(), nil
+}
+
+// mustFrame reads a complete packet from `r` or fails the test.
+func mustFrame(t *testing.T, r *bufio.Reader) []byte {
+	frame, err := readFrame(r)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	return frame
+}
+
+func TestTopicFilter(t *testing.T) {
+	for filter, expected := range map[string]string{
+		"a/b/c":   "a/b/c",
+		"a/+/c":   "a/*/c",
+		"a/#":     "a/*",
+		"#":       "*",
+		"+/+/c/#": "*/*/c/*",
+	} {
+		topic, err := TopicFilter(filter)
+		if err != nil || topic != expected {
+			t.Fatal("inconsistent topic filter.", filter, topic, err)
+		}
+	}
+	for _, filter := range []string{"", "a/#/c", "a/b+", "a/*"} {
+		if _, err := TopicFilter(filter); err == nil {
+			t.Fatal("expected invalid topic filter.", filter)
+		}
+	}
+}
+
+func TestMatchFilter(t *testing.T) {
+	for _, c := range []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"a/+/c", "a/b/c", true},
+		{"#", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+	} {
+		if MatchFilter(c.filter, c.topic) != c.match {
+			t.Fatal("inconsistent filter match.", c.filter, c.topic, c.match)
+		}
+	}
+}
+
+func TestMQTTConn(t *testing.T) {
+	var (
+		device, broker = net.Pipe()
+		mc             = NewMQTTConn(broker, NewMQTTSessions())
+		fromDevice     = bufio.NewReader(mc)
+		toDevice       = make(chan []byte, 8)
+		body           bytes.Buffer
+	)
+	defer device.Close()
+	defer mc.Close()
+	// drain the device side, pipes are unbuffered
+	go func() {
+		r := bufio.NewReader(device)
+		for {
+			frame, err := readFrame(r)
+			if err != nil {
+				close(toDevice)
+				return
+			}
+			toDevice <- frame
+		}
+	}()
+	// CONNECT: clean session, will, username and password
+	protocol.SetString(MQTTProtoName, &body)
+	body.WriteByte(MQTTProtoLevel)
+	body.WriteByte(0x80 | 0x40 | 0x20 | 0x08 | 0x04 | 0x02)
+	protocol.SetUint16(30, &body)
+	protocol.SetString("device", &body)
+	protocol.SetString("devices/device/status", &body)
+	protocol.SetBytes([]byte("offline"), &body)
+	protocol.SetString("user", &body)
+	protocol.SetBytes([]byte("pass"), &body)
+	go device.Write(mqttPacket(MCONNECT, body.Bytes()))
+	frame := mustFrame(t, fromDevice)
+	cn := protocol.NewConnect(packet.NewPacket(frame, frame[0], len(frame)))
+	if cn == nil {
+		t.Fatal("cn==nil, expected translated connect.")
+	}
+	if cn.ClientId != "device" || cn.Username != "user" || cn.Password != "pass" || cn.KeepAlive != 30 || !cn.Meta.CleanStart {
+		t.Fatal("inconsistent connect after translation.", cn)
+	}
+	if cn.WillTopic != "devices/device/status" || string(cn.WillMessage) != "offline" || cn.WillQoS != 1 || !cn.WillRetain {
+		t.Fatal("inconsistent will after translation.", cn.WillTopic, cn.WillQoS, cn.WillRetain)
+	}
+	// CONNACK
+	ca := protocol.NewRawConnack()
+	ca.ResultCode = protobase.RESPOK
+	ca.SessionId = "token"
+	ca.Meta.WideLength = true
+	ca.Caps = &protocol.Capabilities{MaxQoS: protobase.LQOS1, WildcardAvailable: true}
+	if err := ca.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	go mc.Write(ca.Encoded.Bytes())
+	if frame = <-toDevice; !bytes.Equal(frame, []byte{MCONNACK, 0x02, 0x00, MConnAccepted}) {
+		t.Fatal("inconsistent connack after translation.", frame)
+	}
+	// SUBSCRIBE
+	body.Reset()
+	protocol.SetUint16(7, &body)
+	protocol.SetString("sensors/+/temperature", &body)
+	body.WriteByte(protobase.LQOS2)
+	protocol.SetString("a/#/b", &body)
+	body.WriteByte(protobase.LQOS0)
+	go device.Write(mqttPacket(MSUBSCRIBE, body.Bytes()))
+	frame = mustFrame(t, fromDevice)
+	sub := protocol.NewSubscribe(packet.NewPacket(frame, frame[0], len(frame)))
+	if sub == nil || sub.Topic != "sensors/*/temperature" || sub.Meta.Qos != protobase.LQOS2 || sub.Meta.MessageId != 7 {
+		t.Fatal("inconsistent subscribe after translation.", sub)
+	}
+	if frame = <-toDevice; !bytes.Equal(frame, []byte{MSUBACK, 0x04, 0x00, 0x07, protobase.LQOS1, MSubFailure}) {
+		t.Fatal("inconsistent suback, expected granted QoS and failure.", frame)
+	}
+	// PUBLISH ( broker to device )
+	pb := protocol.NewRawPublish()
+	pb.Topic, pb.Message = "sensors/a/temperature", []byte("21.5")
+	pb.Meta.Qos, pb.Meta.MessageId, pb.Meta.WideLength = protobase.LQOS1, 9, true
+	pb.Expiry = 60
+	if err := pb.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	go mc.Write(pb.Encoded.Bytes())
+	body.Reset()
+	protocol.SetString(pb.Topic, &body)
+	protocol.SetUint16(9, &body)
+	body.Write(pb.Message)
+	if frame = <-toDevice; !bytes.Equal(frame, mqttPacket(MPUBLISH|0x02, body.Bytes())) {
+		t.Fatal("inconsistent publish after translation.", frame)
+	}
+	// PUBACK ( device to broker )
+	go device.Write(mqttPacket(MPUBACK, []byte{0x00, 0x09}))
+	if frame = mustFrame(t, fromDevice); !bytes.Equal(frame, []byte{protobase.CPUBACK, 0x02, 0x00, 0x09}) {
+		t.Fatal("inconsistent puback after translation.", frame)
+	}
+}
+
+func TestMQTTSubscriptions(t *testing.T) {
+	var (
+		device, broker = net.Pipe()
+		mc             = NewMQTTConn(broker, NewMQTTSessions())
+		fromDevice     = bufio.NewReader(mc)
+		toDevice       = make(chan []byte, 8)
+		body           bytes.Buffer
+	)
+	defer device.Close()
+	defer mc.Close()
+	go func() {
+		r := bufio.NewReader(device)
+		for {
+			frame, err := readFrame(r)
+			if err != nil {
+				close(toDevice)
+				return
+			}
+			toDevice <- frame
+		}
+	}()
+	mc.SetPermissionDelegate(func(clid string, topic string) bool { return topic != "private/*" })
+	protocol.SetString(MQTTProtoName, &body)
+	body.WriteByte(MQTTProtoLevel)
+	body.WriteByte(0x02)
+	protocol.SetUint16(30, &body)
+	protocol.SetString("device", &body)
+	go device.Write(mqttPacket(MCONNECT, body.Bytes()))
+	mustFrame(t, fromDevice)
+	ca := protocol.NewRawConnack()
+	ca.ResultCode = protobase.RESPOK
+	ca.Meta.WideLength = true
+	ca.Caps = &protocol.Capabilities{MaxQoS: protobase.LQOS2, WildcardAvailable: true}
+	ca.Encode()
+	go mc.Write(ca.Encoded.Bytes())
+	<-toDevice
+	// overlapping filters share the protox subscription
+	body.Reset()
+	protocol.SetUint16(1, &body)
+	protocol.SetString("a/+", &body)
+	body.WriteByte(protobase.LQOS1)
+	protocol.SetString("a/#", &body)
+	body.WriteByte(protobase.LQOS1)
+	protocol.SetString("private/#", &body)
+	body.WriteByte(protobase.LQOS0)
+	go device.Write(mqttPacket(MSUBSCRIBE, body.Bytes()))
+	for _, topic := range []string{"a/*", "a/*", "a"} {
+		frame := mustFrame(t, fromDevice)
+		if sub := protocol.NewSubscribe(packet.NewPacket(frame, frame[0], len(frame))); sub == nil || sub.Topic != topic {
+			t.Fatal("inconsistent subscribe after translation.", topic, frame)
+		}
+	}
+	if frame := <-toDevice; !bytes.Equal(frame, []byte{MSUBACK, 0x05, 0x00, 0x01, protobase.LQOS1, protobase.LQOS1, MSubFailure}) {
+		t.Fatal("inconsistent suback, expected refusal by the broker.", frame)
+	}
+	// a subscription is kept while another filter covers it
+	body.Reset()
+	protocol.SetUint16(2, &body)
+	protocol.SetString("a/#", &body)
+	go device.Write(mqttPacket(MUNSUBSCRIBE, body.Bytes()))
+	frame := mustFrame(t, fromDevice)
+	if unsub := protocol.NewUnSubscribe(packet.NewPacket(frame, frame[0], len(frame))); unsub == nil || unsub.Topic != "a" {
+		t.Fatal("inconsistent unsubscribe after translation.", frame)
+	}
+	if frame = <-toDevice; !bytes.Equal(frame, []byte{MUNSUBACK, 0x02, 0x00, 0x02}) {
+		t.Fatal("inconsistent unsuback.", frame)
+	}
+	// deliveries not matching MQTT filters are acknowledged and dropped
+	for _, topic := range []string{"a/b/c", "a/b"} {
+		pb := protocol.NewRawPublish()
+		pb.Topic, pb.Message = topic, []byte("data")
+		pb.Meta.Qos, pb.Meta.MessageId, pb.Meta.WideLength = protobase.LQOS1, 5, true
+		pb.Encode()
+		mc.Write(pb.Encoded.Bytes())
+	}
+	if frame = <-toDevice; frame[0]&0xF0 != MPUBLISH || bytes.Contains(frame, []byte("a/b/c")) {
+		t.Fatal("inconsistent delivery, expected matching topic only.", frame)
+	}
+	body.Reset()
+	protocol.SetUint16(3, &body)
+	protocol.SetString("a/+", &body)
+	go device.Write(mqttPacket(MUNSUBSCRIBE, body.Bytes()))
+	if frame = mustFrame(t, fromDevice); !bytes.Equal(frame, []byte{protobase.CPUBACK, 0x02, 0x00, 0x05}) {
+		t.Fatal("expected dropped delivery to be acknowledged.", frame)
+	}
+	frame = mustFrame(t, fromDevice)
+	if unsub := protocol.NewUnSubscribe(packet.NewPacket(frame, frame[0], len(frame))); unsub == nil || unsub.Topic != "a/*" {
+		t.Fatal("inconsistent unsubscribe after translation.", frame)
+	}
+}
@@ -0,0 +1,1045 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package adaptor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+	"github.com/mitghi/protox/protocol/packet"
+)
+
+// SNProtocolId is the protocol identifier of datagram connect packets.
+const SNProtocolId byte = 0x01
+
+// Datagram packet types ( MQTT-SN numbering ).
+const (
+	SNCONNECT     byte = 0x04
+	SNCONNACK     byte = 0x05
+	SNREGISTER    byte = 0x0A
+	SNREGACK      byte = 0x0B
+	SNPUBLISH     byte = 0x0C
+	SNPUBACK      byte = 0x0D
+	SNSUBSCRIBE   byte = 0x12
+	SNSUBACK      byte = 0x13
+	SNUNSUBSCRIBE byte = 0x14
+	SNUNSUBACK    byte = 0x15
+	SNPINGREQ     byte = 0x16
+	SNPINGRESP    byte = 0x17
+	SNDISCONNECT  byte = 0x18
+)
+
+// Datagram flags. `SNFlagCredentials` is only meaningful in connect
+// packets where username and password follow the client identifier.
+const (
+	SNFlagDup         byte = 0x80
+	SNFlagCredentials byte = 0x80
+	SNFlagQoS         byte = 0x60
+	SNFlagRetain      byte = 0x10
+	SNFlagClean       byte = 0x04
+	SNFlagTopicType   byte = 0x03
+)
+
+// Topic identifier types.
+const (
+	SNTopicNormal byte = 0x00 // registered topic identifier
+	SNTopicShort  byte = 0x02 // two characters topic name
+)
+
+// Datagram return codes.
+const (
+	SNAccepted     byte = 0x00
+	SNCongestion   byte = 0x01
+	SNInvalidTopic byte = 0x02
+	SNNotSupported byte = 0x03
+)
+
+// Datagram session defaults.
+const (
+	SNDefaultRetryInterval time.Duration = time.Second * 10
+	SNDefaultMaxRetries    int           = 3
+	SNDefaultMaxBuffered   int           = 64
+	// SNSleepGrace is the factor applied to the sleep duration
+	// announced by a client before its session is considered lost.
+	SNSleepGrace float64 = 1.5
+)
+
+const (
+	snMaxDatagram int           = 65535
+	snMaxTick     time.Duration = time.Millisecond * 250
+	snInbound     int           = 64
+)
+
+// Datagram adaptor error messages
+var (
+	ESNMalformed    error = errors.New("adaptor: malformed datagram.")
+	ESNNotConnected error = errors.New("adaptor: datagram connect is expected.")
+	ESNBadVersion   error = errors.New("adaptor: unsupported datagram protocol id.")
+	ESNRetries      error = errors.New("adaptor: datagram retransmission limit exceeded.")
+	ESNSleepExpired error = errors.New("adaptor: sleeping datagram client did not wake up.")
+)
+
+// SNListener is a `net.Listener` serving MQTT-SN style datagram clients
+// over a `net.PacketConn`. Datagrams are demultiplexed by peer address
+// into sessions, each session is a `SNConn` and is returned by `Accept`
+// when its first connect packet arrives. Options must be set before
+// the first call to `Accept`.
+type SNListener struct {
+	sync.Mutex
+	// RetryInterval is the time after which an unacknowledged
+	// QoS 1 publish or register packet is retransmitted.
+	RetryInterval time.Duration
+	// MaxRetries is the number of retransmissions before a session
+	// is closed.
+	MaxRetries int
+	// MaxBuffered is the number of packets kept for a sleeping client,
+	// oldest packets are dropped first.
+	MaxBuffered int
+
+	pc       net.PacketConn
+	sessions map[string]*SNConn
+	tokens   *MQTTSessions
+	accept   chan *SNConn
+	done     chan struct{}
+	start    sync.Once
+	stop     sync.Once
+}
+
+// NewSNListener returns a pointer to a new `SNListener` reading
+// datagrams from `pc`.
+func NewSNListener(pc net.PacketConn) *SNListener {
+	return &SNListener{
+		RetryInterval: SNDefaultRetryInterval,
+		MaxRetries:    SNDefaultMaxRetries,
+		MaxBuffered:   SNDefaultMaxBuffered,
+		pc:            pc,
+		sessions:      make(map[string]*SNConn),
+		tokens:        NewMQTTSessions(),
+		accept:        make(chan *SNConn),
+		done:          make(chan struct{}),
+	}
+}
+
+// Accept waits for and returns the next datagram session.
+func (l *SNListener) Accept() (net.Conn, error) {
+	l.start.Do(func() { go l.serve() })
+	select {
+	case sc := <-l.accept:
+		return sc, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the packet connection and all sessions.
+func (l *SNListener) Close() (err error) {
+	l.stop.Do(func() {
+		err = l.pc.Close()
+		close(l.done)
+	})
+	l.Lock()
+	sessions := make([]*SNConn, 0, len(l.sessions))
+	for _, sc := range l.sessions {
+		sessions = append(sessions, sc)
+	}
+	l.Unlock()
+	for _, sc := range sessions {
+		sc.Close()
+	}
+	return err
+}
+
+// Addr returns the local address of the packet connection.
+func (l *SNListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// serve reads datagrams and routes them to their sessions.
+func (l *SNListener) serve() {
+	buf := make([]byte, snMaxDatagram)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.Close()
+			return
+		}
+		dgram := make([]byte, n)
+		copy(dgram, buf[:n])
+		typ, body, err := snParse(dgram)
+		if err != nil {
+			continue
+		}
+		l.route(addr, typ, body)
+	}
+}
+
+// route delivers a datagram to the session of `addr`. Unknown peers
+// must open a session with a connect packet, a sleeping client only
+// wakes up from the address it is bound to.
+func (l *SNListener) route(addr net.Addr, typ byte, body []byte) {
+	var (
+		key string = addr.String()
+		sc  *SNConn
+		ok  bool
+	)
+	l.Lock()
+	if sc, ok = l.sessions[key]; !ok && typ == SNCONNECT {
+		sc = newSNConn(l, addr)
+		l.sessions[key] = sc
+	}
+	l.Unlock()
+	if sc == nil {
+		return
+	}
+	sc.deliver(typ, body)
+	if !ok {
+		select {
+		case l.accept <- sc:
+		case <-l.done:
+		}
+	}
+}
+
+// remove removes session `sc`.
+func (l *SNListener) remove(sc *SNConn) {
+	l.Lock()
+	defer l.Unlock()
+	key := sc.RemoteAddr().String()
+	if l.sessions[key] == sc {
+		delete(l.sessions, key)
+	}
+}
+
+// snDatagram is a received datagram.
+type snDatagram struct {
+	typ  byte
+	body []byte
+}
+
+// snInflight is an outgoing packet awaiting acknowledgement.
+type snInflight struct {
+	dgram   []byte
+	at      time.Time // zero while buffered
+	retries int
+}
+
+// snBuffered is an outgoing packet kept for a sleeping client.
+type snBuffered struct {
+	dgram []byte
+	key   uint32 // inflight key, zero when untracked
+}
+
+// SNConn is a `net.Conn` which translates MQTT-SN style datagrams
+// of a peer into protox packets, and protox packets written to it into
+// datagrams. Topic names are replaced by short identifiers registered
+// per session, QoS 1 packets are retransmitted until acknowledged and
+// packets are buffered while the client sleeps.
+type SNConn struct {
+	sync.Mutex // guards pending writes
+
+	l        *SNListener
+	in       chan snDatagram
+	ping     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	rbuf     bytes.Buffer // translated packets pending read
+	wbuf     bytes.Buffer // written packets pending translation
+	mu       sync.Mutex   // guards session state
+	addr     net.Addr
+	clientId string
+	maxQoS   byte
+	wide     bool
+	// keepalive is the interval in which protox pings are injected
+	// on behalf of a sleeping client.
+	keepalive time.Duration
+	connected bool
+	asleep    bool
+	sleep     time.Duration // tolerated sleep duration
+	wakeBy    time.Time     // sleep deadline
+	lastPing  time.Time
+	pongs     int // injected pings awaiting pong
+	topics    map[string]uint16
+	names     map[uint16]string
+	ready     map[uint16]bool         // acknowledged topic registrations
+	waiting   map[uint16][]snBuffered // publishes awaiting registration
+	acks      map[uint16]uint16       // inbound message id to topic id
+	inflight  map[uint32]*snInflight
+	buffered  []snBuffered
+	nextTopic uint16
+	nextId    uint16
+}
+
+// newSNConn returns a pointer to a new `SNConn` of peer `addr` and
+// starts its retransmission timer.
+func newSNConn(l *SNListener, addr net.Addr) *SNConn {
+	sc := &SNConn{
+		l:        l,
+		in:       make(chan snDatagram, snInbound),
+		ping:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		addr:     addr,
+		maxQoS:   protobase.LQOS1,
+		wide:     true,
+		topics:   make(map[string]uint16),
+		names:    make(map[uint16]string),
+		ready:    make(map[uint16]bool),
+		waiting:  make(map[uint16][]snBuffered),
+		acks:     make(map[uint16]uint16),
+		inflight: make(map[uint32]*snInflight),
+	}
+	go sc.timer()
+	return sc
+}
+
+// Read reads translated protox packets.
+func (sc *SNConn) Read(b []byte) (n int, err error) {
+	for sc.rbuf.Len() == 0 {
+		select {
+		case d := <-sc.in:
+			if err = sc.translateIn(d.typ, d.body); err != nil {
+				return 0, err
+			}
+		case <-sc.ping:
+			sc.forward(protobase.CPING, nil)
+		case <-sc.done:
+			return 0, io.EOF
+		}
+	}
+	return sc.rbuf.Read(b)
+}
+
+// Write translates protox packets in `b` and sends them as
+// datagrams. Incomplete packets are kept until the remaining bytes
+// are written.
+func (sc *SNConn) Write(b []byte) (n int, err error) {
+	sc.Lock()
+	defer sc.Unlock()
+	select {
+	case <-sc.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	sc.wbuf.Write(b)
+	for {
+		pkt := nextPacket(&sc.wbuf)
+		if pkt == nil {
+			break
+		}
+		if err = sc.translateOut(pkt); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Close closes the session, the packet connection is owned by
+// the listener and remains open.
+func (sc *SNConn) Close() error {
+	sc.once.Do(func() {
+		close(sc.done)
+		sc.l.remove(sc)
+	})
+	return nil
+}
+
+// LocalAddr returns the local address of the listener.
+func (sc *SNConn) LocalAddr() net.Addr {
+	return sc.l.pc.LocalAddr()
+}
+
+// RemoteAddr returns the address of the peer.
+func (sc *SNConn) RemoteAddr() net.Addr {
+	return sc.addr
+}
+
+// SetDeadline is a no-op, sessions are timed by retransmissions
+// and sleep durations.
+func (sc *SNConn) SetDeadline(t time.Time) error { return nil }
+
+// SetReadDeadline is a no-op.
+func (sc *SNConn) SetReadDeadline(t time.Time) error { return nil }
+
+// SetWriteDeadline is a no-op.
+func (sc *SNConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// deliver queues a received datagram, it is dropped when the
+// session is congested.
+func (sc *SNConn) deliver(typ byte, body []byte) {
+	select {
+	case sc.in <- snDatagram{typ, body}:
+	case <-sc.done:
+	default:
+	}
+}
+
+// timer retransmits unacknowledged packets, keeps the protox
+// connection of sleeping clients alive and closes the session
+// when retransmissions are exhausted or the client oversleeps.
+func (sc *SNConn) timer() {
+	tick := sc.l.RetryInterval / 2
+	if tick > snMaxTick || tick <= 0 {
+		tick = snMaxTick
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sc.done:
+			return
+		case now := <-ticker.C:
+			if err := sc.expire(now); err != nil {
+				sc.Close()
+				return
+			}
+		}
+	}
+}
+
+// expire performs the timed duties of `timer` at time `now`.
+func (sc *SNConn) expire(now time.Time) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.asleep {
+		if now.After(sc.wakeBy) {
+			return ESNSleepExpired
+		}
+		if sc.keepalive > 0 && now.Sub(sc.lastPing) >= sc.keepalive {
+			select {
+			case sc.ping <- struct{}{}:
+				sc.lastPing = now
+				sc.pongs++
+			default:
+			}
+		}
+		return nil
+	}
+	for _, f := range sc.inflight {
+		if f.at.IsZero() || now.Sub(f.at) < sc.l.RetryInterval {
+			continue
+		}
+		if f.retries >= sc.l.MaxRetries {
+			return ESNRetries
+		}
+		f.retries++
+		if f.dgram[snHeaderLen(f.dgram)] == SNPUBLISH {
+			f.dgram[snHeaderLen(f.dgram)+1] |= SNFlagDup
+		}
+		f.at = now
+		sc.writeTo(f.dgram)
+	}
+	return nil
+}
+
+// translateIn translates a datagram into protox packets.
+func (sc *SNConn) translateIn(typ byte, body []byte) (err error) {
+	defer func() {
+		err = protocol.RecoverError(err, recover())
+	}()
+	sc.mu.Lock()
+	connected := sc.connected
+	if sc.asleep {
+		sc.wakeBy = time.Now().Add(sc.sleep)
+	}
+	sc.mu.Unlock()
+	if !connected && typ != SNCONNECT {
+		return ESNNotConnected
+	}
+	switch typ {
+	case SNCONNECT:
+		return sc.onConnect(body)
+	case SNREGISTER:
+		return sc.onRegister(body)
+	case SNREGACK:
+		return sc.onRegack(body)
+	case SNPUBLISH:
+		return sc.onPublish(body)
+	case SNPUBACK:
+		return sc.onPuback(body)
+	case SNSUBSCRIBE:
+		return sc.onSubscribe(body)
+	case SNUNSUBSCRIBE:
+		return sc.onUnsubscribe(body)
+	case SNPINGREQ:
+		return sc.onPing()
+	case SNDISCONNECT:
+		return sc.onDisconnect(body)
+	}
+	return ESNMalformed
+}
+
+// forward writes a protox packet with command `cmd` and `body`
+// as its content.
+func (sc *SNConn) forward(cmd byte, body []byte) {
+	sc.rbuf.WriteByte(cmd)
+	protocol.EncodeLength(int32(len(body)), &sc.rbuf)
+	sc.rbuf.Write(body)
+}
+
+// onConnect translates a connect packet. A connect packet of an
+// established session is a retransmission or ends the sleep, it is
+// acknowledged without reaching the broker.
+func (sc *SNConn) onConnect(body []byte) (err error) {
+	if len(body) < 4 {
+		return ESNMalformed
+	}
+	var (
+		flags byte              = body[0]
+		cn    *protocol.Connect = protocol.NewRawConnect()
+	)
+	sc.mu.Lock()
+	if sc.connected {
+		sc.asleep = false
+		err = sc.send(snFrame(SNCONNACK, []byte{SNAccepted}), 0)
+		sc.mu.Unlock()
+		return err
+	}
+	sc.mu.Unlock()
+	if body[1] != SNProtocolId {
+		sc.mu.Lock()
+		sc.send(snFrame(SNCONNACK, []byte{SNNotSupported}), 0)
+		sc.mu.Unlock()
+		return ESNBadVersion
+	}
+	cn.KeepAlive = int(binary.BigEndian.Uint16(body[2:4]))
+	if (flags & SNFlagCredentials) != 0 {
+		var (
+			r   *bytes.Reader = bytes.NewReader(body[4:])
+			rem int32         = int32(len(body) - 4)
+		)
+		cn.ClientId = protocol.GetString(r, &rem)
+		cn.Username = protocol.GetString(r, &rem)
+		cn.Password = protocol.GetString(r, &rem)
+	} else {
+		cn.ClientId = string(body[4:])
+	}
+	cn.Meta.CleanStart = (flags & SNFlagClean) != 0
+	cn.Meta.WideLength = true
+	if !cn.Meta.CleanStart && cn.ClientId != "" {
+		cn.SessionId = sc.l.tokens.get(cn.ClientId)
+	}
+	if err = cn.Encode(); err != nil {
+		return err
+	}
+	sc.mu.Lock()
+	sc.clientId = cn.ClientId
+	sc.keepalive = time.Second * time.Duration(cn.KeepAlive) / 2
+	sc.connected = true
+	sc.mu.Unlock()
+	sc.rbuf.Write(cn.Encoded.Bytes())
+	return nil
+}
+
+// onRegister registers a topic name announced by the client.
+func (sc *SNConn) onRegister(body []byte) error {
+	if len(body) < 5 {
+		return ESNMalformed
+	}
+	var (
+		msgId uint16 = binary.BigEndian.Uint16(body[2:4])
+		name  string = string(body[4:])
+		ack   []byte = make([]byte, 5)
+		id    uint16
+	)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if strings.ContainsAny(name, "+#"+string(messages.TWLDCD)) {
+		ack[4] = SNInvalidTopic
+	} else {
+		id = sc.register(name)
+		sc.ready[id] = true
+	}
+	binary.BigEndian.PutUint16(ack[0:2], id)
+	binary.BigEndian.PutUint16(ack[2:4], msgId)
+	return sc.send(snFrame(SNREGACK, ack), 0)
+}
+
+// onRegack completes a topic registration of the broker and sends
+// publishes awaiting it.
+func (sc *SNConn) onRegack(body []byte) (err error) {
+	if len(body) < 5 {
+		return ESNMalformed
+	}
+	var (
+		id    uint16 = binary.BigEndian.Uint16(body[0:2])
+		msgId uint16 = binary.BigEndian.Uint16(body[2:4])
+	)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.inflight, snKey(SNREGISTER, msgId))
+	waiting := sc.waiting[id]
+	delete(sc.waiting, id)
+	if body[4] != SNAccepted {
+		// QoS 1 publishes remain in the message storage
+		// until acknowledged.
+		for _, w := range waiting {
+			delete(sc.inflight, w.key)
+		}
+		return nil
+	}
+	sc.ready[id] = true
+	for _, w := range waiting {
+		if err = sc.send(w.dgram, w.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onPublish translates a publish packet. Publishes to unknown topic
+// identifiers are refused.
+func (sc *SNConn) onPublish(body []byte) (err error) {
+	if len(body) < 5 {
+		return ESNMalformed
+	}
+	var (
+		flags byte              = body[0]
+		id    uint16            = binary.BigEndian.Uint16(body[1:3])
+		msgId uint16            = binary.BigEndian.Uint16(body[3:5])
+		pb    *protocol.Publish = protocol.NewRawPublish()
+		ok    bool
+	)
+	pb.Meta.Dup, pb.Meta.Ret = (flags&SNFlagDup) != 0, (flags&SNFlagRetain) != 0
+	pb.Meta.Qos = (flags & SNFlagQoS) >> 5
+	if pb.Meta.Qos > protobase.LQOS1 {
+		return ESNMalformed
+	}
+	sc.mu.Lock()
+	switch flags & SNFlagTopicType {
+	case SNTopicNormal:
+		pb.Topic, ok = sc.names[id]
+	case SNTopicShort:
+		pb.Topic, ok = string(body[1:3]), true
+	}
+	if !ok {
+		err = sc.send(snFrame(SNPUBACK, snAck(id, msgId, SNInvalidTopic)), 0)
+		sc.mu.Unlock()
+		return err
+	}
+	if pb.Meta.Qos > 0 {
+		sc.acks[msgId] = id
+		pb.Meta.MessageId = msgId
+	}
+	pb.Meta.WideLength = sc.wide
+	sc.mu.Unlock()
+	pb.Message = body[5:]
+	if err = pb.Encode(); err != nil {
+		return err
+	}
+	sc.rbuf.Write(pb.Encoded.Bytes())
+	return nil
+}
+
+// onPuback completes a QoS 1 delivery to the client.
+func (sc *SNConn) onPuback(body []byte) error {
+	if len(body) < 5 {
+		return ESNMalformed
+	}
+	msgId := binary.BigEndian.Uint16(body[2:4])
+	sc.mu.Lock()
+	_, ok := sc.inflight[snKey(SNPUBLISH, msgId)]
+	delete(sc.inflight, snKey(SNPUBLISH, msgId))
+	sc.mu.Unlock()
+	if ok && body[4] == SNAccepted {
+		sc.forward(protobase.CPUBACK, body[2:4])
+	}
+	return nil
+}
+
+// onSubscribe translates a subscribe packet and acknowledges it.
+// Topic names without wildcards are registered and their
+// identifier is returned.
+func (sc *SNConn) onSubscribe(body []byte) (err error) {
+	if len(body) < 4 {
+		return ESNMalformed
+	}
+	var (
+		flags byte   = body[0]
+		msgId []byte = body[1:3]
+		qos   byte   = (flags & SNFlagQoS) >> 5
+		name  string = string(body[3:])
+		id    uint16
+	)
+	if (flags&SNFlagTopicType) != SNTopicNormal && (flags&SNFlagTopicType) != SNTopicShort {
+		return sc.reply(SNSUBACK, []byte{flags, 0x00, 0x00, msgId[0], msgId[1], SNNotSupported})
+	}
+	topic, err := TopicFilter(name)
+	if err != nil || qos > protobase.MAXQoS {
+		return sc.reply(SNSUBACK, []byte{flags, 0x00, 0x00, msgId[0], msgId[1], SNInvalidTopic})
+	}
+	sc.mu.Lock()
+	if qos > sc.maxQoS {
+		// deliveries above QoS 1 have no datagram counterpart
+		qos = sc.maxQoS
+	}
+	sc.mu.Unlock()
+	sub := protocol.NewRawSubscribe()
+	sub.Topic = topic
+	sub.Meta.Qos, sub.Meta.MessageId = qos, binary.BigEndian.Uint16(msgId)
+	if err = sub.Encode(); err != nil {
+		return err
+	}
+	sc.rbuf.Write(sub.Encoded.Bytes())
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if topic == name && (flags&SNFlagTopicType) == SNTopicNormal {
+		id = sc.register(name)
+		sc.ready[id] = true
+	}
+	ack := []byte{(flags &^ SNFlagQoS) | (qos << 5), 0x00, 0x00, msgId[0], msgId[1], SNAccepted}
+	binary.BigEndian.PutUint16(ack[1:3], id)
+	return sc.send(snFrame(SNSUBACK, ack), 0)
+}
+
+// onUnsubscribe translates an unsubscribe packet and acknowledges it.
+func (sc *SNConn) onUnsubscribe(body []byte) (err error) {
+	if len(body) < 4 {
+		return ESNMalformed
+	}
+	if topic, err := TopicFilter(string(body[3:])); err == nil {
+		unsub := protocol.NewRawUnSubscribe()
+		unsub.Topic = topic
+		unsub.Meta.Qos, unsub.Meta.MessageId = protobase.LQOS1, binary.BigEndian.Uint16(body[1:3])
+		if err = unsub.Encode(); err != nil {
+			return err
+		}
+		sc.rbuf.Write(unsub.Encoded.Bytes())
+	}
+	return sc.reply(SNUNSUBACK, body[1:3])
+}
+
+// onPing answers a ping request. Sleeping clients wake up for it,
+// packets buffered while sleeping are sent before the response.
+func (sc *SNConn) onPing() (err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if !sc.asleep {
+		sc.forward(protobase.CPING, nil)
+		return nil
+	}
+	if err = sc.flush(); err != nil {
+		return err
+	}
+	return sc.writeTo(snFrame(SNPINGRESP, nil))
+}
+
+// onDisconnect ends the session, or puts the client asleep when a
+// sleep duration is given.
+func (sc *SNConn) onDisconnect(body []byte) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.asleep = false
+	if err := sc.send(snFrame(SNDISCONNECT, nil), 0); err != nil {
+		return err
+	}
+	if len(body) < 2 {
+		sc.forward(protobase.CDISCONNECT, nil)
+		return nil
+	}
+	sc.asleep = true
+	sc.sleep = time.Duration(float64(time.Second) * float64(binary.BigEndian.Uint16(body)) * SNSleepGrace)
+	sc.lastPing = time.Now()
+	sc.wakeBy = sc.lastPing.Add(sc.sleep)
+	return nil
+}
+
+// translateOut translates a protox packet into datagrams. Packets
+// without datagram counterpart, or already acknowledged by the
+// adaptor ( e.g. SUBACK ) are dropped.
+func (sc *SNConn) translateOut(pkt []byte) (err error) {
+	defer func() {
+		err = protocol.RecoverError(err, recover())
+	}()
+	switch pkt[0] & 0xF0 {
+	case protobase.CCONNACK:
+		return sc.onConnack(pkt)
+	case protobase.CPUBLISH:
+		return sc.onPublishOut(pkt)
+	case protobase.CPUBACK:
+		return sc.onPubackOut(pkt)
+	case protobase.CPONG:
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		if sc.pongs > 0 {
+			// response to a ping injected for a sleeping client
+			sc.pongs--
+			return nil
+		}
+		return sc.send(snFrame(SNPINGRESP, nil), 0)
+	case protobase.CDISCONNECT:
+		return sc.reply(SNDISCONNECT, nil)
+	}
+	return nil
+}
+
+// onConnack translates a protox connack packet and keeps the
+// negotiated session and limits.
+func (sc *SNConn) onConnack(pkt []byte) error {
+	ca := protocol.NewConnack(packet.NewPacket(pkt, pkt[0], len(pkt)))
+	if ca == nil {
+		return ESNMalformed
+	}
+	code := SNNotSupported
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if ca.ResultCode == protobase.RESPOK {
+		code = SNAccepted
+		sc.wide = ca.Meta.WideLength
+		if ca.Caps != nil {
+			if ca.Caps.MaxQoS < sc.maxQoS {
+				sc.maxQoS = ca.Caps.MaxQoS
+			}
+			if ca.Caps.ServerKeepAlive > 0 {
+				sc.keepalive = time.Second * time.Duration(ca.Caps.ServerKeepAlive) / 2
+			}
+		}
+		if ca.SessionId != "" && sc.clientId != "" {
+			sc.l.tokens.set(sc.clientId, ca.SessionId)
+		}
+	}
+	return sc.send(snFrame(SNCONNACK, []byte{code}), 0)
+}
+
+// onPublishOut translates a protox publish packet. Topics without
+// identifier are registered first, the publish is sent once the
+// client acknowledges the registration.
+func (sc *SNConn) onPublishOut(pkt []byte) (err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	pb := protocol.NewPublishWide(packet.NewPacket(pkt, pkt[0], len(pkt)), sc.wide)
+	if pb == nil {
+		return ESNMalformed
+	}
+	var (
+		body    bytes.Buffer
+		message []byte = pb.Message
+		flags   byte   = (pb.Meta.Qos << 5) & SNFlagQoS
+		key     uint32
+		id      uint16
+	)
+	if pb.Compression != protobase.COMPNone {
+		if message, err = protocol.Decompress(pb.Compression, message); err != nil {
+			return err
+		}
+	}
+	if pb.Meta.Ret {
+		flags |= SNFlagRetain
+	}
+	if len(pb.Topic) == 2 {
+		flags |= SNTopicShort
+		body.WriteByte(flags)
+		body.WriteString(pb.Topic)
+	} else {
+		id = sc.register(pb.Topic)
+		body.WriteByte(flags)
+		protocol.SetUint16(id, &body)
+	}
+	protocol.SetUint16(pb.Meta.MessageId, &body)
+	body.Write(message)
+	dgram := snFrame(SNPUBLISH, body.Bytes())
+	if pb.Meta.Qos > 0 {
+		key = snKey(SNPUBLISH, pb.Meta.MessageId)
+		sc.inflight[key] = &snInflight{dgram: dgram}
+	}
+	if id != 0 && !sc.ready[id] {
+		sc.waiting[id] = append(sc.waiting[id], snBuffered{dgram, key})
+		if len(sc.waiting[id]) == 1 {
+			return sc.announce(id, pb.Topic)
+		}
+		return nil
+	}
+	return sc.send(dgram, key)
+}
+
+// onPubackOut translates a protox puback packet of a publish
+// received from the client.
+func (sc *SNConn) onPubackOut(pkt []byte) error {
+	msgId := binary.BigEndian.Uint16(pkt[protocol.GetHeaderBoundary(pkt):])
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	id := sc.acks[msgId]
+	delete(sc.acks, msgId)
+	return sc.send(snFrame(SNPUBACK, snAck(id, msgId, SNAccepted)), 0)
+}
+
+// announce sends a register packet for topic `name`, it must be
+// called while holding the state lock.
+func (sc *SNConn) announce(id uint16, name string) error {
+	var body bytes.Buffer
+	sc.nextId++
+	if sc.nextId == 0 {
+		sc.nextId = 1
+	}
+	protocol.SetUint16(id, &body)
+	protocol.SetUint16(sc.nextId, &body)
+	body.WriteString(name)
+	key := snKey(SNREGISTER, sc.nextId)
+	sc.inflight[key] = &snInflight{dgram: snFrame(SNREGISTER, body.Bytes())}
+	return sc.send(sc.inflight[key].dgram, key)
+}
+
+// register returns the identifier of topic `name` and assigns one
+// when missing, it must be called while holding the state lock.
+func (sc *SNConn) register(name string) uint16 {
+	if id, ok := sc.topics[name]; ok {
+		return id
+	}
+	sc.nextTopic++
+	if sc.nextTopic == 0 {
+		sc.nextTopic = 1
+	}
+	sc.topics[name], sc.names[sc.nextTopic] = sc.nextTopic, name
+	return sc.nextTopic
+}
+
+// reply sends a datagram with type `typ` and `body` as its content.
+func (sc *SNConn) reply(typ byte, body []byte) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.send(snFrame(typ, body), 0)
+}
+
+// send sends `dgram`, or buffers it while the client sleeps. A non
+// zero `key` refers to the inflight entry of the datagram. It must be
+// called while holding the state lock.
+func (sc *SNConn) send(dgram []byte, key uint32) error {
+	if sc.asleep {
+		sc.buffered = append(sc.buffered, snBuffered{dgram, key})
+		if len(sc.buffered) > sc.l.MaxBuffered {
+			// QoS 1 publishes remain in the message storage
+			// until acknowledged.
+			delete(sc.inflight, sc.buffered[0].key)
+			sc.buffered = sc.buffered[1:]
+		}
+		return nil
+	}
+	if f, ok := sc.inflight[key]; ok {
+		f.at = time.Now()
+	}
+	return sc.writeTo(dgram)
+}
+
+// flush retransmits packets left unacknowledged before the client
+// fell asleep and sends buffered packets, it must be called while
+// holding the state lock.
+func (sc *SNConn) flush() error {
+	var (
+		now      time.Time    = time.Now()
+		buffered []snBuffered = sc.buffered
+	)
+	sc.buffered = nil
+	for _, f := range sc.inflight {
+		if f.at.IsZero() {
+			continue
+		}
+		f.at = now
+		if err := sc.writeTo(f.dgram); err != nil {
+			return err
+		}
+	}
+	for _, b := range buffered {
+		if f, ok := sc.inflight[b.key]; ok {
+			f.at = now
+		} else if b.key != 0 {
+			// dropped while buffered
+			continue
+		}
+		if err := sc.writeTo(b.dgram); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTo writes `dgram` to the peer.
+func (sc *SNConn) writeTo(dgram []byte) error {
+	_, err := sc.l.pc.WriteTo(dgram, sc.addr)
+	return err
+}
+
+// snKey returns the inflight key of message `msgId` of type `typ`.
+func snKey(typ byte, msgId uint16) uint32 {
+	return uint32(typ)<<16 | uint32(msgId)
+}
+
+// snAck returns the body of an acknowledgement.
+func snAck(id uint16, msgId uint16, code byte) []byte {
+	ack := make([]byte, 5)
+	binary.BigEndian.PutUint16(ack[0:2], id)
+	binary.BigEndian.PutUint16(ack[2:4], msgId)
+	ack[4] = code
+	return ack
+}
+
+// snFrame returns a datagram with type `typ` and `body` as its
+// content. The length is a single byte, or 0x01 followed by two
+// bytes when it exceeds 255.
+func snFrame(typ byte, body []byte) []byte {
+	var buf bytes.Buffer
+	if n := len(body) + 2; n <= 0xFF {
+		buf.WriteByte(byte(n))
+	} else {
+		buf.WriteByte(0x01)
+		protocol.SetUint16(uint16(n+2), &buf)
+	}
+	buf.WriteByte(typ)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// snHeaderLen returns the length of the length field of `dgram`.
+func snHeaderLen(dgram []byte) int {
+	if dgram[0] == 0x01 {
+		return 3
+	}
+	return 1
+}
+
+// snParse returns type and body of `dgram`.
+func snParse(dgram []byte) (typ byte, body []byte, err error) {
+	if len(dgram) < 2 {
+		return 0, nil, ESNMalformed
+	}
+	var (
+		hl int = snHeaderLen(dgram)
+		n  int = int(dgram[0])
+	)
+	if hl == 3 {
+		if len(dgram) < 4 {
+			return 0, nil, ESNMalformed
+		}
+		n = int(binary.BigEndian.Uint16(dgram[1:3]))
+	}
+	if n != len(dgram) {
+		return 0, nil, ESNMalformed
+	}
+	return dgram[hl], dgram[hl+1:], nil
+}
@@ -0,0 +1,266 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package adaptor
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+	"github.com/mitghi/protox/protocol/packet"
+)
+
+// snDevice is a datagram client used by tests.
+type snDevice struct {
+	t    *testing.T
+	pc   net.PacketConn
+	addr net.Addr
+}
+
+// newSNDevice returns a new datagram client of listener at `addr`.
+func newSNDevice(t *testing.T, addr net.Addr) *snDevice {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	return &snDevice{t, pc, addr}
+}
+
+// send sends a datagram with type `typ` and `body` as its content.
+func (d *snDevice) send(typ byte, body []byte) {
+	if _, err := d.pc.WriteTo(snFrame(typ, body), d.addr); err != nil {
+		d.t.Fatal("err!=nil", err)
+	}
+}
+
+// expect reads a datagram and compares it with type `typ` and `body`.
+func (d *snDevice) expect(typ byte, body []byte) {
+	d.t.Helper()
+	buf := make([]byte, snMaxDatagram)
+	d.pc.SetReadDeadline(time.Now().Add(time.Second * 2))
+	n, _, err := d.pc.ReadFrom(buf)
+	if err != nil {
+		d.t.Fatal("err!=nil", err)
+	}
+	rtyp, rbody, err := snParse(buf[:n])
+	if err != nil || rtyp != typ || !bytes.Equal(rbody, body) {
+		d.t.Fatalf("inconsistent datagram, expected type(%x) body(%v), got type(%x) body(%v). error: %v", typ, body, rtyp, rbody, err)
+	}
+}
+
+// drain reads translated packets of `conn` into a channel, a
+// session translates datagrams while it is read.
+func drain(conn net.Conn) <-chan []byte {
+	frames := make(chan []byte, 8)
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			frame, err := readFrame(r)
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- frame
+		}
+	}()
+	return frames
+}
+
+// mustRead reads a translated packet from `frames` or fails the test.
+func mustRead(t *testing.T, frames <-chan []byte) []byte {
+	t.Helper()
+	select {
+	case frame, ok := <-frames:
+		if !ok {
+			t.Fatal("expected packet, session is closed.")
+		}
+		return frame
+	case <-time.After(time.Second * 2):
+		t.Fatal("expected packet, timed out.")
+	}
+	return nil
+}
+
+func TestSNFrame(t *testing.T) {
+	for _, body := range [][]byte{nil, []byte("short"), bytes.Repeat([]byte{0x01}, 300)} {
+		typ, rbody, err := snParse(snFrame(SNPUBLISH, body))
+		if err != nil || typ != SNPUBLISH || !bytes.Equal(rbody, body) {
+			t.Fatal("inconsistent datagram after parsing.", typ, len(rbody), err)
+		}
+	}
+	if _, _, err := snParse([]byte{0x05, SNPUBLISH}); err != ESNMalformed {
+		t.Fatal("expected ESNMalformed, got", err)
+	}
+}
+
+func TestSNConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	var (
+		l      *SNListener = NewSNListener(pc)
+		device *snDevice   = newSNDevice(t, pc.LocalAddr())
+		body   bytes.Buffer
+	)
+	l.RetryInterval = time.Millisecond * 100
+	defer l.Close()
+	defer device.pc.Close()
+	// CONNECT with credentials
+	body.Write([]byte{SNFlagCredentials | SNFlagClean, SNProtocolId})
+	protocol.SetUint16(30, &body)
+	protocol.SetString("device", &body)
+	protocol.SetString("user", &body)
+	protocol.SetString("pass", &body)
+	device.send(SNCONNECT, body.Bytes())
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	fromDevice := drain(conn)
+	frame := mustRead(t, fromDevice)
+	cn := protocol.NewConnect(packet.NewPacket(frame, frame[0], len(frame)))
+	if cn == nil || cn.ClientId != "device" || cn.Username != "user" || cn.Password != "pass" || cn.KeepAlive != 30 || !cn.Meta.CleanStart {
+		t.Fatal("inconsistent connect after translation.", cn)
+	}
+	// CONNACK
+	ca := protocol.NewRawConnack()
+	ca.ResultCode = protobase.RESPOK
+	ca.Meta.WideLength = true
+	ca.Caps = &protocol.Capabilities{MaxQoS: protobase.MAXQoS, ServerKeepAlive: 1}
+	if err = ca.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if _, err = conn.Write(ca.Encoded.Bytes()); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	device.expect(SNCONNACK, []byte{SNAccepted})
+	// REGISTER and PUBLISH ( device to broker )
+	device.send(SNREGISTER, append([]byte{0x00, 0x00, 0x00, 0x01}, "sensors/temp"...))
+	device.expect(SNREGACK, snAck(1, 1, SNAccepted))
+	device.send(SNPUBLISH, append([]byte{protobase.LQOS1 << 5, 0x00, 0x01, 0x00, 0x05}, "21.5"...))
+	frame = mustRead(t, fromDevice)
+	pb := protocol.NewPublishWide(packet.NewPacket(frame, frame[0], len(frame)), true)
+	if pb == nil || pb.Topic != "sensors/temp" || string(pb.Message) != "21.5" || pb.Meta.Qos != protobase.LQOS1 || pb.Meta.MessageId != 5 {
+		t.Fatal("inconsistent publish after translation.", pb)
+	}
+	conn.Write([]byte{protobase.CPUBACK, 0x02, 0x00, 0x05})
+	device.expect(SNPUBACK, snAck(1, 5, SNAccepted))
+	device.send(SNPUBLISH, append([]byte{protobase.LQOS1 << 5, 0x00, 0x07, 0x00, 0x06}, "21.5"...))
+	device.expect(SNPUBACK, snAck(7, 6, SNInvalidTopic))
+	// SUBSCRIBE, granted QoS is capped to 1
+	device.send(SNSUBSCRIBE, append([]byte{protobase.LQOS2 << 5, 0x00, 0x02}, "alerts/+"...))
+	frame = mustRead(t, fromDevice)
+	sub := protocol.NewSubscribe(packet.NewPacket(frame, frame[0], len(frame)))
+	if sub == nil || sub.Topic != "alerts/*" || sub.Meta.Qos != protobase.LQOS1 || sub.Meta.MessageId != 2 {
+		t.Fatal("inconsistent subscribe after translation.", sub)
+	}
+	device.expect(SNSUBACK, []byte{protobase.LQOS1 << 5, 0x00, 0x00, 0x00, 0x02, SNAccepted})
+	// PUBLISH ( broker to device ), topic is registered first and
+	// the publish is retransmitted until acknowledged
+	pb = protocol.NewRawPublish()
+	pb.Topic, pb.Message = "alerts/fire", []byte("on")
+	pb.Meta.Qos, pb.Meta.MessageId, pb.Meta.WideLength = protobase.LQOS1, 9, true
+	if err = pb.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	conn.Write(pb.Encoded.Bytes())
+	device.expect(SNREGISTER, append([]byte{0x00, 0x02, 0x00, 0x01}, "alerts/fire"...))
+	device.send(SNREGACK, snAck(2, 1, SNAccepted))
+	device.expect(SNPUBLISH, append([]byte{protobase.LQOS1 << 5, 0x00, 0x02, 0x00, 0x09}, "on"...))
+	device.expect(SNPUBLISH, append([]byte{SNFlagDup | protobase.LQOS1<<5, 0x00, 0x02, 0x00, 0x09}, "on"...))
+	device.send(SNPUBACK, snAck(2, 9, SNAccepted))
+	if frame = mustRead(t, fromDevice); !bytes.Equal(frame, []byte{protobase.CPUBACK, 0x02, 0x00, 0x09}) {
+		t.Fatal("inconsistent puback after translation.", frame)
+	}
+	// DISCONNECT with sleep duration, the connection is kept alive
+	// and packets are buffered
+	device.send(SNDISCONNECT, []byte{0x00, 0x3C})
+	device.expect(SNDISCONNECT, nil)
+	if frame = mustRead(t, fromDevice); frame[0] != protobase.CPING {
+		t.Fatal("expected injected ping, got", frame)
+	}
+	conn.Write([]byte{protobase.CPONG, 0x00})
+	pb = protocol.NewRawPublish()
+	pb.Topic, pb.Message = "ab", []byte("buffered")
+	pb.Meta.WideLength = true
+	if err = pb.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	conn.Write(pb.Encoded.Bytes())
+	// PINGREQ from a new address does not take over the session
+	other := newSNDevice(t, pc.LocalAddr())
+	defer other.pc.Close()
+	other.send(SNPINGREQ, []byte("device"))
+	other.pc.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	if _, _, err = other.pc.ReadFrom(make([]byte, snMaxDatagram)); err == nil {
+		t.Fatal("expected no response to an unknown peer.")
+	}
+	if conn.RemoteAddr().String() != device.pc.LocalAddr().String() {
+		t.Fatal("inconsistent remote address, expected bound session.", conn.RemoteAddr())
+	}
+	// PINGREQ from the bound address wakes the client up
+	device.send(SNPINGREQ, []byte("device"))
+	device.expect(SNPUBLISH, append([]byte{SNTopicShort, 'a', 'b', 0x00, 0x00}, "buffered"...))
+	device.expect(SNPINGRESP, nil)
+}
+
+func TestSNConnRetries(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	var (
+		l      *SNListener = NewSNListener(pc)
+		device *snDevice   = newSNDevice(t, pc.LocalAddr())
+	)
+	l.RetryInterval, l.MaxRetries = time.Millisecond*50, 1
+	defer l.Close()
+	defer device.pc.Close()
+	device.send(SNCONNECT, append([]byte{SNFlagClean, SNProtocolId, 0x00, 0x1E}, "device"...))
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	fromDevice := drain(conn)
+	mustRead(t, fromDevice)
+	pb := protocol.NewRawPublish()
+	pb.Topic, pb.Message = "ab", []byte("lost")
+	pb.Meta.Qos, pb.Meta.MessageId, pb.Meta.WideLength = protobase.LQOS1, 3, true
+	if err = pb.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	conn.Write(pb.Encoded.Bytes())
+	select {
+	case _, ok := <-fromDevice:
+		if ok {
+			t.Fatal("unexpected packet, expected closed session.")
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("timed out, expected closed session.")
+	}
+}
@@ -55,6 +55,14 @@ var (
 	InvalidCmdForState       = errors.New("protox: Command inconsistent with state")
 	CriticalTimeout          = errors.New("protox: Critial timeout section missed")
 	InvalidHeader            = errors.New("protox: Invalid header")
+	MessageExpired           = errors.New("protox: Message is expired")
+)
+
+// Length limits of length-prefixed fields. Wide fields are only used
+// for payloads when both parties negotiated them during CONNECT.
+const (
+	MaxFieldLen     = 0xFFFF
+	MaxWideFieldLen = 0xFFFFFFF // bounded by remaining length encoding
 )
 
 // Protocol is protocol structure embedded
@@ -87,4 +95,14 @@ type ProtoMeta struct {
 	MessageId  uint16
 	CleanStart bool
 	HasSession bool
+	// WideLength indicates that payload fields
+	// are prefixed with uint32 length.
+	WideLength bool
+	// Props is the optional property section, it is
+	// only carried by `Publish` packets. It is ignored by
+	// other packets: their fixed headers have no spare bit
+	// to flag it and several already end with optional
+	// fields detected by length, so carrying it there
+	// needs a new protocol version.
+	Props protobase.Properties
 }
@@ -0,0 +1,136 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+
+	"github.com/mitghi/protox/protobase"
+)
+
+// Codecs lists supported compression codecs in order of preference.
+var Codecs = []byte{protobase.COMPGzip, protobase.COMPFlate}
+
+// ValidCodec returns whether `codec` is a supported compression codec.
+func ValidCodec(codec byte) bool {
+	switch codec {
+	case protobase.COMPNone, protobase.COMPFlate, protobase.COMPGzip:
+		return true
+	}
+	return false
+}
+
+// NegotiateCodec returns the first codec in `offered` ( client
+// preference ) which is also present in `supported`. It returns
+// `COMPNone` when there is no common codec.
+func NegotiateCodec(supported []byte, offered []byte) byte {
+	for _, o := range offered {
+		if o == protobase.COMPNone {
+			continue
+		}
+		for _, s := range supported {
+			if o == s {
+				return o
+			}
+		}
+	}
+	return protobase.COMPNone
+}
+
+// Compress compresses `data` using `codec`.
+func Compress(codec byte, data []byte) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+		err error
+	)
+	switch codec {
+	case protobase.COMPNone:
+		return data, nil
+	case protobase.COMPFlate:
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+	case protobase.COMPGzip:
+		w = gzip.NewWriter(&buf)
+	default:
+		return nil, BadCodecError
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses `data` which is compressed using `codec`.
+func Decompress(codec byte, data []byte) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+	switch codec {
+	case protobase.COMPNone:
+		return data, nil
+	case protobase.COMPFlate:
+		r = flate.NewReader(bytes.NewReader(data))
+	case protobase.COMPGzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, BadCodecError
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Recode returns `msg` with its payload compressed using `codec`.
+// It returns `msg` itself when it is already encoded that way,
+// otherwise a copy with the re-encoded payload.
+func Recode(msg protobase.MsgInterface, codec byte) (protobase.MsgInterface, error) {
+	if msg.Compression() == codec {
+		return msg, nil
+	}
+	payload, err := Decompress(msg.Compression(), msg.Envelope().Payload())
+	if err != nil {
+		return nil, err
+	}
+	if payload, err = Compress(codec, payload); err != nil {
+		return nil, err
+	}
+	nmb, ok := msg.Clone(msg.Dir()).(*MsgBox)
+	if !ok {
+		return nil, BadMsgTypeError
+	}
+	nmb.envelope = NewMsgEnvelope(msg.Envelope().Route(), payload)
+	nmb.compression = codec
+	return nmb, nil
+}
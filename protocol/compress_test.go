@@ -0,0 +1,112 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol/packet"
+)
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"sensor":"temperature","value":21.5}`), 32)
+	for _, codec := range []byte{protobase.COMPNone, protobase.COMPFlate, protobase.COMPGzip} {
+		c, err := Compress(codec, data)
+		if err != nil {
+			t.Fatal("err!=nil", codec, err)
+		}
+		if codec != protobase.COMPNone && len(c) >= len(data) {
+			t.Fatal("expected compressed payload to be smaller", codec, len(c))
+		}
+		d, err := Decompress(codec, c)
+		if err != nil {
+			t.Fatal("err!=nil", codec, err)
+		}
+		if !bytes.Equal(d, data) {
+			t.Fatal("inconsistent payload after decompression", codec)
+		}
+	}
+	if _, err := Compress(0x7F, data); err != BadCodecError {
+		t.Fatal("expected BadCodecError", err)
+	}
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	if c := NegotiateCodec(Codecs, []byte{protobase.COMPFlate, protobase.COMPGzip}); c != protobase.COMPFlate {
+		t.Fatal("expected client preference to be respected", c)
+	}
+	if c := NegotiateCodec(nil, []byte{protobase.COMPGzip}); c != protobase.COMPNone {
+		t.Fatal("expected no compression without broker support", c)
+	}
+	if c := NegotiateCodec(Codecs, nil); c != protobase.COMPNone {
+		t.Fatal("expected no compression without client offer", c)
+	}
+}
+
+func TestRecode(t *testing.T) {
+	data := []byte("a repetitive payload, a repetitive payload")
+	mb := NewMsgBox(1, 0, protobase.MDInbound, NewMsgEnvelope("a/topic", data))
+	gz, err := Recode(mb, protobase.COMPGzip)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if gz.Compression() != protobase.COMPGzip || mb.Compression() != protobase.COMPNone {
+		t.Fatal("inconsistent compression codecs", gz.Compression(), mb.Compression())
+	}
+	fl, err := Recode(gz, protobase.COMPFlate)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	plain, err := Recode(fl, protobase.COMPNone)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if !bytes.Equal(plain.Envelope().Payload(), data) || plain.Envelope().Route() != "a/topic" {
+		t.Fatal("inconsistent message after recoding", string(plain.Envelope().Payload()))
+	}
+	if same, _ := Recode(mb, protobase.COMPNone); same != protobase.MsgInterface(mb) {
+		t.Fatal("expected the same message when codec matches")
+	}
+}
+
+func TestPublishCompression(t *testing.T) {
+	conn := NewRawPublish()
+	conn.Topic = "a/telemetry/topic"
+	conn.Message, _ = Compress(protobase.COMPFlate, []byte("compressed payload"))
+	conn.Compression = protobase.COMPFlate
+	if err := conn.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	nc := NewPublish(conn.GetPacket().(*packet.Packet))
+	if nc == nil {
+		t.Fatal("nc==nil, expected!=nil")
+	}
+	if nc.Compression != protobase.COMPFlate || len(nc.Meta.Props) != 0 {
+		t.Fatal("inconsistent compression after decode", nc.Compression, nc.Meta.Props)
+	}
+	if d, err := Decompress(nc.Compression, nc.Message); err != nil || string(d) != "compressed payload" {
+		t.Fatal("inconsistent payload after decode", string(d), err)
+	}
+}
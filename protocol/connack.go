@@ -55,6 +55,9 @@ func (ca *Connack) Encode() (err error) {
 	if ca.Meta.CleanStart {
 		flags |= 0x2
 	}
+	if ca.Meta.WideLength {
+		flags |= packet.RWIDELENGTH // 0x1
+	}
 	cmd |= flags
 
 	ca.Header.WriteByte(cmd)
@@ -62,6 +65,15 @@ func (ca *Connack) Encode() (err error) {
 	if ca.SessionId != "" {
 		SetString(ca.SessionId, &varHeader)
 	}
+	// NOTE
+	// . version is optional and trailing, older
+	//   decoders ignore it.
+	if ca.Version != "" || ca.Caps != nil {
+		SetString(ca.Version, &varHeader)
+	}
+	if ca.Caps != nil {
+		ca.Caps.encode(&varHeader)
+	}
 	EncodeLength(int32(varHeader.Len()), ca.Header)
 	ca.Header.Write(varHeader.Bytes())
 	ca.Encoded = ca.Header
@@ -104,6 +116,7 @@ func (ca *Connack) DecodeFrom(buff []byte) (err error) {
 	hasSession, hasSessionId, cleanStart := ParseHCOptions(opts)
 	ca.Meta.HasSession = hasSession
 	ca.Meta.CleanStart = cleanStart
+	ca.Meta.WideLength = (opts & packet.RWIDELENGTH) != 0
 	/* d e b u g */
 
 	packets = buff[hbnd:]
@@ -119,16 +132,71 @@ func (ca *Connack) DecodeFrom(buff []byte) (err error) {
 	if hasSessionId && packetRemaining > 0 {
 		ca.SessionId = GetString(buffrd, &packetRemaining)
 	}
+	if packetRemaining > 0 {
+		ca.Version = GetString(buffrd, &packetRemaining)
+	}
+	if packetRemaining > 0 {
+		ca.Caps = &Capabilities{}
+		ca.Caps.decode(buffrd, &packetRemaining)
+	}
 	return err
 }
 
+// encode writes capabilities into `buf`.
+func (c *Capabilities) encode(buf *bytes.Buffer) {
+	var flags uint8
+	if c.RetainAvailable {
+		flags |= 0x1
+	}
+	if c.WildcardAvailable {
+		flags |= 0x2
+	}
+	if c.AssignedClientId != "" {
+		flags |= 0x4
+	}
+	if c.Compression != protobase.COMPNone {
+		flags |= 0x8
+	}
+	SetUint8(flags, buf)
+	SetUint8(c.MaxQoS, buf)
+	SetUint32(c.MaxPacketSize, buf)
+	SetUint16(c.ServerKeepAlive, buf)
+	SetUint16(c.ReceiveMax, buf)
+	if c.AssignedClientId != "" {
+		SetString(c.AssignedClientId, buf)
+	}
+	if c.Compression != protobase.COMPNone {
+		SetUint8(c.Compression, buf)
+	}
+}
+
+// decode reads capabilities from `r`.
+func (c *Capabilities) decode(r *bytes.Reader, packetRemaining *int32) {
+	flags := GetUint8(r, packetRemaining)
+	c.RetainAvailable = (flags & 0x1) != 0
+	c.WildcardAvailable = (flags & 0x2) != 0
+	c.MaxQoS = GetUint8(r, packetRemaining)
+	c.MaxPacketSize = GetUint32(r, packetRemaining)
+	c.ServerKeepAlive = GetUint16(r, packetRemaining)
+	c.ReceiveMax = GetUint16(r, packetRemaining)
+	if (flags & 0x4) != 0 {
+		c.AssignedClientId = GetString(r, packetRemaining)
+	}
+	if (flags & 0x8) != 0 {
+		c.Compression = GetUint8(r, packetRemaining)
+	}
+}
+
 type ConnackOpts struct {
 	// TODO
 	optcode    byte
 	ResultCode byte
 	SessionId  string
+	Version    string
+	Caps       *Capabilities
 	HasSession bool
 	CleanStart bool
+	WideLength bool
 }
 
 func NewConnackOpts() *ConnackOpts {
@@ -149,6 +217,9 @@ func (ca *ConnackOpts) Match(protobase.OptCode) bool {
 func (ca *ConnackOpts) ParseFrom(cack *Connack) {
 	ca.ResultCode = cack.ResultCode
 	ca.SessionId = cack.SessionId
+	ca.Version = cack.Version
+	ca.Caps = cack.Caps
 	ca.HasSession = cack.Meta.HasSession
 	ca.CleanStart = cack.Meta.CleanStart
+	ca.WideLength = cack.Meta.WideLength
 }
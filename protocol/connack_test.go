@@ -33,6 +33,7 @@ import (
 func TestConnack(t *testing.T) {
 	c := NewRawConnack()
 	c.Meta.HasSession = true
+	c.Meta.WideLength = true
 	c.ResultCode = protobase.RESPFAIL
 	c.Encode()
 
@@ -47,8 +48,59 @@ func TestConnack(t *testing.T) {
 	if nc.Meta.HasSession == false {
 		t.Fatal("nc.Meta.HasSession == false, expected true")
 	}
+	if nc.Meta.WideLength == false {
+		t.Fatal("nc.Meta.WideLength == false, expected true")
+	}
 	fmt.Println("")
 	for _, v := range c.Encoded.Bytes() {
 		fmt.Printf("%x ", v)
 	}
 }
+
+func TestConnackVersion(t *testing.T) {
+	c := NewRawConnack()
+	c.ResultCode = protobase.RESPOK
+	c.SessionId = "session"
+	c.Version = protobase.ProtoVersion
+	if err := c.Encode(); err != nil {
+		t.Fatal("err!=nil, expected nil.", err)
+	}
+	nc := NewConnack(c.GetPacket())
+	if nc == nil {
+		t.Fatal("nc==nil, expected!=nil. Unable to decode packet.")
+	}
+	if nc.SessionId != c.SessionId || nc.Version != c.Version {
+		t.Fatal("inconsistent session/version, expected same.", nc.SessionId, nc.Version)
+	}
+}
+
+func TestConnackCaps(t *testing.T) {
+	c := NewRawConnack()
+	c.ResultCode = protobase.RESPOK
+	c.Caps = &Capabilities{
+		MaxQoS:            0x1,
+		MaxPacketSize:     4096,
+		ServerKeepAlive:   30,
+		ReceiveMax:        10,
+		RetainAvailable:   true,
+		WildcardAvailable: true,
+		AssignedClientId:  "assigned",
+		Compression:       protobase.COMPGzip,
+	}
+	if err := c.Encode(); err != nil {
+		t.Fatal("err!=nil, expected nil.", err)
+	}
+	nc := NewConnack(c.GetPacket())
+	if nc == nil {
+		t.Fatal("nc==nil, expected!=nil. Unable to decode packet.")
+	}
+	if nc.Caps == nil {
+		t.Fatal("nc.Caps==nil, expected!=nil.")
+	}
+	if *nc.Caps != *c.Caps {
+		t.Fatal("inconsistent capabilities, expected same.", *nc.Caps, *c.Caps)
+	}
+	if nc.Version != "" {
+		t.Fatal("nc.Version!=\"\", expected empty.", nc.Version)
+	}
+}
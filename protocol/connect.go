@@ -55,19 +55,40 @@ func (cn *Connect) Encode() (err error) {
 	if cn.Username != "" {
 		flags |= 0x8
 	}
+	if cn.Meta.WideLength {
+		flags |= 0x10
+	}
+	if cn.WillTopic != "" {
+		flags |= 0x20
+	}
+	if len(cn.Versions) > 0 {
+		flags |= 0x40
+	}
+	if cn.SessionId != "" {
+		flags |= 0x80
+	}
 	cmd = cn.Command
 	if cn.Meta.CleanStart {
 		cmd |= 0x8 // clean-start bit
 	}
+	if len(cn.Codecs) > 0 {
+		cmd |= 0x4 // codecs bit
+	}
 	cn.Header.WriteByte(cmd)
 	hasPassword := (flags & 0x1) != 0
 	hasClientId := (flags & 0x2) != 0
 	hasKeepalive := (flags & 0x4) != 0
 	hasUsername := (flags & 0x8) != 0
+	hasWill := (flags & 0x20) != 0
+	hasVersions := (flags & 0x40) != 0
+	hasSessionId := (flags & 0x80) != 0
 	logger.FInfo(fn, "* [Connection] --OPTIONS[keepalive, clid, clusrname, clpasswd]=(",
 		hasKeepalive, hasClientId, hasUsername, hasPassword, ")--")
-	vhProtocol := []byte(protobase.ProtoVersion)
-	SetString(string(vhProtocol), &vh)
+	vhProtocol := cn.Version
+	if vhProtocol == "" {
+		vhProtocol = protobase.ProtoVersion
+	}
+	SetString(vhProtocol, &vh)
 	SetUint8(flags, &vh)
 	if hasPassword {
 		logger.FTrace(1, "Encode", "setting string")
@@ -85,6 +106,42 @@ func (cn *Connect) Encode() (err error) {
 		logger.FTrace(1, "Encode", "setting username")
 		SetString(cn.Username, &pl)
 	}
+	if hasWill {
+		logger.FTrace(1, "Encode", "setting will")
+		var wflags uint8 = cn.WillQoS & 0x3
+		if cn.WillRetain {
+			wflags |= 0x4
+		}
+		SetString(cn.WillTopic, &pl)
+		SetUint8(wflags, &pl)
+		SetUint32(cn.WillDelay, &pl)
+		SetPayload(cn.WillMessage, cn.Meta.WideLength, &pl)
+	}
+	if hasVersions {
+		if len(cn.Versions) > 0xFF {
+			RaiseError(BsgTooLongError)
+		}
+		SetUint8(uint8(len(cn.Versions)), &pl)
+		for _, v := range cn.Versions {
+			SetString(v, &pl)
+		}
+	}
+	if hasSessionId {
+		SetString(cn.SessionId, &pl)
+	}
+	// NOTE
+	// . codecs are optional and trailing, they are
+	//   flagged in the fixed header as the flags
+	//   byte is exhausted.
+	if len(cn.Codecs) > 0 {
+		if len(cn.Codecs) > 0xFF {
+			RaiseError(BsgTooLongError)
+		}
+		SetUint8(uint8(len(cn.Codecs)), &pl)
+		for _, c := range cn.Codecs {
+			SetUint8(c, &pl)
+		}
+	}
 	if _, err := vh.Write(pl.Bytes()); err != nil {
 		return err
 	}
@@ -108,6 +165,7 @@ func (cn *Connect) DecodeFrom(buff []byte) (err error) {
 	if ((opts & 0x8) >> 3) == 1 {
 		cn.Meta.CleanStart = true
 	}
+	hasCodecs := (opts & 0x4) != 0
 	buffreader := bytes.NewReader(packets)
 	packetRemaining := int32(len(packets))
 	versionStr := GetString(buffreader, &packetRemaining)
@@ -117,6 +175,10 @@ func (cn *Connect) DecodeFrom(buff []byte) (err error) {
 	hasClientId := (flags & 0x2) != 0
 	hasKeepalive := (flags & 0x4) != 0
 	hasUsername := (flags & 0x8) != 0
+	cn.Meta.WideLength = (flags & 0x10) != 0
+	hasWill := (flags & 0x20) != 0
+	hasVersions := (flags & 0x40) != 0
+	hasSessionId := (flags & 0x80) != 0
 	logger.Debug("--OPTIONS[keepalive, clid, clusrname, clpasswd]=(",
 		hasKeepalive, hasClientId, hasUsername, hasPassword, ")--")
 	if hasPassword {
@@ -135,6 +197,31 @@ func (cn *Connect) DecodeFrom(buff []byte) (err error) {
 		username := GetString(buffreader, &packetRemaining)
 		cn.Username = username
 	}
+	if hasWill {
+		cn.WillTopic = GetString(buffreader, &packetRemaining)
+		wflags := GetUint8(buffreader, &packetRemaining)
+		cn.WillQoS = wflags & 0x3
+		cn.WillRetain = (wflags & 0x4) != 0
+		cn.WillDelay = GetUint32(buffreader, &packetRemaining)
+		cn.WillMessage = GetPayload(buffreader, &packetRemaining, cn.Meta.WideLength)
+	}
+	if hasVersions {
+		n := GetUint8(buffreader, &packetRemaining)
+		cn.Versions = make([]string, 0, n)
+		for i := uint8(0); i < n; i++ {
+			cn.Versions = append(cn.Versions, GetString(buffreader, &packetRemaining))
+		}
+	}
+	if hasSessionId {
+		cn.SessionId = GetString(buffreader, &packetRemaining)
+	}
+	if hasCodecs {
+		n := GetUint8(buffreader, &packetRemaining)
+		cn.Codecs = make([]byte, 0, n)
+		for i := uint8(0); i < n; i++ {
+			cn.Codecs = append(cn.Codecs, GetUint8(buffreader, &packetRemaining))
+		}
+	}
 	return err
 }
 
@@ -25,6 +25,8 @@ package protocol
 import (
 	"fmt"
 	"testing"
+
+	"github.com/mitghi/protox/protobase"
 )
 
 const (
@@ -75,6 +77,110 @@ func TestConnect(t *testing.T) {
 	// var v *packet.Packet = conn.GetPacket().(*packet.Packet)
 }
 
+func TestConnectWideLength(t *testing.T) {
+	conn := makeConnectPacket()
+	conn.Meta.WideLength = true
+	if err := conn.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	nc := NewConnect(conn.GetPacket())
+	if nc == nil {
+		t.Fatal("nc==nil")
+	}
+	if !nc.Meta.WideLength {
+		t.Fatal("nc.Meta.WideLength==false, expected true")
+	}
+	if nc.Username != usr || nc.ClientId != clid {
+		t.Fatal("inconsistent credentials after decode")
+	}
+}
+
+func TestConnectWill(t *testing.T) {
+	conn := makeConnectPacket()
+	conn.WillTopic = "devices/identifier_string/status"
+	conn.WillMessage = []byte("offline")
+	conn.WillQoS = 0x1
+	conn.WillRetain = true
+	conn.WillDelay = 30
+	if err := conn.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	nc := NewConnect(conn.GetPacket())
+	if nc == nil {
+		t.Fatal("nc==nil")
+	}
+	if nc.WillTopic != conn.WillTopic || string(nc.WillMessage) != string(conn.WillMessage) {
+		t.Fatal("inconsistent will after decode", nc.WillTopic, string(nc.WillMessage))
+	}
+	if nc.WillQoS != conn.WillQoS || !nc.WillRetain || nc.WillDelay != conn.WillDelay {
+		t.Fatal("inconsistent will options after decode", nc.WillQoS, nc.WillRetain, nc.WillDelay)
+	}
+	if nc.Username != usr || nc.ClientId != clid {
+		t.Fatal("inconsistent credentials after decode")
+	}
+}
+
+func TestConnectVersions(t *testing.T) {
+	conn := makeConnectPacket()
+	conn.Version = "PRX2"
+	conn.Versions = []string{protobase.ProtoVersion}
+	if err := conn.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	nc := NewConnect(conn.GetPacket())
+	if nc == nil {
+		t.Fatal("nc==nil")
+	}
+	if nc.Version != conn.Version || len(nc.Versions) != 1 || nc.Versions[0] != protobase.ProtoVersion {
+		t.Fatal("inconsistent versions after decode", nc.Version, nc.Versions)
+	}
+}
+
+func TestConnectSession(t *testing.T) {
+	conn := makeConnectPacket()
+	conn.SessionId = "opaque-session-token"
+	if err := conn.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	nc := NewConnect(conn.GetPacket())
+	if nc == nil {
+		t.Fatal("nc==nil")
+	}
+	if nc.SessionId != conn.SessionId {
+		t.Fatal("inconsistent session id after decode", nc.SessionId)
+	}
+}
+
+func TestConnectCodecs(t *testing.T) {
+	conn := makeConnectPacket()
+	conn.SessionId = "opaque-session-token"
+	conn.Codecs = []byte{protobase.COMPGzip, protobase.COMPFlate}
+	if err := conn.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	nc := NewConnect(conn.GetPacket())
+	if nc == nil {
+		t.Fatal("nc==nil")
+	}
+	if string(nc.Codecs) != string(conn.Codecs) || nc.SessionId != conn.SessionId {
+		t.Fatal("inconsistent codecs after decode", nc.Codecs, nc.SessionId)
+	}
+	// trailing bytes without the codecs bit are not codecs
+	conn = makeConnectPacket()
+	if err := conn.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	raw := append(conn.Encoded.Bytes(), 0x1, protobase.COMPGzip)
+	raw[1] += 2
+	nc = NewRawConnect()
+	if err := nc.DecodeFrom(raw); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if len(nc.Codecs) != 0 {
+		t.Fatal("codecs decoded without codecs bit", nc.Codecs)
+	}
+}
+
 // TODO:
 // . move to stash
 
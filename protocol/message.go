@@ -23,6 +23,8 @@
 package protocol
 
 import (
+	"time"
+
 	"github.com/mitghi/protox/protobase"
 )
 
@@ -43,6 +45,12 @@ type MsgBox struct {
 	messageId uint16
 	dir       protobase.MsgDir
 	envelope  protobase.MsgEnvelopeInterface
+	retain    bool
+	retainTTL uint32
+	props     protobase.Properties
+	expiresAt time.Time
+	// compression codec of the payload
+	compression byte
 	// TODO
 	// meta      protobase.MetaEnvelopeInterface
 }
@@ -58,7 +66,7 @@ func NewMsgEnvelope(route string, payload []byte) *MsgEnvelope {
 // NewMsgBox is a function that allocates and initializes a new `MsgBox`
 // and return a pointer to it.
 func NewMsgBox(qos byte, messageId uint16, dir protobase.MsgDir, envelope protobase.MsgEnvelopeInterface) *MsgBox {
-	return &MsgBox{qos, messageId, dir, envelope}
+	return &MsgBox{qos: qos, messageId: messageId, dir: dir, envelope: envelope}
 }
 
 // Section: MsgEnvelope receiver methods.
@@ -101,6 +109,64 @@ func (mb *MsgBox) Envelope() protobase.MsgEnvelopeInterface {
 	return mb.envelope
 }
 
+// Retain returns whether the message is (or shall be) retained.
+func (mb *MsgBox) Retain() bool {
+	return mb.retain
+}
+
+// RetainTTL returns retain lifetime in seconds, zero means forever.
+func (mb *MsgBox) RetainTTL() uint32 {
+	return mb.retainTTL
+}
+
+// SetRetain sets retain flag and its lifetime in seconds.
+func (mb *MsgBox) SetRetain(retain bool, ttl uint32) {
+	mb.retain = retain
+	mb.retainTTL = ttl
+}
+
+// Properties returns user properties of the message.
+func (mb *MsgBox) Properties() protobase.Properties {
+	return mb.props
+}
+
+// SetProperties sets user properties of the message.
+func (mb *MsgBox) SetProperties(props protobase.Properties) {
+	mb.props = props
+}
+
+// Expiry returns the remaining lifetime in seconds, zero means
+// the message never expires.
+func (mb *MsgBox) Expiry() uint32 {
+	return remaining(mb.expiresAt, time.Now())
+}
+
+// SetExpiry sets lifetime of the message in seconds starting
+// from now. Zero removes the deadline.
+func (mb *MsgBox) SetExpiry(seconds uint32) {
+	if seconds == 0 {
+		mb.expiresAt = time.Time{}
+		return
+	}
+	mb.expiresAt = time.Now().Add(time.Duration(seconds) * time.Second)
+}
+
+// Expired returns whether lifetime of the message has passed.
+func (mb *MsgBox) Expired() bool {
+	return !mb.expiresAt.IsZero() && !time.Now().Before(mb.expiresAt)
+}
+
+// Compression returns the compression codec of the payload.
+func (mb *MsgBox) Compression() byte {
+	return mb.compression
+}
+
+// SetCompression marks the payload as compressed using `codec`,
+// it does not alter the payload itself ( see `Recode` ).
+func (mb *MsgBox) SetCompression(codec byte) {
+	mb.compression = codec
+}
+
 // Clone deep-copies and returns current message and set its
 // direction to argument `dir`.
 func (mb *MsgBox) Clone(dir protobase.MsgDir) protobase.MsgInterface {
@@ -120,5 +186,23 @@ func (mb *MsgBox) Clone(dir protobase.MsgDir) protobase.MsgInterface {
 	}
 	nme := NewMsgEnvelope(route, payload)
 	nmb := NewMsgBox(mb.qos, mb.messageId, dir, nme)
+	nmb.retain = mb.retain
+	nmb.retainTTL = mb.retainTTL
+	nmb.props = mb.props.Clone()
+	nmb.expiresAt = mb.expiresAt
+	nmb.compression = mb.compression
 	return nmb
 }
+
+// remaining returns seconds left until `deadline` rounded up, and
+// at least one second while a deadline is set.
+func remaining(deadline time.Time, now time.Time) uint32 {
+	if deadline.IsZero() {
+		return 0
+	}
+	left := deadline.Sub(now)
+	if left <= 0 {
+		return 1
+	}
+	return uint32((left + time.Second - 1) / time.Second)
+}
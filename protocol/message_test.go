@@ -22,7 +22,11 @@
 
 package protocol
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/mitghi/protox/protobase"
+)
 
 func TestMsgEnvelope(t *testing.T) {
 	// TODO
@@ -31,3 +35,17 @@ func TestMsgEnvelope(t *testing.T) {
 func TestMsgBox(t *testing.T) {
 	// TODO
 }
+
+func TestMsgBoxCloneProperties(t *testing.T) {
+	mb := NewMsgBox(1, 1, protobase.MDInbound, NewMsgEnvelope("a/topic", []byte("payload")))
+	mb.SetRetain(true, 10)
+	mb.SetProperties(protobase.Properties{protobase.PROPReplyTo: []byte("a/reply")})
+	nmb := mb.Clone(protobase.MDOutbound)
+	if !nmb.Retain() || nmb.RetainTTL() != 10 {
+		t.Fatal("retain is not cloned, expected same.", nmb.Retain(), nmb.RetainTTL())
+	}
+	nmb.Properties().Set(protobase.PROPReplyTo, []byte("changed"))
+	if v, _ := mb.Properties().Get(protobase.PROPReplyTo); string(v) != "a/reply" {
+		t.Fatal("properties are shared after clone, expected copy.", string(v))
+	}
+}
@@ -28,6 +28,7 @@ var (
 	PROTOCODES map[byte]string = map[byte]string{
 		0x01: "PCONNECT",
 		0x02: "PCONNACK",
+		0x03: "PPUBREC",
 		0x04: "PQUEUE",
 		0x05: "PQUEUEACK",
 		0x06: "PPUBACK",
@@ -39,6 +40,7 @@ var (
 		0x0C: "PPING",
 		0x0D: "PPONG",
 		0x0E: "PDISCONNECT",
+		0x0F: "PPUBCOMP",
 		// TODO
 		// 0x03: "PRESPONSE",
 		// 0x04: "PREQACK",
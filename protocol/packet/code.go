@@ -2,13 +2,15 @@ package packet
 
 // Maximum supported Quality of Service
 const (
-	MAXQoS byte = 0x1
+	MAXQoS byte = 0x2
 )
 
 // Control packet codes ( shifted to left, mask : 0xF0 )
 const (
 	CCONNECT     byte = byte(0x1 << 4)
 	CCONNACK     byte = byte(0x2 << 4)
+	CPUBREC      byte = byte(0x3 << 4)
+	CPUBREL      byte = byte(0x3<<4) | PUBRELFlag
 	CQUEUE       byte = byte(0x4 << 4)
 	CQUEUEACK    byte = byte(0x5 << 4)
 	CPUBACK      byte = byte(0x6 << 4)
@@ -20,6 +22,7 @@ const (
 	CPING        byte = byte(0xC << 4)
 	CPONG        byte = byte(0xD << 4)
 	CDISCONNECT  byte = byte(0xE << 4)
+	CPUBCOMP     byte = byte(0xF << 4)
 	// TODO
 	//  CRESACK byte      = byte(0x6 << 4)
 	//  CREQACK      byte = byte(0x4 << 4)
@@ -27,6 +30,11 @@ const (
 	// 	CRESPONSE    byte = byte(0x3 << 4)
 )
 
+// PUBRELFlag marks a PUBREC coded packet as PUBREL.
+const (
+	PUBRELFlag byte = 0x02
+)
+
 // Quality of Service codes
 const (
 	LQOS0 = 0x00
@@ -52,6 +60,7 @@ const (
 	// . add the rest
 	RHASSESSION = 0x08
 	RCLEANSTART = 0x04
+	RWIDELENGTH = 0x01
 )
 
 // // Control packet raw codes
@@ -1,6 +1,8 @@
 package protocol
 
 import (
+	"time"
+
 	"github.com/mitghi/protox/protobase"
 )
 
@@ -13,6 +15,7 @@ const (
 	QAcNone byte = iota
 	QAcOK
 	QAcERR
+	QAcNoResponders
 )
 
 // Queue constants
@@ -21,8 +24,21 @@ const (
 	QADestroy
 	QADrain
 	QANone
+	QAScatter
+	// QATimeout notifies a requester that its request pending on
+	// the return path in `Address` timed out.
+	QATimeout
 )
 
+// QReplyPrefix is the address prefix of queue return paths, messages
+// sent to a return path are replies to a pending request.
+const QReplyPrefix = "$reply/"
+
+// SharePrefix is the topic prefix of shared subscriptions. Clients
+// subscribed to "$share/<group>/<filter>" compete for messages of
+// `filter`, each message is delivered to one member of the group.
+const SharePrefix = "$share/"
+
 var (
 	_ protobase.EDProtocol = (*Connect)(nil)
 )
@@ -35,8 +51,18 @@ type Connect struct {
 	Username   string
 	Password   string
 	Version    string
+	Versions   []string // versions supported by client, most recent first
 	KeepAlive  int
 	CleanStart bool
+	SessionId  string // session token issued by the broker
+	Codecs     []byte // offered compression codecs, preferred first
+	// last will, published by the broker when the
+	// connection ends without a `Disconnect` packet.
+	WillTopic   string
+	WillMessage []byte
+	WillQoS     byte
+	WillRetain  bool
+	WillDelay   uint32 // seconds
 }
 
 // Connack is a control packet. It acknowledges the incomming connections
@@ -48,8 +74,21 @@ type Connack struct {
 
 	ResultCode byte
 	SessionId  string
-	// TODO
-	// . add config fields from broker to client
+	Version    string // negotiated protocol version
+	Caps       *Capabilities
+}
+
+// Capabilities are broker limits and features advertised to
+// clients in `Connack`.
+type Capabilities struct {
+	MaxQoS            byte
+	MaxPacketSize     uint32 // 0 = unlimited
+	ServerKeepAlive   uint16 // seconds, 0 = client keepalive
+	ReceiveMax        uint16 // max inflight QoS>0 publishes, 0 = unlimited
+	RetainAvailable   bool
+	WildcardAvailable bool
+	Compression       byte // negotiated payload compression codec
+	AssignedClientId  string
 }
 
 // Disconnect is a control packet. It temrinates the connection.
@@ -70,6 +109,21 @@ type Puback struct {
 	Protocol
 }
 
+//
+type Pubrec struct {
+	Protocol
+}
+
+//
+type Pubrel struct {
+	Protocol
+}
+
+//
+type Pubcomp struct {
+	Protocol
+}
+
 //
 type Suback struct {
 	Protocol
@@ -86,12 +140,29 @@ type Publish struct {
 
 	Topic   string
 	Message []byte
+	// RetainTTL is lifetime of a retained message in
+	// seconds. It is only sent when retain flag is set
+	// and zero means forever.
+	RetainTTL uint32
+	// Expiry is lifetime of the message in seconds, zero
+	// means forever. It is carried as `PROPExpiry`.
+	Expiry uint32
+	// Compression is the codec of a compressed payload, it
+	// is carried as `PROPCompression`.
+	Compression byte
+	// ExpiresAt is the local deadline of a stored packet,
+	// it is not part of the wire format.
+	ExpiresAt time.Time
+	// Attempts is the number of deliveries of a stored packet,
+	// it is not part of the wire format.
+	Attempts int
 }
 
 type QAck struct {
 	Protocol
 
-	Code byte
+	Code       byte
+	Responders uint16 // number of responders a scattered request reached
 }
 
 type Queue struct {
@@ -188,12 +259,52 @@ func NewPuback(packet protobase.PacketInterface) (pa *Puback) {
 	return pa
 }
 
+//
+func NewPubrec(packet protobase.PacketInterface) (pr *Pubrec) {
+	pr = &Pubrec{
+		Protocol: NewProtocol(protobase.CPUBREC),
+	}
+	if err := pr.DecodeFrom(packet.GetData()); err != nil {
+		return nil
+	}
+	return pr
+}
+
+//
+func NewPubrel(packet protobase.PacketInterface) (pr *Pubrel) {
+	pr = &Pubrel{
+		Protocol: NewProtocol(protobase.CPUBREL),
+	}
+	if err := pr.DecodeFrom(packet.GetData()); err != nil {
+		return nil
+	}
+	return pr
+}
+
+//
+func NewPubcomp(packet protobase.PacketInterface) (pc *Pubcomp) {
+	pc = &Pubcomp{
+		Protocol: NewProtocol(protobase.CPUBCOMP),
+	}
+	if err := pc.DecodeFrom(packet.GetData()); err != nil {
+		return nil
+	}
+	return pc
+}
+
 //
 func NewPublish(packet protobase.PacketInterface) (p *Publish) {
+	return NewPublishWide(packet, false)
+}
+
+// NewPublishWide decodes a `Publish` packet whose payload length
+// is prefixed as uint32 when `wide` is set ( negotiated during CONNECT ).
+func NewPublishWide(packet protobase.PacketInterface, wide bool) (p *Publish) {
 	p = &Publish{
 		Protocol: NewProtocol(protobase.CPUBLISH),
 		Topic:    "",
 	}
+	p.Meta.WideLength = wide
 	if err := p.DecodeFrom(packet.GetData()); err != nil {
 		return nil
 	}
@@ -211,6 +322,20 @@ func NewQAck(packet protobase.PacketInterface) (qa *QAck) {
 	return qa
 }
 
+// NewQueueWide decodes a queue packet whose message length prefix
+// is 4 bytes when `wide` is set.
+func NewQueueWide(packet protobase.PacketInterface, wide bool) (q *Queue) {
+	q = &Queue{
+		Protocol: NewProtocol(protobase.CQUEUE),
+		Action:   QANone,
+	}
+	q.Meta.WideLength = wide
+	if err := q.DecodeFrom(packet.GetData()); err != nil {
+		return nil
+	}
+	return q
+}
+
 // - MARK: Initializers.
 
 func NewQueue() *Queue {
@@ -324,6 +449,27 @@ func NewRawPuback() *Puback {
 	}
 }
 
+//
+func NewRawPubrec() *Pubrec {
+	return &Pubrec{
+		Protocol: NewProtocol(protobase.CPUBREC),
+	}
+}
+
+//
+func NewRawPubrel() *Pubrel {
+	return &Pubrel{
+		Protocol: NewProtocol(protobase.CPUBREL),
+	}
+}
+
+//
+func NewRawPubcomp() *Pubcomp {
+	return &Pubcomp{
+		Protocol: NewProtocol(protobase.CPUBCOMP),
+	}
+}
+
 //
 func NewRawPublish() *Publish {
 	return &Publish{
@@ -25,6 +25,8 @@ package protocol
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"sort"
 
 	"github.com/google/uuid"
 	"github.com/mitghi/protox/protobase"
@@ -144,6 +146,46 @@ func (p *Protocol) Encode() (err error) {
 	return err
 }
 
+// encodeProperties writes `props` as a property section into `buf`.
+// The section is prefixed with its length and contains entries of
+// property identifier, uint16 length and value.
+func encodeProperties(props protobase.Properties, buf *bytes.Buffer) {
+	var (
+		section bytes.Buffer
+		ids     []int = make([]int, 0, len(props))
+	)
+	for id := range props {
+		ids = append(ids, int(id))
+	}
+	// deterministic order
+	sort.Ints(ids)
+	for _, id := range ids {
+		SetUint8(byte(id), &section)
+		SetBytes(props[byte(id)], &section)
+	}
+	if section.Len() > MaxFieldLen {
+		RaiseError(BsgTooLongError)
+	}
+	SetUint16(uint16(section.Len()), buf)
+	buf.Write(section.Bytes())
+}
+
+// decodeProperties reads a property section written by `encodeProperties`.
+// Unknown properties are kept as is.
+func decodeProperties(r io.Reader, packetRemaining *int32) protobase.Properties {
+	slen := int32(GetUint16(r, packetRemaining))
+	if slen > *packetRemaining {
+		RaiseError(DataExceedsPacketError)
+	}
+	*packetRemaining -= slen
+	props := make(protobase.Properties)
+	for slen > 0 {
+		id := GetUint8(r, &slen)
+		props[id] = GetBytes(r, &slen)
+	}
+	return props
+}
+
 // ParseHOptions is a function that parses first 0x0F
 // bits into Fixed Header options.
 func ParseHOptions(opts byte) (dup, retain bool, qos byte) {
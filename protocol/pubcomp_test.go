@@ -0,0 +1,48 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package protocol
+
+import (
+	"testing"
+
+	"github.com/mitghi/protox/protocol/packet"
+)
+
+func TestPubcomp(t *testing.T) {
+	pc := NewRawPubcomp()
+	pc.Meta.MessageId = 1024
+	if err := pc.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	p := pc.GetPacket().(*packet.Packet)
+	if p.Data[0] != pc.Command {
+		t.Fatal("invalid header, expected", pc.Command, "got", p.Data[0])
+	}
+	npc := NewPubcomp(p)
+	if npc == nil {
+		t.Fatal("npc==nil, expected non-nil. Unable to decode packet.")
+	}
+	if npc.Meta.MessageId != pc.Meta.MessageId {
+		t.Fatal("inconsistent message id, expected", pc.Meta.MessageId, "got", npc.Meta.MessageId)
+	}
+}
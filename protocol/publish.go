@@ -24,6 +24,10 @@ package protocol
 
 import (
 	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/mitghi/protox/protobase"
 )
 
 //
@@ -46,7 +50,18 @@ func (p *Publish) Encode() (err error) {
 		SetUint16(p.Meta.MessageId, &varHeader)
 	}
 	SetString(p.Topic, &varHeader)
-	SetBytes(p.Message, &payload)
+	SetPayload(p.Message, p.Meta.WideLength, &payload)
+	// NOTE
+	// . optional trailing fields, older decoders ignore them.
+	//   retain lifetime slot is kept when properties follow.
+	props := p.wireProperties()
+	hasProps := len(props) > 0
+	if p.Meta.Ret && (p.RetainTTL > 0 || hasProps) {
+		SetUint32(p.RetainTTL, &payload)
+	}
+	if hasProps {
+		encodeProperties(props, &payload)
+	}
 	varHeader.ReadFrom(&payload)
 	EncodeLength(int32(varHeader.Len()), p.Header)
 	p.Header.Write(varHeader.Bytes())
@@ -83,8 +98,68 @@ func (p *Publish) DecodeFrom(buff []byte) (err error) {
 	}
 	topic := GetString(buffrd, &packetRemaining)
 	p.Topic = topic
-	message := GetBytes(buffrd, &packetRemaining)
+	message := GetPayload(buffrd, &packetRemaining, p.Meta.WideLength)
 	p.Message = message
+	if p.Meta.Ret && packetRemaining >= 4 {
+		p.RetainTTL = GetUint32(buffrd, &packetRemaining)
+	}
+	if packetRemaining > 0 {
+		p.Meta.Props = decodeProperties(buffrd, &packetRemaining)
+		p.extractFields()
+	}
 
 	return err
 }
+
+// Expired returns whether the local deadline of the packet has
+// passed. Packets without a deadline never expire.
+func (p *Publish) Expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !now.Before(p.ExpiresAt)
+}
+
+// Remaining returns the remaining lifetime in seconds at `now`
+// rounded up. It returns `0` when the packet has no deadline.
+func (p *Publish) Remaining(now time.Time) uint32 {
+	return remaining(p.ExpiresAt, now)
+}
+
+// wireProperties returns the properties to be encoded, it merges
+// `Expiry` and `Compression` without mutating `Meta.Props`.
+func (p *Publish) wireProperties() protobase.Properties {
+	if p.Expiry == 0 && p.Compression == protobase.COMPNone {
+		return p.Meta.Props
+	}
+	props := p.Meta.Props.Clone()
+	if props == nil {
+		props = make(protobase.Properties)
+	}
+	if p.Expiry > 0 {
+		var val [4]byte
+		binary.BigEndian.PutUint32(val[:], p.Expiry)
+		props[protobase.PROPExpiry] = val[:]
+	}
+	if p.Compression != protobase.COMPNone {
+		props[protobase.PROPCompression] = []byte{p.Compression}
+	}
+	return props
+}
+
+// extractFields moves `PROPExpiry` and `PROPCompression` from
+// decoded properties into `Expiry` and `Compression`.
+func (p *Publish) extractFields() {
+	if val, ok := p.Meta.Props.Get(protobase.PROPExpiry); ok {
+		if len(val) == 4 {
+			p.Expiry = binary.BigEndian.Uint32(val)
+		}
+		delete(p.Meta.Props, protobase.PROPExpiry)
+	}
+	if val, ok := p.Meta.Props.Get(protobase.PROPCompression); ok {
+		if len(val) == 1 {
+			p.Compression = val[0]
+		}
+		delete(p.Meta.Props, protobase.PROPCompression)
+	}
+	if len(p.Meta.Props) == 0 {
+		p.Meta.Props = nil
+	}
+}
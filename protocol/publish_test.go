@@ -23,10 +23,13 @@
 package protocol
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/mitghi/protox/protobase"
 	"github.com/mitghi/protox/protocol/packet"
 )
 
@@ -107,3 +110,156 @@ func TestDecodeRaw(t *testing.T) {
 		t.Fatal("err!=nil, cannot decode", err)
 	}
 }
+
+func TestPublishWide(t *testing.T) {
+	p := NewRawPublish()
+	p.Topic = "a/large/topic"
+	p.Message = bytes.Repeat([]byte{0xCE}, MaxFieldLen*2)
+	if err := p.Encode(); err != BsgTooLongError {
+		t.Fatal("expected BsgTooLongError for narrow payload, got", err)
+	}
+	p = NewRawPublish()
+	p.Topic = "a/large/topic"
+	p.Message = bytes.Repeat([]byte{0xCE}, MaxFieldLen*2)
+	p.Meta.WideLength = true
+	if err := p.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	np := NewPublishWide(p.GetPacket(), true)
+	if np == nil {
+		t.Fatal("np==nil, expected non-nil. Unable to decode packet.")
+	}
+	if !bytes.Equal(np.Message, p.Message) || np.Topic != p.Topic {
+		t.Fatal("inconsistent publish, expected equal topic and message")
+	}
+}
+
+func TestReadPacketMaxSize(t *testing.T) {
+	p := NewRawPublish()
+	p.Topic = "a/topic"
+	p.Message = bytes.Repeat([]byte{0xCE}, 1024)
+	if err := p.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	var (
+		data []byte = p.Encoded.Bytes()
+		pack []byte
+		rl   uint32
+	)
+	rd := bufio.NewReader(bytes.NewReader(data[1:]))
+	pack = append(pack, data[0])
+	if err := ReadPacket(rd, &pack, &rl, 512); err != PacketTooLargeError {
+		t.Fatal("expected PacketTooLargeError, got", err)
+	}
+	pack, rl = pack[:0], 0
+	rd = bufio.NewReader(bytes.NewReader(data[1:]))
+	pack = append(pack, data[0])
+	if err := ReadPacket(rd, &pack, &rl, uint32(len(data))); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if !bytes.Equal(pack, data) {
+		t.Fatal("inconsistent packet, expected equal")
+	}
+}
+
+func TestPublishRetainTTL(t *testing.T) {
+	conn := NewRawPublish()
+	conn.Topic = "a/retained/topic"
+	conn.Message = []byte("a retained message")
+	conn.Meta.Ret = true
+	conn.RetainTTL = 120
+	if err := conn.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	nc := NewPublish(conn.GetPacket().(*packet.Packet))
+	if nc == nil {
+		t.Fatal("nc==nil, expected!=nil")
+	}
+	if !nc.Meta.Ret || nc.RetainTTL != conn.RetainTTL {
+		t.Fatal("retain mismatch, expected equal", nc.Meta.Ret, nc.RetainTTL)
+	}
+	if string(nc.Message) != string(conn.Message) {
+		t.Fatal("nc.Message!=conn.Message, expected equal")
+	}
+}
+
+func TestPublishProperties(t *testing.T) {
+	for _, ret := range []bool{false, true} {
+		conn := NewRawPublish()
+		conn.Topic = "a/request/topic"
+		conn.Message = []byte("a message with properties")
+		conn.Meta.Ret = ret
+		conn.Meta.Props = protobase.Properties{
+			protobase.PROPContentType:   []byte("application/json"),
+			protobase.PROPCorrelationId: []byte("correlation"),
+			protobase.PROPReplyTo:       []byte("a/reply/topic"),
+		}
+		if err := conn.Encode(); err != nil {
+			t.Fatal("err!=nil", err)
+		}
+		nc := NewPublish(conn.GetPacket().(*packet.Packet))
+		if nc == nil {
+			t.Fatal("nc==nil, expected!=nil")
+		}
+		if string(nc.Message) != string(conn.Message) || nc.RetainTTL != 0 {
+			t.Fatal("inconsistent publish after decode", string(nc.Message), nc.RetainTTL)
+		}
+		if len(nc.Meta.Props) != len(conn.Meta.Props) {
+			t.Fatal("inconsistent properties, expected same.", nc.Meta.Props)
+		}
+		for id, val := range conn.Meta.Props {
+			if v, ok := nc.Meta.Props.Get(id); !ok || string(v) != string(val) {
+				t.Fatal("inconsistent property value, expected same.", id, string(v))
+			}
+		}
+	}
+}
+
+func TestPropertiesPublishOnly(t *testing.T) {
+	plain, withProps := NewRawPuback(), NewRawPuback()
+	plain.Meta.MessageId, withProps.Meta.MessageId = 7, 7
+	withProps.Meta.Props = protobase.Properties{protobase.PROPContentType: []byte("text/plain")}
+	if err := plain.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if err := withProps.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if !bytes.Equal(plain.GetBytes(), withProps.GetBytes()) {
+		t.Fatal("inconsistent puback, expected properties to be ignored.", withProps.GetBytes())
+	}
+}
+
+func TestPublishExpiry(t *testing.T) {
+	conn := NewRawPublish()
+	conn.Topic = "a/stale/topic"
+	conn.Message = []byte("a message with a lifetime")
+	conn.Expiry = 30
+	conn.Meta.Props = protobase.Properties{
+		protobase.PROPContentType: []byte("text/plain"),
+	}
+	if err := conn.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if _, ok := conn.Meta.Props.Get(protobase.PROPExpiry); ok {
+		t.Fatal("expected Encode to keep Meta.Props untouched")
+	}
+	nc := NewPublish(conn.GetPacket().(*packet.Packet))
+	if nc == nil {
+		t.Fatal("nc==nil, expected!=nil")
+	}
+	if nc.Expiry != conn.Expiry {
+		t.Fatal("inconsistent expiry, expected same.", nc.Expiry)
+	}
+	if len(nc.Meta.Props) != 1 {
+		t.Fatal("expected expiry to be removed from properties", nc.Meta.Props)
+	}
+	now := time.Now()
+	nc.ExpiresAt = now.Add(time.Second * 10)
+	if nc.Expired(now) || nc.Remaining(now) != 10 {
+		t.Fatal("inconsistent deadline", nc.Remaining(now))
+	}
+	if !nc.Expired(now.Add(time.Second * 10)) {
+		t.Fatal("expected packet to be expired")
+	}
+}
@@ -38,9 +38,17 @@ func (qa *QAck) Encode() (err error) {
 		// merge proto code and ack code
 		cmd byte = qa.Command | qa.Code
 	)
-	varHeader.WriteByte(cmd)
-	// TODO:
-	// . add ack body
+	qa.Header.WriteByte(cmd)
+	// message id of the acknowledged queue packet, followed by
+	// the number of responders of scattered requests
+	if qa.Meta.MessageId > 0 || qa.Responders > 0 {
+		SetUint16(qa.Meta.MessageId, &varHeader)
+	}
+	if qa.Responders > 0 {
+		SetUint16(qa.Responders, &varHeader)
+	}
+	EncodeLength(int32(varHeader.Len()), qa.Header)
+	qa.Header.Write(varHeader.Bytes())
 	qa.Encoded = qa.Header
 	return err
 }
@@ -53,10 +61,19 @@ func (qa *QAck) DecodeFrom(buff []byte) (err error) {
 		return InvalidHeader
 	}
 	var (
-		hbnd   int    = GetHeaderBoundary(buff)
-		header []byte = buff[:hbnd]
+		hbnd            int    = GetHeaderBoundary(buff)
+		header          []byte = buff[:hbnd]
+		packets         []byte = buff[hbnd:]
+		packetRemaining int32  = int32(len(packets))
 	)
 	qa.Code = (header[0] & 0x0F)
+	buffrd := bytes.NewReader(packets)
+	if packetRemaining >= 2 {
+		qa.Meta.MessageId = GetUint16(buffrd, &packetRemaining)
+	}
+	if packetRemaining >= 2 {
+		qa.Responders = GetUint16(buffrd, &packetRemaining)
+	}
 
 	return err
 }
@@ -1 +1,59 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
 package protocol
+
+import (
+	"testing"
+
+	"github.com/mitghi/protox/protocol/packet"
+)
+
+func TestQAck(t *testing.T) {
+	var (
+		qa *QAck = NewRawQAck()
+		p  *packet.Packet
+	)
+	qa.Code = QAcERR
+	qa.Meta.MessageId = 7
+	if err := qa.Encode(); err != nil {
+		t.Fatal("expected err==nil", err)
+	}
+	p = qa.GetPacket().(*packet.Packet)
+	nqa := NewQAck(p)
+	if nqa == nil {
+		t.Fatal("expected nqa!=nil")
+	}
+	if nqa.Code != qa.Code || nqa.Meta.MessageId != qa.Meta.MessageId || nqa.Responders != 0 {
+		t.Fatal("inconsistent state, assertion failed.", nqa.Code, nqa.Meta.MessageId)
+	}
+	// scattered requests report their responders
+	qa = NewRawQAck()
+	qa.Code, qa.Meta.MessageId, qa.Responders = QAcOK, 8, 3
+	if err := qa.Encode(); err != nil {
+		t.Fatal("expected err==nil", err)
+	}
+	nqa = NewQAck(qa.GetPacket())
+	if nqa == nil || nqa.Code != QAcOK || nqa.Meta.MessageId != 8 || nqa.Responders != 3 {
+		t.Fatal("inconsistent state, assertion failed.", nqa)
+	}
+}
@@ -25,7 +25,6 @@ package protocol
 import (
 	"bytes"
 	"errors"
-	"fmt"
 
 	"github.com/mitghi/protox/protobase"
 )
@@ -64,6 +63,10 @@ func ParseQAction(opts byte) protobase.QAction {
 		return QADestroy
 	case QADrain:
 		return QADrain
+	case QAScatter:
+		return QAScatter
+	case QATimeout:
+		return QATimeout
 	default:
 		return QANone
 	}
@@ -107,6 +110,12 @@ func CreateQCMDOpts(action protobase.QAction) (opts byte, err error) {
 		opts |= 0x1
 	case QADrain:
 		opts |= 0x2
+	case QANone:
+		opts |= 0x3
+	case QAScatter:
+		opts |= 0x4
+	case QATimeout:
+		opts |= 0x5
 	default:
 		return 0x0, errors.New("protocol(queue): invalid option.")
 	}
@@ -136,7 +145,7 @@ func (q *Queue) Encode() (err error) {
 		hasAddress    bool = len(q.Address) > 0
 		hasReturnPath bool = len(q.ReturnPath) > 0
 		hasMark       bool = len(q.Mark) > 0
-		hasOpts       bool = hasMessageId || hasAddress || hasReturnPath
+		hasOpts       bool = hasMessageId || hasAddress || hasReturnPath || hasMark
 		vopts         byte = CreateQVarOpts(hasMessageId, hasAddress, hasReturnPath, hasMark)
 		opts          byte = CreateQOpts(hasOpts, q.Meta.Dup, hasPayload)
 		// merge proto code and fixed options
@@ -163,7 +172,7 @@ func (q *Queue) Encode() (err error) {
 		SetBytes(q.Mark, &varHeader)
 	}
 	if hasPayload {
-		SetBytes(q.Message, &payload)
+		SetPayload(q.Message, q.Meta.WideLength, &payload)
 	}
 	varHeader.ReadFrom(&payload)
 	EncodeLength(int32(varHeader.Len()), q.Header)
@@ -207,7 +216,6 @@ func (q *Queue) DecodeFrom(buff []byte) (err error) {
 	if hasOpts {
 		opts = byte(GetUint16(buffrd, &packetRemaining))
 		action = ParseQAction(opts & 0x0F)
-		fmt.Printf("%b", opts)
 		q.Action = action
 		hasMessageId, hasAddress, hasReturnPath, hasMark = ParseQVarOptions((opts & 0xF0) >> 4)
 		if hasMessageId {
@@ -224,7 +232,7 @@ func (q *Queue) DecodeFrom(buff []byte) (err error) {
 		}
 	}
 	if hasPayload {
-		q.Message = GetBytes(buffrd, &packetRemaining)
+		q.Message = GetPayload(buffrd, &packetRemaining, q.Meta.WideLength)
 	}
 
 	return err
@@ -73,3 +73,23 @@ func TestQueue(t *testing.T) {
 		t.Fatal("inconsistent state, assertion failed.")
 	}
 }
+
+func TestQueueMessage(t *testing.T) {
+	var (
+		q  *Queue = NewQueue()
+		nq *Queue
+	)
+	// messages are enqueued without a command
+	q.Address = "simple/queue/path"
+	q.Message = []byte("payload")
+	if err := q.Encode(); err != nil {
+		t.Fatal("expected err==nil", err)
+	}
+	nq = NewQueueWide(q.GetPacket(), false)
+	if nq == nil {
+		t.Fatal("expected nq!=nil")
+	}
+	if nq.Action != QANone || nq.Address != q.Address || !bytes.Equal(nq.Message, q.Message) {
+		t.Fatal("inconsistent state, assertion failed.", nq.Action, nq.Address, nq.Message)
+	}
+}
@@ -24,6 +24,7 @@ package protocol
 
 import (
 	"bytes"
+	"strings"
 )
 
 //
@@ -100,3 +101,17 @@ func (self *Subscribe) DecodeFrom(buff []byte) (err error) {
 
 	return err
 }
+
+// ParseShare splits a shared subscription topic into its group and
+// filter. It returns false when `topic` is not a shared subscription.
+func ParseShare(topic string) (group string, filter string, ok bool) {
+	if !strings.HasPrefix(topic, SharePrefix) {
+		return "", "", false
+	}
+	rest := topic[len(SharePrefix):]
+	i := strings.IndexByte(rest, '/')
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
@@ -21,3 +21,23 @@
  */
 
 package protocol
+
+import (
+	"testing"
+)
+
+func TestParseShare(t *testing.T) {
+	for topic, want := range map[string][2]string{
+		"$share/workers/jobs/*": {"workers", "jobs/*"},
+		"$share/workers/jobs":   {"workers", "jobs"},
+		"$share/workers":        {},
+		"$share//jobs":          {},
+		"$share/workers/":       {},
+		"jobs/*":                {},
+	} {
+		group, filter, ok := ParseShare(topic)
+		if ok != (want[0] != "") || group != want[0] || filter != want[1] {
+			t.Fatal("inconsistent shared subscription.", topic, group, filter, ok)
+		}
+	}
+}
@@ -21,3 +21,26 @@
  */
 
 package protocol
+
+import (
+	"testing"
+
+	"github.com/mitghi/protox/protocol/packet"
+)
+
+func TestUnSubscribe(t *testing.T) {
+	us := NewRawUnSubscribe()
+	us.Topic = "a/simple/topic"
+	us.Meta.Qos = 0x1
+	us.Meta.MessageId = 12
+	if err := us.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	nus := NewUnSubscribe(us.GetPacket().(*packet.Packet))
+	if nus == nil {
+		t.Fatal("nus==nil, expected non-nil. Unable to decode packet.")
+	}
+	if nus.Topic != us.Topic || nus.Meta.MessageId != us.Meta.MessageId {
+		t.Fatal("inconsistent unsubscribe packet, expected equal topic and message id.")
+	}
+}
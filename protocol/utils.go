@@ -40,6 +40,8 @@ var (
 	BadReturnCodeError     = errors.New("protox: is invalid")
 	DataExceedsPacketError = errors.New("protox: data exceeds packet length")
 	BsgTooLongError        = errors.New("protox: message is too long")
+	PacketTooLargeError    = errors.New("protox: packet exceeds maximum packet size")
+	BadCodecError          = errors.New("protox: compression codec is invalid")
 )
 
 // PanicErr is a wrapper for `error`.
@@ -107,6 +109,21 @@ func GetUint16(r io.Reader, packetRemaining *int32) uint16 {
 	return uint16(b[0])<<8 | uint16(b[1])
 }
 
+// GetUint32 reads a `uint32` from a `io.Reader` and moves the pointer
+// forward.
+func GetUint32(r io.Reader, packetRemaining *int32) uint32 {
+	if *packetRemaining < 4 {
+		RaiseError(DataExceedsPacketError)
+	}
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		RaiseError(err)
+	}
+	*packetRemaining -= 4
+
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
 // GetString reads a `string` from a `io.Reader` and moves the pointer
 // forward.
 func GetString(r io.Reader, packetRemaining *int32) string {
@@ -139,6 +156,31 @@ func GetBytes(r io.Reader, packetRemaining *int32) []byte {
 	return b
 }
 
+// GetWideBytes reads n bytes from the buffer where n
+// is total length as uint32.
+func GetWideBytes(r io.Reader, packetRemaining *int32) []byte {
+	buffLen := int64(GetUint32(r, packetRemaining))
+	if int64(*packetRemaining) < buffLen {
+		RaiseError(DataExceedsPacketError)
+	}
+	b := make([]byte, buffLen)
+	if _, err := io.ReadFull(r, b); err != nil {
+		RaiseError(err)
+	}
+	*packetRemaining -= int32(buffLen)
+
+	return b
+}
+
+// GetPayload reads a payload field whose length is prefixed
+// either as uint16 or as uint32 when `wide` is set.
+func GetPayload(r io.Reader, packetRemaining *int32, wide bool) []byte {
+	if wide {
+		return GetWideBytes(r, packetRemaining)
+	}
+	return GetBytes(r, packetRemaining)
+}
+
 // SetUint8 writes a single byte to `buf` argument.
 func SetUint8(val uint8, buf *bytes.Buffer) {
 	buf.WriteByte(byte(val))
@@ -150,20 +192,56 @@ func SetUint16(val uint16, buf *bytes.Buffer) {
 	buf.WriteByte(byte(val & 0x00ff))
 }
 
-// SetString writes a string as bytes into the `buf` argument.
+// SetUint32 writes a `uint32` to `buf` argument in big-endian order.
+func SetUint32(val uint32, buf *bytes.Buffer) {
+	buf.WriteByte(byte(val >> 24))
+	buf.WriteByte(byte(val >> 16))
+	buf.WriteByte(byte(val >> 8))
+	buf.WriteByte(byte(val))
+}
+
+// SetString writes a string as bytes into the `buf` argument. It
+// raises `BsgTooLongError` when `val` does not fit into uint16 length.
 func SetString(val string, buf *bytes.Buffer) {
+	if len(val) > MaxFieldLen {
+		RaiseError(BsgTooLongError)
+	}
 	length := uint16(len(val))
 	SetUint16(length, buf)
 	buf.WriteString(val)
 }
 
-// SetBytes writes a byte slice into the `buf` argument.
+// SetBytes writes a byte slice into the `buf` argument. It raises
+// `BsgTooLongError` when `val` does not fit into uint16 length.
 func SetBytes(val []byte, buf *bytes.Buffer) {
+	if len(val) > MaxFieldLen {
+		RaiseError(BsgTooLongError)
+	}
 	length := uint16(len(val))
 	SetUint16(length, buf)
 	buf.Write(val)
 }
 
+// SetWideBytes writes a byte slice prefixed with its uint32 length
+// into the `buf` argument.
+func SetWideBytes(val []byte, buf *bytes.Buffer) {
+	if int64(len(val)) > MaxWideFieldLen {
+		RaiseError(BsgTooLongError)
+	}
+	SetUint32(uint32(len(val)), buf)
+	buf.Write(val)
+}
+
+// SetPayload writes a payload field into the `buf` argument. Its length
+// is prefixed either as uint16 or as uint32 when `wide` is set.
+func SetPayload(val []byte, wide bool, buf *bytes.Buffer) {
+	if wide {
+		SetWideBytes(val, buf)
+		return
+	}
+	SetBytes(val, buf)
+}
+
 // BoolToBytes converts a boolean value to a byte.
 func BoolToByte(val bool) byte {
 	if val {
@@ -222,13 +300,14 @@ func GetHeaderBoundary(buf []byte) int {
 }
 
 // ReadPacket reads packets from a `*bufio.Reader` stream. It implements MQTT receive
-// algorithm.
+// algorithm. Packets whose total size exceeds `max` are rejected with
+// `PacketTooLargeError` before reading the remaining bytes ( 0 means unlimited ).
 //  TODO
 //   Write test cases
 //   Check reliability against slow send attacks
 //   Limit maximum read time
 //   Stress test
-func ReadPacket(reader *bufio.Reader, pack *[]byte, rl *uint32) error {
+func ReadPacket(reader *bufio.Reader, pack *[]byte, rl *uint32, max uint32) error {
 	// TODO: write test cases
 	var mp uint32 = 1
 	for {
@@ -247,6 +326,9 @@ func ReadPacket(reader *bufio.Reader, pack *[]byte, rl *uint32) error {
 			return BadLengthEncodingError
 		}
 	}
+	if max > 0 && uint64(len(*pack))+uint64(*rl) > uint64(max) {
+		return PacketTooLargeError
+	}
 	if *rl > 0 {
 		var remaining []byte = make([]byte, *rl)
 		if _, err := io.ReadFull(reader, remaining); err != nil { // Read 'length' remaining bytes
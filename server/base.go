@@ -34,6 +34,7 @@ import (
 	"errors"
 	"net"
 	"sync"
+	"time"
 
 	/* will be replaced with new lock-free implementation */
 	"github.com/mitghi/protox/logging"
@@ -100,6 +101,21 @@ var (
 	SRVInvalidMode    error = errors.New("server: invalid serving mode.")
 	SRVMissingOptions error = errors.New("server: options are missing.")
 	SRVTLSInvalidCA   error = errors.New("server: invalid caFile.")
+	SRVWSSubprotocol  error = errors.New("server: websocket subprotocol is not supported.")
+	SRVBadCredentials error = errors.New("server: invalid credentials.")
+	SRVBanned         error = errors.New("server: client is banned.")
+	SRVSocketInUse    error = errors.New("server: unix socket is in use.")
+	SRVNotSocket      error = errors.New("server: path exists and is not a unix socket.")
+	SRVInvalidProxy   error = errors.New("server: invalid trusted proxy address.")
+	SRVProxyHeader    error = errors.New("server: malformed proxy protocol header.")
+	SRVRestartError   error = errors.New("server: cannot restart due to incompatible state.")
+	SRVNoRestart      error = errors.New("server: restart is not supported on this platform.")
+	SRVInvalidQueue   error = errors.New("server: invalid queue address.")
+	SRVNoQueue        error = errors.New("server: no such queue.")
+	SRVQueueDenied    error = errors.New("server: queue operation is not permitted.")
+	SRVNoResponders   error = errors.New("server: no responders are available.")
+	SRVNoRequest      error = errors.New("server: no pending request for return path.")
+	SRVReturnPath     error = errors.New("server: return path is in use.")
 )
 
 // SConnTyp is server client type ( CLIENT, RESOURCE, ROUTER, MONITOR, .... )
@@ -117,6 +133,30 @@ type ConnectionDelegate func(net.Conn) protobase.ProtoConnection
 // Defaults
 var (
 	DefaultHeartbeat int = 1
+	// DefaultSweepInterval is the interval in which expired
+	// messages are purged from the message store.
+	DefaultSweepInterval time.Duration = time.Second * 5
+	// DefaultWSPath is the HTTP path upgraded to WebSocket
+	// connections by `ServeWS`.
+	DefaultWSPath string = "/protox"
+	// DefaultProxyTimeout is the deadline for reading PROXY
+	// protocol headers.
+	DefaultProxyTimeout time.Duration = time.Second * 5
+	// DefaultRequestTimeout is the lifetime of a pending request
+	// awaiting its reply.
+	DefaultRequestTimeout time.Duration = time.Second * 30
+	// DefaultDeadLetterTopic is the topic prefix undeliverable
+	// messages are published under.
+	DefaultDeadLetterTopic string = "$dead"
+	// DefaultDrainTimeout is the time existing connections are
+	// given to finish in-flight deliveries on restart.
+	DefaultDrainTimeout time.Duration = time.Second * 10
+	// DefaultDrainInterval is the interval idle connections are
+	// disconnected in while draining.
+	DefaultDrainInterval time.Duration = time.Millisecond * 250
+	// DefaultSnapshotGrace is the time a restarted process waits
+	// for the session snapshot beyond the drain timeout.
+	DefaultSnapshotGrace time.Duration = time.Second * 5
 )
 
 // Server is a main implementation of `protocol.ServerInterface`.
@@ -134,11 +174,32 @@ type Server struct {
 	Store              protobase.MessageStorage
 	Router             protobase.RouterInterface
 	State              *serverState
-	listener           *net.Listener
+	listeners          []*listener
+	extra              []ServerConfigs
+	frontends          map[ServerType]ServerConfigs // listener configurations of frontends
 	opts               *ServerConfigs
 	StatusChan         chan uint32
 	critical           chan struct{}
 	heartbeat          int
+	maxPacketSize      uint32
+	maxQoS             byte
+	receiveMax         uint16
+	messageExpiry      time.Duration
+	codecs             []byte
+	versions           []string
+	noCompress         []string
+	wsPath             string
+	sinks              map[string]sink
+	sockets            map[string]filer // listening sockets handed over on restart
+	queues             *queues          // durable named queues
+	requests           *requests        // pending requests by return path
+	shares             *shares          // shared subscriptions by topic
+	requestTimeout     time.Duration
+	deadLetter         string // dead-letter topic prefix, empty disables it
+	maxAttempts        int    // maximum deliveries of a stored message, 0 = unlimited
+	drainTimeout       time.Duration
+	retainAvailable    bool
+	wildcardAvailable  bool
 	Status             uint32
 	// TODO: NOTE:
 	//  . add timestamp and expiration date.
@@ -0,0 +1,114 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+// Reasons of dead-lettered messages, carried as `PROPDeadReason`.
+const (
+	// DeadLetterAttempts indicates that the maximum number of
+	// deliveries is reached.
+	DeadLetterAttempts = "attempts"
+	// DeadLetterExpired indicates that the message expired before
+	// it was acknowledged.
+	DeadLetterExpired = "expired"
+	// DeadLetterRejected indicates that the message cannot be
+	// delivered to the client.
+	DeadLetterRejected = "rejected"
+)
+
+// SetDeadLetterTopic sets the topic prefix undeliverable messages
+// are published under, an empty topic discards them. A message of
+// topic "a/b" is published to "<topic>/a/b".
+func (s *Server) SetDeadLetterTopic(topic string) {
+	s.deadLetter = strings.TrimSuffix(topic, "/")
+}
+
+// SetMaxAttempts sets the maximum number of deliveries of a stored
+// message before it is dead-lettered. Zero means unlimited.
+func (s *Server) SetMaxAttempts(attempts int) {
+	s.maxAttempts = attempts
+}
+
+// isDeadLetter returns whether `topic` is a dead-letter topic.
+func (s *Server) isDeadLetter(topic string) bool {
+	return strings.HasPrefix(topic, s.deadLetter+"/")
+}
+
+// sendDeadLetter publishes message `msg` undeliverable to client
+// `clid` to the dead-letter topic. Its original topic, `reason` and
+// `attempts` are carried as properties.
+func (s *Server) sendDeadLetter(clid string, msg protobase.MsgInterface, attempts int, reason string) {
+	const fn = "sendDeadLetter"
+	topic := msg.Envelope().Route()
+	if s.deadLetter == "" || s.isDeadLetter(topic) {
+		// dead letters are not dead-lettered again
+		logger.FDebugf(fn, "- [DeadLetter] discarding message on topic(%s) of client(%s), reason(%s).", topic, clid, reason)
+		return
+	}
+	var (
+		props protobase.Properties = msg.Properties().Clone()
+		count [4]byte
+	)
+	if props == nil {
+		props = make(protobase.Properties)
+	}
+	binary.BigEndian.PutUint32(count[:], uint32(attempts))
+	props.Set(protobase.PROPDeadTopic, []byte(topic))
+	props.Set(protobase.PROPDeadReason, []byte(reason))
+	props.Set(protobase.PROPDeadAttempts, count[:])
+	dl := protocol.NewMsgBox(protobase.LQOS1, 0, protobase.MDInbound, protocol.NewMsgEnvelope(s.deadLetter+"/"+topic, msg.Envelope().Payload()))
+	dl.SetProperties(props)
+	dl.SetCompression(msg.Compression())
+	logger.FDebugf(fn, "* [DeadLetter] message on topic(%s) of client(%s) is dead-lettered after (%d) attempts, reason(%s).", topic, clid, attempts, reason)
+	s.NotifyPublish(nil, dl)
+}
+
+// deadLetterOut removes stored packet `pb` of client `clid`, releases
+// its message id and dead-letters it.
+func (s *Server) deadLetterOut(clid string, pb *protocol.Publish, reason string) {
+	s.Store.DeleteOut(clid, pb)
+	if idstore := s.Store.GetIDStoreO(clid); idstore != nil {
+		idstore.FreeId(pb.Meta.MessageId)
+	}
+	s.sendDeadLetter(clid, messageOf(pb), pb.Attempts, reason)
+}
+
+// messageOf returns the message of a stored publish packet. Its
+// expiry is the remaining lifetime of the packet.
+func messageOf(pb *protocol.Publish) *protocol.MsgBox {
+	msg := protocol.NewMsgBox(pb.Meta.Qos, 0, protobase.MDOutbound, protocol.NewMsgEnvelope(pb.Topic, pb.Message))
+	msg.SetProperties(pb.Meta.Props)
+	msg.SetCompression(pb.Compression)
+	if !pb.ExpiresAt.IsZero() {
+		msg.SetExpiry(pb.Remaining(time.Now()))
+	}
+	return msg
+}
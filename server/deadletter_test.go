@@ -0,0 +1,150 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/mitghi/protox/client"
+	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/networking"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+// redeliveryConn counts redelivered packets.
+type redeliveryConn struct {
+	*testConn
+	redelivered int
+}
+
+func (rc *redeliveryConn) SendRedelivery(pb protobase.EDProtocol) error {
+	rc.redelivered++
+	return nil
+}
+
+func storeOut(s *Server, clid string, topic string) *protocol.Publish {
+	pb := protocol.NewRawPublish()
+	pb.Topic, pb.Message, pb.Meta.Qos, pb.Attempts = topic, []byte("job"), protobase.LQOS1, 1
+	s.Store.AddOutbound(clid, pb)
+	pb.Meta.MessageId = s.Store.GetIDStoreO(clid).GetNewID(pb.UUID())
+	return pb
+}
+
+func mustDeadLetter(t *testing.T, msg protobase.MsgInterface, topic string, reason string, attempts uint32) {
+	props := msg.Properties()
+	if msg.Envelope().Route() != "$dead/"+topic || string(msg.Envelope().Payload()) != "job" {
+		t.Fatal("inconsistent dead letter.", msg.Envelope().Route())
+	}
+	if v, _ := props.Get(protobase.PROPDeadTopic); string(v) != topic {
+		t.Fatal("expected original topic.", string(v))
+	}
+	if v, _ := props.Get(protobase.PROPDeadReason); string(v) != reason {
+		t.Fatal("inconsistent reason.", string(v), reason)
+	}
+	if v, _ := props.Get(protobase.PROPDeadAttempts); len(v) != 4 || binary.BigEndian.Uint32(v) != attempts {
+		t.Fatal("inconsistent attempts.", v, attempts)
+	}
+}
+
+func TestDeadLetterAttempts(t *testing.T) {
+	var (
+		s        *Server = NewServer()
+		a                = newTestConn(s, "a")
+		observer         = newTestConn(s, "observer")
+		rc               = &redeliveryConn{testConn: a}
+	)
+	s.Status = protobase.ServerRunning
+	s.SetMessageStore(messages.NewInitedMessageStore())
+	s.SetMaxAttempts(2)
+	subscribeTo(s, observer, "$dead/jobs/*", 1)
+	s.Store.AddClient("a")
+	pb := storeOut(s, "a", "jobs/build")
+	s.Redeliver(rc)
+	if rc.redelivered != 1 || pb.Attempts != 2 || len(observer.msgs) != 0 {
+		t.Fatal("expected message to be redelivered.", rc.redelivered, pb.Attempts, len(observer.msgs))
+	}
+	s.Redeliver(rc)
+	if rc.redelivered != 1 || len(s.Store.GetAllOut("a")) != 0 || s.Store.GetIDStoreO("a").IsOccupied(pb.Meta.MessageId) {
+		t.Fatal("expected message to be removed after maximum attempts.", rc.redelivered)
+	}
+	if len(observer.msgs) != 1 {
+		t.Fatal("expected message to be dead-lettered.", len(observer.msgs))
+	}
+	mustDeadLetter(t, observer.msgs[0], "jobs/build", DeadLetterAttempts, 2)
+}
+
+func TestDeadLetterExpired(t *testing.T) {
+	var (
+		s        *Server = NewServer()
+		observer         = newTestConn(s, "observer")
+	)
+	s.Status = protobase.ServerRunning
+	s.SetMessageStore(messages.NewInitedMessageStore())
+	subscribeTo(s, observer, "$dead/jobs/*", 1)
+	s.Store.AddClient("a")
+	storeOut(s, "a", "jobs/build").ExpiresAt = time.Now().Add(-time.Second)
+	if n := s.purgeExpired(time.Now()); n != 1 || len(observer.msgs) != 1 {
+		t.Fatal("expected expired message to be dead-lettered.", n, len(observer.msgs))
+	}
+	mustDeadLetter(t, observer.msgs[0], "jobs/build", DeadLetterExpired, 1)
+	// dead letters are discarded when disabled
+	s.SetDeadLetterTopic("")
+	storeOut(s, "a", "jobs/build").ExpiresAt = time.Now().Add(-time.Second)
+	if n := s.purgeExpired(time.Now()); n != 1 || len(observer.msgs) != 1 {
+		t.Fatal("expected expired message to be discarded.", n, len(observer.msgs))
+	}
+}
+
+func TestDeadLetterOversized(t *testing.T) {
+	var (
+		s        *Server                = NewServer()
+		conn     *networking.Connection = networking.NewConnection(nil)
+		observer                        = newTestConn(s, "observer")
+	)
+	s.Status = protobase.ServerRunning
+	s.SetMessageStore(messages.NewInitedMessageStore())
+	s.Store.AddClient("narrow")
+	conn.SetClient(client.NewClient("narrow", "", "narrow"))
+	conn.SetMessageStorage(s.Store)
+	cl := newConnection(STCLIENT, "narrow", nil, false, false)
+	cl.setInfo(nil, conn, conn.GetClient(), nil, nil)
+	s.State.set("narrow", cl)
+	subscribeTo(s, conn, "jobs/*", 1)
+	subscribeTo(s, observer, "$dead/jobs/*", 1)
+	// payloads over 65535 bytes cannot be encoded for narrow sessions
+	payload := make([]byte, 0x10000)
+	s.NotifyPublish(nil, protocol.NewMsgBox(1, 0, protobase.MDInbound, protocol.NewMsgEnvelope("jobs/build", payload)))
+	if len(s.Store.GetAllOut("narrow")) != 0 || len(s.Store.GetIDStoreO("narrow").Occupied()) != 0 {
+		t.Fatal("expected oversized message not to be stored.")
+	}
+	if len(observer.msgs) != 1 {
+		t.Fatal("expected oversized message to be dead-lettered.", len(observer.msgs))
+	}
+	if v, _ := observer.msgs[0].Properties().Get(protobase.PROPDeadReason); string(v) != DeadLetterRejected {
+		t.Fatal("inconsistent reason.", string(v))
+	}
+	s.Redeliver(conn)
+}
@@ -24,95 +24,257 @@ package server
 
 import (
 	"crypto/tls"
+	"errors"
+	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/mitghi/protox/protobase"
 )
 
+// listener is an accepting socket served by `ServeTCP`.
+type listener struct {
+	net.Listener
+	heartbeat int
+}
+
 // ServeTCP is the main listening loop. It is responsible for
-// accepting incoming TCP connections from clients.
+// accepting incoming TCP connections from clients. Listeners
+// added by `AddListener` are served alongside `address` and
+// share router and session state. Listeners inherited from a parent
+// process ( see `Restart` ) are served instead of opening new ones.
 func (s *Server) ServeTCP(address string) (err error) {
 	const fn = "ServeTCP"
 	s.State.mode = ProtoTCP
 	var (
-		server net.Listener
-		ticker *time.Ticker
+		primary   net.Listener
+		listeners []*listener
+		wg        sync.WaitGroup
+		ticker    *time.Ticker
 	)
+	// sessions handed over by a parent process
+	s.restore()
 	// TODO
 	// . set default address
-	server, err = s.serverInstance(address)
+	primary, err = s.serverInstance(address)
+	if err == nil {
+		listeners, err = s.openListeners()
+	}
 	if err != nil {
 		logger.Debug(fn, "- [Fatal] Cannot listen for incomming connections.")
+		if primary != nil {
+			primary.Close()
+		}
 		s.StatusChan <- protobase.ServerStopped
 		_ = s.SetStatus(protobase.ServerStopped)
 		return err
 	}
-	defer server.Close()
-	s.listener = &server
+	listeners = append([]*listener{{Listener: primary, heartbeat: s.heartbeat}}, listeners...)
+	s.Lock()
+	s.listeners = listeners
+	s.Unlock()
+	defer s.closeListeners()
 	s.StatusChan <- protobase.ServerRunning
 	_ = s.SetStatus(protobase.ServerRunning)
 	ticker = time.NewTicker(time.Millisecond * 500)
 	defer ticker.Stop()
 	defer s.SetStatus(protobase.ServerStopped)
+	go s.expirySweeper()
 	go func() {
 		for _ = range ticker.C {
-			if stat := s.GetStatus(); stat == protobase.ForceShutdown {
-				if err := (*s.listener).Close(); err != nil {
-					logger.FError(fn, "- [TCP Handler] cannot close the server.", err)
-				}
+			if s.isStopping() {
+				s.closeListeners()
 				break
 			}
 		}
 	}()
-ML:
+	for _, l := range listeners[1:] {
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+			s.accept(l)
+		}(l)
+	}
+	err = s.accept(listeners[0])
+	// stop other listeners with the primary one
+	s.closeListeners()
+	wg.Wait()
+	if s.GetStatus() == protobase.Restart {
+		s.drain()
+	}
+	s.disconnectAll()
+	// Wait for all corous to finish
+	s.corous.Wait()
+	// tell other side of chan because shit hit the fan!
+	s.critical <- struct{}{}
+	return err
+}
+
+// accept accepts connections on `l` until it is closed.
+func (s *Server) accept(l *listener) (err error) {
+	const fn = "accept"
 	for {
 		var (
 			conn net.Conn
 		)
-		conn, err = server.Accept()
+		conn, err = l.Accept()
 		if err != nil {
 			logger.FDebug(fn, "- [Server] returning from Tcp handler. error:", err)
-			break ML
+			return err
 		}
 		logger.FInfo(fn, "* [Genesis] Participation request accepted.")
 		s.corous.Add(1)
-		go s.handleIncomingConnection(conn)
+		go s.handleConnection(conn, l.heartbeat)
 	}
-	s.disconnectAll()
-	// Wait for all corous to finish
-	s.corous.Wait()
-	// tell other side of chan because shit hit the fan!
-	s.critical <- struct{}{}
-	return err
+}
+
+// openListeners opens listeners added by `AddListener`. It
+// returns an error when any of them fails.
+func (s *Server) openListeners() (listeners []*listener, err error) {
+	for _, opts := range s.extra {
+		opts := opts
+		l, _, err := s.listen(&opts, opts.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		heartbeat := opts.HeartBeat
+		if heartbeat == 0 {
+			heartbeat = s.heartbeat
+		}
+		listeners = append(listeners, &listener{Listener: l, heartbeat: heartbeat})
+	}
+	return listeners, nil
+}
+
+// closeListeners closes all listeners served by `ServeTCP`.
+func (s *Server) closeListeners() {
+	const fn = "closeListeners"
+	s.RLock()
+	defer s.RUnlock()
+	for _, l := range s.listeners {
+		if err := l.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.FError(fn, "- [TCP Handler] cannot close the listener.", err)
+		}
+	}
+}
+
+// Addrs returns addresses of listeners served by `ServeTCP`.
+func (s *Server) Addrs() (addrs []net.Addr) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
 }
 
 func (s *Server) serverInstance(address string) (listener net.Listener, err error) {
+	var mode byte
+	listener, mode, err = s.listen(s.opts, address)
+	if err == nil {
+		s.State.mode = mode
+	}
+	return listener, err
+}
+
+// frontendListener listens on `address` for frontend `typ` according
+// to its own configuration ( see `SetFrontend` ).
+func (s *Server) frontendListener(typ ServerType, address string) (listener net.Listener, err error) {
+	opts, ok := s.frontends[typ]
+	if !ok {
+		opts = ServerConfigs{Mode: ProtoTCP}
+	}
+	opts.Addr = address
+	if err = precheckOpts(&opts); err != nil {
+		return nil, err
+	}
+	listener, _, err = s.listen(&opts, address)
+	return listener, err
+}
+
+// closeOnStop closes `c` once the server is stopping. The returned
+// function ends watching, it is called when `c` is done otherwise.
+func (s *Server) closeOnStop(c io.Closer) (stop func()) {
+	const fn = "closeOnStop"
 	var (
-		opts       TLSOptions
-		tlsconfigs *tls.Config
+		done   chan struct{} = make(chan struct{})
+		once   sync.Once
+		ticker *time.Ticker = time.NewTicker(time.Millisecond * 500)
 	)
-	if (s.opts == nil) || (s.opts.Config == nil) {
-		listener, err = net.Listen("tcp", address)
-		if err == nil {
-			s.State.mode = ProtoTCP
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if !s.isStopping() {
+					continue
+				}
+				if err := c.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+					logger.FError(fn, "- [Server] cannot close the listener.", err)
+				}
+				return
+			}
 		}
-		return listener, err
+	}()
+	return func() {
+		once.Do(func() { close(done) })
 	}
-	opts, ok := s.opts.Config.(TLSOptions)
-	if !ok {
-		err = SRVMissingOptions
-		goto ERROR
+}
+
+// closerFunc adapts a function to `io.Closer`.
+type closerFunc func() error
+
+// Close calls `fn`.
+func (fn closerFunc) Close() error {
+	return fn()
+}
+
+// listen listens on `address` according to `opts` and returns the
+// listener and its transport mode.
+func (s *Server) listen(opts *ServerConfigs, address string) (listener net.Listener, mode byte, err error) {
+	if (opts == nil) || (opts.Config == nil) {
+		listener, err = s.listenTCP(opts, address)
+		return listener, ProtoTCP, err
 	}
-	tlsconfigs, err = s.generateTLSConfig(&opts)
-	if err != nil {
-		goto ERROR
+	switch config := opts.Config.(type) {
+	case TCPOptions:
+		listener, err = s.listenTCP(opts, address)
+		return listener, ProtoTCP, err
+	case UNIXSOptions:
+		listener, err = s.socket("unix", address, func() (net.Listener, error) {
+			return unixListener(address, &config)
+		})
+		return listener, ProtoUNIXSO, err
+	case TLSOptions:
+		tlsconfigs, err := generateTLSConfig(&config)
+		if err != nil {
+			return nil, ProtoTLS, err
+		}
+		// PROXY protocol headers precede the handshake
+		listener, err = s.listenTCP(opts, address)
+		if err != nil {
+			return nil, ProtoTLS, err
+		}
+		return tls.NewListener(listener, tlsconfigs), ProtoTLS, nil
+	default:
+		return nil, opts.Mode, SRVMissingOptions
 	}
-	listener, err = tls.Listen("tcp", address, tlsconfigs)
-	if err == nil {
-		s.State.mode = ProtoTLS
+}
+
+// listenTCP listens on tcp `address` and parses PROXY protocol
+// headers when enabled in `opts`.
+func (s *Server) listenTCP(opts *ServerConfigs, address string) (listener net.Listener, err error) {
+	listener, err = s.socket("tcp", address, func() (net.Listener, error) {
+		return net.Listen("tcp", address)
+	})
+	if err != nil || opts == nil || opts.Proxy == nil {
+		return listener, err
 	}
-	return listener, err
-ERROR:
-	return nil, err
+	return newProxyListener(listener, opts.Proxy)
 }
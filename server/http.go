@@ -0,0 +1,322 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+// Gateway request and response headers
+const (
+	HTTPHeaderQoS      = "X-Protox-QoS"
+	HTTPHeaderRetain   = "X-Protox-Retain"
+	HTTPHeaderClientId = "X-Protox-Client-Id"
+)
+
+// HTTPTopicsPath is the path prefix of gateway topic resources.
+const HTTPTopicsPath = "/topics/"
+
+// Gateway defaults
+var (
+	// DefaultSSEKeepAlive is the interval in which idle event
+	// streams receive a comment to keep proxies from timing out.
+	DefaultSSEKeepAlive time.Duration = time.Second * 15
+	// DefaultSSEBuffer is the number of messages buffered for an
+	// event stream, messages are dropped for slower streams.
+	DefaultSSEBuffer int = 64
+)
+
+// gatewaySeq sequences event stream subscriber identifiers.
+var gatewaySeq uint64
+
+// gatewayEvent is the data of a Server-Sent Event carrying a
+// routed message. Payloads which are not valid UTF-8 are base64
+// encoded.
+type gatewayEvent struct {
+	Topic   string `json:"topic"`
+	Payload string `json:"payload"`
+	Base64  bool   `json:"base64,omitempty"`
+	Retain  bool   `json:"retain,omitempty"`
+}
+
+// ServeHTTPGateway is the listening loop for the HTTP publish/subscribe
+// gateway. Topics are served under `HTTPTopicsPath`:
+//
+//	POST /topics/{topic}  publishes the request body
+//	GET  /topics/{filter} streams messages as Server-Sent Events
+//	                      when `text/event-stream` is accepted
+//	GET  /topics/{topic}  returns the retained message
+//
+// Requests authenticate with HTTP basic credentials and are subject
+// to the same permissions as native clients. The listener is
+// configured by `SetFrontend` with `ProtoHTTP` ( e.g. TLS ).
+func (s *Server) ServeHTTPGateway(address string) (err error) {
+	const fn = "ServeHTTPGateway"
+	var (
+		listener net.Listener
+		hs       *http.Server
+	)
+	listener, err = s.frontendListener(ProtoHTTP, address)
+	if err != nil {
+		logger.Debug(fn, "- [Fatal] Cannot listen for incomming HTTP requests.")
+		return err
+	}
+	hs = &http.Server{Handler: s.gatewayHandler(), ReadHeaderTimeout: time.Second * 10}
+	defer s.closeOnStop(hs)()
+	err = hs.Serve(listener)
+	logger.FDebug(fn, "- [Server] returning from HTTP handler. error:", err)
+	return err
+}
+
+// gatewayHandler returns the HTTP handler of the gateway.
+func (s *Server) gatewayHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(HTTPTopicsPath, s.handleTopic)
+	return mux
+}
+
+// handleTopic authenticates and dispatches topic requests.
+func (s *Server) handleTopic(w http.ResponseWriter, r *http.Request) {
+	topic := strings.TrimPrefix(r.URL.Path, HTTPTopicsPath)
+	if topic == "" {
+		http.Error(w, "missing topic.", http.StatusBadRequest)
+		return
+	}
+	if s.GetStatus() != protobase.ServerRunning {
+		http.Error(w, "server is not running.", http.StatusServiceUnavailable)
+		return
+	}
+	clid, ok := s.gatewayAuth(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		s.gatewayPublish(w, r, clid, topic)
+	case http.MethodGet:
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			s.gatewaySubscribe(w, r, clid, topic)
+		} else {
+			s.gatewayRetained(w, r, clid, topic)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+// gatewayAuth authenticates the request using HTTP basic credentials
+// and the client id header, which defaults to the username. It returns
+// the username permissions are checked against. It writes the error
+// response and returns false when the request is rejected.
+func (s *Server) gatewayAuth(w http.ResponseWriter, r *http.Request) (string, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="protox"`)
+		http.Error(w, "authentication required.", http.StatusUnauthorized)
+		return "", false
+	}
+	clientId := r.Header.Get(HTTPHeaderClientId)
+	if clientId == "" {
+		clientId = username
+	}
+	if err := s.authenticate(username, password, clientId); err != nil {
+		if err == SRVBanned {
+			http.Error(w, "banned.", http.StatusForbidden)
+		} else {
+			http.Error(w, "invalid credentials.", http.StatusUnauthorized)
+		}
+		return "", false
+	}
+	return username, true
+}
+
+// gatewayPublish publishes the request body on `topic`. QoS and
+// retain flag are taken from headers and the content type is kept
+// as a message property.
+func (s *Server) gatewayPublish(w http.ResponseWriter, r *http.Request, clid string, topic string) {
+	const fn = "gatewayPublish"
+	var (
+		qos     uint64
+		retain  bool
+		payload []byte
+		err     error
+	)
+	if strings.IndexByte(topic, messages.TWLDCD) >= 0 {
+		http.Error(w, "wildcards are not allowed in topic names.", http.StatusBadRequest)
+		return
+	}
+	if !s.canPublish(clid, topic) {
+		logger.FDebugf(fn, "- [Permission] client(%s) has no permission to publish on topic(%s).", clid, topic)
+		http.Error(w, "forbidden.", http.StatusForbidden)
+		return
+	}
+	if h := r.Header.Get(HTTPHeaderQoS); h != "" {
+		if qos, err = strconv.ParseUint(h, 10, 8); err != nil || byte(qos) > s.maxQoS {
+			http.Error(w, "invalid QoS.", http.StatusBadRequest)
+			return
+		}
+	}
+	if h := r.Header.Get(HTTPHeaderRetain); h != "" {
+		if retain, err = strconv.ParseBool(h); err != nil {
+			http.Error(w, "invalid retain flag.", http.StatusBadRequest)
+			return
+		}
+	}
+	if s.maxPacketSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(s.maxPacketSize))
+	}
+	if payload, err = io.ReadAll(r.Body); err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			http.Error(w, "payload too large.", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "unable to read payload.", http.StatusBadRequest)
+		return
+	}
+	msg := protocol.NewMsgBox(byte(qos), 0, protobase.MDInbound, protocol.NewMsgEnvelope(topic, payload))
+	msg.SetRetain(retain, 0)
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		msg.SetProperties(protobase.Properties{protobase.PROPContentType: []byte(ct)})
+	}
+	logger.Infof("+ [Gateway] Client(%s) publishing on Topic(%s) with QoS(%d).", clid, topic, qos)
+	s.NotifyPublish(nil, msg)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// gatewayRetained writes the retained message of `topic`.
+func (s *Server) gatewayRetained(w http.ResponseWriter, r *http.Request, clid string, topic string) {
+	if !s.canSubscribe(clid, topic) {
+		http.Error(w, "forbidden.", http.StatusForbidden)
+		return
+	}
+	for _, p := range s.Router.FindRetained(topic) {
+		rp, ok := p.(*protocol.Publish)
+		if !ok || rp.Topic != topic {
+			continue
+		}
+		ct := "application/octet-stream"
+		if v, ok := rp.Meta.Props.Get(protobase.PROPContentType); ok {
+			ct = string(v)
+		}
+		w.Header().Set("Content-Type", ct)
+		w.Header().Set(HTTPHeaderQoS, strconv.Itoa(int(rp.Meta.Qos)))
+		w.Write(rp.Message)
+		return
+	}
+	http.Error(w, "no retained message.", http.StatusNotFound)
+}
+
+// gatewaySubscribe streams messages routed to `filter` as Server-Sent
+// Events, starting with retained messages. It returns when the client
+// goes away or the server stops.
+func (s *Server) gatewaySubscribe(w http.ResponseWriter, r *http.Request, clid string, filter string) {
+	const fn = "gatewaySubscribe"
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+	if !s.canSubscribe(clid, filter) {
+		logger.FDebugf(fn, "- [Permission] client(%s) has no permission to subscribe to topic(%s).", clid, filter)
+		http.Error(w, "forbidden.", http.StatusForbidden)
+		return
+	}
+	var (
+		id     string                      = fmt.Sprintf("$http/%s/%d", clid, atomic.AddUint64(&gatewaySeq, 1))
+		msgs   chan protobase.MsgInterface = make(chan protobase.MsgInterface, DefaultSSEBuffer)
+		ticker *time.Ticker                = time.NewTicker(DefaultSSEKeepAlive)
+	)
+	defer ticker.Stop()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	s.attachSink(id, filter, protobase.LQOS0, func(msg protobase.MsgInterface) {
+		select {
+		case msgs <- msg:
+		default:
+			logger.FDebugf(fn, "- [Gateway] stream(%s) is full, dropping message.", id)
+		}
+	})
+	defer s.detachSink(id, filter)
+	logger.Infof("+ [Gateway] Client(%s) streaming topic(%s).", clid, filter)
+	for _, p := range s.Router.FindRetained(filter) {
+		if rp, ok := p.(*protocol.Publish); ok {
+			if writeEvent(w, rp.Topic, rp.Message, true) != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-msgs:
+			if writeEvent(w, msg.Envelope().Route(), msg.Envelope().Payload(), false) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if s.isStopping() {
+				return
+			}
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes a message as a Server-Sent Event.
+func writeEvent(w io.Writer, topic string, payload []byte, retain bool) error {
+	ev := gatewayEvent{Topic: topic, Retain: retain}
+	if utf8.Valid(payload) {
+		ev.Payload = string(payload)
+	} else {
+		ev.Payload, ev.Base64 = base64.StdEncoding.EncodeToString(payload), true
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+	return err
+}
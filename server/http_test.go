@@ -0,0 +1,109 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitghi/protox/auth"
+	"github.com/mitghi/protox/protobase"
+)
+
+func TestHTTPGateway(t *testing.T) {
+	var (
+		s     *Server = NewServer()
+		authz         = auth.NewAuthenticator()
+		srv           = httptest.NewServer(s.gatewayHandler())
+	)
+	defer srv.Close()
+	creds, _ := authz.MakeCreds("web", "secret", "web")
+	authz.Register(creds)
+	s.SetAuthenticator(authz)
+	s.SetPermissionDelegate(func(_ protobase.AuthInterface, args ...string) bool {
+		return args[2] != "private"
+	})
+	s.Status = protobase.ServerRunning
+	request := func(method string, topic string, body string, header map[string]string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+HTTPTopicsPath+topic, strings.NewReader(body))
+		req.SetBasicAuth("web", "secret")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("err!=nil", err)
+		}
+		return resp
+	}
+	// authentication
+	resp, _ := http.Post(srv.URL+HTTPTopicsPath+"a/b", "text/plain", strings.NewReader("x"))
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("expected unauthorized request.", resp.Status)
+	}
+	if resp = request(http.MethodPost, "private", "x", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatal("expected forbidden topic.", resp.Status)
+	}
+	if resp = request(http.MethodPost, "a/b", "x", map[string]string{HTTPHeaderQoS: "3"}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatal("expected invalid QoS.", resp.Status)
+	}
+	// retained publish
+	if resp = request(http.MethodPost, "a/b", "21.5", map[string]string{HTTPHeaderRetain: "true", "Content-Type": "text/plain"}); resp.StatusCode != http.StatusNoContent {
+		t.Fatal("inconsistent publish status.", resp.Status)
+	}
+	resp = request(http.MethodGet, "a/b", "", nil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "21.5" || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Fatal("inconsistent retained message.", resp.Status, string(body))
+	}
+	if resp = request(http.MethodGet, "a/c", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatal("expected missing retained message.", resp.Status)
+	}
+	// event stream
+	resp = request(http.MethodGet, "a/*", "", map[string]string{"Accept": "text/event-stream"})
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	next := func() string {
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal("err!=nil", err)
+			}
+			if strings.HasPrefix(line, "data: ") {
+				return strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}
+	if ev := next(); ev != `{"topic":"a/b","payload":"21.5","retain":true}` {
+		t.Fatal("inconsistent retained event.", ev)
+	}
+	request(http.MethodPost, "a/c", "\xff", nil)
+	if ev := next(); ev != `{"topic":"a/c","payload":"/w==","base64":true}` {
+		t.Fatal("inconsistent published event.", ev)
+	}
+}
@@ -0,0 +1,156 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mitghi/protox/networking"
+	"github.com/mitghi/protox/protobase"
+)
+
+// listenerConn records the heartbeat of accepted connections.
+type listenerConn struct {
+	*networking.Connection
+	s          *Server
+	conn       net.Conn
+	heartbeats chan<- int
+}
+
+func (lc *listenerConn) SetHeartBeat(heartbeat int) {
+	lc.heartbeats <- heartbeat
+}
+
+func (lc *listenerConn) Handle() {
+	lc.conn.Close()
+	lc.s.NotifyReject(lc)
+}
+
+func TestServeListeners(t *testing.T) {
+	var (
+		s          *Server  = NewServer()
+		path       string   = filepath.Join(t.TempDir(), "protox.sock")
+		heartbeats chan int = make(chan int, 2)
+	)
+	s.SetHeartBeat(30)
+	s.SetConnectionHandler(func(conn net.Conn) protobase.ProtoConnection {
+		return &listenerConn{networking.NewConnection(conn), s, conn, heartbeats}
+	})
+	if err := s.AddListener(ServerConfigs{Addr: "127.0.0.1:0", Mode: ProtoTCP, Config: TCPOptions{}}); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if err := s.AddListener(ServerConfigs{Addr: path, Mode: ProtoUNIXSO, Config: UNIXSOptions{}, HeartBeat: 5}); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	go s.ServeTCP("127.0.0.1:0")
+	if stat := <-s.GetStatusChan(); stat != protobase.ServerRunning {
+		t.Fatal("server is not running.", stat)
+	}
+	addrs := s.Addrs()
+	if len(addrs) != 3 {
+		t.Fatal("inconsistent number of listeners.", addrs)
+	}
+	for i, expected := range []int{30, 30, 5} {
+		conn, err := net.Dial(addrs[i].Network(), addrs[i].String())
+		if err != nil {
+			t.Fatal("err!=nil", err)
+		}
+		conn.Close()
+		select {
+		case heartbeat := <-heartbeats:
+			if heartbeat != expected {
+				t.Fatal("inconsistent listener heartbeat.", addrs[i], heartbeat)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("connection is not accepted.", addrs[i])
+		}
+	}
+	ch, err := s.Shutdown()
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second * 5):
+		t.Fatal("server did not stop.")
+	}
+	for _, addr := range addrs {
+		if conn, err := net.Dial(addr.Network(), addr.String()); err == nil {
+			conn.Close()
+			t.Fatal("listener is still accepting connections.", addr)
+		}
+	}
+}
+
+func TestFrontendListener(t *testing.T) {
+	var (
+		path string = filepath.Join(t.TempDir(), "mqtt.sock")
+	)
+	s, err := NewServerWithConfigs(ServerConfigs{Addr: filepath.Join(t.TempDir(), "protox.sock"), Mode: ProtoUNIXSO, Config: UNIXSOptions{}})
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	// the primary configuration does not apply to frontends
+	l, err := s.frontendListener(ProtoWS, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	defer l.Close()
+	if l.Addr().Network() != "tcp" {
+		t.Fatal("inconsistent frontend listener, expected plain tcp.", l.Addr())
+	}
+	s.SetFrontend(ProtoMQTT, ServerConfigs{Mode: ProtoUNIXSO, Config: UNIXSOptions{}})
+	ml, err := s.frontendListener(ProtoMQTT, path)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	defer ml.Close()
+	if ml.Addr().Network() != "unix" {
+		t.Fatal("inconsistent frontend listener, expected unix socket.", ml.Addr())
+	}
+	if s.State.mode != 0 {
+		t.Fatal("frontends must not change the server mode.", s.State.mode)
+	}
+}
+
+func TestCloseOnStop(t *testing.T) {
+	var (
+		s      *Server       = NewServer()
+		closed chan struct{} = make(chan struct{})
+	)
+	s.Status = protobase.ServerRunning
+	stop := s.closeOnStop(closerFunc(func() error {
+		close(closed)
+		return nil
+	}))
+	defer stop()
+	s.SetStatus(protobase.ServerStopped)
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 2):
+		t.Fatal("expected closer to be called once the server stops.")
+	}
+}
@@ -0,0 +1,75 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"net"
+
+	"github.com/mitghi/protox/protocol"
+	"github.com/mitghi/protox/protocol/adaptor"
+)
+
+// ServeMQTT is the listening loop for MQTT 3.1.1 clients. Connections
+// are translated by `adaptor.MQTTConn` and share router, auth and
+// retain storage with protox clients. The listener is configured by
+// `SetFrontend` with `ProtoMQTT`.
+func (s *Server) ServeMQTT(address string) (err error) {
+	const fn = "ServeMQTT"
+	var (
+		listener net.Listener
+		sessions *adaptor.MQTTSessions = adaptor.NewMQTTSessions()
+	)
+	listener, err = s.frontendListener(ProtoMQTT, address)
+	if err != nil {
+		logger.Debug(fn, "- [Fatal] Cannot listen for incomming MQTT connections.")
+		return err
+	}
+	defer listener.Close()
+	defer s.closeOnStop(listener)()
+	for {
+		var (
+			conn net.Conn
+		)
+		conn, err = listener.Accept()
+		if err != nil {
+			logger.FDebug(fn, "- [Server] returning from MQTT handler. error:", err)
+			break
+		}
+		logger.FInfo(fn, "* [Genesis] MQTT participation request accepted.")
+		s.corous.Add(1)
+		mc := adaptor.NewMQTTConn(conn, sessions)
+		mc.SetPermissionDelegate(s.canSubscribeMQTT)
+		go s.handleIncomingConnection(mc)
+	}
+	return err
+}
+
+// canSubscribeMQTT returns whether MQTT client `clid` is allowed to
+// subscribe to the translated `topic`, shared subscriptions are
+// checked by their filter as for protox clients.
+func (s *Server) canSubscribeMQTT(clid string, topic string) bool {
+	if _, filter, ok := protocol.ParseShare(topic); ok {
+		topic = filter
+	}
+	return s.canSubscribe(clid, topic)
+}
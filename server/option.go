@@ -3,11 +3,19 @@ package server
 import (
 	"crypto/tls"
 	"net"
+	"os"
+	"time"
 )
 
 // UNIXSOPtions contains necessary information required by unix socket server.
 type UNIXSOptions struct {
-	// TODO
+	// Perm is the file mode of the socket, zero keeps
+	// the mode given by umask.
+	Perm os.FileMode
+	// Owner and Group are names or numeric ids of the
+	// socket owner, empty keeps the process owner.
+	Owner string
+	Group string
 }
 
 // TCPOptions contains necessarry information required by TCP server.
@@ -26,6 +34,19 @@ type TLSOptions struct {
 	ShouldVerify     bool
 }
 
+// ProxyOptions enables PROXY protocol ( v1 and v2 ) headers on
+// TCP and TLS listeners. Connections from trusted upstreams must
+// start with a header, their remote address is the one it carries.
+// Other connections are served with their own address.
+type ProxyOptions struct {
+	// Trusted contains addresses or CIDR networks of upstreams
+	// allowed to send headers.
+	Trusted []string
+	// Timeout is the deadline for reading the header, zero uses
+	// `DefaultProxyTimeout`.
+	Timeout time.Duration
+}
+
 // ServerConfigs is a struct for configuring the server.
 type ServerConfigs struct {
 	Config interface{}
@@ -35,6 +56,11 @@ type ServerConfigs struct {
 	// TRate is the cycle interval in milliseconds
 	// for performing status check.
 	TRate int
+	// HeartBeat is the heartbeat of connections accepted
+	// by an additional listener, zero uses the server one.
+	HeartBeat int
+	// Proxy enables PROXY protocol headers, nil disables them.
+	Proxy *ProxyOptions
 	// TODO
 	// . add callbacks
 }
@@ -42,10 +68,24 @@ type ServerConfigs struct {
 func precheckOpts(opts *ServerConfigs) error {
 	// TODO
 	// . precheck server options before proceeding
+	if opts.Mode == ProtoUNIXSO && opts.Config != nil {
+		if _, ok := opts.Config.(UNIXSOptions); !ok {
+			return SRVMissingOptions
+		}
+		if opts.Addr == "" {
+			return SRVInvalidAddr
+		}
+		return nil
+	}
 	_, _, err := net.SplitHostPort(opts.Addr)
 	if err != nil {
 		return SRVInvalidAddr
 	}
+	if opts.Proxy != nil {
+		if _, err = parseTrusted(opts.Proxy.Trusted); err != nil {
+			return err
+		}
+	}
 	if opts.Config != nil {
 		switch opts.Mode {
 		case ProtoTCP:
@@ -61,9 +101,6 @@ func precheckOpts(opts *ServerConfigs) error {
 		case ProtoSSL:
 			// TODO
 			return SRVInvalidMode
-		case ProtoUNIXSO:
-			// TODO
-			return SRVInvalidMode
 		default:
 			return SRVInvalidMode
 		}
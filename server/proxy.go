@@ -0,0 +1,262 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROXY protocol signatures and limits.
+var (
+	proxyV1Sig []byte = []byte("PROXY ")
+	proxyV2Sig []byte = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const proxyV1MaxLen int = 107
+
+// proxyListener is a `net.Listener` whose connections parse PROXY
+// protocol headers of trusted upstreams.
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// proxyConn is a `net.Conn` reporting the client address carried by
+// its PROXY protocol header. The header is read on first use, so the
+// accepting loop is never blocked by slow upstreams.
+type proxyConn struct {
+	net.Conn
+	once    sync.Once
+	reader  *bufio.Reader
+	remote  net.Addr
+	trusted bool
+	timeout time.Duration
+	err     error
+}
+
+// newProxyListener returns a listener parsing PROXY protocol headers
+// of connections accepted by `l`. It closes `l` on invalid options.
+func newProxyListener(l net.Listener, opts *ProxyOptions) (net.Listener, error) {
+	trusted, err := parseTrusted(opts.Trusted)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultProxyTimeout
+	}
+	return &proxyListener{Listener: l, trusted: trusted, timeout: timeout}, nil
+}
+
+// Accept waits for and returns the next connection.
+func (pl *proxyListener) Accept() (net.Conn, error) {
+	conn, err := pl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, trusted: pl.isTrusted(conn.RemoteAddr()), timeout: pl.timeout}, nil
+}
+
+// isTrusted returns whether `addr` belongs to a trusted upstream.
+func (pl *proxyListener) isTrusted(addr net.Addr) bool {
+	ta, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range pl.trusted {
+		if n.Contains(ta.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads from the connection past the PROXY protocol header.
+func (pc *proxyConn) Read(b []byte) (int, error) {
+	pc.init()
+	if pc.err != nil {
+		return 0, pc.err
+	}
+	if pc.reader != nil {
+		return pc.reader.Read(b)
+	}
+	return pc.Conn.Read(b)
+}
+
+// RemoteAddr returns the client address. It waits for the header
+// of trusted upstreams.
+func (pc *proxyConn) RemoteAddr() net.Addr {
+	pc.init()
+	return pc.remote
+}
+
+// init reads the header of trusted upstreams.
+func (pc *proxyConn) init() {
+	const fn = "init"
+	pc.once.Do(func() {
+		pc.remote = pc.Conn.RemoteAddr()
+		if !pc.trusted {
+			return
+		}
+		pc.reader = bufio.NewReader(pc.Conn)
+		pc.Conn.SetReadDeadline(time.Now().Add(pc.timeout))
+		addr, err := readProxyHeader(pc.reader)
+		pc.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			logger.FDebugf(fn, "- [Proxy] invalid header from upstream (%s). error: %v", pc.remote, err)
+			pc.err = err
+			return
+		}
+		if addr != nil {
+			logger.FDebugf(fn, "* [Proxy] upstream (%s) forwards client (%s).", pc.remote, addr)
+			pc.remote = addr
+		}
+	})
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from `r`
+// and returns the source address it carries. Address is nil for
+// health checks of the upstream ( v1 UNKNOWN or v2 LOCAL ) and
+// non-IP sources.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(len(proxyV1Sig))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prefix, proxyV1Sig) {
+		return readProxyV1(r)
+	}
+	prefix, err = r.Peek(len(proxyV2Sig))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prefix, proxyV2Sig) {
+		return readProxyV2(r)
+	}
+	return nil, SRVProxyHeader
+}
+
+// readProxyV1 reads a human readable header, e.g.
+// "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, SRVProxyHeader
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, SRVProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, SRVProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads a binary header. Type-length-value extensions
+// are skipped.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	var (
+		version byte   = header[12] >> 4
+		command byte   = header[12] & 0x0F
+		family  byte   = header[13] >> 4
+		payload []byte = make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	)
+	if version != 0x2 || command > 0x1 {
+		return nil, SRVProxyHeader
+	}
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if command == 0x0 {
+		// LOCAL
+		return nil, nil
+	}
+	switch family {
+	case 0x1:
+		if len(payload) < 12 {
+			return nil, SRVProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x2:
+		if len(payload) < 36 {
+			return nil, SRVProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	return nil, nil
+}
+
+// parseTrusted parses addresses and CIDR networks of trusted
+// upstreams.
+func parseTrusted(trusted []string) (nets []*net.IPNet, err error) {
+	for _, t := range trusted {
+		if !strings.Contains(t, "/") {
+			ip := net.ParseIP(t)
+			if ip == nil {
+				return nil, SRVInvalidProxy
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, SRVInvalidProxy
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,117 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// proxyV2Header crafts a v2 header with `command`, `family` and
+// `payload`.
+func proxyV2Header(command byte, family byte, payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(proxyV2Sig)
+	buf.WriteByte(0x20 | command)
+	buf.WriteByte(family<<4 | 0x1)
+	buf.Write([]byte{byte(len(payload) >> 8), byte(len(payload))})
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{10, 0, 0, 7, 10, 0, 0, 1, 0xDB, 0xA0, 0x07, 0x5B}
+	for header, expected := range map[string]string{
+		"PROXY TCP4 10.0.0.7 10.0.0.1 56224 1883\r\n":                 "10.0.0.7:56224",
+		"PROXY TCP6 2001:db8::7 2001:db8::1 443 1883\r\n":             "[2001:db8::7]:443",
+		"PROXY UNKNOWN\r\n":                                           "",
+		string(proxyV2Header(0x1, 0x1, append(v4, 0x03, 0x00, 0x00))): "10.0.0.7:56224",
+		string(proxyV2Header(0x0, 0x0, nil)):                          "",
+	} {
+		r := bufio.NewReader(bytes.NewReader(append([]byte(header), "payload"...)))
+		addr, err := readProxyHeader(r)
+		if err != nil {
+			t.Fatalf("err!=nil for header %q. error: %v", header, err)
+		}
+		if (addr == nil && expected != "") || (addr != nil && addr.String() != expected) {
+			t.Fatalf("inconsistent address for header %q, expected (%s), got (%v).", header, expected, addr)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+			t.Fatalf("inconsistent data after header %q, got %q.", header, rest)
+		}
+	}
+	for _, header := range []string{
+		"PROXY TCP4 10.0.0.7 10.0.0.1 56224\r\n",
+		"PROXY TCP4 2001:db8::7 10.0.0.1 56224 1883\r\n",
+		"PROXY TCP4 10.0.0.7 10.0.0.1 56224 1883\n",
+		string(proxyV2Header(0x1, 0x1, v4[:8])),
+		"\x10\x20protox connect",
+	} {
+		if _, err := readProxyHeader(bufio.NewReader(bytes.NewReader([]byte(header)))); err == nil {
+			t.Fatalf("err==nil, expected malformed header %q.", header)
+		}
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	if _, err := parseTrusted([]string{"10.0.0.0/33"}); err != SRVInvalidProxy {
+		t.Fatal("expected SRVInvalidProxy, got", err)
+	}
+	for trusted, expected := range map[string]string{
+		"127.0.0.1":  "10.0.0.7:56224",
+		"10.0.0.0/8": "",
+	} {
+		l, err := NewServer().listenTCP(&ServerConfigs{Proxy: &ProxyOptions{Trusted: []string{trusted}}}, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("err!=nil", err)
+		}
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal("err!=nil", err)
+		}
+		header := "PROXY TCP4 10.0.0.7 10.0.0.1 56224 1883\r\n"
+		go client.Write([]byte(header + "payload"))
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal("err!=nil", err)
+		}
+		data := "payload"
+		if expected == "" {
+			// untrusted upstreams are served as is
+			expected, data = client.LocalAddr().String(), header+data
+		}
+		if addr := conn.RemoteAddr().String(); addr != expected {
+			t.Fatalf("inconsistent remote address, expected (%s), got (%s).", expected, addr)
+		}
+		buf := make([]byte, len(data))
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != data {
+			t.Fatalf("inconsistent data, expected %q, got %q. error: %v", data, buf, err)
+		}
+		conn.Close()
+		client.Close()
+		l.Close()
+	}
+}
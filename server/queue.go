@@ -0,0 +1,540 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/mitghi/protox/containers"
+	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+// queue is a durable named queue. Messages wait in the queue until it
+// is drained and each one is handed to exactly one consumer. Delivered
+// messages are kept until the consumer acknowledges them.
+type queue struct {
+	mq        *messages.MessageQueue
+	consumers []string             // client ids draining the queue
+	next      int                  // round robin position in consumers
+	seq       int                  // ack id of the last inserted message
+	inflight  map[uint16]*delivery // unacknowledged messages by message id
+}
+
+// delivery is a message handed to a consumer awaiting its
+// acknowledgement.
+type delivery struct {
+	consumer string
+	item     *queued
+}
+
+// outgoing is a delivery collected under the queues lock, it is sent
+// once the lock is released.
+type outgoing struct {
+	msgid uint16
+	prc   protobase.ProtoConnection
+	d     *delivery
+}
+
+// queued is a message waiting in a queue.
+type queued struct {
+	aid        int
+	returnPath string
+	mark       []byte
+	message    []byte
+}
+
+// queues holds named queues by their address.
+type queues struct {
+	sync.Mutex
+
+	m    map[string]*queue
+	ids  map[uint16]string // addresses of unacknowledged messages by message id
+	last uint16            // message id of the last delivery
+}
+
+func newQueues() *queues {
+	return &queues{m: make(map[string]*queue), ids: make(map[uint16]string)}
+}
+
+func newQueue() *queue {
+	return &queue{mq: messages.NewMessageQueue(), inflight: make(map[uint16]*delivery)}
+}
+
+// insert appends a message to the queue.
+func (qu *queue) insert(returnPath string, mark []byte, message []byte) {
+	qu.seq++
+	qu.mq.InsertOut(qu.seq, &queued{aid: qu.seq, returnPath: returnPath, mark: mark, message: message})
+}
+
+// items returns the messages of the queue which are not acknowledged,
+// delivered ones first.
+func (qu *queue) items() []*queued {
+	items := qu.delivered("")
+	qu.mq.RLock()
+	defer qu.mq.RUnlock()
+	for _, item := range *qu.mq.Q {
+		items = append(items, item.(*queued))
+	}
+	return items
+}
+
+// delivered returns unacknowledged messages delivered to client `clid`
+// in their order, an empty `clid` returns those of all consumers.
+func (qu *queue) delivered(clid string) (items []*queued) {
+	for _, d := range qu.inflight {
+		if clid == "" || d.consumer == clid {
+			items = append(items, d.item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].aid < items[j].aid })
+	return items
+}
+
+// prepend puts `items` back in front of the queue.
+func (qu *queue) prepend(items ...*queued) {
+	qu.mq.Lock()
+	defer qu.mq.Unlock()
+	q := make(containers.Queue, 0, len(items)+qu.mq.Q.Size())
+	for _, item := range items {
+		q = append(q, item)
+	}
+	*qu.mq.Q = append(q, *qu.mq.Q...)
+}
+
+// newId returns an unused message id for a delivery from the queue at
+// `address`, or 0 when all are in use.
+func (qs *queues) newId(address string) uint16 {
+	for i := 0; i < 0xFFFF; i++ {
+		if qs.last++; qs.last == 0 {
+			qs.last = 1
+		}
+		if _, ok := qs.ids[qs.last]; !ok {
+			qs.ids[qs.last] = address
+			return qs.last
+		}
+	}
+	return 0
+}
+
+// requeue puts messages delivered to client `clid` and not yet
+// acknowledged back in front of their queues. It returns the addresses
+// of queues with requeued messages.
+func (qs *queues) requeue(clid string) (addresses []string) {
+	qs.Lock()
+	defer qs.Unlock()
+	for address, qu := range qs.m {
+		items := qu.delivered(clid)
+		if len(items) == 0 {
+			continue
+		}
+		for msgid, d := range qu.inflight {
+			if d.consumer == clid {
+				delete(qu.inflight, msgid)
+				delete(qs.ids, msgid)
+			}
+		}
+		qu.prepend(items...)
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// consuming returns the addresses of queues client `clid` consumes.
+func (qs *queues) consuming(clid string) (addresses []string) {
+	qs.Lock()
+	defer qs.Unlock()
+	for address, qu := range qs.m {
+		for _, cid := range qu.consumers {
+			if cid == clid {
+				addresses = append(addresses, address)
+				break
+			}
+		}
+	}
+	return addresses
+}
+
+// detach removes client `clid` from consumers of all queues.
+func (qs *queues) detach(clid string) {
+	qs.Lock()
+	defer qs.Unlock()
+	for _, qu := range qs.m {
+		for i, cid := range qu.consumers {
+			if cid == clid {
+				qu.consumers = append(qu.consumers[:i], qu.consumers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// NotifyQueue executes the queue command in `pdu` on behalf of `prc`
+// and acknowledges it with `protocol.QAcOK` or `protocol.QAcERR`.
+// `protocol.QAInitialize` creates a queue, `protocol.QADestroy` removes
+// it with its pending messages, `protocol.QADrain` makes the client a
+// consumer, `protocol.QAScatter` fans a request out to subscribers
+// ( see `scatter` ) and any other action enqueues the message. Messages
+// with a return path are requests ( see `request` ).
+func (s *Server) NotifyQueue(prc protobase.ProtoConnection, pdu protobase.EDProtocol) {
+	const fn = "NotifyQueue"
+	q, ok := pdu.(*protocol.Queue)
+	if !ok {
+		logger.FWarn(fn, "- [Queue] received a non queue packet.")
+		return
+	}
+	var (
+		clid       string = prc.GetClient().GetIdentifier()
+		responders int
+		err        error
+	)
+	switch q.Action {
+	case protocol.QAInitialize:
+		err = s.createQueue(clid, q.Address)
+	case protocol.QADestroy:
+		err = s.destroyQueue(clid, q.Address)
+	case protocol.QADrain:
+		err = s.drainQueue(clid, q.Address)
+	case protocol.QAScatter:
+		responders, err = s.scatter(clid, q)
+	case protocol.QATimeout:
+		// timeouts are only sent by the server
+		err = SRVInvalidQueue
+	default:
+		if q.ReturnPath != "" {
+			err = s.request(clid, q)
+		} else if s.isReplyPath(q.Address) {
+			err = s.reply(clid, q)
+		} else {
+			err = s.enqueue(clid, q)
+		}
+	}
+	if err != nil {
+		logger.FDebugf(fn, "- [Queue] command of client(%s) on queue(%s) failed. error: %s", clid, q.Address, err)
+	}
+	s.ackQueue(prc, q.Meta.MessageId, responders, err)
+	if err == nil {
+		s.dispatchQueue(q.Address)
+	}
+}
+
+// NotifyQueueAck releases the queue message which `prc` acknowledged in
+// `pdu` with `protocol.QAcOK`. Messages acknowledged with any other code
+// are put back in their queue and handed to the next consumer.
+func (s *Server) NotifyQueueAck(prc protobase.ProtoConnection, pdu protobase.EDProtocol) {
+	const fn = "NotifyQueueAck"
+	qa, ok := pdu.(*protocol.QAck)
+	if !ok {
+		logger.FWarn(fn, "- [Queue] received a non queue acknowledgement packet.")
+		return
+	}
+	var (
+		clid    string = prc.GetClient().GetIdentifier()
+		msgid   uint16 = qa.Meta.MessageId
+		address string
+		d       *delivery
+	)
+	s.queues.Lock()
+	if address, ok = s.queues.ids[msgid]; ok {
+		if qu := s.queues.m[address]; qu != nil {
+			if d = qu.inflight[msgid]; d != nil && d.consumer == clid {
+				delete(qu.inflight, msgid)
+				delete(s.queues.ids, msgid)
+				if qa.Code != protocol.QAcOK {
+					qu.prepend(d.item)
+				}
+			}
+		}
+	}
+	s.queues.Unlock()
+	if d == nil || d.consumer != clid {
+		logger.FDebugf(fn, "- [Queue] client(%s) acknowledged unknown MessageId(%d).", clid, msgid)
+		return
+	}
+	if qa.Code != protocol.QAcOK {
+		logger.FDebugf(fn, "* [Queue] client(%s) rejected message of queue(%s).", clid, address)
+		s.dispatchQueue(address)
+	}
+}
+
+// releaseQueues puts messages delivered to client `clid` and not yet
+// acknowledged back in their queues and hands them to other consumers.
+func (s *Server) releaseQueues(clid string) {
+	for _, address := range s.queues.requeue(clid) {
+		s.dispatchQueue(address)
+	}
+}
+
+// resumeQueues hands waiting messages of queues client `clid` consumes
+// over to their consumers.
+func (s *Server) resumeQueues(clid string) {
+	for _, address := range s.queues.consuming(clid) {
+		s.dispatchQueue(address)
+	}
+}
+
+// ackQueue acknowledges the queue command `msgid` of `prc`, scattered
+// requests report the number of their `responders`.
+func (s *Server) ackQueue(prc protobase.ProtoConnection, msgid uint16, responders int, err error) {
+	const fn = "ackQueue"
+	qa := protocol.NewRawQAck()
+	switch err {
+	case nil:
+		qa.Code = protocol.QAcOK
+	case SRVNoResponders:
+		qa.Code = protocol.QAcNoResponders
+	default:
+		qa.Code = protocol.QAcERR
+	}
+	qa.Meta.MessageId = msgid
+	qa.Responders = uint16(responders)
+	if err := prc.SendPacket(qa); err != nil {
+		logger.FDebugf(fn, "- [Queue] unable to acknowledge MessageId(%d). error: %s", msgid, err)
+	}
+}
+
+// createQueue creates the queue at `address`. Creating an existing
+// queue is not an error.
+func (s *Server) createQueue(clid string, address string) error {
+	const fn = "createQueue"
+	if address == "" {
+		return SRVInvalidQueue
+	}
+	if !s.canQueue(clid, "publish", address) {
+		return SRVQueueDenied
+	}
+	s.queues.Lock()
+	defer s.queues.Unlock()
+	if _, ok := s.queues.m[address]; !ok {
+		s.queues.m[address] = newQueue()
+		logger.FDebugf(fn, "+ [Queue] client(%s) created queue(%s).", clid, address)
+	}
+	return nil
+}
+
+// destroyQueue removes the queue at `address` and drops its pending
+// messages.
+func (s *Server) destroyQueue(clid string, address string) error {
+	const fn = "destroyQueue"
+	if !s.canQueue(clid, "publish", address) {
+		return SRVQueueDenied
+	}
+	s.queues.Lock()
+	defer s.queues.Unlock()
+	qu, ok := s.queues.m[address]
+	if !ok {
+		return SRVNoQueue
+	}
+	delete(s.queues.m, address)
+	for msgid := range qu.inflight {
+		delete(s.queues.ids, msgid)
+	}
+	logger.FDebugf(fn, "+ [Queue] client(%s) destroyed queue(%s) with (%d) pending messages.", clid, address, qu.mq.Q.Size())
+	return nil
+}
+
+// drainQueue adds client `clid` to consumers of the queue at `address`.
+// Waiting messages are dispatched once the command is acknowledged.
+func (s *Server) drainQueue(clid string, address string) error {
+	if !s.canQueue(clid, "subscribe", address) {
+		return SRVQueueDenied
+	}
+	s.queues.Lock()
+	defer s.queues.Unlock()
+	qu, ok := s.queues.m[address]
+	if !ok {
+		return SRVNoQueue
+	}
+	for _, cid := range qu.consumers {
+		if cid == clid {
+			return nil
+		}
+	}
+	qu.consumers = append(qu.consumers, clid)
+	return nil
+}
+
+// enqueue appends the message of `q` to the queue at its address.
+func (s *Server) enqueue(clid string, q *protocol.Queue) error {
+	if !s.canQueue(clid, "publish", q.Address) {
+		return SRVQueueDenied
+	}
+	s.queues.Lock()
+	defer s.queues.Unlock()
+	qu, ok := s.queues.m[q.Address]
+	if !ok {
+		return SRVNoQueue
+	}
+	qu.insert(q.ReturnPath, q.Mark, q.Message)
+	return nil
+}
+
+// dispatchQueue hands messages waiting in the queue at `address` to its
+// online consumers in turn. Messages stay queued while no consumer is
+// online and are kept until the consumer acknowledges them. Deliveries
+// are sent after the queues lock is released, those which cannot be
+// sent are put back in the queue.
+func (s *Server) dispatchQueue(address string) {
+	const fn = "dispatchQueue"
+	var (
+		online map[string]protobase.ProtoConnection = s.onlineConsumers(address)
+		failed []outgoing
+	)
+	if len(online) == 0 {
+		return
+	}
+	for _, o := range s.queues.collect(address, online) {
+		if err := sendQueue(o.prc, o.msgid, address, o.d.item.returnPath, o.d.item.mark, o.d.item.message); err != nil {
+			logger.FDebugf(fn, "- [Queue] unable to deliver message of queue(%s) to client(%s). error: %s", address, o.d.consumer, err)
+			failed = append(failed, o)
+			continue
+		}
+		logger.FDebugf(fn, "+ [Queue] delivered message of queue(%s) to client(%s).", address, o.d.consumer)
+	}
+	if len(failed) > 0 {
+		s.queues.rollback(address, failed)
+	}
+}
+
+// collect hands messages waiting in the queue at `address` to consumers
+// in `online` in turn and marks them as delivered. It returns the
+// deliveries to send.
+func (qs *queues) collect(address string, online map[string]protobase.ProtoConnection) (pending []outgoing) {
+	const fn = "collect"
+	qs.Lock()
+	defer qs.Unlock()
+	qu, ok := qs.m[address]
+	if !ok {
+		return nil
+	}
+	for item, _ := qu.mq.Get().(*queued); item != nil; item, _ = qu.mq.Get().(*queued) {
+		clid, prc := qu.nextConsumer(online)
+		if prc == nil {
+			break
+		}
+		msgid := qs.newId(address)
+		if msgid == 0 {
+			logger.FDebugf(fn, "- [Queue] too many unacknowledged messages, queue(%s) is paused.", address)
+			break
+		}
+		qu.mq.Ack.CreateOutAck(item.aid)
+		qu.mq.ReleaseOut(item.aid)
+		d := &delivery{consumer: clid, item: item}
+		qu.inflight[msgid] = d
+		pending = append(pending, outgoing{msgid, prc, d})
+	}
+	return pending
+}
+
+// rollback puts messages of `failed` deliveries back in front of the
+// queue at `address`. Deliveries which were requeued or dropped in
+// the meantime are skipped.
+func (qs *queues) rollback(address string, failed []outgoing) {
+	qs.Lock()
+	defer qs.Unlock()
+	qu, ok := qs.m[address]
+	if !ok {
+		return
+	}
+	items := make([]*queued, 0, len(failed))
+	for _, o := range failed {
+		if qu.inflight[o.msgid] != o.d {
+			continue
+		}
+		delete(qu.inflight, o.msgid)
+		delete(qs.ids, o.msgid)
+		items = append(items, o.d.item)
+	}
+	qu.prepend(items...)
+}
+
+// onlineConsumers returns connections of online consumers of the queue
+// at `address` by their client id. Connections are looked up without
+// holding the queues lock.
+func (s *Server) onlineConsumers(address string) map[string]protobase.ProtoConnection {
+	var consumers []string
+	s.queues.Lock()
+	if qu, ok := s.queues.m[address]; ok {
+		consumers = append(consumers, qu.consumers...)
+	}
+	s.queues.Unlock()
+	online := make(map[string]protobase.ProtoConnection, len(consumers))
+	for _, clid := range consumers {
+		cl := s.State.get(clid)
+		if cl == nil || cl.proto == nil || cl.proto.GetStatus() != protobase.STATONLINE {
+			continue
+		}
+		online[clid] = cl.proto
+	}
+	return online
+}
+
+// sendQueue delivers a queue message to `prc`, messages with a non zero
+// `msgid` are acknowledged by the consumer.
+func sendQueue(prc protobase.ProtoConnection, msgid uint16, address string, returnPath string, mark []byte, message []byte) error {
+	q := protocol.NewRawQueue()
+	q.Meta.MessageId = msgid
+	q.Address = address
+	q.ReturnPath = returnPath
+	q.Mark = mark
+	q.Message = message
+	return prc.SendPacket(q)
+}
+
+// nextConsumer returns the next consumer of the queue at `address`
+// which is in `online`, or nil when none is online.
+func (qs *queues) nextConsumer(address string, online map[string]protobase.ProtoConnection) (string, protobase.ProtoConnection) {
+	qs.Lock()
+	defer qs.Unlock()
+	qu, ok := qs.m[address]
+	if !ok {
+		return "", nil
+	}
+	return qu.nextConsumer(online)
+}
+
+// nextConsumer returns the next consumer of `qu` which is in `online`,
+// or nil when none is online. It must be called while holding the
+// queues lock.
+func (qu *queue) nextConsumer(online map[string]protobase.ProtoConnection) (string, protobase.ProtoConnection) {
+	for i := 0; i < len(qu.consumers); i++ {
+		clid := qu.consumers[qu.next%len(qu.consumers)]
+		qu.next = (qu.next + 1) % len(qu.consumers)
+		if prc, ok := online[clid]; ok {
+			return clid, prc
+		}
+	}
+	return "", nil
+}
+
+// canQueue returns whether client `clid` is allowed to perform `action`
+// on the queue at `address`. Queues are unrestricted when
+// authentication is disabled.
+func (s *Server) canQueue(clid string, action string, address string) bool {
+	if s.Authenticator != nil && s.Authenticator.GetMode() == protobase.AUTHModeNone {
+		return true
+	}
+	return s.hasPerm(clid, action, address)
+}
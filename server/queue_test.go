@@ -0,0 +1,220 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+func queueCmd(action protobase.QAction, address string, message string, msgid uint16) *protocol.Queue {
+	q := protocol.NewRawQueue()
+	q.Action, q.Address, q.Message = action, address, []byte(message)
+	q.Meta.MessageId = msgid
+	return q
+}
+
+func mustAck(t *testing.T, tc *testConn, msgid uint16, code byte) {
+	t.Helper()
+	sent := tc.take()
+	if len(sent) == 0 {
+		t.Fatal("expected queue acknowledgement.", msgid)
+	}
+	qa, ok := sent[0].(*protocol.QAck)
+	if !ok || qa.Meta.MessageId != msgid || qa.Code != code {
+		t.Fatal("inconsistent queue acknowledgement.", sent[0], code)
+	}
+	tc.lock.Lock()
+	tc.sent = append(sent[1:], tc.sent...)
+	tc.lock.Unlock()
+}
+
+// consume acknowledges queue messages sent to `tc` with `code` and
+// returns their content.
+func consume(s *Server, tc *testConn, code byte) (messages []string) {
+	for _, pb := range tc.take() {
+		q, ok := pb.(*protocol.Queue)
+		if !ok {
+			continue
+		}
+		qa := protocol.NewRawQAck()
+		qa.Code, qa.Meta.MessageId = code, q.Meta.MessageId
+		s.NotifyQueueAck(tc, qa)
+		messages = append(messages, string(q.Message))
+	}
+	return messages
+}
+
+func TestNotifyQueue(t *testing.T) {
+	var (
+		s        *Server = NewServer()
+		producer         = newTestConn(s, "producer")
+		a                = newTestConn(s, "a")
+		b                = newTestConn(s, "b")
+	)
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return true })
+	s.NotifyQueue(a, queueCmd(protocol.QADrain, "jobs", "", 1))
+	mustAck(t, a, 1, protocol.QAcERR)
+	s.NotifyQueue(producer, queueCmd(protocol.QAInitialize, "jobs", "", 1))
+	mustAck(t, producer, 1, protocol.QAcOK)
+	for i, job := range []string{"j1", "j2", "j3"} {
+		s.NotifyQueue(producer, queueCmd(protocol.QANone, "jobs", job, uint16(i+2)))
+		mustAck(t, producer, uint16(i+2), protocol.QAcOK)
+	}
+	// pending messages wait for a consumer
+	s.NotifyQueue(a, queueCmd(protocol.QADrain, "jobs", "", 2))
+	mustAck(t, a, 2, protocol.QAcOK)
+	if msgs := consume(s, a, protocol.QAcOK); len(msgs) != 3 || msgs[0] != "j1" || msgs[2] != "j3" {
+		t.Fatal("inconsistent drained messages.", msgs)
+	}
+	s.NotifyQueue(b, queueCmd(protocol.QADrain, "jobs", "", 1))
+	mustAck(t, b, 1, protocol.QAcOK)
+	// each message goes to exactly one consumer
+	for i := 0; i < 4; i++ {
+		s.NotifyQueue(producer, queueCmd(protocol.QANone, "jobs", "job", 10))
+		mustAck(t, producer, 10, protocol.QAcOK)
+	}
+	if na, nb := len(consume(s, a, protocol.QAcOK)), len(consume(s, b, protocol.QAcOK)); na != 2 || nb != 2 {
+		t.Fatal("inconsistent distribution among consumers.", na, nb)
+	}
+	// consumers are detached when they disconnect
+	s.queues.detach("a")
+	s.queues.detach("b")
+	s.NotifyQueue(producer, queueCmd(protocol.QANone, "jobs", "late", 11))
+	mustAck(t, producer, 11, protocol.QAcOK)
+	if items := s.queues.m["jobs"].items(); len(items) != 1 || string(items[0].message) != "late" {
+		t.Fatal("expected message to wait in the queue.", items)
+	}
+	s.NotifyQueue(producer, queueCmd(protocol.QADestroy, "jobs", "", 12))
+	mustAck(t, producer, 12, protocol.QAcOK)
+	s.NotifyQueue(producer, queueCmd(protocol.QANone, "jobs", "lost", 13))
+	mustAck(t, producer, 13, protocol.QAcERR)
+	s.NotifyQueue(producer, queueCmd(protocol.QADestroy, "jobs", "", 14))
+	mustAck(t, producer, 14, protocol.QAcERR)
+}
+
+func TestQueueRedelivery(t *testing.T) {
+	var (
+		s        *Server = NewServer()
+		producer         = newTestConn(s, "producer")
+		a                = newTestConn(s, "a")
+		b                = newTestConn(s, "b")
+	)
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return true })
+	s.NotifyQueue(producer, queueCmd(protocol.QAInitialize, "jobs", "", 1))
+	s.NotifyQueue(a, queueCmd(protocol.QADrain, "jobs", "", 1))
+	producer.take()
+	a.take()
+	s.NotifyQueue(producer, queueCmd(protocol.QANone, "jobs", "j1", 2))
+	// unacknowledged messages stay in the queue
+	sent := a.take()
+	if len(sent) != 1 || s.queues.m["jobs"].mq.Q.Size() != 0 || len(s.queues.m["jobs"].items()) != 1 {
+		t.Fatal("expected message to be held until acknowledged.", sent)
+	}
+	// rejected messages are delivered again
+	a.sent = sent
+	if msgs := consume(s, a, protocol.QAcERR); len(msgs) != 1 || msgs[0] != "j1" {
+		t.Fatal("inconsistent rejected messages.", msgs)
+	}
+	if msgs := consume(s, a, protocol.QAcERR); len(msgs) != 1 || msgs[0] != "j1" {
+		t.Fatal("expected rejected message to be redelivered.", msgs)
+	}
+	// messages of disconnected consumers go to the others
+	s.NotifyQueue(b, queueCmd(protocol.QADrain, "jobs", "", 1))
+	mustAck(t, b, 1, protocol.QAcOK)
+	a.offline = true
+	a.take()
+	s.releaseQueues("a")
+	b.offline = true
+	if msgs := consume(s, b, protocol.QAcERR); len(msgs) != 1 || msgs[0] != "j1" {
+		t.Fatal("expected message of disconnected consumer to be redelivered.", msgs)
+	}
+	// and wait for a consumer to reconnect
+	if items := s.queues.m["jobs"].items(); len(items) != 1 || len(s.queues.m["jobs"].inflight) != 0 {
+		t.Fatal("expected message to wait in the queue.", items)
+	}
+	a.offline = false
+	s.resumeQueues("a")
+	if msgs := consume(s, a, protocol.QAcOK); len(msgs) != 1 || msgs[0] != "j1" {
+		t.Fatal("expected message to be dispatched on reconnect.", msgs)
+	}
+	if items := s.queues.m["jobs"].items(); len(items) != 0 || len(s.queues.ids) != 0 {
+		t.Fatal("expected acknowledged message to be released.", items)
+	}
+}
+
+func TestQueueSendFailure(t *testing.T) {
+	var (
+		s        *Server = NewServer()
+		producer         = newTestConn(s, "producer")
+		a                = newTestConn(s, "a")
+		held     bool
+	)
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return true })
+	s.NotifyQueue(producer, queueCmd(protocol.QAInitialize, "jobs", "", 1))
+	s.NotifyQueue(a, queueCmd(protocol.QADrain, "jobs", "", 1))
+	producer.take()
+	a.take()
+	// deliveries are sent without holding the queues lock
+	a.sendErr = func(pb protobase.EDProtocol) error {
+		if _, ok := pb.(*protocol.Queue); !ok {
+			return nil
+		}
+		if s.queues.TryLock() {
+			s.queues.Unlock()
+		} else {
+			held = true
+		}
+		return errors.New("closed")
+	}
+	s.NotifyQueue(producer, queueCmd(protocol.QANone, "jobs", "j1", 2))
+	mustAck(t, producer, 2, protocol.QAcOK)
+	if held {
+		t.Fatal("expected queues lock to be released while sending.")
+	}
+	// and put back in the queue when they fail
+	if items := s.queues.m["jobs"].items(); len(items) != 1 || len(s.queues.m["jobs"].inflight) != 0 || len(s.queues.ids) != 0 {
+		t.Fatal("expected failed delivery to be rolled back.", items)
+	}
+	a.sendErr = nil
+	s.resumeQueues("a")
+	if msgs := consume(s, a, protocol.QAcOK); len(msgs) != 1 || msgs[0] != "j1" {
+		t.Fatal("expected rolled back message to be delivered.", msgs)
+	}
+}
+
+func TestNotifyQueueDenied(t *testing.T) {
+	var (
+		s        *Server = NewServer()
+		producer         = newTestConn(s, "producer")
+	)
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return false })
+	s.NotifyQueue(producer, queueCmd(protocol.QAInitialize, "jobs", "", 1))
+	mustAck(t, producer, 1, protocol.QAcERR)
+	if _, ok := s.queues.m["jobs"]; ok {
+		t.Fatal("queue created without permission.")
+	}
+}
@@ -0,0 +1,223 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+// request is a pending request awaiting replies of its responders.
+type request struct {
+	requester  string              // client id of the requester
+	responders map[string]struct{} // client ids yet to reply
+	mark       []byte              // correlation mark of the request
+	timer      *time.Timer         // releases the return path on timeout
+}
+
+// requests holds pending requests by their return path.
+type requests struct {
+	sync.Mutex
+
+	m map[string]*request
+}
+
+func newRequests() *requests {
+	return &requests{m: make(map[string]*request)}
+}
+
+// take removes and returns the pending request of `returnPath`.
+func (rs *requests) take(returnPath string) *request {
+	rs.Lock()
+	defer rs.Unlock()
+	req, ok := rs.m[returnPath]
+	if !ok {
+		return nil
+	}
+	delete(rs.m, returnPath)
+	req.timer.Stop()
+	return req
+}
+
+// answer removes `responder` from the responders of the request
+// pending on `returnPath` and returns the request, or nil when the
+// responder or its mark does not match. The request is released once
+// all its responders have replied.
+func (rs *requests) answer(returnPath string, responder string, mark []byte) *request {
+	rs.Lock()
+	defer rs.Unlock()
+	req, ok := rs.m[returnPath]
+	if !ok || !bytes.Equal(req.mark, mark) {
+		return nil
+	}
+	if _, ok = req.responders[responder]; !ok {
+		return nil
+	}
+	delete(req.responders, responder)
+	if len(req.responders) == 0 {
+		delete(rs.m, returnPath)
+		req.timer.Stop()
+	}
+	return req
+}
+
+// isReplyPath returns whether `address` is a return path.
+func (s *Server) isReplyPath(address string) bool {
+	return strings.HasPrefix(address, protocol.QReplyPrefix)
+}
+
+// await registers a request of client `clid` waiting on the return
+// path of `q` for replies of `responders`, the return path is
+// released after the request timeout and the requester is notified
+// ( see `timeout` ).
+func (s *Server) await(clid string, q *protocol.Queue, responders []string) error {
+	const fn = "await"
+	returnPath := q.ReturnPath
+	req := &request{
+		requester:  clid,
+		responders: make(map[string]struct{}, len(responders)),
+		mark:       q.Mark,
+	}
+	for _, responder := range responders {
+		req.responders[responder] = struct{}{}
+	}
+	s.requests.Lock()
+	defer s.requests.Unlock()
+	if _, ok := s.requests.m[returnPath]; ok {
+		return SRVReturnPath
+	}
+	req.timer = time.AfterFunc(s.requestTimeout, func() {
+		if req := s.requests.take(returnPath); req != nil {
+			logger.FDebugf(fn, "- [Request] request of client(%s) with return path(%s) timed out with (%d) missing replies.", clid, returnPath, len(req.responders))
+			s.timeout(returnPath, req)
+		}
+	})
+	s.requests.m[returnPath] = req
+	return nil
+}
+
+// request routes the request in `q` to exactly one online consumer of
+// the queue at its address. Its reply is accepted from that consumer
+// only and routed back to client `clid` until the request times out.
+// It returns `SRVNoResponders` when the queue has no online consumer.
+func (s *Server) request(clid string, q *protocol.Queue) error {
+	const fn = "request"
+	if !s.isReplyPath(q.ReturnPath) {
+		return SRVInvalidQueue
+	}
+	if !s.canQueue(clid, "publish", q.Address) {
+		return SRVQueueDenied
+	}
+	responder, prc := s.queues.nextConsumer(q.Address, s.onlineConsumers(q.Address))
+	if prc == nil {
+		return SRVNoResponders
+	}
+	if err := s.await(clid, q, []string{responder}); err != nil {
+		return err
+	}
+	if err := sendQueue(prc, 0, q.Address, q.ReturnPath, q.Mark, q.Message); err != nil {
+		s.requests.take(q.ReturnPath)
+		return err
+	}
+	logger.FDebugf(fn, "+ [Request] routed request of client(%s) on queue(%s) to client(%s).", clid, q.Address, responder)
+	return nil
+}
+
+// scatter fans the request in `q` out to all online subscribers of its
+// address and returns their number. Their replies are routed back to
+// client `clid` until all of them replied or the request times out.
+// It returns `SRVNoResponders` when there is no online subscriber.
+func (s *Server) scatter(clid string, q *protocol.Queue) (int, error) {
+	const fn = "scatter"
+	if !s.isReplyPath(q.ReturnPath) {
+		return 0, SRVInvalidQueue
+	}
+	if !s.canQueue(clid, "publish", q.Address) {
+		return 0, SRVQueueDenied
+	}
+	var (
+		responders []string
+		conns      []protobase.ProtoConnection
+	)
+	m, _ := s.Router.Find(q.Address)
+	for k := range m {
+		if k == clid || s.getSink(k) != nil {
+			continue
+		}
+		cl := s.State.get(k)
+		if cl == nil || cl.proto == nil || cl.proto.GetStatus() != protobase.STATONLINE {
+			continue
+		}
+		responders = append(responders, k)
+		conns = append(conns, cl.proto)
+	}
+	if len(responders) == 0 {
+		return 0, SRVNoResponders
+	}
+	if err := s.await(clid, q, responders); err != nil {
+		return 0, err
+	}
+	for i, prc := range conns {
+		if err := sendQueue(prc, 0, q.Address, q.ReturnPath, q.Mark, q.Message); err != nil {
+			logger.FDebugf(fn, "- [Scatter] unable to deliver request to client(%s). error: %s", responders[i], err)
+		}
+	}
+	logger.FDebugf(fn, "+ [Scatter] fanned request of client(%s) on topic(%s) out to (%d) clients.", clid, q.Address, len(responders))
+	return len(responders), nil
+}
+
+// reply routes the reply in `q` from client `clid` back to the
+// requester waiting on its return path.
+func (s *Server) reply(clid string, q *protocol.Queue) error {
+	const fn = "reply"
+	req := s.requests.answer(q.Address, clid, q.Mark)
+	if req == nil {
+		return SRVNoRequest
+	}
+	cl := s.State.get(req.requester)
+	if cl == nil || cl.proto == nil {
+		logger.FDebugf(fn, "- [Reply] requester(%s) of return path(%s) is gone.", req.requester, q.Address)
+		return SRVNoRequest
+	}
+	return sendQueue(cl.proto, 0, q.Address, "", q.Mark, q.Message)
+}
+
+// timeout notifies the requester of `req` that its request pending on
+// `returnPath` timed out by sending `protocol.QATimeout` to the path.
+func (s *Server) timeout(returnPath string, req *request) {
+	const fn = "timeout"
+	cl := s.State.get(req.requester)
+	if cl == nil || cl.proto == nil || cl.proto.GetStatus() != protobase.STATONLINE {
+		return
+	}
+	q := protocol.NewRawQueue()
+	q.Action, q.Address, q.Mark = protocol.QATimeout, returnPath, req.mark
+	if err := cl.proto.SendPacket(q); err != nil {
+		logger.FDebugf(fn, "- [Request] unable to notify requester(%s) of return path(%s). error: %s", req.requester, returnPath, err)
+	}
+}
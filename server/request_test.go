@@ -0,0 +1,207 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+func requestCmd(address string, returnPath string, mark string, message string, msgid uint16) *protocol.Queue {
+	q := queueCmd(protocol.QANone, address, message, msgid)
+	q.ReturnPath, q.Mark = returnPath, []byte(mark)
+	return q
+}
+
+func TestRequest(t *testing.T) {
+	var (
+		s         *Server = NewServer()
+		requester         = newTestConn(s, "requester")
+		a                 = newTestConn(s, "a")
+		b                 = newTestConn(s, "b")
+		path      string  = protocol.QReplyPrefix + "1"
+	)
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return true })
+	s.NotifyQueue(requester, requestCmd("svc", path, "m1", "ping", 1))
+	mustAck(t, requester, 1, protocol.QAcNoResponders)
+	s.NotifyQueue(a, queueCmd(protocol.QAInitialize, "svc", "", 1))
+	mustAck(t, a, 1, protocol.QAcOK)
+	s.NotifyQueue(requester, requestCmd("svc", path, "m1", "ping", 2))
+	mustAck(t, requester, 2, protocol.QAcNoResponders)
+	for _, responder := range []*testConn{a, b} {
+		s.NotifyQueue(responder, queueCmd(protocol.QADrain, "svc", "", 2))
+		mustAck(t, responder, 2, protocol.QAcOK)
+	}
+	// exactly one responder receives the request
+	s.NotifyQueue(requester, requestCmd("svc", path, "m1", "ping", 3))
+	mustAck(t, requester, 3, protocol.QAcOK)
+	sent := a.take()
+	if len(sent) != 1 || len(b.take()) != 0 {
+		t.Fatal("expected request to be routed to one responder.", sent)
+	}
+	if q := sent[0].(*protocol.Queue); q.ReturnPath != path || string(q.Mark) != "m1" || string(q.Message) != "ping" {
+		t.Fatal("inconsistent routed request.", q)
+	}
+	s.NotifyQueue(requester, requestCmd("svc", path, "m1", "ping", 4))
+	mustAck(t, requester, 4, protocol.QAcERR)
+	// replies are accepted from the chosen responder only
+	s.NotifyQueue(b, requestCmd(path, "", "m1", "forged", 3))
+	mustAck(t, b, 3, protocol.QAcERR)
+	s.NotifyQueue(a, requestCmd(path, "", "m2", "pong", 3))
+	mustAck(t, a, 3, protocol.QAcERR)
+	s.NotifyQueue(a, requestCmd(path, "", "m1", "pong", 4))
+	mustAck(t, a, 4, protocol.QAcOK)
+	sent = requester.take()
+	if len(sent) != 1 {
+		t.Fatal("expected reply to be routed to requester.", sent)
+	}
+	if q := sent[0].(*protocol.Queue); q.Address != path || string(q.Mark) != "m1" || string(q.Message) != "pong" {
+		t.Fatal("inconsistent routed reply.", q)
+	}
+	s.NotifyQueue(a, requestCmd(path, "", "m1", "pong", 5))
+	mustAck(t, a, 5, protocol.QAcERR)
+}
+
+func TestRequestSendFailure(t *testing.T) {
+	var (
+		s         *Server = NewServer()
+		requester         = newTestConn(s, "requester")
+		responder         = newTestConn(s, "responder")
+		path      string  = protocol.QReplyPrefix + "1"
+		held      bool
+	)
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return true })
+	s.NotifyQueue(responder, queueCmd(protocol.QAInitialize, "svc", "", 1))
+	s.NotifyQueue(responder, queueCmd(protocol.QADrain, "svc", "", 2))
+	responder.take()
+	// requests are sent without holding the queues lock
+	responder.sendErr = func(pb protobase.EDProtocol) error {
+		if _, ok := pb.(*protocol.Queue); !ok {
+			return nil
+		}
+		if s.queues.TryLock() {
+			s.queues.Unlock()
+		} else {
+			held = true
+		}
+		return errors.New("closed")
+	}
+	s.NotifyQueue(requester, requestCmd("svc", path, "m1", "ping", 1))
+	mustAck(t, requester, 1, protocol.QAcERR)
+	if held {
+		t.Fatal("expected queues lock to be released while sending.")
+	}
+	// and their return path is released when sending fails
+	s.requests.Lock()
+	n := len(s.requests.m)
+	s.requests.Unlock()
+	if n != 0 {
+		t.Fatal("expected return path to be released.", n)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	var (
+		s         *Server = NewServer()
+		requester         = newTestConn(s, "requester")
+		responder         = newTestConn(s, "responder")
+		path      string  = protocol.QReplyPrefix + "1"
+	)
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return true })
+	s.SetRequestTimeout(time.Millisecond * 10)
+	s.NotifyQueue(responder, queueCmd(protocol.QAInitialize, "svc", "", 1))
+	s.NotifyQueue(responder, queueCmd(protocol.QADrain, "svc", "", 2))
+	responder.take()
+	s.NotifyQueue(requester, requestCmd("svc", path, "m1", "ping", 1))
+	mustAck(t, requester, 1, protocol.QAcOK)
+	responder.take()
+	time.Sleep(time.Millisecond * 50)
+	s.requests.Lock()
+	n := len(s.requests.m)
+	s.requests.Unlock()
+	if n != 0 {
+		t.Fatal("expected return path to be released.", n)
+	}
+	// the requester is notified on the return path
+	sent := requester.take()
+	if len(sent) != 1 {
+		t.Fatal("expected requester to be notified.", sent)
+	}
+	if q := sent[0].(*protocol.Queue); q.Action != protocol.QATimeout || q.Address != path || string(q.Mark) != "m1" {
+		t.Fatal("inconsistent timeout notification.", q)
+	}
+	// clients cannot send timeouts
+	s.NotifyQueue(requester, queueCmd(protocol.QATimeout, path, "", 2))
+	mustAck(t, requester, 2, protocol.QAcERR)
+	s.NotifyQueue(responder, requestCmd(path, "", "m1", "late", 3))
+	mustAck(t, responder, 3, protocol.QAcERR)
+}
+
+func TestScatter(t *testing.T) {
+	var (
+		s         *Server = NewServer()
+		requester         = newTestConn(s, "requester")
+		a                 = newTestConn(s, "a")
+		b                 = newTestConn(s, "b")
+		path      string  = protocol.QReplyPrefix + "1"
+	)
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return true })
+	scatter := func(msgid uint16) *protocol.Queue {
+		q := requestCmd("sensors/poll", path, "m1", "report", msgid)
+		q.Action = protocol.QAScatter
+		return q
+	}
+	s.NotifyQueue(requester, scatter(1))
+	mustAck(t, requester, 1, protocol.QAcNoResponders)
+	s.Router.Add("a", "sensors/*", 0)
+	s.Router.Add("b", "sensors/poll", 0)
+	s.Router.Add("requester", "sensors/poll", 0)
+	s.attachSink("sink", "sensors/poll", 0, func(protobase.MsgInterface) {})
+	s.NotifyQueue(requester, scatter(2))
+	sent := requester.take()
+	if qa, ok := sent[0].(*protocol.QAck); !ok || qa.Code != protocol.QAcOK || qa.Responders != 2 {
+		t.Fatal("inconsistent scatter acknowledgement.", sent[0])
+	}
+	for _, responder := range []*testConn{a, b} {
+		if sent := responder.take(); len(sent) != 1 || sent[0].(*protocol.Queue).ReturnPath != path {
+			t.Fatal("expected request to be fanned out.", sent)
+		}
+	}
+	// each responder replies once
+	s.NotifyQueue(a, requestCmd(path, "", "m1", "a", 1))
+	mustAck(t, a, 1, protocol.QAcOK)
+	s.NotifyQueue(a, requestCmd(path, "", "m1", "a", 2))
+	mustAck(t, a, 2, protocol.QAcERR)
+	s.NotifyQueue(b, requestCmd(path, "", "m1", "b", 1))
+	mustAck(t, b, 1, protocol.QAcOK)
+	if sent := requester.take(); len(sent) != 2 || string(sent[0].(*protocol.Queue).Message) != "a" || string(sent[1].(*protocol.Queue).Message) != "b" {
+		t.Fatal("inconsistent gathered replies.", sent)
+	}
+	if len(s.requests.m) != 0 {
+		t.Fatal("expected return path to be released.", s.requests.m)
+	}
+}
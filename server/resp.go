@@ -0,0 +1,437 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/mitghi/protox/commands"
+	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+// RESPDefaultUser is the username of single argument AUTH commands.
+const RESPDefaultUser = "default"
+
+// respConn is a connection of the RESP frontend. Replies and routed
+// messages are written by a single writer in order.
+type respConn struct {
+	net.Conn
+	s        *Server
+	rd       *commands.Reader
+	out      chan []byte
+	done     chan struct{}
+	id       uint64
+	clid     string            // authenticated username
+	channels map[string]string // channel -> sink id
+	patterns map[string]string // pattern -> sink id
+}
+
+// newRESPConn allocates and initializes a new `respConn` and returns
+// a pointer to it.
+func newRESPConn(s *Server, conn net.Conn, id uint64) *respConn {
+	rc := &respConn{
+		Conn:     conn,
+		s:        s,
+		rd:       commands.NewReader(conn),
+		out:      make(chan []byte, DefaultSSEBuffer),
+		done:     make(chan struct{}),
+		id:       id,
+		channels: make(map[string]string),
+		patterns: make(map[string]string),
+	}
+	if s.maxPacketSize > 0 {
+		rc.rd.MaxLength = int(s.maxPacketSize)
+	}
+	return rc
+}
+
+// ServeRESP is the listening loop for clients speaking the Redis
+// serialization protocol ( RESP ). It accepts PING, AUTH, PUBLISH,
+// SUBSCRIBE, PSUBSCRIBE, UNSUBSCRIBE, PUNSUBSCRIBE, GET ( retained
+// message ) and QUIT commands and bridges them into the router. Other
+// commands require authentication and are subject to the same
+// permissions as native clients. Patterns use the protox wildcard
+// syntax. The listener is configured by `SetFrontend` with `ProtoRP`.
+func (s *Server) ServeRESP(address string) (err error) {
+	const fn = "ServeRESP"
+	var (
+		listener net.Listener
+		lock     sync.Mutex
+		conns    map[net.Conn]struct{} = make(map[net.Conn]struct{})
+		seq      uint64
+	)
+	listener, err = s.frontendListener(ProtoRP, address)
+	if err != nil {
+		logger.Debug(fn, "- [Fatal] Cannot listen for incomming RESP connections.")
+		return err
+	}
+	defer listener.Close()
+	// connections are closed along with the listener
+	defer s.closeOnStop(closerFunc(func() error {
+		lock.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		lock.Unlock()
+		return listener.Close()
+	}))()
+	for {
+		var (
+			conn net.Conn
+		)
+		conn, err = listener.Accept()
+		if err != nil {
+			logger.FDebug(fn, "- [Server] returning from RESP handler. error:", err)
+			break
+		}
+		seq++
+		lock.Lock()
+		conns[conn] = struct{}{}
+		lock.Unlock()
+		go func(conn net.Conn, id uint64) {
+			s.handleRESP(conn, id)
+			lock.Lock()
+			delete(conns, conn)
+			lock.Unlock()
+		}(conn, seq)
+	}
+	return err
+}
+
+// handleRESP executes commands of a RESP connection until it is
+// closed or the client quits.
+func (s *Server) handleRESP(conn net.Conn, id uint64) {
+	const fn = "handleRESP"
+	rc := newRESPConn(s, conn, id)
+	defer rc.close()
+	go rc.writer()
+	logger.FInfo(fn, "* [Genesis] RESP connection accepted.")
+	for {
+		args, err := rc.rd.ReadCommand()
+		if err != nil {
+			if err == commands.EINVARRH || err == commands.EVIOTOK || err == commands.EINLEN || err == commands.EVIOLEN {
+				rc.reply(respError("ERR Protocol error: " + err.Error()))
+			}
+			logger.FDebug(fn, "- [RESP] closing connection.", err)
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if !rc.exec(args) {
+			return
+		}
+	}
+}
+
+// writer writes replies and routed messages to the connection. It
+// flushes queued replies and closes the connection when done.
+func (rc *respConn) writer() {
+	for {
+		select {
+		case b := <-rc.out:
+			if _, err := rc.Conn.Write(b); err != nil {
+				rc.Conn.Close()
+			}
+		case <-rc.done:
+			for {
+				select {
+				case b := <-rc.out:
+					rc.Conn.Write(b)
+				default:
+					rc.Conn.Close()
+					return
+				}
+			}
+		}
+	}
+}
+
+// reply queues `b` to be written.
+func (rc *respConn) reply(b []byte) {
+	select {
+	case rc.out <- b:
+	case <-rc.done:
+	}
+}
+
+// close removes subscriptions and releases the writer.
+func (rc *respConn) close() {
+	for filter, id := range rc.channels {
+		rc.s.detachSink(id, filter)
+	}
+	for filter, id := range rc.patterns {
+		rc.s.detachSink(id, filter)
+	}
+	close(rc.done)
+}
+
+// subscriptions returns the number of subscribed channels and patterns.
+func (rc *respConn) subscriptions() int {
+	return len(rc.channels) + len(rc.patterns)
+}
+
+// exec executes a single command. It returns false when the
+// connection must be closed.
+func (rc *respConn) exec(args []string) bool {
+	cmd := strings.ToUpper(args[0])
+	if rc.subscriptions() > 0 {
+		switch cmd {
+		case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PING", "QUIT":
+		default:
+			rc.reply(respError(fmt.Sprintf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", args[0])))
+			return true
+		}
+	}
+	switch cmd {
+	case "PING":
+		if rc.subscriptions() > 0 {
+			msg := ""
+			if len(args) > 1 {
+				msg = args[1]
+			}
+			rc.reply(respArray("pong", msg))
+		} else if len(args) > 1 {
+			rc.reply(respBulk(args[1]))
+		} else {
+			rc.reply(respSimple("PONG"))
+		}
+	case "QUIT":
+		rc.reply(respSimple("OK"))
+		return false
+	case "AUTH":
+		rc.auth(args[1:])
+	case "PUBLISH":
+		if rc.arity(args, 3, 3) && rc.authorized() {
+			rc.publish(args[1], args[2])
+		}
+	case "SUBSCRIBE":
+		if rc.arity(args, 2, -1) && rc.authorized() {
+			rc.subscribe(args[1:], false)
+		}
+	case "PSUBSCRIBE":
+		if rc.arity(args, 2, -1) && rc.authorized() {
+			rc.subscribe(args[1:], true)
+		}
+	case "UNSUBSCRIBE":
+		rc.unsubscribe(args[1:], false)
+	case "PUNSUBSCRIBE":
+		rc.unsubscribe(args[1:], true)
+	case "GET":
+		if rc.arity(args, 2, 2) && rc.authorized() {
+			rc.get(args[1])
+		}
+	default:
+		rc.reply(respError(fmt.Sprintf("ERR unknown command '%s'", args[0])))
+	}
+	return true
+}
+
+// arity checks the number of arguments, `max` less than zero means
+// unbounded.
+func (rc *respConn) arity(args []string, min int, max int) bool {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		rc.reply(respError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(args[0]))))
+		return false
+	}
+	return true
+}
+
+// authorized returns whether the connection is authenticated.
+func (rc *respConn) authorized() bool {
+	if rc.clid == "" {
+		rc.reply(respError("NOAUTH Authentication required."))
+		return false
+	}
+	return true
+}
+
+// auth authenticates the connection, `args` is either password or
+// username and password.
+func (rc *respConn) auth(args []string) {
+	var username, password string
+	switch len(args) {
+	case 1:
+		username, password = RESPDefaultUser, args[0]
+	case 2:
+		username, password = args[0], args[1]
+	default:
+		rc.reply(respError("ERR wrong number of arguments for 'auth' command"))
+		return
+	}
+	if err := rc.s.authenticate(username, password, username); err != nil {
+		rc.reply(respError("WRONGPASS invalid username-password pair or user is disabled."))
+		return
+	}
+	rc.clid = username
+	rc.reply(respSimple("OK"))
+}
+
+// publish publishes `message` on `topic` and replies with the number
+// of subscribers.
+func (rc *respConn) publish(topic string, message string) {
+	if strings.IndexByte(topic, messages.TWLDCD) >= 0 {
+		rc.reply(respError("ERR wildcards are not allowed in topic names"))
+		return
+	}
+	if !rc.s.canPublish(rc.clid, topic) {
+		rc.reply(respError("NOPERM this user has no permissions to access the channel"))
+		return
+	}
+	m, _ := rc.s.Router.Find(topic)
+	msg := protocol.NewMsgBox(protobase.LQOS0, 0, protobase.MDInbound, protocol.NewMsgEnvelope(topic, []byte(message)))
+	rc.s.NotifyPublish(nil, msg)
+	rc.reply(respInt(len(m)))
+}
+
+// subscribe subscribes to channels, or to patterns when `pattern`
+// is set. Channels must not contain wildcards.
+func (rc *respConn) subscribe(filters []string, pattern bool) {
+	const fn = "subscribe"
+	kind, subs := "subscribe", rc.channels
+	if pattern {
+		kind, subs = "psubscribe", rc.patterns
+	}
+	for _, filter := range filters {
+		if !pattern && strings.IndexByte(filter, messages.TWLDCD) >= 0 {
+			rc.reply(respError("ERR wildcards are only allowed in patterns, use PSUBSCRIBE"))
+			return
+		}
+		if !rc.s.canSubscribe(rc.clid, filter) {
+			rc.reply(respError("NOPERM this user has no permissions to access one of the channels"))
+			return
+		}
+	}
+	for _, filter := range filters {
+		if _, ok := subs[filter]; !ok {
+			filter := filter
+			id := fmt.Sprintf("$resp/%d/%s/%s", rc.id, kind, filter)
+			subs[filter] = id
+			rc.s.attachSink(id, filter, protobase.LQOS0, func(msg protobase.MsgInterface) {
+				var b []byte
+				if pattern {
+					b = respArray("pmessage", filter, msg.Envelope().Route(), string(msg.Envelope().Payload()))
+				} else {
+					b = respArray("message", msg.Envelope().Route(), string(msg.Envelope().Payload()))
+				}
+				select {
+				case rc.out <- b:
+				case <-rc.done:
+				default:
+					logger.FDebugf(fn, "- [RESP] connection(%d) is full, dropping message.", rc.id)
+				}
+			})
+		}
+		rc.reply(respSubscription(kind, filter, rc.subscriptions()))
+	}
+}
+
+// unsubscribe removes subscriptions to channels, or to patterns when
+// `pattern` is set. All of them are removed when `filters` is empty.
+func (rc *respConn) unsubscribe(filters []string, pattern bool) {
+	kind, subs := "unsubscribe", rc.channels
+	if pattern {
+		kind, subs = "punsubscribe", rc.patterns
+	}
+	if len(filters) == 0 {
+		for filter := range subs {
+			filters = append(filters, filter)
+		}
+		if len(filters) == 0 {
+			c := commands.NewCommand()
+			fmt.Fprintf(c, commands.ARRFormat, 3)
+			c.WriteString(kind)
+			c.Write([]byte(commands.NILString))
+			fmt.Fprintf(c, commands.INTFormat, 0)
+			rc.reply(c.Bytes())
+			return
+		}
+	}
+	for _, filter := range filters {
+		if id, ok := subs[filter]; ok {
+			rc.s.detachSink(id, filter)
+			delete(subs, filter)
+		}
+		rc.reply(respSubscription(kind, filter, rc.subscriptions()))
+	}
+}
+
+// get replies with the retained message of `topic`.
+func (rc *respConn) get(topic string) {
+	if !rc.s.canSubscribe(rc.clid, topic) {
+		rc.reply(respError("NOPERM this user has no permissions to access the key"))
+		return
+	}
+	for _, p := range rc.s.Router.FindRetained(topic) {
+		if rp, ok := p.(*protocol.Publish); ok && rp.Topic == topic {
+			rc.reply(respBulk(string(rp.Message)))
+			return
+		}
+	}
+	rc.reply([]byte(commands.NILString))
+}
+
+// respSimple returns a simple string reply.
+func respSimple(s string) []byte {
+	return []byte(fmt.Sprintf(commands.SIMFormat, s))
+}
+
+// respError returns an error reply.
+func respError(s string) []byte {
+	return []byte(fmt.Sprintf(commands.ERRFormat, s))
+}
+
+// respInt returns an integer reply.
+func respInt(n int) []byte {
+	return []byte(fmt.Sprintf(commands.INTFormat, n))
+}
+
+// respBulk returns a bulk string reply.
+func respBulk(s string) []byte {
+	return []byte(fmt.Sprintf(commands.STRFormat, len(s), s))
+}
+
+// respArray returns an array reply of bulk strings.
+func respArray(items ...string) []byte {
+	c := commands.NewCommand()
+	fmt.Fprintf(c, commands.ARRFormat, len(items))
+	for _, item := range items {
+		c.WriteString(item)
+	}
+	return c.Bytes()
+}
+
+// respSubscription returns a ( un ) subscription reply.
+func respSubscription(kind string, filter string, count int) []byte {
+	c := commands.NewCommand()
+	fmt.Fprintf(c, commands.ARRFormat, 3)
+	c.WriteString(kind)
+	c.WriteString(filter)
+	fmt.Fprintf(c, commands.INTFormat, count)
+	return c.Bytes()
+}
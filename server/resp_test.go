@@ -0,0 +1,96 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/mitghi/protox/auth"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+func TestRESPFrontend(t *testing.T) {
+	var (
+		s              *Server = NewServer()
+		authz                  = auth.NewAuthenticator()
+		client, server         = net.Pipe()
+	)
+	defer client.Close()
+	creds, _ := authz.MakeCreds("web", "secret", "web")
+	authz.Register(creds)
+	s.SetAuthenticator(authz)
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return true })
+	s.Status = protobase.ServerRunning
+	retained := protocol.NewMsgBox(protobase.LQOS0, 0, protobase.MDInbound, protocol.NewMsgEnvelope("a/b", []byte("21.5")))
+	retained.SetRetain(true, 0)
+	s.NotifyPublish(nil, retained)
+	go s.handleRESP(server, 1)
+	expect := func(command string, reply string) {
+		if command != "" {
+			if _, err := io.WriteString(client, command); err != nil {
+				t.Fatal("err!=nil", err)
+			}
+		}
+		buf := make([]byte, len(reply))
+		if _, err := io.ReadFull(client, buf); err != nil {
+			t.Fatal("err!=nil", err)
+		}
+		if string(buf) != reply {
+			t.Fatalf("inconsistent reply to %q ( %q != %q ).", command, buf, reply)
+		}
+	}
+	expect("PING\r\n", "+PONG\r\n")
+	expect("*2\r\n$3\r\nGET\r\n$3\r\na/b\r\n", "-NOAUTH Authentication required.\r\n")
+	expect("*3\r\n$4\r\nAUTH\r\n$3\r\nweb\r\n$5\r\nwrong\r\n", "-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+	expect("*3:13\r\n$4\r\nAUTH\r\n$3\r\nweb\r\n$6\r\nsecret\r\n", "+OK\r\n")
+	expect("*2\r\n$3\r\nGET\r\n$3\r\na/b\r\n", "$4\r\n21.5\r\n")
+	expect("*2\r\n$3\r\nGET\r\n$3\r\na/c\r\n", "$-1\r\n")
+	expect("*2\r\n$9\r\nSUBSCRIBE\r\n$3\r\na/*\r\n", "-ERR wildcards are only allowed in patterns, use PSUBSCRIBE\r\n")
+	expect("*2\r\n$9\r\nSUBSCRIBE\r\n$3\r\na/b\r\n", "*3\r\n$9\r\nsubscribe\r\n$3\r\na/b\r\n:1\r\n")
+	expect("*2\r\n$10\r\nPSUBSCRIBE\r\n$3\r\na/*\r\n", "*3\r\n$10\r\npsubscribe\r\n$3\r\na/*\r\n:2\r\n")
+	expect("*2\r\n$3\r\nGET\r\n$3\r\na/b\r\n", "-ERR Can't execute 'GET': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context\r\n")
+	// routed messages, order of subscribers is not defined
+	var (
+		message  = "*3\r\n$7\r\nmessage\r\n$3\r\na/b\r\n$2\r\nhi\r\n"
+		pmessage = "*4\r\n$8\r\npmessage\r\n$3\r\na/*\r\n$3\r\na/b\r\n$2\r\nhi\r\n"
+	)
+	s.NotifyPublish(nil, protocol.NewMsgBox(protobase.LQOS0, 0, protobase.MDInbound, protocol.NewMsgEnvelope("a/b", []byte("hi"))))
+	buf := make([]byte, len(message)+len(pmessage))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if !bytes.Contains(buf, []byte(message)) || !bytes.Contains(buf, []byte(pmessage)) {
+		t.Fatalf("inconsistent routed messages ( %q ).", buf)
+	}
+	expect("*1\r\n$11\r\nUNSUBSCRIBE\r\n", "*3\r\n$11\r\nunsubscribe\r\n$3\r\na/b\r\n:1\r\n")
+	expect("*1\r\n$12\r\nPUNSUBSCRIBE\r\n", "*3\r\n$12\r\npunsubscribe\r\n$3\r\na/*\r\n:0\r\n")
+	expect("*3\r\n$7\r\nPUBLISH\r\n$3\r\na/b\r\n$2\r\nhi\r\n", ":0\r\n")
+	expect("QUIT\r\n", "+OK\r\n")
+	if _, err := client.Read(buf); err != io.EOF {
+		t.Fatal("expected closed connection.", err)
+	}
+}
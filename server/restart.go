@@ -0,0 +1,594 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+	"github.com/mitghi/protox/protocol/packet"
+)
+
+// Environment of a process started by `Restart`.
+const (
+	// RestartListenersEnv lists sockets inherited from the parent
+	// process, the n-th socket is file descriptor 3+n.
+	RestartListenersEnv = "PROTOX_LISTENERS"
+	// RestartSnapshotEnv is the path of the session snapshot
+	// written by the parent process.
+	RestartSnapshotEnv = "PROTOX_SNAPSHOT"
+	// RestartDeadlineEnv is the time until which the new process
+	// waits for the session snapshot.
+	RestartDeadlineEnv = "PROTOX_SNAPSHOT_DEADLINE"
+)
+
+// snapshotDir is the prefix of private directories holding the
+// session snapshot during a restart.
+const snapshotDir = "protox-restart-"
+
+// filer is implemented by sockets which can be handed over.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// snapshot is the session state handed over to a restarted process.
+type snapshot struct {
+	Sessions []snapshotSession `json:"sessions"`
+	Queues   []snapshotQueue   `json:"queues,omitempty"`
+	Retained []snapshotRetain  `json:"retained,omitempty"`
+	Wills    []snapshotWill    `json:"wills,omitempty"`
+}
+
+// snapshotSession is a client session and its pending packets.
+type snapshotSession struct {
+	ClientId string            `json:"clientId"`
+	Token    string            `json:"token"`
+	Topics   map[string]byte   `json:"topics"`
+	Outbound []snapshotMessage `json:"outbound,omitempty"`
+	Inbound  []uint16          `json:"inbound,omitempty"` // ids of QoS 2 publishes awaiting release
+}
+
+// snapshotMessage is an encoded outbound packet.
+type snapshotMessage struct {
+	Data      []byte    `json:"data"`
+	Wide      bool      `json:"wide,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Attempts  int       `json:"attempts,omitempty"`
+}
+
+// snapshotQueue is a named queue and its pending messages.
+type snapshotQueue struct {
+	Address   string           `json:"address"`
+	Consumers []string         `json:"consumers,omitempty"`
+	Messages  []snapshotQueued `json:"messages,omitempty"`
+}
+
+// snapshotQueued is a message waiting in a queue.
+type snapshotQueued struct {
+	ReturnPath string `json:"returnPath,omitempty"`
+	Mark       []byte `json:"mark,omitempty"`
+	Message    []byte `json:"message,omitempty"`
+}
+
+// snapshotRetain is a retained message of a topic.
+type snapshotRetain struct {
+	Topic     string               `json:"topic"`
+	Message   []byte               `json:"message"`
+	QoS       byte                 `json:"qos,omitempty"`
+	Props     protobase.Properties `json:"props,omitempty"`
+	ExpiresAt time.Time            `json:"expiresAt,omitempty"`
+}
+
+// snapshotWill is a delayed last will awaiting its deadline.
+type snapshotWill struct {
+	ClientId    string               `json:"clientId"`
+	Topic       string               `json:"topic"`
+	Message     []byte               `json:"message,omitempty"`
+	QoS         byte                 `json:"qos,omitempty"`
+	Retain      bool                 `json:"retain,omitempty"`
+	RetainTTL   uint32               `json:"retainTTL,omitempty"`
+	Props       protobase.Properties `json:"props,omitempty"`
+	Compression byte                 `json:"compression,omitempty"`
+	At          time.Time            `json:"at"`
+}
+
+// inherited holds sockets handed over by a parent process, it is
+// read from the environment once.
+var inherited struct {
+	sync.Mutex
+	once  sync.Once
+	files map[string]*os.File
+}
+
+// inheritedFile returns the socket inherited for `key`, or nil.
+func inheritedFile(key string) *os.File {
+	inherited.once.Do(func() {
+		inherited.files = make(map[string]*os.File)
+		env := os.Getenv(RestartListenersEnv)
+		if env == "" {
+			return
+		}
+		os.Unsetenv(RestartListenersEnv)
+		for i, k := range strings.Split(env, ",") {
+			inherited.files[k] = os.NewFile(uintptr(3+i), k)
+		}
+	})
+	inherited.Lock()
+	defer inherited.Unlock()
+	f := inherited.files[key]
+	delete(inherited.files, key)
+	return f
+}
+
+// socket returns the listener inherited for `network` and `address`,
+// or listens by `open`. Listeners are recorded for a later handoff.
+func (s *Server) socket(network string, address string, open func() (net.Listener, error)) (listener net.Listener, err error) {
+	const fn = "socket"
+	key := network + "://" + address
+	if f := inheritedFile(key); f != nil {
+		listener, err = net.FileListener(f)
+		f.Close()
+		logger.FDebugf(fn, "* [Restart] inherited listener (%s).", key)
+	} else {
+		listener, err = open()
+	}
+	if err == nil {
+		s.addSocket(key, listener)
+	}
+	return listener, err
+}
+
+// listenPacket returns the udp socket inherited for `address`, or
+// listens on it. It is recorded for a later handoff.
+func (s *Server) listenPacket(address string) (pc net.PacketConn, err error) {
+	key := "udp://" + address
+	if f := inheritedFile(key); f != nil {
+		pc, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		pc, err = net.ListenPacket("udp", address)
+	}
+	if err == nil {
+		s.addSocket(key, pc)
+	}
+	return pc, err
+}
+
+// addSocket records socket `sock` under `key`.
+func (s *Server) addSocket(key string, sock interface{}) {
+	f, ok := sock.(filer)
+	if !ok {
+		return
+	}
+	s.Lock()
+	s.sockets[key] = f
+	s.Unlock()
+}
+
+// socketFiles duplicates file descriptors of recorded sockets. Unix
+// sockets are not unlinked when closed by this process anymore.
+func (s *Server) socketFiles() (keys []string, files []*os.File, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	for key, sock := range s.sockets {
+		if ul, ok := sock.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		f, err := sock.File()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, err
+		}
+		keys, files = append(keys, key), append(files, f)
+	}
+	return keys, files, nil
+}
+
+// SetDrainTimeout sets the time existing connections are given to
+// finish in-flight deliveries on restart.
+func (s *Server) SetDrainTimeout(timeout time.Duration) {
+	s.drainTimeout = timeout
+}
+
+// GetDrainTimeout returns the time existing connections are drained
+// for on restart.
+func (s *Server) GetDrainTimeout() time.Duration {
+	return s.drainTimeout
+}
+
+// Restart performs a graceful binary upgrade. Listening sockets are
+// handed over to a new process running the same executable right
+// away, incoming connections wait in their backlog until it has loaded
+// session state. This server stops accepting connections, drains
+// existing ones ( see `drain` ) and writes session state for the new
+// process to load. The returned channel is signaled once session state
+// is handed over.
+func (s *Server) Restart() (<-chan struct{}, error) {
+	const fn = "Restart"
+	if s.GetStatus() != protobase.ServerRunning {
+		return nil, SRVRestartError
+	}
+	keys, files, err := s.socketFiles()
+	if err != nil {
+		logger.FError(fn, "- [Restart] cannot duplicate listening sockets.", err)
+		return nil, err
+	}
+	// the snapshot is handed over through a directory only
+	// accessible by this user.
+	dir, err := os.MkdirTemp("", snapshotDir)
+	if err != nil {
+		for _, f := range files {
+			f.Close()
+		}
+		logger.FError(fn, "- [Restart] cannot create snapshot directory.", err)
+		return nil, err
+	}
+	var (
+		ch       chan struct{} = make(chan struct{}, 1)
+		path     string        = filepath.Join(dir, "snapshot")
+		deadline time.Time     = time.Now().Add(s.drainTimeout + DefaultSnapshotGrace)
+	)
+	err = spawn(keys, files, path, deadline)
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		logger.FError(fn, "- [Restart] cannot start new process.", err)
+		return nil, err
+	}
+	s.SetStatus(protobase.Restart)
+	go func() {
+		tick := time.NewTicker(time.Millisecond * 500)
+		defer tick.Stop()
+		for _ = range tick.C {
+			if stat := s.GetStatus(); stat == protobase.ServerStopped {
+				break
+			}
+		}
+		if err := s.WriteSnapshot(path); err != nil {
+			logger.FError(fn, "- [Restart] cannot write session snapshot.", err)
+		}
+		ch <- struct{}{}
+	}()
+	return ch, nil
+}
+
+// drain disconnects online clients once they have no unacknowledged
+// outbound packets, clients still busy after the drain timeout are
+// disconnected regardless.
+func (s *Server) drain() {
+	const fn = "drain"
+	var (
+		deadline time.Time    = time.Now().Add(s.drainTimeout)
+		tick     *time.Ticker = time.NewTicker(DefaultDrainInterval)
+	)
+	defer tick.Stop()
+	for busy := s.disconnectIdle(); busy > 0; busy = s.disconnectIdle() {
+		if time.Now().After(deadline) {
+			logger.FDebugf(fn, "- [Restart] drain timeout reached with (%d) busy clients.", busy)
+			return
+		}
+		<-tick.C
+	}
+}
+
+// disconnectIdle disconnects online clients without unacknowledged
+// outbound packets and returns the number of remaining ones.
+func (s *Server) disconnectIdle() (busy int) {
+	s.State.RLock()
+	defer s.State.RUnlock()
+	for clid, cl := range s.State.clients {
+		if cl.Status() != protobase.STATONLINE {
+			continue
+		}
+		if s.Store != nil && len(s.Store.GetAllOut(clid)) > 0 {
+			busy++
+			continue
+		}
+		s.goDown(cl)
+	}
+	return busy
+}
+
+// restore loads the session snapshot handed over by a parent process.
+// The snapshot is awaited until the deadline set by the parent.
+func (s *Server) restore() {
+	const fn = "restore"
+	path := os.Getenv(RestartSnapshotEnv)
+	if path == "" {
+		return
+	}
+	deadline, err := time.Parse(time.RFC3339Nano, os.Getenv(RestartDeadlineEnv))
+	if err != nil {
+		deadline = time.Now()
+	}
+	os.Unsetenv(RestartSnapshotEnv)
+	os.Unsetenv(RestartDeadlineEnv)
+	for _, err = os.Lstat(path); err != nil && time.Now().Before(deadline); _, err = os.Lstat(path) {
+		time.Sleep(DefaultDrainInterval)
+	}
+	if err != nil {
+		logger.FError(fn, "- [Restart] session snapshot was not handed over.", err)
+		return
+	}
+	if err = s.LoadSnapshot(path); err != nil {
+		logger.FError(fn, "- [Restart] cannot load session snapshot.", err)
+	}
+	os.Remove(path)
+	if dir := filepath.Dir(path); strings.HasPrefix(filepath.Base(dir), snapshotDir) {
+		os.Remove(dir)
+	}
+}
+
+// WriteSnapshot writes sessions, their subscriptions, pending
+// outbound packets, reserved inbound message ids, queues, retained
+// messages and pending wills to `path`. It fails when the temporary
+// file next to `path` already exists.
+func (s *Server) WriteSnapshot(path string) error {
+	var snap snapshot
+	s.State.RLock()
+	for clid, ss := range s.State.sessions {
+		entry := snapshotSession{ClientId: clid, Token: ss.token, Topics: make(map[string]byte, len(ss.topics))}
+		for topic, qos := range ss.topics {
+			entry.Topics[topic] = qos
+		}
+		snap.Sessions = append(snap.Sessions, entry)
+	}
+	for clid, pw := range s.State.wills {
+		snap.Wills = append(snap.Wills, snapshotWillOf(clid, pw))
+	}
+	for clid, cl := range s.State.clients {
+		cl.RLock()
+		if cl.will != nil {
+			snap.Wills = append(snap.Wills, snapshotWillOf(clid, cl.will))
+		}
+		cl.RUnlock()
+	}
+	s.State.RUnlock()
+	for i := range snap.Sessions {
+		if s.Store == nil {
+			break
+		}
+		for _, p := range s.Store.GetAllOut(snap.Sessions[i].ClientId) {
+			if m, ok := snapshotOf(p); ok {
+				snap.Sessions[i].Outbound = append(snap.Sessions[i].Outbound, m)
+			}
+		}
+		if iidstore := s.Store.GetIDStoreI(snap.Sessions[i].ClientId); iidstore != nil {
+			snap.Sessions[i].Inbound = iidstore.Occupied()
+		}
+	}
+	s.queues.Lock()
+	for address, qu := range s.queues.m {
+		entry := snapshotQueue{Address: address, Consumers: qu.consumers}
+		for _, item := range qu.items() {
+			entry.Messages = append(entry.Messages, snapshotQueued{ReturnPath: item.returnPath, Mark: item.mark, Message: item.message})
+		}
+		snap.Queues = append(snap.Queues, entry)
+	}
+	s.queues.Unlock()
+	s.Router.EachRetained(func(p protobase.EDProtocol, expiry time.Time) {
+		if pb, ok := p.(*protocol.Publish); ok {
+			snap.Retained = append(snap.Retained, snapshotRetain{Topic: pb.Topic, Message: pb.Message, QoS: pb.Meta.Qos, Props: pb.Meta.Props, ExpiresAt: expiry})
+		}
+	})
+	data, err := json.Marshal(&snap)
+	if err != nil {
+		return err
+	}
+	// an existing file or link is never written through
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadSnapshot restores sessions, queues, retained messages and pending
+// wills written by `WriteSnapshot` to `path`. Clients resume sessions by
+// their session token.
+func (s *Server) LoadSnapshot(path string) error {
+	const fn = "LoadSnapshot"
+	var snap snapshot
+	f, err := openSnapshot(path)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	for _, entry := range snap.Sessions {
+		ss := &session{token: entry.Token, topics: make(map[string]byte, len(entry.Topics))}
+		for topic, qos := range entry.Topics {
+			ss.topics[topic] = qos
+			s.subscribe(entry.ClientId, topic, qos)
+		}
+		s.State.setSession(entry.ClientId, ss)
+		if s.Store == nil {
+			continue
+		}
+		s.Store.AddClient(entry.ClientId)
+		idstore := s.Store.GetIDStoreO(entry.ClientId)
+		for _, m := range entry.Outbound {
+			p, id, ok := m.decode()
+			if !ok {
+				logger.FDebugf(fn, "- [Restart] dropping malformed packet of client(%s).", entry.ClientId)
+				continue
+			}
+			s.Store.AddOutbound(entry.ClientId, p)
+			if idstore != nil {
+				idstore.Reserve(id, p.UUID())
+			}
+		}
+		// releases of inbound QoS 2 publishes are acknowledged
+		// and their retransmissions dismissed.
+		if iidstore := s.Store.GetIDStoreI(entry.ClientId); iidstore != nil {
+			for _, id := range entry.Inbound {
+				iidstore.Reserve(id, uuid.New())
+			}
+		}
+		logger.FDebugf(fn, "+ [Restart] restored session of client(%s) with (%d) subscriptions.", entry.ClientId, len(ss.topics))
+	}
+	s.queues.Lock()
+	for _, entry := range snap.Queues {
+		qu := newQueue()
+		qu.consumers = entry.Consumers
+		for _, m := range entry.Messages {
+			qu.insert(m.ReturnPath, m.Mark, m.Message)
+		}
+		s.queues.m[entry.Address] = qu
+	}
+	s.queues.Unlock()
+	now := time.Now()
+	for _, entry := range snap.Retained {
+		var ttl time.Duration
+		if !entry.ExpiresAt.IsZero() {
+			if ttl = entry.ExpiresAt.Sub(now); ttl <= 0 {
+				continue
+			}
+		}
+		rp := protocol.NewRawPublish()
+		rp.Topic = entry.Topic
+		rp.Message = entry.Message
+		rp.Meta.Qos = entry.QoS
+		rp.Meta.Ret = true
+		rp.Meta.Props = entry.Props
+		if err := s.Router.AddRetained(entry.Topic, rp, ttl); err != nil {
+			logger.FDebugf(fn, "- [Restart] unable to restore retained message of topic(%s). error: %s", entry.Topic, err)
+		}
+	}
+	for _, entry := range snap.Wills {
+		will := protocol.NewMsgBox(entry.QoS, 0, protobase.MDInbound, protocol.NewMsgEnvelope(entry.Topic, entry.Message))
+		will.SetRetain(entry.Retain, entry.RetainTTL)
+		will.SetProperties(entry.Props)
+		will.SetCompression(entry.Compression)
+		s.restoreWill(entry.ClientId, will, entry.At)
+	}
+	return nil
+}
+
+// restoreWill arms a timer which publishes `will` of client `clid` at
+// `at` unless the client connects in the meantime.
+func (s *Server) restoreWill(clid string, will protobase.MsgInterface, at time.Time) {
+	pw := &pendingWill{msg: will, at: at}
+	s.State.Lock()
+	defer s.State.Unlock()
+	pw.timer = time.AfterFunc(time.Until(at), func() {
+		s.State.Lock()
+		if s.State.wills[clid] != pw {
+			s.State.Unlock()
+			return
+		}
+		delete(s.State.wills, clid)
+		s.State.Unlock()
+		s.publishWill(clid, will)
+	})
+	s.State.wills[clid] = pw
+}
+
+// snapshotWillOf returns the snapshot of pending will `pw` of client
+// `clid`.
+func snapshotWillOf(clid string, pw *pendingWill) snapshotWill {
+	var (
+		msg protobase.MsgInterface         = pw.msg
+		env protobase.MsgEnvelopeInterface = msg.Envelope()
+	)
+	return snapshotWill{
+		ClientId:    clid,
+		Topic:       env.Route(),
+		Message:     env.Payload(),
+		QoS:         msg.QoS(),
+		Retain:      msg.Retain(),
+		RetainTTL:   msg.RetainTTL(),
+		Props:       msg.Properties(),
+		Compression: msg.Compression(),
+		At:          pw.at,
+	}
+}
+
+// snapshotOf returns the snapshot of publish and pubrel packets.
+func snapshotOf(p protobase.EDProtocol) (snapshotMessage, bool) {
+	switch pkt := p.(type) {
+	case *protocol.Publish:
+		if pkt.Encoded == nil {
+			return snapshotMessage{}, false
+		}
+		return snapshotMessage{Data: pkt.Encoded.Bytes(), Wide: pkt.Meta.WideLength, ExpiresAt: pkt.ExpiresAt, Attempts: pkt.Attempts}, true
+	case *protocol.Pubrel:
+		if pkt.Encoded == nil {
+			return snapshotMessage{}, false
+		}
+		return snapshotMessage{Data: pkt.Encoded.Bytes()}, true
+	}
+	return snapshotMessage{}, false
+}
+
+// decode returns the packet of the snapshot and its message id.
+func (m snapshotMessage) decode() (protobase.EDProtocol, uint16, bool) {
+	if len(m.Data) == 0 {
+		return nil, 0, false
+	}
+	pkt := packet.NewPacket(m.Data, m.Data[0], len(m.Data))
+	switch m.Data[0] & 0xF0 {
+	case protobase.CPUBLISH:
+		if p := protocol.NewPublishWide(pkt, m.Wide); p != nil {
+			p.ExpiresAt = m.ExpiresAt
+			p.Attempts = m.Attempts
+			p.Encoded = bytes.NewBuffer(m.Data)
+			return p, p.Meta.MessageId, true
+		}
+	case protobase.CPUBREL & 0xF0:
+		if p := protocol.NewPubrel(pkt); p != nil {
+			p.Encoded = bytes.NewBuffer(m.Data)
+			return p, p.Meta.MessageId, true
+		}
+	}
+	return nil, 0, false
+}
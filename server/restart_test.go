@@ -0,0 +1,245 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+func TestSnapshot(t *testing.T) {
+	var (
+		s    *Server = NewServer()
+		path string  = filepath.Join(t.TempDir(), "protox.snapshot")
+	)
+	s.SetMessageStore(messages.NewInitedMessageStore())
+	token, _ := s.OpenSession("device", "", false)
+	s.State.addTopic("device", "sensors/*", protobase.LQOS1)
+	pb := protocol.NewRawPublish()
+	pb.Topic, pb.Message = "sensors/temp", []byte("21.5")
+	pb.Meta.Qos, pb.Meta.WideLength = protobase.LQOS1, true
+	pb.ExpiresAt = time.Now().Add(time.Hour).Round(0)
+	pb.Attempts = 3
+	s.Store.AddOutbound("device", pb)
+	pb.Meta.MessageId = s.Store.GetIDStoreO("device").GetNewID(pb.UUID())
+	if err := pb.Encode(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	s.Store.GetIDStoreI("device").Reserve(42, pb.UUID())
+	s.queues.m["jobs"] = newQueue()
+	s.queues.m["jobs"].insert("replies", []byte("mark"), []byte("job"))
+	if err := s.WriteSnapshot(path); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	restored := NewServer()
+	restored.SetMessageStore(messages.NewInitedMessageStore())
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if sid, present := restored.OpenSession("device", token, false); !present || sid != token {
+		t.Fatal("expected resumed session.", sid, present)
+	}
+	if m, _ := restored.Router.Find("sensors/temp"); m["device"] != protobase.LQOS1 {
+		t.Fatal("inconsistent restored subscription.", m)
+	}
+	outbound := restored.Store.GetAllOut("device")
+	if len(outbound) != 1 {
+		t.Fatal("inconsistent number of restored packets.", len(outbound))
+	}
+	rpb, ok := outbound[0].(*protocol.Publish)
+	if !ok || rpb.Topic != pb.Topic || !bytes.Equal(rpb.Encoded.Bytes(), pb.Encoded.Bytes()) || !rpb.ExpiresAt.Equal(pb.ExpiresAt) || rpb.Attempts != pb.Attempts {
+		t.Fatal("inconsistent restored packet.", outbound[0])
+	}
+	if !restored.Store.GetIDStoreO("device").IsOccupied(pb.Meta.MessageId) {
+		t.Fatal("expected reserved message id.", pb.Meta.MessageId)
+	}
+	if !restored.Store.GetIDStoreI("device").IsOccupied(42) {
+		t.Fatal("expected reserved inbound message id.")
+	}
+	qu, ok := restored.queues.m["jobs"]
+	if !ok {
+		t.Fatal("expected restored queue.")
+	}
+	if items := qu.items(); len(items) != 1 || items[0].returnPath != "replies" || string(items[0].mark) != "mark" || string(items[0].message) != "job" {
+		t.Fatal("inconsistent restored queue.", items)
+	}
+}
+
+func TestSnapshotRetainedWills(t *testing.T) {
+	var (
+		s    *Server          = NewServer()
+		path string           = filepath.Join(t.TempDir(), "protox.snapshot")
+		tc   *testConn        = newTestConn(s, "sensor")
+		will *protocol.MsgBox = protocol.NewMsgBox(1, 0, protobase.MDInbound, protocol.NewMsgEnvelope("sensors/sensor/status", []byte("offline")))
+	)
+	rp := protocol.NewRawPublish()
+	rp.Topic, rp.Message, rp.Meta.Ret = "sensors/temp", []byte("21.5"), true
+	rp.Meta.Props = protobase.Properties{protobase.PROPExpiry: []byte{0, 0, 0, 60}}
+	s.Router.AddRetained(rp.Topic, rp, time.Hour)
+	tc.offline = true
+	conn := s.State.get("sensor")
+	conn.Lock()
+	s.deferWill(conn, will, time.Now().Add(time.Hour))
+	conn.Unlock()
+	defer conn.stopWill()
+	if err := s.WriteSnapshot(path); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	restored := NewServer()
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	ps := restored.Router.FindRetained("sensors/temp")
+	if len(ps) != 1 {
+		t.Fatal("expected restored retained message.", ps)
+	}
+	if rpb, ok := ps[0].(*protocol.Publish); !ok || string(rpb.Message) != "21.5" || len(rpb.Meta.Props[protobase.PROPExpiry]) != 4 {
+		t.Fatal("inconsistent restored retained message.", ps[0])
+	}
+	pw, ok := restored.State.wills["sensor"]
+	if !ok || pw.msg.Envelope().Route() != "sensors/sensor/status" || string(pw.msg.Envelope().Payload()) != "offline" {
+		t.Fatal("expected restored will.", pw)
+	}
+	if !restored.State.dropWill("sensor") {
+		t.Fatal("expected pending restored will.")
+	}
+}
+
+func TestSnapshotLink(t *testing.T) {
+	var (
+		s      *Server = NewServer()
+		dir    string  = t.TempDir()
+		path   string  = filepath.Join(dir, "protox.snapshot")
+		target string  = filepath.Join(dir, "target")
+	)
+	if err := os.WriteFile(target, []byte("{}"), 0600); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	// a planted temporary file is never written through
+	if err := os.Symlink(target, path+".tmp"); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if err := s.WriteSnapshot(path); err == nil {
+		t.Fatal("err==nil, expected refusal to write through link.")
+	}
+	if data, _ := os.ReadFile(target); string(data) != "{}" {
+		t.Fatal("link target is modified.", string(data))
+	}
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if err := s.LoadSnapshot(path); err == nil {
+		t.Fatal("err==nil, expected refusal to load through link.")
+	}
+}
+
+func TestSocketFiles(t *testing.T) {
+	s := NewServer()
+	l, err := s.serverInstance("127.0.0.1:0")
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	keys, files, err := s.socketFiles()
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if len(keys) != 1 || keys[0] != "tcp://127.0.0.1:0" {
+		t.Fatal("inconsistent socket keys.", keys)
+	}
+	// sockets outlive the listener they are duplicated from
+	addr := l.Addr().String()
+	l.Close()
+	inherited, err := net.FileListener(files[0])
+	files[0].Close()
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	defer inherited.Close()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	defer conn.Close()
+	if _, err = inherited.Accept(); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	var (
+		s    *Server = NewServer()
+		path string  = filepath.Join(t.TempDir(), "protox.snapshot")
+	)
+	s.SetMessageStore(messages.NewInitedMessageStore())
+	s.OpenSession("device", "", false)
+	os.Setenv(RestartSnapshotEnv, path)
+	os.Setenv(RestartDeadlineEnv, time.Now().Add(time.Second*5).Format(time.RFC3339Nano))
+	// the parent writes the snapshot once it has drained
+	go func() {
+		time.Sleep(DefaultDrainInterval * 2)
+		s.WriteSnapshot(path)
+	}()
+	restored := NewServer()
+	restored.SetMessageStore(messages.NewInitedMessageStore())
+	restored.restore()
+	if restored.State.getSession("device") == nil {
+		t.Fatal("expected session to be restored.")
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("expected snapshot to be removed.")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	var (
+		s    *Server = NewServer()
+		idle         = newTestConn(s, "idle")
+		busy         = newTestConn(s, "busy")
+	)
+	s.SetMessageStore(messages.NewInitedMessageStore())
+	s.Store.AddClient("busy")
+	pb := protocol.NewRawPublish()
+	pb.Topic, pb.Message = "sensors/temp", []byte("21.5")
+	s.Store.AddOutbound("busy", pb)
+	if n := s.disconnectIdle(); n != 1 {
+		t.Fatal("inconsistent number of busy clients.", n)
+	}
+	if idle.Connection.GetStatus() != protobase.STATGODOWN || busy.Connection.GetStatus() == protobase.STATGODOWN {
+		t.Fatal("expected only idle clients to be disconnected.")
+	}
+	// busy clients are disconnected after the drain timeout
+	s.SetDrainTimeout(DefaultDrainInterval)
+	begin := time.Now()
+	s.drain()
+	if elapsed := time.Since(begin); elapsed < DefaultDrainInterval {
+		t.Fatal("expected drain to wait for busy clients.", elapsed)
+	}
+}
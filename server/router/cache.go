@@ -176,8 +176,8 @@ func (sc *subcache) RemoveCacheLine(route string) {
 // the lock before using this receiver.
 func (sc *subcache) RemoveCacheLines(route string) {
 	for k, _ := range sc.cache {
-		if strs.Match(k, route, Sep, Wlcd) {
-			delete(sc.cache, route)
+		if strs.Match(route, k, Sep, Wlcd) {
+			delete(sc.cache, k)
 		}
 	}
 }
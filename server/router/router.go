@@ -35,9 +35,11 @@ package router
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/mitghi/protox/containers"
 	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/protobase"
 	"github.com/mitghi/protox/utils/strs"
 )
 
@@ -281,3 +283,50 @@ func (r *Router) FindRawSubscribers(topic string) (map[string][]*subscription, e
 	err := r.subs.searchPath([]byte(topic), Sep, callback)
 	return m, err
 }
+
+// - MARK: Retain section.
+
+// AddRetained stores `packet` as the retained message of `topic`.
+// It replaces the previous one and expires after `ttl` when
+// `ttl` is non-zero.
+func (r *Router) AddRetained(topic string, packet protobase.EDProtocol, ttl time.Duration) error {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.retain.InsertTTL([]byte(topic), packet, ttl)
+}
+
+// RemoveRetained clears the retained message of `topic`.
+func (r *Router) RemoveRetained(topic string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.retain.Remove([]byte(topic))
+}
+
+// FindRetained returns all retained messages matching `filter`.
+func (r *Router) FindRetained(filter string) []protobase.EDProtocol {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.retain.Match([]byte(filter))
+}
+
+// EachRetained calls `fn` with each retained message and its
+// expiry, a zero expiry never expires. `fn` must not modify
+// retained messages.
+func (r *Router) EachRetained(fn func(protobase.EDProtocol, time.Time)) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.retain.Walk(fn)
+}
+
+// PurgeRetained removes retained messages expired at `now` and
+// returns their count.
+func (r *Router) PurgeRetained(now time.Time) int {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.retain.Prune(now)
+}
@@ -33,7 +33,9 @@ package router
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/mitghi/protox/protocol"
 	"github.com/mitghi/protox/utils/strs"
 )
 
@@ -151,3 +153,32 @@ func TestCache(t *testing.T) {
 	r.subs.cache.CachePrintV()
 	r.subs.PrintV()
 }
+
+func TestRetained(t *testing.T) {
+	r := NewRouter()
+	for _, topic := range []string{"sensors/a/temp", "sensors/b/temp", "sensors/a/humidity"} {
+		p := protocol.NewRawPublish()
+		p.Topic, p.Message, p.Meta.Ret = topic, []byte(topic), true
+		if err := r.AddRetained(topic, p, 0); err != nil {
+			t.Fatal("err!=nil, expected nil", err)
+		}
+	}
+	if ps := r.FindRetained("sensors/*/temp"); len(ps) != 2 {
+		t.Fatal("len(ps)!=2, got", len(ps))
+	}
+	if ps := r.FindRetained("sensors/*"); len(ps) != 3 {
+		t.Fatal("len(ps)!=3, got", len(ps))
+	}
+	if err := r.RemoveRetained("sensors/a/temp"); err != nil {
+		t.Fatal("err!=nil, expected nil", err)
+	}
+	if ps := r.FindRetained("sensors/a/temp"); len(ps) != 0 {
+		t.Fatal("len(ps)!=0, got", len(ps))
+	}
+	if err := r.AddRetained("sensors/c/temp", protocol.NewRawPublish(), time.Millisecond); err != nil {
+		t.Fatal("err!=nil, expected nil", err)
+	}
+	if n := r.PurgeRetained(time.Now().Add(time.Second)); n != 1 {
+		t.Fatal("n!=1, got", n)
+	}
+}
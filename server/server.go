@@ -24,10 +24,13 @@ package server
 
 import (
 	"net"
+	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/mitghi/protox/messages"
 	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
 	"github.com/mitghi/protox/server/router"
 )
 
@@ -41,13 +44,26 @@ var (
 // customized by providing a handler function or delegating.
 func NewServer() (s *Server) {
 	s = &Server{
-		Clients:    make(map[net.Conn]protobase.ProtoConnection),
-		Status:     protobase.ServerNone,
-		StatusChan: make(chan uint32, 1),
-		State:      newServerState(0),
-		Router:     router.NewRouter(),
-		heartbeat:  DefaultHeartbeat,
-		critical:   make(chan struct{}, 1),
+		Clients:           make(map[net.Conn]protobase.ProtoConnection),
+		Status:            protobase.ServerNone,
+		StatusChan:        make(chan uint32, 1),
+		State:             newServerState(0),
+		Router:            router.NewRouter(),
+		heartbeat:         DefaultHeartbeat,
+		maxQoS:            protobase.MAXQoS,
+		critical:          make(chan struct{}, 1),
+		wsPath:            DefaultWSPath,
+		sinks:             make(map[string]sink),
+		sockets:           make(map[string]filer),
+		frontends:         make(map[ServerType]ServerConfigs),
+		queues:            newQueues(),
+		requests:          newRequests(),
+		shares:            newShares(),
+		requestTimeout:    DefaultRequestTimeout,
+		deadLetter:        DefaultDeadLetterTopic,
+		drainTimeout:      DefaultDrainTimeout,
+		retainAvailable:   true,
+		wildcardAvailable: true,
 	}
 	return s
 }
@@ -70,6 +86,16 @@ func (s *Server) GetStatus() uint32 {
 	return atomic.LoadUint32(&s.Status)
 }
 
+// isStopping returns whether the server is shutting down, restarting
+// or stopped.
+func (s *Server) isStopping() bool {
+	switch s.GetStatus() {
+	case protobase.ForceShutdown, protobase.Restart, protobase.ServerStopped:
+		return true
+	}
+	return false
+}
+
 // GetStatusChan returns a channel containing the server status.
 // It is used for reterieving initial status.
 func (s *Server) GetStatusChan() <-chan uint32 {
@@ -108,6 +134,91 @@ func (s *Server) SetPermissionDelegate(pd func(protobase.AuthInterface, ...strin
 	s.permissionDelegate = pd
 }
 
+// SetMaxPacketSize sets the maximum size of a packet accepted from
+// clients. Connections sending larger packets are terminated. Zero
+// disables the limit.
+func (s *Server) SetMaxPacketSize(size uint32) {
+	s.maxPacketSize = size
+}
+
+// SetMaxQoS sets the maximum Quality of Service level accepted from
+// clients. It is advertised to clients on connection.
+func (s *Server) SetMaxQoS(qos byte) {
+	s.maxQoS = qos
+}
+
+// SetReceiveMaximum sets the maximum number of unacknowledged QoS>0
+// publishes a client may have in flight. Zero disables the limit.
+func (s *Server) SetReceiveMaximum(n uint16) {
+	s.receiveMax = n
+}
+
+// SetRetainAvailable sets whether retained messages are supported.
+// It is advertised to clients on connection.
+func (s *Server) SetRetainAvailable(available bool) {
+	s.retainAvailable = available
+}
+
+// SetWildcardAvailable sets whether wildcard subscriptions are
+// supported. It is advertised to clients on connection.
+func (s *Server) SetWildcardAvailable(available bool) {
+	s.wildcardAvailable = available
+}
+
+// SetMessageExpiry sets the default lifetime of messages published
+// without an expiry. Zero keeps such messages forever.
+func (s *Server) SetMessageExpiry(expiry time.Duration) {
+	s.messageExpiry = expiry
+}
+
+// SetRequestTimeout sets how long a request waits for its reply
+// before its return path is released.
+func (s *Server) SetRequestTimeout(timeout time.Duration) {
+	s.requestTimeout = timeout
+}
+
+// SetVersions sets the protocol versions accepted from clients in
+// ascending order, nil accepts `protobase.ProtoVersions`.
+func (s *Server) SetVersions(versions []string) {
+	s.versions = versions
+}
+
+// SetCompression sets the payload compression codecs negotiable
+// with clients, nil disables compression.
+func (s *Server) SetCompression(codecs []byte) {
+	s.codecs = codecs
+}
+
+// SetCompressionOptOut sets topic filters whose messages are always
+// delivered uncompressed.
+func (s *Server) SetCompressionOptOut(filters ...string) {
+	s.noCompress = filters
+}
+
+// AddListener adds a listener served by `ServeTCP` alongside its
+// address. Each listener has its own transport options and heartbeat
+// while router and session state are shared. It must be called
+// before serving.
+func (s *Server) AddListener(opts ServerConfigs) error {
+	if err := precheckOpts(&opts); err != nil {
+		return err
+	}
+	s.extra = append(s.extra, opts)
+	return nil
+}
+
+// SetFrontend sets the listener configuration of frontend `typ` ( e.g.
+// `ProtoWS` ), `opts.Addr` is replaced by the address the frontend is
+// served on. Frontends without configuration listen on plain TCP.
+func (s *Server) SetFrontend(typ ServerType, opts ServerConfigs) {
+	s.frontends[typ] = opts
+}
+
+// SetWSPath sets the HTTP path upgraded to WebSocket connections.
+func (s *Server) SetWSPath(path string) {
+	s.wsPath = path
+}
+
 // SetHeartBeat sets the maximum tolerable time ( heartbeat ) in which not
 // receiving packets from a client does not cause connection termination.
 func (s *Server) SetHeartBeat(heartbeat int) {
@@ -135,10 +246,32 @@ func (s *Server) Redeliver(prc protobase.ProtoConnection) {
 	if c := s.State.get(clid); c != nil {
 		logger.FDebugf(fn, "+ [Redeliver] Starting packet redelivery for client(%s).", clid)
 		outbound = s.Store.GetAllOut(clid)
+		now := time.Now()
 		for _, p := range outbound {
+			ep, ok := p.(*protocol.Publish)
+			if ok && ep.Expired(now) {
+				logger.FDebugf(fn, "- [Redeliver] dropping expired packet MessageId(%d) of client(%s).", ep.Meta.MessageId, clid)
+				s.deadLetterOut(clid, ep, DeadLetterExpired)
+				continue
+			}
+			if ok && s.maxAttempts > 0 && ep.Attempts >= s.maxAttempts {
+				logger.FDebugf(fn, "- [Redeliver] packet MessageId(%d) of client(%s) reached (%d) attempts.", ep.Meta.MessageId, clid, ep.Attempts)
+				s.deadLetterOut(clid, ep, DeadLetterAttempts)
+				continue
+			}
 			logger.FDebugf(fn, "+ [Redeliver] client(%s) has (%+v) packet.", clid, p)
 			if prc.GetStatus() == protobase.STATONLINE {
-				prc.SendRedelivery(p)
+				if !ok {
+					prc.SendRedelivery(p)
+					continue
+				}
+				ep.Attempts++
+				if err := prc.SendRedelivery(p); err == protocol.MessageExpired {
+					s.deadLetterOut(clid, ep, DeadLetterExpired)
+				} else if err != nil {
+					logger.FDebugf(fn, "- [Redeliver] unable to redeliver packet MessageId(%d) of client(%s). error: %s", ep.Meta.MessageId, clid, err)
+					s.deadLetterOut(clid, ep, DeadLetterRejected)
+				}
 			}
 		}
 	}
@@ -162,7 +295,7 @@ func (s *Server) Shutdown() (<-chan struct{}, error) {
 	const fn = "Shutdown"
 	stat := s.GetStatus()
 	switch stat {
-	case protobase.ServerNone, protobase.ServerStopped, protobase.ForceShutdown:
+	case protobase.ServerNone, protobase.ServerStopped, protobase.ForceShutdown, protobase.Restart:
 		if stat == protobase.ForceShutdown {
 			logger.FDebug(fn, "- [Server] already in force-shutdown state, dismissing request....")
 		}
@@ -201,23 +334,37 @@ func (s *Server) NotifyConnected(prc protobase.ProtoConnection) {
 		c    *connection
 	)
 
-	logger.Infof("+ [Server] Client(%s) passed [Genesis] state and is now [Online].", clid)
+	logger.Infof("+ [Server] Client(%s) from (%s) passed [Genesis] state and is now [Online].", clid, conn.RemoteAddr())
 
 	if c = s.State.get(cl.GetIdentifier()); c != nil {
 		logger.FDebugf(fn, "* [Client] client (%s) already exists.", clid)
 		c.Lock()
+		if c.stopWill() {
+			logger.FDebugf(fn, "* [Will] client (%s) reconnected, pending will is discarded.", clid)
+		}
 		c.setInfo(conn, prc, cl, nil, s.Authenticator)
 		c.update()
 		c.Inc(CLConnected)
 		c.Unlock()
 
 		s.Redeliver(prc)
+		s.resumeQueues(clid)
 
 	} else {
 		c = newConnection(STCLIENT, clid, conn, true, true)
 		c.setInfo(conn, prc, cl, nil, s.Authenticator)
+		c.Started()
 		c.Inc(CLConnected)
 		s.State.set(clid, c)
+		if s.State.dropWill(clid) {
+			logger.FDebugf(fn, "* [Will] client (%s) reconnected, restored will is discarded.", clid)
+		}
+		// sessions restored from a snapshot have no connection
+		// state, their queued packets are redelivered here.
+		if s.Store != nil {
+			s.Redeliver(prc)
+		}
+		s.resumeQueues(clid)
 	}
 }
 
@@ -238,7 +385,77 @@ func (s *Server) NotifySubscribe(prc protobase.ProtoConnection, msg protobase.Ms
 	)
 	logger.FDebugf(fn, "+ [Client][Layer] client(%s) attached to stream of (%s) with QoS(%d).", clid, topic, int(qos))
 	logger.Infof("+ [Subscription][Server] Client(%s) subscribed to stream (%s) with QoS(%d).", clid, topic, int(qos))
-	s.Router.Add(clid, topic, qos)
+	s.subscribe(clid, topic, qos)
+	s.State.addTopic(clid, topic, qos)
+	if _, _, ok := protocol.ParseShare(topic); ok {
+		// retained messages are not replayed to groups
+		return
+	}
+	// replay retained messages matching the subscription
+	for _, p := range s.Router.FindRetained(topic) {
+		rp, ok := p.(*protocol.Publish)
+		if !ok {
+			continue
+		}
+		rmsg := protocol.NewMsgBox(rp.Meta.Qos, 0, protobase.MDOutbound, protocol.NewMsgEnvelope(rp.Topic, rp.Message))
+		rmsg.SetRetain(true, 0)
+		rmsg.SetProperties(rp.Meta.Props.Clone())
+		rmsg.SetWishQoS(qos)
+		emsg, err := s.encodeFor(prc, rmsg, map[byte]protobase.MsgInterface{})
+		if err != nil {
+			logger.FDebugf(fn, "- [Retain] unable to encode retained message of topic(%s). error: %s", rp.Topic, err)
+			continue
+		}
+		logger.FDebugf(fn, "+ [Retain] sending retained message of topic(%s) to client(%s).", rp.Topic, clid)
+		prc.SendMessage(emsg, false)
+	}
+}
+
+// NotifyUnsubscribe is a delegate routine that removes client subscriptions. It
+// is only accessible above `Genesis` stage and is invoked after permissions
+// are checked.
+func (s *Server) NotifyUnsubscribe(prc protobase.ProtoConnection, msg protobase.MsgInterface) {
+	const fn = "NotifyUnsubscribe"
+	var (
+		clid  string = prc.GetClient().GetIdentifier()
+		topic string = msg.Envelope().Route()
+	)
+	logger.Infof("+ [Subscription][Server] Client(%s) unsubscribed from stream (%s).", clid, topic)
+	if err := s.unsubscribe(clid, topic); err != nil {
+		logger.FDebugf(fn, "- [Router] unable to remove subscription (%s) of client(%s). error: %s", topic, clid, err)
+	}
+	s.State.removeTopic(clid, topic)
+}
+
+// OpenSession resumes the session of client `clid` unless `clean` is
+// set or `token` does not match the issued one. Clients without a token
+// keep their state across reconnects. A discarded session drops its
+// subscriptions and queued messages and a new token is issued. It
+// returns the session token and whether a session was resumed.
+func (s *Server) OpenSession(clid string, token string, clean bool) (string, bool) {
+	const fn = "OpenSession"
+	ss := s.State.getSession(clid)
+	if ss != nil && !clean && (token == "" || ss.token == token) {
+		logger.FDebugf(fn, "+ [Session] resuming session of client(%s) with (%d) subscriptions.", clid, len(ss.topics))
+		return ss.token, true
+	}
+	if ss != nil {
+		logger.FDebugf(fn, "* [Session] discarding previous session of client(%s).", clid)
+		s.queues.detach(clid)
+		s.releaseQueues(clid)
+		for topic := range ss.topics {
+			if err := s.unsubscribe(clid, topic); err != nil {
+				logger.FDebugf(fn, "- [Router] unable to remove subscription (%s) of client(%s). error: %s", topic, clid, err)
+			}
+		}
+	}
+	if s.Store != nil && (clean || ss != nil || !s.Store.Exists(clid)) {
+		// drop queued packets
+		s.Store.AddClient(clid)
+	}
+	ss = newSession()
+	s.State.setSession(clid, ss)
+	return ss.token, false
 }
 
 // NotifyPublish sends messages from publishers to subscribers. A compatible
@@ -251,8 +468,33 @@ func (s *Server) NotifyPublish(prc protobase.ProtoConnection, msg protobase.MsgI
 		topic   string = msg.Envelope().Route()
 		message []byte = msg.Envelope().Payload()
 	)
+	if msg.Expiry() == 0 && s.messageExpiry > 0 {
+		msg.SetExpiry(uint32(s.messageExpiry / time.Second))
+	}
+	if msg.Expired() {
+		logger.FDebugf(fn, "- [Expiry] message on topic(%s) is expired, dropping.", topic)
+		return
+	}
+	if msg.Retain() && s.retainAvailable {
+		s.retainMessage(msg)
+	}
+	// payload encodings, each is computed once
+	encoded := map[byte]protobase.MsgInterface{msg.Compression(): msg}
 	m, _ := s.Router.Find(topic)
 	for k, wqos := range m {
+		if sk := s.getSink(k); sk != nil {
+			// server local subscribers receive plain payloads
+			if emsg, err := s.encode(protobase.COMPNone, msg, encoded); err == nil {
+				sk(emsg)
+			}
+			continue
+		}
+		if strings.HasPrefix(k, SharePrefix) {
+			// one member of the group receives the message
+			if k, wqos = s.pickShare(k, ""); k == "" {
+				continue
+			}
+		}
 		cl := s.State.get(k)
 		logger.FDebug(fn, "* [Publish] client found.", cl)
 		if cl != nil {
@@ -263,26 +505,161 @@ func (s *Server) NotifyPublish(prc protobase.ProtoConnection, msg protobase.MsgI
 			if cl.proto == prc {
 				logger.FDebug(fn, "? [Publish] cl.proto==prc ? ", "userId", clid)
 			}
-			prclid := prc.GetClient().GetIdentifier()
+			// publisher is nil for messages originated by the server
+			prclid := "server"
+			if prc != nil {
+				prclid = prc.GetClient().GetIdentifier()
+			}
 			if wqos == 0 && cl.proto.GetStatus() != protobase.STATONLINE {
 				continue
 			}
 			logger.FDebugf(fn, "+ [Publish] prc(%s) - client(%s) is [Online], sending message(%s) from route(%s), QoS(%d).",
 				prclid, clid, message, clid, msg.QoS())
-			npb := msg.Clone(protobase.MDOutbound)
+			emsg, err := s.encodeFor(cl.proto, msg, encoded)
+			if err != nil {
+				logger.FDebugf(fn, "- [Compression] unable to encode payload for client(%s). error: %s", clid, err)
+				s.sendDeadLetter(clid, msg, 0, DeadLetterRejected)
+				continue
+			}
+			npb := emsg.Clone(protobase.MDOutbound)
 			npb.SetWishQoS(wqos)
+			// retain flag is only set on messages replayed on subscription
+			npb.SetRetain(false, 0)
 			logger.Infof("+ [Publish     ] Routing Topic(%s)-> Message(%s) for Client(%s) [ WishQoS(%d), wqos(%d) ].", topic, message, clid, npb.QoS(), wqos)
 			// logger.Infof(fn, "+ [Publish     ] Routing Topic(%s)-> Message(%s) for Client(%s) with QoS(%d).", topic, message, clid, npb.QoS())
-			cl.proto.SendMessage(npb, cl.proto == prc)
+			if err := cl.proto.SendMessage(npb, cl.proto == prc); err != nil {
+				logger.FDebugf(fn, "- [Publish] unable to send message to client(%s). error: %s", clid, err)
+				s.sendDeadLetter(clid, msg, 0, DeadLetterRejected)
+				continue
+			}
 			user.Publish(npb)
 		}
 	}
 	return
 }
 
-func (s *Server) NotifyQueue(prc protobase.ProtoConnection, msg protobase.MsgInterface) {
-	const fn = "NotifyQueue"
-	logger.FInfo(fn, "+ [Server][Queue    ] message received.")
+// encodeFor returns `msg` with its payload encoded using the codec
+// negotiated by `prc`, or uncompressed when the topic opted out.
+// Encodings are cached in `encoded` by codec.
+func (s *Server) encodeFor(prc protobase.ProtoConnection, msg protobase.MsgInterface, encoded map[byte]protobase.MsgInterface) (protobase.MsgInterface, error) {
+	codec := prc.Compression()
+	if codec != protobase.COMPNone && s.compressionOptOut(msg.Envelope().Route()) {
+		codec = protobase.COMPNone
+	}
+	return s.encode(codec, msg, encoded)
+}
+
+// encode returns `msg` with its payload encoded using `codec`.
+// Encodings are cached in `encoded` by codec.
+func (s *Server) encode(codec byte, msg protobase.MsgInterface, encoded map[byte]protobase.MsgInterface) (protobase.MsgInterface, error) {
+	if emsg, ok := encoded[codec]; ok {
+		return emsg, nil
+	}
+	emsg, err := protocol.Recode(msg, codec)
+	if err != nil {
+		return nil, err
+	}
+	encoded[codec] = emsg
+	return emsg, nil
+}
+
+// compressionOptOut returns whether messages of `topic` must be
+// delivered uncompressed.
+func (s *Server) compressionOptOut(topic string) bool {
+	for _, filter := range s.noCompress {
+		if topicMatch(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// topicMatch returns whether `topic` matches `filter`. A wildcard
+// matches a single level, or all remaining levels when trailing.
+func topicMatch(filter string, topic string) bool {
+	var (
+		fl []string = strings.Split(filter, string(messages.TSEP))
+		tl []string = strings.Split(topic, string(messages.TSEP))
+		wc string   = string(messages.TWLDCD)
+	)
+	for i, f := range fl {
+		if f == wc && i == len(fl)-1 {
+			return len(tl) >= len(fl)
+		}
+		if i >= len(tl) || (f != wc && f != tl[i]) {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+// retainMessage stores `msg` as the retained message of its topic,
+// or clears the retained message when payload is empty.
+func (s *Server) retainMessage(msg protobase.MsgInterface) {
+	const fn = "retainMessage"
+	var (
+		topic   string = msg.Envelope().Route()
+		message []byte = msg.Envelope().Payload()
+	)
+	if len(message) == 0 {
+		if err := s.Router.RemoveRetained(topic); err != nil {
+			logger.FDebugf(fn, "- [Retain] unable to clear retained message of topic(%s). error: %s", topic, err)
+		}
+		return
+	}
+	// retained payloads are kept uncompressed
+	if msg.Compression() != protobase.COMPNone {
+		plain, err := protocol.Recode(msg, protobase.COMPNone)
+		if err != nil {
+			logger.FDebugf(fn, "- [Retain] unable to decompress retained message of topic(%s). error: %s", topic, err)
+			return
+		}
+		message = plain.Envelope().Payload()
+	}
+	rp := protocol.NewRawPublish()
+	rp.Topic = topic
+	rp.Message = message
+	rp.Meta.Qos = msg.QoS()
+	rp.Meta.Ret = true
+	rp.Meta.Props = msg.Properties().Clone()
+	ttl := time.Duration(msg.RetainTTL()) * time.Second
+	if err := s.Router.AddRetained(topic, rp, ttl); err != nil {
+		logger.FDebugf(fn, "- [Retain] unable to store retained message of topic(%s). error: %s", topic, err)
+	}
+}
+
+// expirySweeper periodically purges expired messages from the
+// message store and the router until the server stops.
+func (s *Server) expirySweeper() {
+	ticker := time.NewTicker(DefaultSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		switch s.GetStatus() {
+		case protobase.ForceShutdown, protobase.Restart, protobase.ServerStopped:
+			return
+		}
+		s.purgeExpired(time.Now())
+	}
+}
+
+// purgeExpired removes messages expired at `now`, dead-letters
+// them and returns their count. Expired retained messages are
+// dropped as well.
+func (s *Server) purgeExpired(now time.Time) (n int) {
+	const fn = "purgeExpired"
+	if r := s.Router.PurgeRetained(now); r > 0 {
+		logger.FDebugf(fn, "- [Expiry] purged (%d) expired retained messages.", r)
+	}
+	for clid, msgs := range s.Store.PurgeExpired(now) {
+		logger.FDebugf(fn, "- [Expiry] purged (%d) expired messages of client(%s).", len(msgs), clid)
+		for _, p := range msgs {
+			if pb, ok := p.(*protocol.Publish); ok {
+				s.sendDeadLetter(clid, messageOf(pb), pb.Attempts, DeadLetterExpired)
+			}
+		}
+		n += len(msgs)
+	}
+	return n
 }
 
 // Setup is for prechecks before running the server. It must crash
@@ -318,18 +695,7 @@ func (s *Server) disconnectAll() {
 		s.State.RLock()
 		for _, v := range s.State.clients {
 			if stat := v.Status(); stat == protobase.STATONLINE {
-				logger.FDebug(fn, "- [STATCONNECTED] setting godown flag for [CLIENT].", "userId", v.uid)
-				sent, recv, connect, disconnect, reject, fault := v.Statics()
-				logger.FDebug(fn, "- [STATCONNECTED] stats for [CLIENT].", "stats", "userId",
-					v.uid, "stats", sent, recv, connect, disconnect, reject, fault)
-				v.proto.SetStatus(protobase.STATGODOWN)
-				errch := v.proto.GetErrChan()
-				// send notification to client's err chan,
-				// drop quitely if chan is closed
-				select {
-				case errch <- struct{}{}:
-				default:
-				}
+				s.goDown(v)
 			} else {
 				logger.FDebugf(fn, "- [Status=%d] client is not connected.", int(stat))
 			}
@@ -339,6 +705,23 @@ func (s *Server) disconnectAll() {
 	}
 }
 
+// goDown sets the godown flag for the connection of client `v`.
+func (s *Server) goDown(v *connection) {
+	const fn = "goDown"
+	logger.FDebug(fn, "- [STATCONNECTED] setting godown flag for [CLIENT].", "userId", v.uid)
+	sent, recv, connect, disconnect, reject, fault := v.Statics()
+	logger.FDebug(fn, "- [STATCONNECTED] stats for [CLIENT].", "stats", "userId",
+		v.uid, "stats", sent, recv, connect, disconnect, reject, fault)
+	v.proto.SetStatus(protobase.STATGODOWN)
+	errch := v.proto.GetErrChan()
+	// send notification to client's err chan,
+	// drop quitely if chan is closed
+	select {
+	case errch <- struct{}{}:
+	default:
+	}
+}
+
 // NotifyRejected notifies the server that the connection is
 // rejected ( invalid creds, ban , ..... ).
 func (s *Server) NotifyReject(prc protobase.ProtoConnection) {
@@ -412,6 +795,9 @@ func (s *Server) NotifyDisconnected(prc protobase.ProtoConnection) {
 		conn.conn = nil
 		conn.Ended()
 		conn.Inc(CLDisconnected)
+		if !isGoingDown {
+			s.scheduleWill(prc, conn)
+		}
 		conn.Unlock()
 		/* critical section - end */
 		if isGoingDown {
@@ -420,14 +806,130 @@ func (s *Server) NotifyDisconnected(prc protobase.ProtoConnection) {
 			cl.Disconnected(protobase.PUForceTerminate)
 		}
 	}
+	s.reassignShared(clid)
+	s.releaseQueues(clid)
 	logger.Infof(fn, "- [Server  ] Client(%s) disconnected.", clid)
 	s.corous.Done()
 }
 
+// scheduleWill publishes the last will of `prc` immediately or after its
+// delay. A pending will is discarded when the client reconnects in time.
+// It must be called while holding `conn` lock.
+func (s *Server) scheduleWill(prc protobase.ProtoConnection, conn *connection) {
+	will, delay := prc.GetWill()
+	if will == nil {
+		return
+	}
+	if delay == 0 {
+		s.publishWill(conn.uid, will)
+		return
+	}
+	s.deferWill(conn, will, time.Now().Add(time.Duration(delay)*time.Second))
+}
+
+// deferWill arms a timer which publishes `will` at `at` unless the
+// client reconnected or the will was replaced in the meantime. It
+// must be called while holding `conn` lock.
+func (s *Server) deferWill(conn *connection, will protobase.MsgInterface, at time.Time) {
+	conn.stopWill()
+	pw := &pendingWill{msg: will, at: at}
+	pw.timer = time.AfterFunc(time.Until(at), func() {
+		conn.Lock()
+		if conn.will != pw || conn.isOnline() {
+			conn.Unlock()
+			return
+		}
+		conn.will = nil
+		conn.Unlock()
+		s.publishWill(conn.uid, will)
+	})
+	conn.will = pw
+}
+
+// publishWill checks publish permission of the client and routes its
+// last will to subscribers.
+func (s *Server) publishWill(clid string, will protobase.MsgInterface) {
+	const fn = "publishWill"
+	var (
+		topic string = will.Envelope().Route()
+	)
+	if s.GetStatus() != protobase.ServerRunning {
+		return
+	}
+	if !s.canPublish(clid, topic) {
+		logger.FDebugf(fn, "- [Will] client(%s) has no permission to publish will on topic(%s).", clid, topic)
+		return
+	}
+	logger.Infof("+ [Will] publishing will of Client(%s) on Topic(%s).", clid, topic)
+	s.NotifyPublish(nil, will)
+}
+
+// authenticate validates credentials of clients connecting through
+// frontends without a protocol connection. It returns an error when
+// the credentials are rejected or the client is banned.
+func (s *Server) authenticate(username string, password string, clid string) error {
+	const fn = "authenticate"
+	creds, err := s.Authenticator.MakeCreds(username, password, clid)
+	if err != nil {
+		logger.FDebug(fn, "- [Credentials] cannot make credentials.", err)
+		return SRVBadCredentials
+	}
+	if bs, ok := s.Authenticator.(protobase.BanInterface); ok && bs.IsBanned(clid) {
+		logger.FDebugf(fn, "- [Banned] client(%s) is banned.", clid)
+		return SRVBanned
+	}
+	if valid, _ := s.Authenticator.CanAuthenticate(creds); !valid {
+		return SRVBadCredentials
+	}
+	return nil
+}
+
+// canPublish returns whether client `clid` is allowed to publish
+// on `topic`.
+func (s *Server) canPublish(clid string, topic string) bool {
+	return s.hasPerm(clid, "publish", topic)
+}
+
+// canSubscribe returns whether client `clid` is allowed to subscribe
+// to `topic`. Subscriptions are unrestricted when authentication is
+// disabled.
+func (s *Server) canSubscribe(clid string, topic string) bool {
+	if s.Authenticator != nil && s.Authenticator.GetMode() == protobase.AUTHModeNone {
+		return true
+	}
+	return s.hasPerm(clid, "subscribe", topic)
+}
+
+// hasPerm returns whether client `clid` is allowed to perform
+// `action` on `topic`.
+func (s *Server) hasPerm(clid string, action string, topic string) bool {
+	if s.permissionDelegate != nil {
+		return s.permissionDelegate(s.Authenticator, "can", action, topic)
+	}
+	if s.Authenticator == nil {
+		return false
+	}
+	userType, err := s.Authenticator.GetUserType(clid)
+	if err != nil {
+		return false
+	}
+	role := s.Authenticator.GetACL().GetRole((string)(userType))
+	if role == nil {
+		return false
+	}
+	return role.HasPerm("can", action, topic)
+}
+
 // HandleIncomingConnection uses delegate functions to build and run new connection.
 // It also passes all the necessary informations such as certain delegate function to
 // a compatible `protocol.ProtoConnection` struct ( made by using delegates ).
 func (s *Server) handleIncomingConnection(conn net.Conn) {
+	s.handleConnection(conn, s.heartbeat)
+}
+
+// handleConnection is `handleIncomingConnection` with the heartbeat
+// of the accepting listener.
+func (s *Server) handleConnection(conn net.Conn, heartbeat int) {
 	if s.onNewConnection == nil {
 		panic("protox: no NewConnection handler is specified.")
 	}
@@ -438,7 +940,13 @@ func (s *Server) handleIncomingConnection(conn net.Conn) {
 	newConnection.SetServer(s)
 	newConnection.SetClientDelegate(s.onNewClient)
 	newConnection.SetMessageStorage(s.Store)
-	newConnection.SetHeartBeat(s.heartbeat)
+	newConnection.SetHeartBeat(heartbeat)
+	newConnection.SetMaxPacketSize(s.maxPacketSize)
+	newConnection.SetMaxQoS(s.maxQoS)
+	newConnection.SetReceiveMaximum(s.receiveMax)
+	newConnection.SetAvailability(s.retainAvailable, s.wildcardAvailable)
+	newConnection.SetCompression(s.codecs)
+	newConnection.SetVersions(s.versions)
 	newConnection.SetPermissionDelegate(s.permissionDelegate)
 	s.corous.Add(1)
 	go newConnection.Handle()
@@ -24,13 +24,76 @@ package server
 
 import (
 	"net"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/mitghi/protox/client"
+	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/networking"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
 )
 
 const (
 	logmode bool = false
 )
 
+// testConn records packets and messages sent to a client.
+type testConn struct {
+	*networking.Connection
+	lock    sync.Mutex
+	sent    []protobase.EDProtocol
+	msgs    []protobase.MsgInterface
+	offline bool
+	sendErr func(protobase.EDProtocol) error // fails sending packets when set
+}
+
+func (tc *testConn) SendPacket(pb protobase.EDProtocol) error {
+	if tc.sendErr != nil {
+		if err := tc.sendErr(pb); err != nil {
+			return err
+		}
+	}
+	tc.lock.Lock()
+	tc.sent = append(tc.sent, pb)
+	tc.lock.Unlock()
+	return nil
+}
+
+func (tc *testConn) SendMessage(msg protobase.MsgInterface, isOwner bool) error {
+	tc.lock.Lock()
+	tc.msgs = append(tc.msgs, msg)
+	tc.lock.Unlock()
+	return nil
+}
+
+func (tc *testConn) GetStatus() uint32 {
+	if tc.offline {
+		return protobase.STATDISCONNECTED
+	}
+	return protobase.STATONLINE
+}
+
+// take returns and clears the recorded packets.
+func (tc *testConn) take() []protobase.EDProtocol {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	sent := tc.sent
+	tc.sent = nil
+	return sent
+}
+
+// newTestConn registers an online client `clid` with `s`.
+func newTestConn(s *Server, clid string) *testConn {
+	tc := &testConn{Connection: networking.NewConnection(nil)}
+	tc.SetClient(client.NewClient(clid, "", clid))
+	cl := newConnection(STCLIENT, clid, nil, false, false)
+	cl.setInfo(nil, tc, tc.GetClient(), nil, nil)
+	s.State.set(clid, cl)
+	return tc
+}
+
 func TestNewServerRouter(t *testing.T) {
 	var (
 		s   *Server = NewServer()
@@ -50,6 +113,171 @@ func TestNewServerRouter(t *testing.T) {
 	}
 }
 
+func TestNotifyUnsubscribe(t *testing.T) {
+	var (
+		s    *Server                = NewServer()
+		conn *networking.Connection = networking.NewConnection(nil)
+	)
+	conn.SetClient(client.NewClient("test", "", "test"))
+	s.Router.Add("test", "a/simple/topic", 1)
+	s.NotifyUnsubscribe(conn, protocol.NewMsgBox(1, 1, protobase.MDInbound, protocol.NewMsgEnvelope("a/simple/topic", nil)))
+	m, err := s.Router.Find("a/simple/topic")
+	if err != nil {
+		t.Fatal("failed to find topic.", err)
+	}
+	if _, ok := m["test"]; ok {
+		t.Fatal("subscription still exists, expected removal.", m)
+	}
+}
+
+func TestPublishWill(t *testing.T) {
+	var (
+		s       *Server                = NewServer()
+		conn    *networking.Connection = networking.NewConnection(nil)
+		will    *protocol.MsgBox       = protocol.NewMsgBox(1, 0, protobase.MDInbound, protocol.NewMsgEnvelope("devices/test/status", []byte("offline")))
+		allowed bool
+	)
+	conn.SetClient(client.NewClient("test", "", "test"))
+	will.SetRetain(true, 0)
+	s.Status = protobase.ServerRunning
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return allowed })
+	s.publishWill("test", will)
+	if ps := s.Router.FindRetained("devices/test/status"); len(ps) != 0 {
+		t.Fatal("will published without permission, expected drop.", ps)
+	}
+	allowed = true
+	s.publishWill("test", will)
+	if ps := s.Router.FindRetained("devices/test/status"); len(ps) != 1 {
+		t.Fatal("will is not published, expected retained will.", ps)
+	}
+}
+
+func TestDeferredWill(t *testing.T) {
+	var (
+		s    *Server          = NewServer()
+		tc   *testConn        = newTestConn(s, "test")
+		will *protocol.MsgBox = protocol.NewMsgBox(1, 0, protobase.MDInbound, protocol.NewMsgEnvelope("devices/test/status", []byte("offline")))
+	)
+	will.SetRetain(true, 0)
+	s.Status = protobase.ServerRunning
+	s.SetPermissionDelegate(func(protobase.AuthInterface, ...string) bool { return true })
+	conn := s.State.get("test")
+	conn.Lock()
+	s.deferWill(conn, will, time.Now().Add(10*time.Millisecond))
+	conn.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	if ps := s.Router.FindRetained("devices/test/status"); len(ps) != 0 {
+		t.Fatal("will published while client is online, expected drop.", ps)
+	}
+	tc.offline = true
+	conn.Lock()
+	s.deferWill(conn, will, time.Now().Add(10*time.Millisecond))
+	conn.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	if ps := s.Router.FindRetained("devices/test/status"); len(ps) != 1 {
+		t.Fatal("will is not published, expected retained will.", ps)
+	}
+}
+
+func TestEncodeFor(t *testing.T) {
+	var (
+		s       *Server                = NewServer()
+		conn    *networking.Connection = networking.NewConnection(nil)
+		data    []byte                 = []byte(`{"sensor":"temperature","value":21.5}`)
+		encoded                        = make(map[byte]protobase.MsgInterface)
+	)
+	gz, _ := protocol.Compress(protobase.COMPGzip, data)
+	msg := protocol.NewMsgBox(1, 0, protobase.MDInbound, protocol.NewMsgEnvelope("sensors/a/temperature", gz))
+	msg.SetCompression(protobase.COMPGzip)
+	encoded[msg.Compression()] = msg
+	// subscriber did not negotiate compression
+	emsg, err := s.encodeFor(conn, msg, encoded)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if emsg.Compression() != protobase.COMPNone || string(emsg.Envelope().Payload()) != string(data) {
+		t.Fatal("inconsistent payload, expected uncompressed.", emsg.Compression())
+	}
+	if cached, _ := s.encodeFor(conn, msg, encoded); cached != emsg {
+		t.Fatal("expected cached encoding to be reused.")
+	}
+	s.SetCompressionOptOut("sensors/*", "logs")
+	for topic, out := range map[string]bool{
+		"sensors/a/temperature": true,
+		"sensors":               false,
+		"logs":                  true,
+		"logs/a":                false,
+	} {
+		if s.compressionOptOut(topic) != out {
+			t.Fatal("inconsistent opt-out for topic.", topic, out)
+		}
+	}
+	// retained payloads are stored uncompressed
+	msg.SetRetain(true, 0)
+	s.retainMessage(msg)
+	ps := s.Router.FindRetained("sensors/a/temperature")
+	if len(ps) != 1 || string(ps[0].(*protocol.Publish).Message) != string(data) {
+		t.Fatal("inconsistent retained message, expected uncompressed payload.", ps)
+	}
+}
+
+func TestRetainUnavailable(t *testing.T) {
+	s := NewServer()
+	s.SetRetainAvailable(false)
+	msg := protocol.NewMsgBox(1, 0, protobase.MDInbound, protocol.NewMsgEnvelope("sensors/a/temperature", []byte("21.5")))
+	msg.SetRetain(true, 0)
+	s.NotifyPublish(nil, msg)
+	if ps := s.Router.FindRetained("sensors/a/temperature"); len(ps) != 0 {
+		t.Fatal("expected retained message to be dismissed.", ps)
+	}
+}
+
+func TestOpenSession(t *testing.T) {
+	var (
+		s    *Server                = NewServer()
+		conn *networking.Connection = networking.NewConnection(nil)
+	)
+	s.SetMessageStore(messages.NewInitedMessageStore())
+	conn.SetClient(client.NewClient("test", "", "test"))
+	token, present := s.OpenSession("test", "", false)
+	if present || token == "" {
+		t.Fatal("inconsistent session, expected new session with token.", token, present)
+	}
+	s.NotifySubscribe(conn, protocol.NewMsgBox(1, 1, protobase.MDInbound, protocol.NewMsgEnvelope("a/simple/topic", nil)))
+	// resume with valid token
+	if tok, present := s.OpenSession("test", token, false); !present || tok != token {
+		t.Fatal("session is not resumed, expected present.", tok, present)
+	}
+	if m, _ := s.Router.Find("a/simple/topic"); len(m) != 1 {
+		t.Fatal("subscription is not restored.", m)
+	}
+	// clients without a token keep their state
+	pb := protocol.NewRawPublish()
+	pb.Topic, pb.Meta.Qos = "a/simple/topic", protobase.LQOS1
+	s.Store.AddOutbound("test", pb)
+	if tok, present := s.OpenSession("test", "", false); !present || tok != token {
+		t.Fatal("session is not resumed without token, expected present.", tok, present)
+	}
+	if m, _ := s.Router.Find("a/simple/topic"); len(m) != 1 || len(s.Store.GetAllOut("test")) != 1 {
+		t.Fatal("state of client without token is discarded.", m)
+	}
+	// invalid token starts a new session
+	if tok, present := s.OpenSession("test", "invalid", false); present || tok == token {
+		t.Fatal("session is resumed with invalid token, expected new session.", tok, present)
+	}
+	if m, _ := s.Router.Find("a/simple/topic"); len(m) != 0 || len(s.Store.GetAllOut("test")) != 0 {
+		t.Fatal("subscription of discarded session still exists.", m)
+	}
+	// clean start discards the session
+	s.NotifySubscribe(conn, protocol.NewMsgBox(1, 1, protobase.MDInbound, protocol.NewMsgEnvelope("a/simple/topic", nil)))
+	if _, present := s.OpenSession("test", "", true); present {
+		t.Fatal("session is resumed on clean start.")
+	}
+	if m, _ := s.Router.Find("a/simple/topic"); len(m) != 0 {
+		t.Fatal("subscription of clean session still exists.", m)
+	}
+}
+
 func TestServeTCP(t *testing.T) {
 	var (
 		s        *Server
@@ -0,0 +1,255 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"sync"
+
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+// SharePrefix is the topic prefix of shared subscriptions ( see
+// `protocol.SharePrefix` ).
+const SharePrefix = protocol.SharePrefix
+
+// Member selection strategies of shared subscriptions.
+const (
+	// ShareRoundRobin selects members in turn.
+	ShareRoundRobin byte = iota
+	// ShareLeastInflight selects the member with the fewest
+	// unacknowledged messages.
+	ShareLeastInflight
+)
+
+// share is a group of clients competing for messages of a filter.
+type share struct {
+	members []string        // client ids in joining order
+	qos     map[string]byte // requested QoS by member
+	next    int             // round robin position in members
+}
+
+// shares holds shared subscriptions by their topic, which is also
+// the subscriber id of the group in the router.
+type shares struct {
+	sync.Mutex
+
+	m        map[string]*share
+	strategy byte
+}
+
+func newShares() *shares {
+	return &shares{m: make(map[string]*share)}
+}
+
+// join adds client `clid` to the group of shared subscription `key`.
+func (ss *shares) join(key string, clid string, qos byte) {
+	ss.Lock()
+	defer ss.Unlock()
+	sh, ok := ss.m[key]
+	if !ok {
+		sh = &share{qos: make(map[string]byte)}
+		ss.m[key] = sh
+	}
+	if _, ok = sh.qos[clid]; !ok {
+		sh.members = append(sh.members, clid)
+	}
+	sh.qos[clid] = qos
+}
+
+// leave removes client `clid` from the group of shared subscription
+// `key` and returns whether the group is empty.
+func (ss *shares) leave(key string, clid string) bool {
+	ss.Lock()
+	defer ss.Unlock()
+	sh, ok := ss.m[key]
+	if !ok {
+		return true
+	}
+	if _, ok = sh.qos[clid]; ok {
+		delete(sh.qos, clid)
+		for i, cid := range sh.members {
+			if cid == clid {
+				sh.members = append(sh.members[:i], sh.members[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(sh.members) == 0 {
+		delete(ss.m, key)
+		return true
+	}
+	return false
+}
+
+// memberOf returns keys of shared subscriptions client `clid` is a
+// member of.
+func (ss *shares) memberOf(clid string) (keys []string) {
+	ss.Lock()
+	defer ss.Unlock()
+	for key, sh := range ss.m {
+		if _, ok := sh.qos[clid]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// subscribe subscribes client `clid` to `topic`, which may be a shared
+// subscription.
+func (s *Server) subscribe(clid string, topic string, qos byte) {
+	if _, filter, ok := protocol.ParseShare(topic); ok {
+		s.shares.join(topic, clid, qos)
+		s.Router.Add(topic, filter, qos)
+		return
+	}
+	s.Router.Add(clid, topic, qos)
+}
+
+// unsubscribe removes the subscription of client `clid` to `topic`.
+// Shared subscriptions are removed from the router with their last
+// member.
+func (s *Server) unsubscribe(clid string, topic string) error {
+	if _, filter, ok := protocol.ParseShare(topic); ok {
+		if s.shares.leave(topic, clid) {
+			return s.Router.Remove(topic, filter)
+		}
+		return nil
+	}
+	return s.Router.Remove(clid, topic)
+}
+
+// SetShareStrategy sets how members of shared subscriptions are
+// selected ( `ShareRoundRobin` or `ShareLeastInflight` ).
+func (s *Server) SetShareStrategy(strategy byte) {
+	s.shares.Lock()
+	s.shares.strategy = strategy
+	s.shares.Unlock()
+}
+
+// pickShare selects the member of shared subscription `key` receiving
+// the next message and its QoS. Online members other than `exclude`
+// are preferred, messages are only queued for offline members which
+// subscribed with QoS above 0. It returns an empty id when no member
+// can receive the message.
+func (s *Server) pickShare(key string, exclude string) (string, byte) {
+	s.shares.Lock()
+	defer s.shares.Unlock()
+	sh, ok := s.shares.m[key]
+	if !ok || len(sh.members) == 0 {
+		return "", 0
+	}
+	var (
+		n        int    = len(sh.members)
+		chosen   string = ""
+		inflight int    = -1
+	)
+	for i := 0; i < n; i++ {
+		clid := sh.members[(sh.next+i)%n]
+		if clid == exclude || !s.isOnline(clid) {
+			continue
+		}
+		if s.shares.strategy != ShareLeastInflight {
+			sh.next = (sh.next + i + 1) % n
+			return clid, sh.qos[clid]
+		}
+		if c := s.inflight(clid); inflight < 0 || c < inflight {
+			chosen, inflight = clid, c
+		}
+	}
+	for i := 0; chosen == "" && i < n; i++ {
+		// messages are queued for an offline member
+		if clid := sh.members[(sh.next+i)%n]; clid != exclude && sh.qos[clid] > 0 {
+			chosen = clid
+		}
+	}
+	if chosen == "" {
+		return "", 0
+	}
+	sh.next = (sh.next + 1) % n
+	return chosen, sh.qos[chosen]
+}
+
+// isOnline returns whether client `clid` is connected.
+func (s *Server) isOnline(clid string) bool {
+	cl := s.State.get(clid)
+	return cl != nil && cl.proto != nil && cl.proto.GetStatus() == protobase.STATONLINE
+}
+
+// inflight returns the number of unacknowledged messages of client
+// `clid`.
+func (s *Server) inflight(clid string) int {
+	if s.Store == nil {
+		return 0
+	}
+	return len(s.Store.GetAllOut(clid))
+}
+
+// reassignShared hands unacknowledged QoS 1 messages which client
+// `clid` received through shared subscriptions over to other online
+// members of their groups.
+func (s *Server) reassignShared(clid string) {
+	const fn = "reassignShared"
+	keys := s.shares.memberOf(clid)
+	if len(keys) == 0 || s.Store == nil {
+		return
+	}
+	for _, p := range s.Store.GetAllOut(clid) {
+		pb, ok := p.(*protocol.Publish)
+		if !ok || pb.Meta.Qos != protobase.LQOS1 {
+			continue
+		}
+		m, _ := s.Router.Find(pb.Topic)
+		if _, ok = m[clid]; ok {
+			// delivered through its own subscription
+			continue
+		}
+		for _, key := range keys {
+			if _, ok = m[key]; !ok {
+				continue
+			}
+			member, qos := s.pickShare(key, clid)
+			if member == "" || !s.isOnline(member) {
+				continue
+			}
+			if !s.Store.DeleteOut(clid, pb) {
+				break
+			}
+			if idstore := s.Store.GetIDStoreO(clid); idstore != nil {
+				idstore.FreeId(pb.Meta.MessageId)
+			}
+			msg := messageOf(pb)
+			prc := s.State.get(member).proto
+			emsg, err := s.encodeFor(prc, msg, map[byte]protobase.MsgInterface{msg.Compression(): msg})
+			if err != nil {
+				logger.FDebugf(fn, "- [Share] unable to encode message for client(%s). error: %s", member, err)
+				break
+			}
+			npb := emsg.Clone(protobase.MDOutbound)
+			npb.SetWishQoS(qos)
+			logger.FDebugf(fn, "+ [Share] reassigning message on topic(%s) of client(%s) to client(%s).", pb.Topic, clid, member)
+			prc.SendMessage(npb, false)
+			break
+		}
+	}
+}
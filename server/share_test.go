@@ -0,0 +1,127 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"testing"
+
+	"github.com/mitghi/protox/messages"
+	"github.com/mitghi/protox/protobase"
+	"github.com/mitghi/protox/protocol"
+)
+
+func subscribeTo(s *Server, prc protobase.ProtoConnection, topic string, qos byte) {
+	s.NotifySubscribe(prc, protocol.NewMsgBox(qos, 1, protobase.MDInbound, protocol.NewMsgEnvelope(topic, nil)))
+}
+
+func publishTo(s *Server, topic string, n int) {
+	for i := 0; i < n; i++ {
+		s.NotifyPublish(nil, protocol.NewMsgBox(1, 0, protobase.MDInbound, protocol.NewMsgEnvelope(topic, []byte("job"))))
+	}
+}
+
+func TestSharedSubscription(t *testing.T) {
+	var (
+		s        *Server = NewServer()
+		a                = newTestConn(s, "a")
+		b                = newTestConn(s, "b")
+		observer         = newTestConn(s, "observer")
+	)
+	s.Status = protobase.ServerRunning
+	subscribeTo(s, a, "$share/workers/jobs/*", 1)
+	subscribeTo(s, b, "$share/workers/jobs/*", 1)
+	subscribeTo(s, observer, "jobs/*", 1)
+	publishTo(s, "jobs/build", 4)
+	if len(a.msgs) != 2 || len(b.msgs) != 2 || len(observer.msgs) != 4 {
+		t.Fatal("inconsistent distribution among group members.", len(a.msgs), len(b.msgs), len(observer.msgs))
+	}
+	// offline members are skipped
+	b.offline = true
+	publishTo(s, "jobs/build", 2)
+	if len(a.msgs) != 4 || len(b.msgs) != 2 {
+		t.Fatal("expected online member to receive messages.", len(a.msgs), len(b.msgs))
+	}
+	// QoS 0 messages are not queued for offline members
+	subscribeTo(s, b, "$share/idle/jobs/*", 0)
+	if member, _ := s.pickShare("$share/idle/jobs/*", ""); member != "" {
+		t.Fatal("expected no member for QoS 0 group without online members.", member)
+	}
+	a.offline = true
+	if member, _ := s.pickShare("$share/workers/jobs/*", ""); member == "" {
+		t.Fatal("expected offline member to be chosen for QoS 1 group.")
+	}
+	a.offline = false
+	s.NotifyUnsubscribe(b, protocol.NewMsgBox(1, 1, protobase.MDInbound, protocol.NewMsgEnvelope("$share/idle/jobs/*", nil)))
+	s.NotifyUnsubscribe(a, protocol.NewMsgBox(1, 1, protobase.MDInbound, protocol.NewMsgEnvelope("$share/workers/jobs/*", nil)))
+	s.NotifyUnsubscribe(b, protocol.NewMsgBox(1, 1, protobase.MDInbound, protocol.NewMsgEnvelope("$share/workers/jobs/*", nil)))
+	if m, _ := s.Router.Find("jobs/build"); len(m) != 1 {
+		t.Fatal("expected group to be removed with its last member.", m)
+	}
+}
+
+func TestShareLeastInflight(t *testing.T) {
+	var (
+		s *Server = NewServer()
+		a         = newTestConn(s, "a")
+		b         = newTestConn(s, "b")
+	)
+	s.Status = protobase.ServerRunning
+	s.SetMessageStore(messages.NewInitedMessageStore())
+	s.SetShareStrategy(ShareLeastInflight)
+	s.Store.AddClient("a")
+	subscribeTo(s, a, "$share/workers/jobs/build", 1)
+	subscribeTo(s, b, "$share/workers/jobs/build", 1)
+	for i := 0; i < 2; i++ {
+		pb := protocol.NewRawPublish()
+		pb.Topic, pb.Meta.Qos = "jobs/build", protobase.LQOS1
+		s.Store.AddOutbound("a", pb)
+	}
+	publishTo(s, "jobs/build", 2)
+	if len(a.msgs) != 0 || len(b.msgs) != 2 {
+		t.Fatal("expected least busy member to receive messages.", len(a.msgs), len(b.msgs))
+	}
+}
+
+func TestReassignShared(t *testing.T) {
+	var (
+		s *Server = NewServer()
+		a         = newTestConn(s, "a")
+		b         = newTestConn(s, "b")
+	)
+	s.SetMessageStore(messages.NewInitedMessageStore())
+	subscribeTo(s, a, "$share/workers/jobs/build", 1)
+	subscribeTo(s, b, "$share/workers/jobs/build", 1)
+	s.Store.AddClient("a")
+	pb := protocol.NewRawPublish()
+	pb.Topic, pb.Message, pb.Meta.Qos = "jobs/build", []byte("job"), protobase.LQOS1
+	s.Store.AddOutbound("a", pb)
+	pb.Meta.MessageId = s.Store.GetIDStoreO("a").GetNewID(pb.UUID())
+	a.offline = true
+	s.reassignShared("a")
+	if len(s.Store.GetAllOut("a")) != 0 || s.Store.GetIDStoreO("a").IsOccupied(pb.Meta.MessageId) {
+		t.Fatal("expected message to be released by disconnected member.")
+	}
+	if len(b.msgs) != 1 || string(b.msgs[0].Envelope().Payload()) != "job" || b.msgs[0].QoS() != protobase.LQOS1 {
+		t.Fatal("expected message to be reassigned.", b.msgs)
+	}
+}
@@ -0,0 +1,58 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"github.com/mitghi/protox/protobase"
+)
+
+// sink receives messages routed to server local subscribers such
+// as gateway streams, which have no protocol connection. It must
+// not block.
+type sink func(protobase.MsgInterface)
+
+// attachSink subscribes sink `fn` identified by `id` to `topic`.
+func (s *Server) attachSink(id string, topic string, qos byte, fn sink) {
+	s.Lock()
+	s.sinks[id] = fn
+	s.Unlock()
+	s.Router.Add(id, topic, qos)
+}
+
+// detachSink removes the subscription of sink `id` to `topic`.
+func (s *Server) detachSink(id string, topic string) {
+	const fn = "detachSink"
+	if err := s.Router.Remove(id, topic); err != nil {
+		logger.FDebugf(fn, "- [Router] unable to remove subscription (%s) of sink(%s). error: %s", topic, id, err)
+	}
+	s.Lock()
+	delete(s.sinks, id)
+	s.Unlock()
+}
+
+// getSink returns the sink identified by `id`, or nil.
+func (s *Server) getSink(id string) sink {
+	s.RLock()
+	defer s.RUnlock()
+	return s.sinks[id]
+}
@@ -28,6 +28,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/mitghi/protox/protobase"
 )
 
@@ -54,8 +55,10 @@ type connstats struct {
 type serverState struct {
 	sync.RWMutex
 
-	clients map[string]*connection
-	mode    byte
+	clients  map[string]*connection
+	sessions map[string]*session
+	wills    map[string]*pendingWill // wills restored from a snapshot
+	mode     byte
 	// TODO
 	// conns   map[net.Conn]*connection
 }
@@ -71,10 +74,25 @@ type connection struct {
 	ip         string
 	hasSession bool
 	persist    bool
+	will       *pendingWill
 	// TODO
 	//  add callbacks
 }
 
+// pendingWill is a delayed last will waiting for its deadline.
+type pendingWill struct {
+	timer *time.Timer
+	msg   protobase.MsgInterface
+	at    time.Time
+}
+
+// session is the persistent state of a client which
+// survives reconnects.
+type session struct {
+	token  string
+	topics map[string]byte
+}
+
 type conninfo struct {
 	start    *time.Time
 	end      *time.Time
@@ -94,10 +112,19 @@ func newConnection(typ SConnType, uid string, conn net.Conn, session bool, persi
 	}
 }
 
+func newSession() *session {
+	return &session{
+		token:  uuid.New().String(),
+		topics: make(map[string]byte),
+	}
+}
+
 func newServerState(mode byte) *serverState {
 	ret := &serverState{
-		clients: make(map[string]*connection),
-		mode:    mode,
+		clients:  make(map[string]*connection),
+		sessions: make(map[string]*session),
+		wills:    make(map[string]*pendingWill),
+		mode:     mode,
 		// conns:   make(map[net.Conn]*connection),
 	}
 	return ret
@@ -123,6 +150,22 @@ func (c *connection) setInfo(conn net.Conn, proto protobase.ProtoConnection, cli
 	c.conninfo.authsys = authsys
 }
 
+// stopWill cancels the pending delayed last will and
+// returns whether it was pending.
+func (c *connection) stopWill() bool {
+	if c.will == nil {
+		return false
+	}
+	stopped := c.will.timer.Stop()
+	c.will = nil
+	return stopped
+}
+
+// isOnline returns whether the client is currently connected.
+func (c *connection) isOnline() bool {
+	return c.conninfo.proto != nil && c.conninfo.proto.GetStatus() == protobase.STATONLINE
+}
+
 func (c *connection) Inc(statics cstflg) {
 	switch statics {
 	case CLConnected:
@@ -197,3 +240,48 @@ func (s *serverState) pruneByCid(cid string) (val *connection) {
 	}
 	return nil
 }
+
+// getSession returns the session of client `cid`.
+func (s *serverState) getSession(cid string) (val *session) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.sessions[cid]
+}
+
+// setSession sets the session of client `cid`.
+func (s *serverState) setSession(cid string, val *session) {
+	s.Lock()
+	s.sessions[cid] = val
+	s.Unlock()
+}
+
+// dropWill cancels the restored will of client `cid` and
+// returns whether it was pending.
+func (s *serverState) dropWill(cid string) bool {
+	s.Lock()
+	defer s.Unlock()
+	pw, ok := s.wills[cid]
+	if !ok {
+		return false
+	}
+	delete(s.wills, cid)
+	return pw.timer.Stop()
+}
+
+// addTopic records the subscription of client `cid` in its session.
+func (s *serverState) addTopic(cid string, topic string, qos byte) {
+	s.Lock()
+	if ss, ok := s.sessions[cid]; ok {
+		ss.topics[topic] = qos
+	}
+	s.Unlock()
+}
+
+// removeTopic removes the subscription of client `cid` from its session.
+func (s *serverState) removeTopic(cid string, topic string) {
+	s.Lock()
+	if ss, ok := s.sessions[cid]; ok {
+		delete(ss.topics, topic)
+	}
+	s.Unlock()
+}
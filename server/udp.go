@@ -0,0 +1,63 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"net"
+
+	"github.com/mitghi/protox/protocol/adaptor"
+)
+
+// ServeUDP is the listening loop for MQTT-SN style datagram clients.
+// Peers are demultiplexed into sessions by `adaptor.SNListener`, each
+// session is translated by `adaptor.SNConn` and shares router, auth
+// and message storage with protox clients.
+func (s *Server) ServeUDP(address string) (err error) {
+	const fn = "ServeUDP"
+	var (
+		pc       net.PacketConn
+		listener *adaptor.SNListener
+	)
+	pc, err = s.listenPacket(address)
+	if err != nil {
+		logger.Debug(fn, "- [Fatal] Cannot listen for incomming datagrams.")
+		return err
+	}
+	listener = adaptor.NewSNListener(pc)
+	defer listener.Close()
+	defer s.closeOnStop(listener)()
+	for {
+		var (
+			conn net.Conn
+		)
+		conn, err = listener.Accept()
+		if err != nil {
+			logger.FDebug(fn, "- [Server] returning from UDP handler. error:", err)
+			break
+		}
+		logger.FInfo(fn, "* [Genesis] datagram participation request accepted.")
+		s.corous.Add(1)
+		go s.handleIncomingConnection(conn)
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+// unixListener listens on unix socket `path` and applies permission
+// and ownership options. A stale socket left by a previous run is
+// removed, a socket accepting connections is left untouched.
+func unixListener(path string, opts *UNIXSOptions) (listener net.Listener, err error) {
+	const fn = "unixListener"
+	if err = removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err = net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Perm != 0 {
+		if err = os.Chmod(path, opts.Perm); err != nil {
+			goto ERROR
+		}
+	}
+	if opts.Owner != "" || opts.Group != "" {
+		var uid, gid int = -1, -1
+		if opts.Owner != "" {
+			if uid, err = lookupId(opts.Owner, false); err != nil {
+				goto ERROR
+			}
+		}
+		if opts.Group != "" {
+			if gid, err = lookupId(opts.Group, true); err != nil {
+				goto ERROR
+			}
+		}
+		if err = os.Chown(path, uid, gid); err != nil {
+			goto ERROR
+		}
+	}
+	return listener, nil
+ERROR:
+	logger.FError(fn, "- [Unix] cannot apply socket options.", err)
+	listener.Close()
+	return nil, err
+}
+
+// removeStaleSocket removes the socket at `path` when no process
+// accepts connections on it.
+func removeStaleSocket(path string) error {
+	const fn = "removeStaleSocket"
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return SRVNotSocket
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return SRVSocketInUse
+	}
+	logger.FDebugf(fn, "* [Unix] removing stale socket (%s).", path)
+	return os.Remove(path)
+}
+
+// lookupId returns the numeric id of user, or group when `group` is
+// set, given by name or id.
+func lookupId(name string, group bool) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	if group {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return -1, err
+		}
+		return strconv.Atoi(g.Gid)
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
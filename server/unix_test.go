@@ -0,0 +1,67 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixListener(t *testing.T) {
+	var (
+		dir  string = t.TempDir()
+		path string = filepath.Join(dir, "protox.sock")
+	)
+	// leave a stale socket behind
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	listener, err := unixListener(path, &UNIXSOptions{Perm: 0660})
+	if err != nil {
+		t.Fatal("err!=nil, expected stale socket removal.", err)
+	}
+	defer listener.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if fi.Mode().Perm() != 0660 {
+		t.Fatal("inconsistent socket permissions.", fi.Mode())
+	}
+	if _, err = unixListener(path, &UNIXSOptions{}); err != SRVSocketInUse {
+		t.Fatal("expected socket in use.", err)
+	}
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0600)
+	if _, err = unixListener(file, &UNIXSOptions{}); err != SRVNotSocket {
+		t.Fatal("expected non socket path to be kept.", err)
+	}
+	if err = precheckOpts(&ServerConfigs{Addr: path, Mode: ProtoUNIXSO, Config: UNIXSOptions{}}); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+}
@@ -22,47 +22,94 @@
 
 package server
 
-/**
-*
-* IMPORTANT
-*
-* this file is only for experimenting web compatibility.
-*
-**/
-
 import (
-	"io"
-	"log"
+	"net"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/mitghi/protox/protobase"
 	"golang.org/x/net/websocket"
 )
 
-// ServeWS is the main listening loop for serving protocol connections
-// over WebSockets. Notice - this is experimental.
-func (s *Server) ServeWS(address string) (err error) {
-	http.Handle("/stat", websocket.Handler(wsHandler))
-	err = http.ListenAndServe(":8080", nil)
-	if err != nil {
-		log.Println("FAILED TO START WEBSOCKET")
-		return err
+// wsConn is a `net.Conn` carrying binary protox frames over a
+// WebSocket connection.
+type wsConn struct {
+	*websocket.Conn
+	remote net.Addr
+	done   chan struct{}
+	once   sync.Once
+}
+
+// newWSConn wraps `ws` and returns a pointer to it.
+func newWSConn(ws *websocket.Conn) *wsConn {
+	ws.PayloadType = websocket.BinaryFrame
+	wc := &wsConn{
+		Conn:   ws,
+		remote: ws.RemoteAddr(),
+		done:   make(chan struct{}),
 	}
+	// server side websocket reports the origin as its remote address
+	if addr, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr); err == nil {
+		wc.remote = addr
+	}
+	return wc
+}
 
-	return nil
+// RemoteAddr returns the peer address.
+func (wc *wsConn) RemoteAddr() net.Addr {
+	return wc.remote
 }
 
-// WsHandler is a connection handling routine used by `ServeWS`.
-func wsHandler(ws *websocket.Conn) {
-	reader, writer := io.Reader(ws), io.Writer(ws)
-	for {
-		buff := make([]byte, 1024)
-		_, err := reader.Read(buff)
-		if err != nil {
-			log.Println("- error in reading line from websocket")
+// Close closes the connection and releases its handler.
+func (wc *wsConn) Close() error {
+	err := wc.Conn.Close()
+	wc.once.Do(func() { close(wc.done) })
+	return err
+}
+
+// wsHandshake accepts WebSocket upgrades offering the protox
+// subprotocol.
+func wsHandshake(config *websocket.Config, req *http.Request) error {
+	for _, p := range config.Protocol {
+		if p == protobase.WSSubprotocol {
+			config.Protocol = []string{p}
+			return nil
 		}
-		log.Println(buff)
-		out := "this is working"
-		b := []byte(out)
-		writer.Write(b)
 	}
+	return SRVWSSubprotocol
+}
+
+// ServeWS is the listening loop for protox clients connecting over
+// WebSockets. Upgrades are served on the path set by `SetWSPath`, the
+// listener is configured by `SetFrontend` with `ProtoWS` ( e.g. TLS ).
+func (s *Server) ServeWS(address string) (err error) {
+	const fn = "ServeWS"
+	var (
+		listener net.Listener
+		mux      *http.ServeMux = http.NewServeMux()
+		hs       *http.Server
+	)
+	listener, err = s.frontendListener(ProtoWS, address)
+	if err != nil {
+		logger.Debug(fn, "- [Fatal] Cannot listen for incomming WebSocket connections.")
+		return err
+	}
+	mux.Handle(s.wsPath, websocket.Server{Handshake: wsHandshake, Handler: s.handleWS})
+	hs = &http.Server{Handler: mux, ReadHeaderTimeout: time.Second * 10}
+	defer s.closeOnStop(hs)()
+	err = hs.Serve(listener)
+	logger.FDebug(fn, "- [Server] returning from WebSocket handler. error:", err)
+	return err
+}
+
+// handleWS passes upgraded connections to the connection pipeline
+// and blocks until they are closed.
+func (s *Server) handleWS(ws *websocket.Conn) {
+	const fn = "handleWS"
+	conn := newWSConn(ws)
+	logger.FInfo(fn, "* [Genesis] WebSocket participation request accepted.")
+	s.corous.Add(1)
+	s.handleIncomingConnection(conn)
+	<-conn.done
 }
@@ -0,0 +1,79 @@
+/* MIT License
+*
+* Copyright (c) 2018 Mike Taghavi <mitghi[at]gmail.com>
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitghi/protox/protobase"
+	"golang.org/x/net/websocket"
+)
+
+func TestWSConn(t *testing.T) {
+	var (
+		remote = make(chan net.Addr, 1)
+		srv    = httptest.NewServer(websocket.Server{Handshake: wsHandshake, Handler: func(ws *websocket.Conn) {
+			conn := newWSConn(ws)
+			remote <- conn.RemoteAddr()
+			io.Copy(conn, conn)
+		}})
+		addr  = "ws" + strings.TrimPrefix(srv.URL, "http") + DefaultWSPath
+		frame = []byte{protobase.CPING, 0x00}
+	)
+	defer srv.Close()
+	config, err := websocket.NewConfig(addr, srv.URL)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if _, err = websocket.DialConfig(config); err == nil {
+		t.Fatal("err==nil, expected subprotocol rejection.")
+	}
+	config.Protocol = []string{"mqtt", protobase.WSSubprotocol}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	defer ws.Close()
+	if p := ws.Config().Protocol; len(p) != 1 || p[0] != protobase.WSSubprotocol {
+		t.Fatal("inconsistent subprotocol negotiation.", p)
+	}
+	if addr, ok := (<-remote).(*net.TCPAddr); !ok || !addr.IP.IsLoopback() {
+		t.Fatal("expected peer address as remote address.", addr)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	if _, err = ws.Write(frame); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	buf := make([]byte, len(frame))
+	if _, err = io.ReadFull(ws, buf); err != nil {
+		t.Fatal("err!=nil", err)
+	}
+	if !bytes.Equal(buf, frame) {
+		t.Fatal("inconsistent echoed frame.", buf)
+	}
+}